@@ -2,9 +2,11 @@ package main
 
 import (
 	"log"
+	"time"
 
 	"github.com/dhij/ecomm/ecomm-api/handler"
 	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/token"
 	"github.com/ianschenck/envflag"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -14,14 +16,25 @@ const minSecretKeySize = 32
 
 func main() {
 	var (
-		secretKey = envflag.String("SECRET_KEY", "01234567890123456789012345678901", "secret key for JWT signing")
+		secretKey = envflag.String("SECRET_KEY", "01234567890123456789012345678901", "secret key for JWT signing without JWT_KEYS, and for unsubscribe links")
+		jwtKeys   = envflag.String("JWT_KEYS", "", "comma separated kid=path of RS256 or EdDSA PEM private keys to sign JWTs with instead of SECRET_KEY, each optionally followed by @ and an RFC 3339 time it becomes active")
 		svcAddr   = envflag.String("GRPC_SVC_ADDR", "0.0.0.0:9091", "address where the ecomm-grpc service is listening on")
+
+		secretKeyGrace = envflag.Duration("SECRET_KEY_GRACE", 24*time.Hour, "how long tokens signed with SECRET_KEY are still accepted after switching to JWT_KEYS, at least the refresh token lifetime to keep users logged in")
+
+		authCacheTTL = envflag.Duration("AUTH_CACHE_TTL", 30*time.Second, "how long auth checks are cached, so that sessions revoked and users changed through other instances may still be accepted for up to this long, 0 to check on every request")
 	)
 	envflag.Parse()
 
 	if len(*secretKey) < minSecretKeySize {
 		log.Fatalf("SECRET_KEY must be at least %d characters", minSecretKeySize)
 	}
+	if *authCacheTTL < 0 {
+		log.Fatalf("invalid AUTH_CACHE_TTL: %v", *authCacheTTL)
+	}
+	if *secretKeyGrace < 0 {
+		log.Fatalf("invalid SECRET_KEY_GRACE: %v", *secretKeyGrace)
+	}
 
 	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
@@ -33,8 +46,23 @@ func main() {
 	}
 	defer conn.Close()
 
+	hdlOpts := []handler.Option{handler.WithAuthCacheTTL(*authCacheTTL)}
+	if *jwtKeys != "" {
+		keys, err := token.LoadKeys(*jwtKeys)
+		if err != nil {
+			log.Fatalf("invalid JWT_KEYS: %v", err)
+		}
+		maker, err := token.NewKeyMaker(keys...)
+		if err != nil {
+			log.Fatalf("invalid JWT_KEYS: %v", err)
+		}
+		// tokens issued with the secret key before the switch stay valid
+		fallback := token.NewFallbackMaker(maker, token.NewJWTMaker(*secretKey), time.Now().Add(*secretKeyGrace))
+		hdlOpts = append(hdlOpts, handler.WithTokenMaker(fallback))
+	}
+
 	client := pb.NewEcommClient(conn)
-	hdl := handler.NewHandler(client, *secretKey)
+	hdl := handler.NewHandler(client, *secretKey, hdlOpts...)
 	handler.RegisterRoutes(hdl)
 	handler.Start(":8080")
 }
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
+	"time"
 
 	"github.com/dhij/ecomm/db"
+	"github.com/dhij/ecomm/ecomm-grpc/broker"
+	"github.com/dhij/ecomm/ecomm-grpc/dispatch"
+	"github.com/dhij/ecomm/ecomm-grpc/outbox"
 	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/ecomm-grpc/pricing"
 	"github.com/dhij/ecomm/ecomm-grpc/server"
 	"github.com/dhij/ecomm/ecomm-grpc/storer"
+	"github.com/dhij/ecomm/money"
 	"github.com/ianschenck/envflag"
 	"google.golang.org/grpc"
 )
@@ -16,9 +23,51 @@ func main() {
 	var (
 		svcAddr = envflag.String("SVC_ADDR", "0.0.0.0:9091", "address where the ecomm-grpc service is listening on")
 		dbAddr  = envflag.String("DB_ADDR", "127.0.0.1:3306", "address where the database is running on")
+
+		taxRate          = envflag.Int64("TAX_RATE_BPS", 0, "tax rate applied to the order subtotal in basis points, e.g. 1300 for 13%")
+		shippingPrice    = envflag.String("SHIPPING_PRICE", "0", "flat shipping price per order, e.g. 4.99")
+		freeShippingOver = envflag.String("FREE_SHIPPING_OVER", "0", "order subtotal from which shipping is free, 0 to disable")
+
+		verifiedReviews = envflag.Bool("VERIFIED_REVIEWS", false, "only let customers who bought a product review it")
+
+		notifyMaxAttempts = envflag.Int64("NOTIFY_MAX_ATTEMPTS", storer.DefaultRetryPolicy.MaxAttempts, "attempts at sending a notification before it is marked failed")
+		notifyBackoffBase = envflag.Duration("NOTIFY_BACKOFF_BASE", storer.DefaultRetryPolicy.BaseDelay, "delay before retrying a failed notification the first time")
+		notifyBackoffMult = envflag.Float64("NOTIFY_BACKOFF_MULTIPLIER", storer.DefaultRetryPolicy.Multiplier, "factor the retry delay grows by with each failed attempt")
+		notifyBackoffMax  = envflag.Duration("NOTIFY_BACKOFF_MAX", storer.DefaultRetryPolicy.MaxDelay, "cap on the retry delay, 0 for none")
+		notifyJitter      = envflag.Float64("NOTIFY_BACKOFF_JITTER", storer.DefaultRetryPolicy.Jitter, "fraction of the retry delay that is randomized, between 0 and 1")
+
+		outboxPollInterval = envflag.Duration("OUTBOX_POLL_INTERVAL", time.Second, "how often pending outbox events are relayed to consumers")
+
+		webhookPollInterval = envflag.Duration("WEBHOOK_POLL_INTERVAL", time.Second, "how often due webhook deliveries are sent")
 	)
 	envflag.Parse()
 
+	retry := storer.RetryPolicy{
+		MaxAttempts: *notifyMaxAttempts,
+		BaseDelay:   *notifyBackoffBase,
+		Multiplier:  *notifyBackoffMult,
+		MaxDelay:    *notifyBackoffMax,
+		Jitter:      *notifyJitter,
+	}
+	if err := retry.Validate(); err != nil {
+		log.Fatalf("invalid notification retry policy: %v", err)
+	}
+	if *outboxPollInterval <= 0 {
+		log.Fatalf("invalid OUTBOX_POLL_INTERVAL: %v", *outboxPollInterval)
+	}
+	if *webhookPollInterval <= 0 {
+		log.Fatalf("invalid WEBHOOK_POLL_INTERVAL: %v", *webhookPollInterval)
+	}
+
+	shipping, err := money.Parse(*shippingPrice, money.DefaultCurrency)
+	if err != nil {
+		log.Fatalf("invalid SHIPPING_PRICE: %v", err)
+	}
+	freeShipping, err := money.Parse(*freeShippingOver, money.DefaultCurrency)
+	if err != nil {
+		log.Fatalf("invalid FREE_SHIPPING_OVER: %v", err)
+	}
+
 	// instantiate db
 	db, err := db.NewDatabase(*dbAddr)
 	if err != nil {
@@ -27,9 +76,29 @@ func main() {
 	defer db.Close()
 	log.Println("successfully connected to database")
 
-	// instantiate server
-	st := storer.NewMySQLStorer(db.GetDB())
-	srv := server.NewServer(st)
+	// instantiate server; enqueued notification events are pushed to the
+	// workers subscribed through the broker
+	b := broker.New()
+	st := storer.NewMySQLStorer(db.GetDB(), storer.WithRetryPolicy(retry), storer.WithOnEnqueue(b.Publish))
+	pricer := pricing.NewPricer(
+		pricing.FlatRateTax{BasisPoints: *taxRate},
+		pricing.FlatShipping{Price: shipping, FreeOver: freeShipping},
+	)
+	opts := []server.Option{server.WithBroker(b)}
+	if *verifiedReviews {
+		opts = append(opts, server.WithVerifiedReviews())
+	}
+	srv := server.NewServer(st, pricer, opts...)
+
+	// relay the events written with order changes, e.g. to enqueue the
+	// order notifications and webhook deliveries
+	relay := outbox.NewRelay(st, outbox.NewNotificationConsumer(st), outbox.NewWebhookConsumer(st))
+	go relay.Run(context.Background(), *outboxPollInterval)
+
+	// deliver the queued webhook deliveries, retried under the same policy
+	// as notifications
+	dispatcher := dispatch.NewDispatcher(st)
+	go dispatcher.Run(context.Background(), *webhookPollInterval)
 
 	// register our server with the gRPC server
 	grpcSrv := grpc.NewServer()
@@ -2,22 +2,93 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/ecomm-notification/notifier"
 	"github.com/dhij/ecomm/ecomm-notification/server"
+	"github.com/dhij/ecomm/ecomm-notification/templates"
+	"github.com/dhij/ecomm/token"
 	"github.com/ianschenck/envflag"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "preview" {
+		if err := preview(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var (
-		svcAddr    = envflag.String("GRPC_SVC_ADDR", "0.0.0.0:9091", "address where the ecomm-grpc service is listening on")
-		adminEmail = envflag.String("ADMIN_EMAIL", "", "admin email")
-		adminPass  = envflag.String("ADMIN_PASSWORD", "", "admin email")
+		svcAddr = envflag.String("GRPC_SVC_ADDR", "0.0.0.0:9091", "address where the ecomm-grpc service is listening on")
+
+		channel      = envflag.String("NOTIFIER", "smtp", "notification channel: smtp, webhook, log or memory")
+		smtpHost     = envflag.String("SMTP_HOST", "smtp.gmail.com", "SMTP server host")
+		smtpPort     = envflag.Int("SMTP_PORT", 587, "SMTP server port")
+		smtpTLS      = envflag.String("SMTP_TLS", string(notifier.StartTLS), "SMTP TLS mode: starttls, tls or none")
+		smtpUsername = envflag.String("SMTP_USERNAME", "", "SMTP username, empty to skip auth")
+		smtpPassword = envflag.String("SMTP_PASSWORD", "", "SMTP password")
+		smtpFrom     = envflag.String("SMTP_FROM", "", "sender address of notification emails")
+		webhookURL   = envflag.String("WEBHOOK_URL", "", "URL notifications are POSTed to by the webhook notifier")
+		logFile      = envflag.String("NOTIFY_LOG_FILE", "", "file the log notifier appends to, empty for stdout")
+		templatesDir = envflag.String("TEMPLATES_DIR", "", "directory of email templates overriding the embedded defaults")
+
+		workerID      = envflag.String("WORKER_ID", "", "id notification events are leased under, defaults to host name and pid")
+		batchSize     = envflag.Int("CLAIM_BATCH_SIZE", 50, "number of notification events claimed per tick")
+		leaseDuration = envflag.Duration("LEASE_DURATION", 5*time.Minute, "how long claimed notification events are held before other workers may claim them")
+		pollInterval  = envflag.Duration("POLL_INTERVAL", 30*time.Second, "how often notification events are claimed besides the ones pushed to the worker")
+		concurrency   = envflag.Int("CONCURRENCY", 10, "number of notifications sent at once")
+
+		shutdownTimeout = envflag.Duration("SHUTDOWN_TIMEOUT", 30*time.Second, "how long notifications in flight are given to finish on shutdown")
+		digestWindow    = envflag.Duration("DIGEST_WINDOW", 0, "window in which the notifications of a customer are sent as one digest, 0 to send each on its own")
+
+		secretKey      = envflag.String("SECRET_KEY", "01234567890123456789012345678901", "secret key shared with ecomm-api that unsubscribe links are signed with")
+		unsubscribeURL = envflag.String("UNSUBSCRIBE_URL", "", "ecomm-api unsubscribe endpoint linked from notifications, empty for no link")
+		resetURL       = envflag.String("PASSWORD_RESET_URL", "", "page password reset emails link to with the reset token, required to send them")
 	)
 	envflag.Parse()
+	if *batchSize < 1 || *batchSize > 100 {
+		log.Fatalf("invalid CLAIM_BATCH_SIZE: %d, must be between 1 and 100", *batchSize)
+	}
+	if *leaseDuration <= 0 {
+		log.Fatalf("invalid LEASE_DURATION: %v", *leaseDuration)
+	}
+	if *pollInterval <= 0 {
+		log.Fatalf("invalid POLL_INTERVAL: %v", *pollInterval)
+	}
+	if *concurrency <= 0 {
+		log.Fatalf("invalid CONCURRENCY: %d", *concurrency)
+	}
+	if *shutdownTimeout <= 0 {
+		log.Fatalf("invalid SHUTDOWN_TIMEOUT: %v", *shutdownTimeout)
+	}
+	if *digestWindow < 0 || (*digestWindow > 0 && *digestWindow >= *leaseDuration) {
+		log.Fatalf("invalid DIGEST_WINDOW: %v, must be shorter than LEASE_DURATION", *digestWindow)
+	}
+	if *unsubscribeURL != "" && len(*secretKey) < 32 {
+		log.Fatal("SECRET_KEY must be at least 32 characters")
+	}
+
+	n, err := newNotifier(*channel, notifier.SMTPConfig{
+		Host:     *smtpHost,
+		Port:     *smtpPort,
+		TLS:      notifier.TLSMode(*smtpTLS),
+		Username: *smtpUsername,
+		Password: *smtpPassword,
+		From:     *smtpFrom,
+	}, *webhookURL, *logFile)
+	if err != nil {
+		log.Fatalf("failed to create notifier: %v", err)
+	}
 
 	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
@@ -30,15 +101,90 @@ func main() {
 	defer conn.Close()
 
 	client := pb.NewEcommClient(conn)
-	srv := server.NewServer(client, &server.AdminInfo{
-		Email:    *adminEmail,
-		Password: *adminPass,
-	})
-
-	done := make(chan struct{})
-	go func() {
-		srv.Run(context.Background())
-		done <- struct{}{}
-	}()
-	<-done
+	srvOpts := []server.Option{
+		server.WithBatchSize(int32(*batchSize)),
+		server.WithLeaseDuration(*leaseDuration),
+		server.WithPollInterval(*pollInterval),
+		server.WithConcurrency(int64(*concurrency)),
+		server.WithShutdownTimeout(*shutdownTimeout),
+	}
+	if *workerID != "" {
+		srvOpts = append(srvOpts, server.WithWorkerID(*workerID))
+	}
+	if *digestWindow > 0 {
+		srvOpts = append(srvOpts, server.WithDigest(*digestWindow))
+	}
+	if *unsubscribeURL != "" {
+		srvOpts = append(srvOpts, server.WithUnsubscribe(token.NewJWTMaker(*secretKey), *unsubscribeURL))
+	}
+	if *resetURL != "" {
+		srvOpts = append(srvOpts, server.WithPasswordReset(*resetURL))
+	}
+	srv := server.NewServer(client, n, templates.NewRenderer(*templatesDir), srvOpts...)
+
+	// stop claiming on SIGINT or SIGTERM and drain the sends in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv.Run(ctx)
+	log.Println("notification worker stopped")
+}
+
+func newNotifier(channel string, smtp notifier.SMTPConfig, webhookURL, logFile string) (notifier.Notifier, error) {
+	switch channel {
+	case "smtp":
+		return notifier.NewSMTPNotifier(smtp)
+	case "webhook":
+		if webhookURL == "" {
+			return nil, fmt.Errorf("WEBHOOK_URL is required for the webhook notifier")
+		}
+		return notifier.NewWebhookNotifier(webhookURL, nil), nil
+	case "log":
+		if logFile == "" {
+			return notifier.NewLogNotifier(os.Stdout), nil
+		}
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening log file: %w", err)
+		}
+		return notifier.NewLogNotifier(f), nil
+	case "memory":
+		return notifier.NewMemoryNotifier(), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", channel)
+	}
+}
+
+// preview renders the email for an order status against sample data, e.g.
+//
+//	ecomm-notification preview -locale fr -format html shipped
+func preview(args []string) error {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	dir := fs.String("templates", "", "directory of email templates overriding the embedded defaults")
+	locale := fs.String("locale", templates.DefaultLocale, "locale to render")
+	format := fs.String("format", "text", "part to print: text, html or all")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ecomm-notification preview [flags] <status>")
+	}
+	status := fs.Arg(0)
+
+	email, err := templates.NewRenderer(*dir).Render(*locale, status, templates.SampleData(status))
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		fmt.Printf("Subject: %s\n\n%s", email.Subject, email.Text)
+	case "html":
+		fmt.Print(email.HTML)
+	case "all":
+		fmt.Printf("Subject: %s\n\n%s\n%s", email.Subject, email.Text, email.HTML)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	return nil
 }
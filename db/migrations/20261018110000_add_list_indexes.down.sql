@@ -0,0 +1,3 @@
+DROP INDEX `orders_created_at_idx` ON `orders`;
+DROP INDEX `orders_status_idx` ON `orders`;
+DROP INDEX `products_category_price_idx` ON `products`;
@@ -0,0 +1,3 @@
+CREATE INDEX `products_category_price_idx` ON `products` (`category`, `price`);
+CREATE INDEX `orders_status_idx` ON `orders` (`status`);
+CREATE INDEX `orders_created_at_idx` ON `orders` (`created_at`);
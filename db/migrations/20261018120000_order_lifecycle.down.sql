@@ -0,0 +1,6 @@
+DROP TABLE `order_status_history`;
+
+UPDATE `orders` SET `status` = 'pending' WHERE `status` IN ('paid', 'processing', 'cancelled', 'refund_requested', 'refunded');
+
+ALTER TABLE `orders`
+	MODIFY COLUMN `status` ENUM('pending', 'shipped', 'delivered') NOT NULL DEFAULT 'pending';
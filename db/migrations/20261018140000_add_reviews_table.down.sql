@@ -0,0 +1,4 @@
+DROP TABLE `reviews`;
+
+ALTER TABLE `products`
+    MODIFY COLUMN `rating` int NOT NULL;
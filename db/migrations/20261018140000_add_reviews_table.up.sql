@@ -0,0 +1,21 @@
+CREATE TABLE `reviews` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `user_id` int NOT NULL,
+  `product_id` int NOT NULL,
+  `stars` tinyint NOT NULL,
+  `title` varchar(255) NOT NULL,
+  `body` text NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime,
+  UNIQUE KEY `reviews_user_id_product_id_key` (`user_id`, `product_id`),
+  KEY `reviews_product_id_idx` (`product_id`, `id`)
+);
+
+ALTER TABLE `reviews`
+    ADD CONSTRAINT `reviews_user_id_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`),
+    ADD CONSTRAINT `reviews_product_id_fk` FOREIGN KEY (`product_id`) REFERENCES `products` (`id`) ON DELETE CASCADE;
+
+ALTER TABLE `products`
+    MODIFY COLUMN `rating` decimal(3,2) NOT NULL DEFAULT 0;
+
+UPDATE `products` SET `rating` = 0, `num_reviews` = 0;
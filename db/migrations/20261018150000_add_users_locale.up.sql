@@ -0,0 +1,2 @@
+ALTER TABLE `users`
+    ADD COLUMN `locale` varchar(35) NOT NULL DEFAULT 'en' AFTER `is_admin`;
@@ -0,0 +1,5 @@
+DROP INDEX `notification_events_queue_claim_idx` ON `notification_events_queue`;
+
+ALTER TABLE `notification_events_queue`
+    DROP COLUMN `leased_until`,
+    DROP COLUMN `leased_by`;
@@ -0,0 +1,5 @@
+ALTER TABLE `notification_events_queue`
+    ADD COLUMN `leased_by` varchar(64) NOT NULL DEFAULT '' AFTER `attempts`,
+    ADD COLUMN `leased_until` datetime AFTER `leased_by`;
+
+CREATE INDEX `notification_events_queue_claim_idx` ON `notification_events_queue` (`attempts`, `leased_until`, `created_at`);
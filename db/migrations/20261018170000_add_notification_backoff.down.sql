@@ -0,0 +1,6 @@
+DROP TABLE `notification_attempts`;
+
+DROP INDEX `notification_events_queue_next_attempt_at_idx` ON `notification_events_queue`;
+
+ALTER TABLE `notification_events_queue`
+    DROP COLUMN `next_attempt_at`;
@@ -0,0 +1,17 @@
+ALTER TABLE `notification_events_queue`
+    ADD COLUMN `next_attempt_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP AFTER `attempts`;
+
+CREATE INDEX `notification_events_queue_next_attempt_at_idx` ON `notification_events_queue` (`next_attempt_at`);
+
+CREATE TABLE `notification_attempts` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `state_id` int NOT NULL,
+  `attempt` int NOT NULL,
+  `succeeded` bool NOT NULL,
+  `message` varchar(512),
+  `attempted_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  KEY `notification_attempts_state_id_idx` (`state_id`, `id`)
+);
+
+ALTER TABLE `notification_attempts`
+    ADD CONSTRAINT `notification_attempts_state_id_fk` FOREIGN KEY (`state_id`) REFERENCES `notification_states` (`id`) ON DELETE CASCADE;
@@ -0,0 +1,7 @@
+DROP TABLE `notification_replays`;
+
+DROP INDEX `notification_states_state_requested_at_idx` ON `notification_states`;
+
+ALTER TABLE `notification_states`
+    DROP COLUMN `order_status`,
+    DROP COLUMN `user_email`;
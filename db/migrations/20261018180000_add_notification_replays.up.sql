@@ -0,0 +1,24 @@
+ALTER TABLE `notification_states`
+    ADD COLUMN `user_email` varchar(256) NOT NULL DEFAULT '' AFTER `order_id`,
+    ADD COLUMN `order_status` varchar(256) NOT NULL DEFAULT '' AFTER `user_email`;
+
+-- the event a state was created for is gone once it is sent or has failed,
+-- so fill in existing states from the order
+UPDATE `notification_states` ns
+    JOIN `orders` o ON o.`id` = ns.`order_id`
+    JOIN `users` u ON u.`id` = o.`user_id`
+    SET ns.`user_email` = u.`email`, ns.`order_status` = o.`status`;
+
+CREATE INDEX `notification_states_state_requested_at_idx` ON `notification_states` (`state`, `requested_at`);
+
+CREATE TABLE `notification_replays` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `state_id` int NOT NULL,
+  `actor_id` int NOT NULL,
+  `previous_message` varchar(512) NOT NULL DEFAULT '',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  KEY `notification_replays_state_id_idx` (`state_id`, `id`)
+);
+
+ALTER TABLE `notification_replays`
+    ADD CONSTRAINT `notification_replays_state_id_fk` FOREIGN KEY (`state_id`) REFERENCES `notification_states` (`id`) ON DELETE CASCADE;
@@ -0,0 +1,5 @@
+ALTER TABLE `notification_states`
+    DROP INDEX `notification_states_outbox_event_id_key`,
+    DROP COLUMN `outbox_event_id`;
+
+DROP TABLE `outbox_events`;
@@ -0,0 +1,16 @@
+CREATE TABLE `outbox_events` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `aggregate_type` varchar(64) NOT NULL,
+  `aggregate_id` int NOT NULL,
+  `event_type` varchar(64) NOT NULL,
+  `payload` json NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `published_at` datetime,
+  KEY `outbox_events_published_at_idx` (`published_at`, `id`)
+);
+
+-- a notification enqueued for an outbox event records it, so that delivering
+-- the event again does not enqueue a second notification
+ALTER TABLE `notification_states`
+    ADD COLUMN `outbox_event_id` int AFTER `order_status`,
+    ADD UNIQUE KEY `notification_states_outbox_event_id_key` (`outbox_event_id`);
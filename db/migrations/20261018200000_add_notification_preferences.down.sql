@@ -0,0 +1,6 @@
+UPDATE `notification_states` SET `state` = 'sent' WHERE `state` = 'suppressed';
+
+ALTER TABLE `notification_states`
+    MODIFY `state` enum('not sent', 'sent', 'failed') NOT NULL;
+
+DROP TABLE `notification_preferences`;
@@ -0,0 +1,14 @@
+CREATE TABLE `notification_preferences` (
+  `user_id` int NOT NULL,
+  `notification_type` varchar(64) NOT NULL,
+  `channel` varchar(32) NOT NULL,
+  `enabled` bool NOT NULL,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`user_id`, `notification_type`, `channel`)
+);
+
+ALTER TABLE `notification_preferences`
+    ADD CONSTRAINT `notification_preferences_user_id_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE;
+
+ALTER TABLE `notification_states`
+    MODIFY `state` enum('not sent', 'sent', 'failed', 'suppressed') NOT NULL;
@@ -0,0 +1,44 @@
+CREATE TABLE `webhooks` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `url` varchar(2048) NOT NULL,
+  `event_types` varchar(255) NOT NULL,
+  `secret` varchar(255) NOT NULL,
+  `active` bool NOT NULL DEFAULT true,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime
+);
+
+-- a delivery of an outbox event to a webhook, queued the way notifications are
+CREATE TABLE `webhook_deliveries` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `webhook_id` int NOT NULL,
+  `outbox_event_id` int NOT NULL,
+  `event_type` varchar(64) NOT NULL,
+  `payload` json NOT NULL,
+  `occurred_at` datetime NOT NULL,
+  `state` enum('pending', 'delivered', 'failed') NOT NULL,
+  `attempts` int NOT NULL DEFAULT 0,
+  `next_attempt_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `leased_by` varchar(64) NOT NULL DEFAULT '',
+  `leased_until` datetime,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `completed_at` datetime,
+  UNIQUE KEY `webhook_deliveries_webhook_id_outbox_event_id_key` (`webhook_id`, `outbox_event_id`),
+  KEY `webhook_deliveries_claim_idx` (`state`, `next_attempt_at`, `leased_until`)
+);
+
+CREATE TABLE `webhook_delivery_attempts` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `delivery_id` int NOT NULL,
+  `attempt` int NOT NULL,
+  `status_code` int NOT NULL DEFAULT 0,
+  `succeeded` bool NOT NULL,
+  `message` varchar(512) NOT NULL DEFAULT '',
+  `attempted_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE `webhook_deliveries`
+    ADD CONSTRAINT `webhook_deliveries_webhook_id_fk` FOREIGN KEY (`webhook_id`) REFERENCES `webhooks` (`id`) ON DELETE CASCADE;
+
+ALTER TABLE `webhook_delivery_attempts`
+    ADD CONSTRAINT `webhook_delivery_attempts_delivery_id_fk` FOREIGN KEY (`delivery_id`) REFERENCES `webhook_deliveries` (`id`) ON DELETE CASCADE;
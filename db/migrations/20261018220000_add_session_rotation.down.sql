@@ -0,0 +1,6 @@
+DROP INDEX `sessions_family_id_idx` ON `sessions`;
+
+ALTER TABLE `sessions`
+    DROP COLUMN `rotated_at`,
+    DROP COLUMN `family_id`,
+    DROP COLUMN `parent_id`;
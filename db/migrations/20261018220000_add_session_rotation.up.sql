@@ -0,0 +1,9 @@
+ALTER TABLE `sessions`
+    ADD COLUMN `parent_id` varchar(255) NOT NULL DEFAULT '' AFTER `id`,
+    ADD COLUMN `family_id` varchar(255) NOT NULL DEFAULT '' AFTER `parent_id`,
+    ADD COLUMN `rotated_at` datetime AFTER `created_at`;
+
+-- every existing session starts its own family
+UPDATE `sessions` SET `family_id` = `id` WHERE `family_id` = '';
+
+CREATE INDEX `sessions_family_id_idx` ON `sessions` (`family_id`);
@@ -0,0 +1,6 @@
+DROP INDEX `sessions_user_email_idx` ON `sessions`;
+
+ALTER TABLE `sessions`
+    DROP COLUMN `last_used_at`,
+    DROP COLUMN `client_ip`,
+    DROP COLUMN `user_agent`;
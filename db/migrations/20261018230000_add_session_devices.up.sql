@@ -0,0 +1,6 @@
+ALTER TABLE `sessions`
+    ADD COLUMN `user_agent` varchar(512) NOT NULL DEFAULT '' AFTER `refresh_token`,
+    ADD COLUMN `client_ip` varchar(45) NOT NULL DEFAULT '' AFTER `user_agent`,
+    ADD COLUMN `last_used_at` datetime AFTER `rotated_at`;
+
+CREATE INDEX `sessions_user_email_idx` ON `sessions` (`user_email`);
@@ -0,0 +1,13 @@
+DELETE FROM `notification_events_queue` WHERE `order_id` IS NULL;
+DELETE FROM `notification_states` WHERE `order_id` IS NULL;
+
+ALTER TABLE `notification_events_queue`
+    DROP COLUMN `payload`,
+    DROP COLUMN `notification_type`,
+    MODIFY COLUMN `order_id` int NOT NULL;
+
+ALTER TABLE `notification_states`
+    DROP COLUMN `notification_type`,
+    MODIFY COLUMN `order_id` int NOT NULL;
+
+DROP TABLE IF EXISTS `password_reset_tokens`;
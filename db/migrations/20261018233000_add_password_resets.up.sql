@@ -0,0 +1,27 @@
+CREATE TABLE `password_reset_tokens` (
+  `id` int PRIMARY KEY NOT NULL AUTO_INCREMENT,
+  `user_id` int NOT NULL,
+  `token_hash` char(64) NOT NULL,
+  `expires_at` datetime NOT NULL,
+  `used_at` datetime,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY `password_reset_tokens_token_hash_key` (`token_hash`),
+  KEY `password_reset_tokens_user_id_idx` (`user_id`)
+);
+
+ALTER TABLE `password_reset_tokens`
+    ADD CONSTRAINT `password_reset_tokens_user_id_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE;
+
+-- notifications need not be about an order, e.g. password reset emails,
+-- whose payload holds the reset token until the email is sent
+ALTER TABLE `notification_states`
+    MODIFY COLUMN `order_id` int,
+    ADD COLUMN `notification_type` varchar(64) NOT NULL DEFAULT '' AFTER `order_status`;
+
+ALTER TABLE `notification_events_queue`
+    MODIFY COLUMN `order_id` int,
+    ADD COLUMN `notification_type` varchar(64) NOT NULL DEFAULT '' AFTER `order_id`,
+    ADD COLUMN `payload` json AFTER `notification_type`;
+
+UPDATE `notification_states` SET `notification_type` = CONCAT('order.', `order_status`);
+UPDATE `notification_events_queue` SET `notification_type` = CONCAT('order.', `order_status`);
@@ -0,0 +1,4 @@
+ALTER TABLE `outbox_events`
+    DROP COLUMN `parked_at`,
+    DROP COLUMN `last_error`,
+    DROP COLUMN `attempts`;
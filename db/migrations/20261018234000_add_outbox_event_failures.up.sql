@@ -0,0 +1,6 @@
+-- an event that keeps failing is parked after a number of attempts so that it
+-- no longer holds up the events behind it; clearing parked_at retries it
+ALTER TABLE `outbox_events`
+    ADD COLUMN `attempts` int NOT NULL DEFAULT 0 AFTER `payload`,
+    ADD COLUMN `last_error` varchar(512) NOT NULL DEFAULT '' AFTER `attempts`,
+    ADD COLUMN `parked_at` datetime AFTER `published_at`;
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/token"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultAuthCacheTTL = 30 * time.Second
+
+var (
+	errNoSession      = errors.New("token has no session")
+	errSessionRevoked = errors.New("session revoked")
+	errSessionRotated = errors.New("session rotated")
+	errUserNotFound   = errors.New("user not found")
+)
+
+// authCache remembers for ttl whether sessions are live and whether users
+// exist and are admins, so that the auth middleware doesn't call the gRPC
+// service on every request. Handlers that revoke sessions or change users
+// forget what they invalidate, but only in this instance's cache: a session
+// revoked or a user demoted or deleted through another instance or directly
+// in the gRPC service keeps being served from the cache for up to ttl. A ttl
+// of 0 disables the cache.
+type authCache struct {
+	client pb.EcommClient
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	sessions  map[string]cachedSession
+	users     map[string]cachedUser
+	lastSweep time.Time
+}
+
+type cachedSession struct {
+	email     string
+	revoked   bool
+	rotated   bool
+	expiresAt time.Time
+}
+
+type cachedUser struct {
+	id        int64
+	found     bool
+	isAdmin   bool
+	expiresAt time.Time
+}
+
+func newAuthCache(client pb.EcommClient, ttl time.Duration) *authCache {
+	return &authCache{
+		client:   client,
+		ttl:      ttl,
+		sessions: make(map[string]cachedSession),
+		users:    make(map[string]cachedUser),
+	}
+}
+
+// check reports whether the user of claims is an admin, or an error when the
+// session of claims is revoked or rotated, or the user is gone. Renewing
+// rotates a session, which retires the access tokens issued for it.
+func (c *authCache) check(ctx context.Context, claims *token.UserClaims) (bool, error) {
+	if claims.SessionID == "" {
+		return false, errNoSession
+	}
+
+	sess, err := c.session(ctx, claims.SessionID)
+	if err != nil {
+		return false, err
+	}
+	if sess.revoked || sess.email != claims.Email {
+		return false, errSessionRevoked
+	}
+	if sess.rotated {
+		return false, errSessionRotated
+	}
+
+	u, err := c.user(ctx, claims.Email)
+	if err != nil {
+		return false, err
+	}
+	if !u.found || u.id != claims.ID {
+		return false, errUserNotFound
+	}
+
+	return u.isAdmin, nil
+}
+
+func (c *authCache) session(ctx context.Context, id string) (cachedSession, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	sess, ok := c.sessions[id]
+	c.mu.Unlock()
+	if ok && now.Before(sess.expiresAt) {
+		return sess, nil
+	}
+
+	// logging out deletes the session
+	sr, err := c.client.GetSession(ctx, &pb.SessionReq{Id: id})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return cachedSession{}, err
+	}
+	sess = cachedSession{
+		email:     sr.GetUserEmail(),
+		revoked:   err != nil || sr.GetIsRevoked(),
+		rotated:   sr.GetRotatedAt() != nil,
+		expiresAt: now.Add(c.ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+	c.sessions[id] = sess
+
+	return sess, nil
+}
+
+func (c *authCache) user(ctx context.Context, email string) (cachedUser, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	u, ok := c.users[email]
+	c.mu.Unlock()
+	if ok && now.Before(u.expiresAt) {
+		return u, nil
+	}
+
+	ur, err := c.client.GetUser(ctx, &pb.UserReq{Email: email})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return cachedUser{}, err
+	}
+	u = cachedUser{
+		id:        ur.GetId(),
+		found:     err == nil,
+		isAdmin:   ur.GetIsAdmin(),
+		expiresAt: now.Add(c.ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+	c.users[email] = u
+
+	return u, nil
+}
+
+// sweep drops expired entries at most once per ttl. c.mu must be held.
+func (c *authCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+
+	for id, sess := range c.sessions {
+		if !now.Before(sess.expiresAt) {
+			delete(c.sessions, id)
+		}
+	}
+	for email, u := range c.users {
+		if !now.Before(u.expiresAt) {
+			delete(c.users, email)
+		}
+	}
+}
+
+func (c *authCache) forgetSession(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.sessions, id)
+}
+
+// forgetUser forgets the user with email along with all of their sessions.
+func (c *authCache) forgetUser(email string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.users, email)
+	for id, sess := range c.sessions {
+		if sess.email == email {
+			delete(c.sessions, id)
+		}
+	}
+}
+
+// forgetUserID is forgetUser for callers that only know the user ID.
+func (c *authCache) forgetUserID(id int64) {
+	c.mu.Lock()
+	var emails []string
+	for email, u := range c.users {
+		if u.id == id {
+			emails = append(emails, email)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, email := range emails {
+		c.forgetUser(email)
+	}
+}
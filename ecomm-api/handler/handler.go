@@ -3,6 +3,10 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -11,23 +15,59 @@ import (
 	"github.com/dhij/ecomm/token"
 	"github.com/dhij/ecomm/util"
 	"github.com/go-chi/chi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// emailChannel is the notification channel unsubscribe links turn off.
+const emailChannel = "email"
+
 type handler struct {
-	ctx        context.Context
-	client     pb.EcommClient
-	TokenMaker *token.JWTMaker
+	ctx          context.Context
+	client       pb.EcommClient
+	TokenMaker   token.Maker
+	unsubMaker   *token.JWTMaker
+	authCacheTTL time.Duration
+	auth         *authCache
 }
 
-func NewHandler(client pb.EcommClient, secretKey string) *handler {
-	return &handler{
-		ctx:        context.Background(),
-		client:     client,
-		TokenMaker: token.NewJWTMaker(secretKey),
+type Option func(*handler)
+
+// WithTokenMaker signs and verifies access and refresh tokens with maker
+// instead of HS256 and the secret key. Unsubscribe links are still signed
+// with the secret key.
+func WithTokenMaker(maker token.Maker) Option {
+	return func(h *handler) {
+		h.TokenMaker = maker
 	}
 }
 
+// WithAuthCacheTTL sets how long the auth middleware trusts what it learnt
+// about a session or user, which bounds how long a change made by another
+// instance takes to apply. Zero checks on every request.
+func WithAuthCacheTTL(ttl time.Duration) Option {
+	return func(h *handler) {
+		h.authCacheTTL = ttl
+	}
+}
+
+func NewHandler(client pb.EcommClient, secretKey string, opts ...Option) *handler {
+	h := &handler{
+		ctx:          context.Background(),
+		client:       client,
+		TokenMaker:   token.NewJWTMaker(secretKey),
+		unsubMaker:   token.NewJWTMaker(secretKey),
+		authCacheTTL: defaultAuthCacheTTL,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.auth = newAuthCache(client, h.authCacheTTL)
+
+	return h
+}
+
 func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
 	var p ProductReq
 	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
@@ -35,19 +75,635 @@ func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := h.client.CreateProduct(h.ctx, toPBProductReq(p))
+	product, err := h.client.CreateProduct(h.ctx, toPBProductReq(p))
+	if err != nil {
+		http.Error(w, "error creating product", http.StatusInternalServerError)
+		return
+	}
+
+	res := toProductRes(product)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) getProduct(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	product, err := h.client.GetProduct(h.ctx, &pb.ProductReq{Id: i})
+	if err != nil {
+		http.Error(w, "error getting product", http.StatusInternalServerError)
+		return
+	}
+
+	res := toProductRes(product)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) listProducts(w http.ResponseWriter, r *http.Request) {
+	req, err := toPBListProductsReq(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lpr, err := h.client.ListProducts(h.ctx, req)
+	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "error listing products", http.StatusInternalServerError)
+		return
+	}
+
+	res := ListProductRes{
+		Products:   []ProductRes{},
+		NextCursor: lpr.GetNextPageToken(),
+	}
+	for _, p := range lpr.GetProducts() {
+		res.Products = append(res.Products, toProductRes(p))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) updateProduct(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	var p ProductReq
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+	p.ID = i
+
+	updated, err := h.client.UpdateProduct(h.ctx, toPBProductReq(p))
+	if err != nil {
+		http.Error(w, "error updating product", http.StatusInternalServerError)
+		return
+	}
+
+	res := toProductRes(updated)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) deleteProduct(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.client.DeleteProduct(h.ctx, &pb.ProductReq{Id: i})
+	if err != nil {
+		http.Error(w, "error deleting product", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listReviews(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	pp, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reviews, err := h.client.ListReviews(h.ctx, &pb.ListReviewsReq{
+		ProductId: productID,
+		PageSize:  pp.limit,
+		PageToken: pp.cursor,
+		Sort:      pp.sort,
+	})
+	if err != nil {
+		writeReviewError(w, err, "error listing reviews")
+		return
+	}
+
+	res := ListReviewRes{
+		Reviews:    []ReviewRes{},
+		NextCursor: reviews.GetNextPageToken(),
+	}
+	for _, rv := range reviews.GetReviews() {
+		res.Reviews = append(res.Reviews, toReviewRes(rv))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) createReview(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	productID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	var rv ReviewReq
+	if err := json.NewDecoder(r.Body).Decode(&rv); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	review, err := h.client.CreateReview(h.ctx, &pb.ReviewReq{
+		ProductId: productID,
+		UserId:    claims.ID,
+		Stars:     rv.Stars,
+		Title:     rv.Title,
+		Body:      rv.Body,
+	})
+	if err != nil {
+		writeReviewError(w, err, "error creating review")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toReviewRes(review))
+}
+
+func (h *handler) updateReview(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	productID, reviewID, err := parseReviewPath(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rv ReviewReq
+	if err := json.NewDecoder(r.Body).Decode(&rv); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	review, err := h.client.UpdateReview(h.ctx, &pb.ReviewReq{
+		Id:        reviewID,
+		ProductId: productID,
+		UserId:    claims.ID,
+		Stars:     rv.Stars,
+		Title:     rv.Title,
+		Body:      rv.Body,
+	})
+	if err != nil {
+		writeReviewError(w, err, "error updating review")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toReviewRes(review))
+}
+
+func (h *handler) deleteReview(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	productID, reviewID, err := parseReviewPath(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.client.DeleteReview(h.ctx, &pb.ReviewReq{
+		Id:        reviewID,
+		ProductId: productID,
+		UserId:    claims.ID,
+		IsAdmin:   claims.IsAdmin,
+	})
+	if err != nil {
+		writeReviewError(w, err, "error deleting review")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseReviewPath(r *http.Request) (int64, int64, error) {
+	productID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("error parsing ID")
+	}
+
+	reviewID, err := strconv.ParseInt(chi.URLParam(r, "reviewID"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("error parsing review ID")
+	}
+
+	return productID, reviewID, nil
+}
+
+func writeReviewError(w http.ResponseWriter, err error, msg string) {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+	case codes.NotFound:
+		http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+	case codes.PermissionDenied:
+		http.Error(w, status.Convert(err).Message(), http.StatusForbidden)
+	case codes.AlreadyExists:
+		http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+	default:
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) createOrder(w http.ResponseWriter, r *http.Request) {
+	var o OrderReq
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	po := toPBOrderReq(o)
+	po.UserId = claims.ID
+	po.UserEmail = claims.Email
+
+	created, err := h.client.CreateOrder(h.ctx, po)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		case codes.FailedPrecondition:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	res := toOrderRes(created)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) getOrder(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	id := chi.URLParam(r, "id")
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.client.GetOrder(h.ctx, &pb.GetOrderReq{
+		Id:      i,
+		UserId:  claims.ID,
+		IsAdmin: claims.IsAdmin,
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	res := toOrderRes(order)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) listMyOrders(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	pp, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	orders, err := h.client.ListOrdersForUser(h.ctx, &pb.ListOrdersForUserReq{
+		UserId:    claims.ID,
+		PageSize:  pp.limit,
+		PageToken: pp.cursor,
+		Sort:      pp.sort,
+	})
+	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	res := ListOrderRes{
+		Orders:     []OrderRes{},
+		NextCursor: orders.GetNextPageToken(),
+	}
+	for _, o := range orders.GetOrders() {
+		res.Orders = append(res.Orders, toOrderRes(o))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) listOrders(w http.ResponseWriter, r *http.Request) {
+	req, err := toPBListOrdersReq(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	orders, err := h.client.ListOrders(h.ctx, req)
+	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	res := ListOrderRes{
+		Orders:     []OrderRes{},
+		NextCursor: orders.GetNextPageToken(),
+	}
+	for _, o := range orders.GetOrders() {
+		res.Orders = append(res.Orders, toOrderRes(o))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) updateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	var o OrderReq
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	orderStatus, err := toPBOrderStatus(OrderStatus(o.Status))
+	if err != nil {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.client.UpdateOrderStatus(h.ctx, &pb.UpdateOrderStatusReq{
+		Id:      o.ID,
+		Status:  orderStatus,
+		UserId:  claims.ID,
+		IsAdmin: claims.IsAdmin,
+		Reason:  o.Reason,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+			return
+		case codes.PermissionDenied:
+			http.Error(w, status.Convert(err).Message(), http.StatusForbidden)
+			return
+		case codes.FailedPrecondition:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to update order status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toOrderRes(res))
+}
+
+func (h *handler) getOrderHistory(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	id := chi.URLParam(r, "id")
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.client.ListOrderStatusHistory(h.ctx, &pb.GetOrderReq{
+		Id:      i,
+		UserId:  claims.ID,
+		IsAdmin: claims.IsAdmin,
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	res := OrderHistoryRes{
+		Changes: toOrderStatusChanges(history.GetChanges()),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) deleteOrder(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = h.client.DeleteOrder(h.ctx, &pb.OrderReq{
+		Id: i,
+	})
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) getCart(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	cart, err := h.client.GetCart(h.ctx, &pb.CartReq{UserId: claims.ID})
+	if err != nil {
+		http.Error(w, "error getting cart", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toCartRes(cart))
+}
+
+func (h *handler) addToCart(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	var ci CartItemReq
+	if err := json.NewDecoder(r.Body).Decode(&ci); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	cart, err := h.client.AddToCart(h.ctx, &pb.CartReq{
+		UserId:    claims.ID,
+		ProductId: ci.ProductID,
+		Quantity:  ci.Quantity,
+	})
+	if err != nil {
+		writeCartError(w, err, "error adding to cart")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toCartRes(cart))
+}
+
+func (h *handler) updateCartItem(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	productID, err := strconv.ParseInt(chi.URLParam(r, "productID"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing product ID", http.StatusBadRequest)
+		return
+	}
+
+	var ci CartItemReq
+	if err := json.NewDecoder(r.Body).Decode(&ci); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	cart, err := h.client.UpdateCartItem(h.ctx, &pb.CartReq{
+		UserId:    claims.ID,
+		ProductId: productID,
+		Quantity:  ci.Quantity,
+	})
+	if err != nil {
+		writeCartError(w, err, "error updating cart item")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toCartRes(cart))
+}
+
+func (h *handler) removeCartItem(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	productID, err := strconv.ParseInt(chi.URLParam(r, "productID"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing product ID", http.StatusBadRequest)
+		return
+	}
+
+	cart, err := h.client.RemoveCartItem(h.ctx, &pb.CartReq{
+		UserId:    claims.ID,
+		ProductId: productID,
+	})
+	if err != nil {
+		writeCartError(w, err, "error removing cart item")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toCartRes(cart))
+}
+
+func (h *handler) checkout(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	var c CheckoutReq
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.client.Checkout(h.ctx, &pb.CheckoutReq{
+		UserId:        claims.ID,
+		UserEmail:     claims.Email,
+		PaymentMethod: c.PaymentMethod,
+	})
+	if err != nil {
+		writeCartError(w, err, "error checking out")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toOrderRes(order))
+}
+
+func writeCartError(w http.ResponseWriter, err error, msg string) {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+	case codes.NotFound:
+		http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+	case codes.FailedPrecondition, codes.Aborted:
+		http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+	default:
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) listNotifications(w http.ResponseWriter, r *http.Request) {
+	req, err := toPBListNotificationStatesReq(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	states, err := h.client.ListNotificationStates(h.ctx, req)
 	if err != nil {
-		http.Error(w, "error creating product", http.StatusInternalServerError)
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "error listing notifications", http.StatusInternalServerError)
 		return
 	}
 
-	res := toProductRes(product)
+	res := ListNotificationStateRes{
+		Notifications: []NotificationStateRes{},
+		NextCursor:    states.GetNextPageToken(),
+	}
+	for _, ns := range states.GetStates() {
+		res.Notifications = append(res.Notifications, toNotificationStateRes(ns))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(res)
 }
 
-func (h *handler) getProduct(w http.ResponseWriter, r *http.Request) {
+func (h *handler) getNotification(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	i, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
@@ -55,181 +711,329 @@ func (h *handler) getProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := h.client.GetProduct(h.ctx, &pb.ProductReq{Id: i})
+	ns, err := h.client.GetNotificationState(h.ctx, &pb.NotificationStateReq{Id: i})
 	if err != nil {
-		http.Error(w, "error getting product", http.StatusInternalServerError)
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "error getting notification", http.StatusInternalServerError)
 		return
 	}
 
-	res := toProductRes(product)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(toNotificationStateRes(ns))
 }
 
-func (h *handler) listProducts(w http.ResponseWriter, r *http.Request) {
-	lpr, err := h.client.ListProducts(h.ctx, &pb.ProductReq{})
+// replayNotifications puts failed notifications back on the queue. The admin
+// making the request is recorded against each of them.
+func (h *handler) replayNotifications(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	var req ReplayNotificationsReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
+
+	replayed, err := h.client.ReplayNotifications(h.ctx, &pb.ReplayNotificationsReq{
+		Ids:     req.IDs,
+		ActorId: claims.ID,
+	})
 	if err != nil {
-		http.Error(w, "error listing products", http.StatusInternalServerError)
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		case codes.NotFound:
+			http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+			return
+		case codes.FailedPrecondition:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "error replaying notifications", http.StatusInternalServerError)
 		return
 	}
 
-	var res []ProductRes
-	for _, p := range lpr.GetProducts() {
-		res = append(res, toProductRes(p))
+	res := ReplayNotificationsRes{
+		Replayed: []int64{},
+	}
+	for _, ev := range replayed.GetEvents() {
+		res.Replayed = append(res.Replayed, ev.GetStateId())
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
 
-func (h *handler) updateProduct(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	i, err := strconv.ParseInt(id, 10, 64)
+func (h *handler) getNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	prefs, err := h.client.GetNotificationPreferences(h.ctx, &pb.NotificationPreferencesReq{UserId: claims.ID})
 	if err != nil {
-		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "error getting notification preferences", http.StatusInternalServerError)
 		return
 	}
 
-	var p ProductReq
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toNotificationPreferencesRes(prefs))
+}
+
+// updateNotificationPreferences turns the given types of notifications on or
+// off for the logged in user and returns all of their preferences.
+func (h *handler) updateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	var req NotificationPreferencesReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "error decoding request body", http.StatusBadRequest)
 		return
 	}
-	p.ID = i
 
-	updated, err := h.client.UpdateProduct(h.ctx, toPBProductReq(p))
+	prefs, err := h.client.UpdateNotificationPreferences(h.ctx, &pb.UpdateNotificationPreferencesReq{
+		UserId:      claims.ID,
+		Preferences: toPBNotificationPreferences(req.Preferences),
+	})
 	if err != nil {
-		http.Error(w, "error updating product", http.StatusInternalServerError)
+		writeNotificationPreferencesError(w, err, "error updating notification preferences")
 		return
 	}
 
-	res := toProductRes(updated)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(toNotificationPreferencesRes(prefs))
 }
 
-func (h *handler) deleteProduct(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	i, err := strconv.ParseInt(id, 10, 64)
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>Stop receiving {{.Type}} emails?</p>
+<button type="submit">Unsubscribe</button>
+</form>
+</body>
+</html>
+`))
+
+// confirmUnsubscribe answers the unsubscribe link in the email body with a
+// page that asks to confirm. Following the link changes nothing, so that link
+// scanners of mail providers don't unsubscribe anyone.
+func (h *handler) confirmUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	tokenStr := r.URL.Query().Get("token")
+	claims, err := h.unsubMaker.VerifyUnsubscribeToken(tokenStr)
 	if err != nil {
-		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		http.Error(w, "invalid or expired unsubscribe link", http.StatusBadRequest)
 		return
 	}
 
-	_, err = h.client.DeleteProduct(h.ctx, &pb.ProductReq{Id: i})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	unsubscribePage.Execute(w, struct{ Token, Type string }{tokenStr, claims.Type})
+}
+
+// unsubscribe turns off email for the type of notification in a signed
+// unsubscribe token. It needs no login: the token is the proof. It answers
+// the confirmation page, which posts the token as a form field, and one-click
+// unsubscribes by mail clients, which post to the link itself.
+func (h *handler) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.unsubMaker.VerifyUnsubscribeToken(r.FormValue("token"))
 	if err != nil {
-		http.Error(w, "error deleting product", http.StatusInternalServerError)
+		http.Error(w, "invalid or expired unsubscribe link", http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	_, err = h.client.UpdateNotificationPreferences(h.ctx, &pb.UpdateNotificationPreferencesReq{
+		UserId: claims.UserID,
+		Preferences: []*pb.NotificationPreference{
+			{Type: claims.Type, Channel: emailChannel, Enabled: false},
+		},
+	})
+	if err != nil {
+		writeNotificationPreferencesError(w, err, "error unsubscribing")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "You have been unsubscribed from %s emails.\n", claims.Type)
 }
 
-func (h *handler) createOrder(w http.ResponseWriter, r *http.Request) {
-	var o OrderReq
-	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
-		return
+func writeNotificationPreferencesError(w http.ResponseWriter, err error, msg string) {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+	case codes.NotFound:
+		http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+	default:
+		http.Error(w, msg, http.StatusInternalServerError)
 	}
+}
 
-	claims := r.Context().Value(authKey{}).(*token.UserClaims)
-	po := toPBOrderReq(o)
-	po.UserId = claims.ID
-	po.UserEmail = claims.Email
+func (h *handler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "error decoding request body", http.StatusBadRequest)
+		return
+	}
 
-	created, err := h.client.CreateOrder(h.ctx, po)
+	created, err := h.client.CreateWebhook(h.ctx, toPBWebhookReq(0, req))
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeWebhookError(w, err, "error creating webhook")
 		return
 	}
 
-	res := toOrderRes(created)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(toWebhookRes(created))
 }
 
-func (h *handler) getOrder(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value(authKey{}).(*token.UserClaims)
-
-	order, err := h.client.GetOrder(h.ctx, &pb.OrderReq{
-		UserId: claims.ID,
-	})
+func (h *handler) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.client.ListWebhooks(h.ctx, &pb.ListWebhooksReq{})
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		http.Error(w, "error listing webhooks", http.StatusInternalServerError)
 		return
 	}
 
-	res := toOrderRes(order)
+	res := ListWebhookRes{Webhooks: []WebhookRes{}}
+	for _, wr := range webhooks.GetWebhooks() {
+		res.Webhooks = append(res.Webhooks, toWebhookRes(wr))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
-func (h *handler) listOrders(w http.ResponseWriter, r *http.Request) {
-	orders, err := h.client.ListOrders(h.ctx, &pb.OrderReq{})
+func (h *handler) getWebhook(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
 		return
 	}
 
-	var res []OrderRes
-	for _, o := range orders.Orders {
-		res = append(res, toOrderRes(o))
+	wr, err := h.client.GetWebhook(h.ctx, &pb.WebhookReq{Id: i})
+	if err != nil {
+		writeWebhookError(w, err, "error getting webhook")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(toWebhookRes(wr))
 }
 
-func (h *handler) updateOrderStatus(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+func (h *handler) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
 
-	var o OrderReq
-	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+	var req WebhookReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "error decoding request body", http.StatusBadRequest)
 		return
 	}
 
-	status, err := toPBOrderStatus(OrderStatus(o.Status))
+	updated, err := h.client.UpdateWebhook(h.ctx, toPBWebhookReq(i, req))
 	if err != nil {
-		http.Error(w, "invalid status", http.StatusBadRequest)
+		writeWebhookError(w, err, "error updating webhook")
 		return
 	}
 
-	res, err := h.client.UpdateOrderStatus(h.ctx, &pb.OrderReq{
-		Id:        o.ID,
-		UserId:    claims.ID,
-		UserEmail: claims.Email,
-		Status:    status,
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toWebhookRes(updated))
+}
+
+func (h *handler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.client.DeleteWebhook(h.ctx, &pb.WebhookReq{Id: i})
+	if err != nil {
+		writeWebhookError(w, err, "error deleting webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+
+	pp, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.client.ListWebhookDeliveries(h.ctx, &pb.ListWebhookDeliveriesReq{
+		WebhookId: i,
+		PageSize:  pp.limit,
+		PageToken: pp.cursor,
+		Sort:      pp.sort,
 	})
 	if err != nil {
-		http.Error(w, "failed to update order status", http.StatusInternalServerError)
+		writeWebhookError(w, err, "error listing webhook deliveries")
 		return
 	}
 
+	res := ListWebhookDeliveryRes{
+		Deliveries: []WebhookDeliveryRes{},
+		NextCursor: deliveries.GetNextPageToken(),
+	}
+	for _, d := range deliveries.GetDeliveries() {
+		res.Deliveries = append(res.Deliveries, toWebhookDeliveryRes(d))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
-func (h *handler) deleteOrder(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	i, err := strconv.ParseInt(id, 10, 64)
+func (h *handler) getWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
-		panic(err)
+		http.Error(w, "error parsing ID", http.StatusBadRequest)
+		return
+	}
+	deliveryID, err := strconv.ParseInt(chi.URLParam(r, "deliveryID"), 10, 64)
+	if err != nil {
+		http.Error(w, "error parsing delivery ID", http.StatusBadRequest)
+		return
 	}
 
-	_, err = h.client.DeleteOrder(h.ctx, &pb.OrderReq{
-		Id: i,
-	})
+	d, err := h.client.GetWebhookDelivery(h.ctx, &pb.WebhookDeliveryReq{Id: deliveryID})
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeWebhookError(w, err, "error getting webhook delivery")
+		return
+	}
+	if d.GetWebhookId() != i {
+		http.Error(w, fmt.Sprintf("webhook delivery %d not found", deliveryID), http.StatusNotFound)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toWebhookDeliveryRes(d))
+}
+
+func writeWebhookError(w http.ResponseWriter, err error, msg string) {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+	case codes.NotFound:
+		http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+	default:
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
 }
 
 func (h *handler) createUser(w http.ResponseWriter, r *http.Request) {
@@ -249,6 +1053,10 @@ func (h *handler) createUser(w http.ResponseWriter, r *http.Request) {
 
 	created, err := h.client.CreateUser(h.ctx, toPBUserReq(u))
 	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "error creating user", http.StatusInternalServerError)
 		return
 	}
@@ -260,13 +1068,26 @@ func (h *handler) createUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *handler) listUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.client.ListUsers(h.ctx, &pb.UserReq{})
+	req, err := toPBListUsersReq(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, err := h.client.ListUsers(h.ctx, req)
 	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "error listing users", http.StatusInternalServerError)
 		return
 	}
 
-	var res ListUserRes
+	res := ListUserRes{
+		Users:      []UserRes{},
+		NextCursor: users.GetNextPageToken(),
+	}
 	for _, u := range users.GetUsers() {
 		res.Users = append(res.Users, toUserRes(u))
 	}
@@ -287,9 +1108,14 @@ func (h *handler) updateUser(w http.ResponseWriter, r *http.Request) {
 
 	updated, err := h.client.UpdateUser(h.ctx, toPBUserReq(u))
 	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "error updating user", http.StatusInternalServerError)
 		return
 	}
+	h.auth.forgetUser(claims.Email)
 
 	res := toUserRes(updated)
 	w.Header().Set("Content-Type", "application/json")
@@ -312,6 +1138,56 @@ func (h handler) deleteUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "error deleting user", http.StatusInternalServerError)
 		return
 	}
+	h.auth.forgetUserID(i)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forgotPassword emails a password reset link to the user. It accepts any
+// email so that it can't be used to find out who has an account.
+func (h *handler) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.client.RequestPasswordReset(h.ctx, &pb.RequestPasswordResetReq{
+		Email: req.Email,
+	})
+	if err != nil {
+		http.Error(w, "error requesting password reset", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.client.ResetPassword(h.ctx, &pb.ResetPasswordReq{
+		Token:    req.Token,
+		Password: req.Password,
+	})
+	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "error resetting password", http.StatusInternalServerError)
+		return
+	}
+	// the user's sessions were revoked along with the old password
+	h.auth.forgetUser(res.GetUserEmail())
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -337,25 +1213,28 @@ func (h *handler) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// create a json web token (JWT) and return it as response
-	accessToken, accessClaims, err := h.TokenMaker.CreateToken(ur.GetId(), ur.GetEmail(), ur.GetIsAdmin(), 15*time.Minute)
+	// create json web tokens (JWT) and return them as response; the refresh
+	// token starts the session the access token belongs to
+	refreshToken, refreshClaims, err := h.TokenMaker.CreateToken(ur.GetId(), ur.GetEmail(), ur.GetIsAdmin(), "", 24*time.Hour)
 	if err != nil {
 		http.Error(w, "error creating token", http.StatusInternalServerError)
 		return
 	}
 
-	refreshToken, refreshClaims, err := h.TokenMaker.CreateToken(ur.GetId(), ur.GetEmail(), ur.GetIsAdmin(), 24*time.Hour)
+	accessToken, accessClaims, err := h.TokenMaker.CreateToken(ur.GetId(), ur.GetEmail(), ur.GetIsAdmin(), refreshClaims.SessionID, 15*time.Minute)
 	if err != nil {
 		http.Error(w, "error creating token", http.StatusInternalServerError)
 		return
 	}
 
 	session, err := h.client.CreateSession(h.ctx, &pb.SessionReq{
-		Id:           refreshClaims.RegisteredClaims.ID,
+		Id:           refreshClaims.SessionID,
 		UserEmail:    ur.GetEmail(),
 		RefreshToken: refreshToken,
 		IsRevoked:    false,
 		ExpiresAt:    timestamppb.New(refreshClaims.RegisteredClaims.ExpiresAt.Time),
+		UserAgent:    r.UserAgent(),
+		ClientIp:     clientIP(r),
 	})
 	if err != nil {
 		http.Error(w, "error creating session", http.StatusInternalServerError)
@@ -378,14 +1257,19 @@ func (h *handler) loginUser(w http.ResponseWriter, r *http.Request) {
 
 func (h *handler) logoutUser(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	if claims.SessionID == "" {
+		http.Error(w, "token has no session", http.StatusUnauthorized)
+		return
+	}
 
 	_, err := h.client.DeleteSession(h.ctx, &pb.SessionReq{
-		Id: claims.RegisteredClaims.ID,
+		Id: claims.SessionID,
 	})
 	if err != nil {
 		http.Error(w, "error deleting session", http.StatusInternalServerError)
 		return
 	}
+	h.auth.forgetSession(claims.SessionID)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -398,21 +1282,36 @@ func (h *handler) renewAccessToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	refreshClaims, err := h.TokenMaker.VerifyToken(req.RefreshToken)
-	if err != nil {
+	if err != nil || refreshClaims.TokenType() != token.RefreshToken {
 		http.Error(w, "error verifying token", http.StatusUnauthorized)
 		return
 	}
 
-	session, err := h.client.GetSession(h.ctx, &pb.SessionReq{
-		Id: refreshClaims.RegisteredClaims.ID,
-	})
+	// rotate the session so that the presented refresh token can't be used
+	// again; presenting it twice revokes every session in its family
+	nextToken, nextClaims, err := h.TokenMaker.CreateToken(refreshClaims.ID, refreshClaims.Email, refreshClaims.IsAdmin, "", 24*time.Hour)
 	if err != nil {
-		http.Error(w, "error getting session", http.StatusInternalServerError)
+		http.Error(w, "error creating token", http.StatusInternalServerError)
 		return
 	}
 
-	if session.IsRevoked {
-		http.Error(w, "session revoked", http.StatusUnauthorized)
+	session, err := h.client.RotateSession(h.ctx, &pb.RotateSessionReq{
+		Id:           refreshClaims.RegisteredClaims.ID,
+		NextId:       nextClaims.SessionID,
+		RefreshToken: nextToken,
+		ExpiresAt:    timestamppb.New(nextClaims.RegisteredClaims.ExpiresAt.Time),
+		UserAgent:    r.UserAgent(),
+		ClientIp:     clientIP(r),
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unauthenticated, codes.NotFound:
+			// reusing the token may have revoked the whole family
+			h.auth.forgetUser(refreshClaims.Email)
+			http.Error(w, "invalid session", http.StatusUnauthorized)
+		default:
+			http.Error(w, "error rotating session", http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -420,33 +1319,138 @@ func (h *handler) renewAccessToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid session", http.StatusUnauthorized)
 		return
 	}
+	// access tokens of the rotated session stop working now
+	h.auth.forgetSession(refreshClaims.RegisteredClaims.ID)
 
-	accessToken, accessClaims, err := h.TokenMaker.CreateToken(refreshClaims.ID, refreshClaims.Email, refreshClaims.IsAdmin, 15*time.Minute)
+	accessToken, accessClaims, err := h.TokenMaker.CreateToken(refreshClaims.ID, refreshClaims.Email, refreshClaims.IsAdmin, nextClaims.SessionID, 15*time.Minute)
 	if err != nil {
 		http.Error(w, "error creating token", http.StatusInternalServerError)
 		return
 	}
 
 	res := RenewAccessTokenRes{
-		AccessToken:          accessToken,
-		AccessTokenExpiresAt: accessClaims.RegisteredClaims.ExpiresAt.Time,
+		SessionID:             session.GetId(),
+		AccessToken:           accessToken,
+		RefreshToken:          nextToken,
+		AccessTokenExpiresAt:  accessClaims.RegisteredClaims.ExpiresAt.Time,
+		RefreshTokenExpiresAt: nextClaims.RegisteredClaims.ExpiresAt.Time,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// jwksPublisher is a token.Maker whose tokens can be verified with public
+// keys.
+type jwksPublisher interface {
+	JWKS() token.JWKS
+}
+
+// getJWKS publishes the keys access tokens are signed with, none when they are
+// signed with the secret key.
+func (h *handler) getJWKS(w http.ResponseWriter, r *http.Request) {
+	res := token.JWKS{Keys: []token.JWK{}}
+	if p, ok := h.TokenMaker.(jwksPublisher); ok {
+		res = p.JWKS()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
 
 func (h *handler) revokeSession(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	if claims.SessionID == "" {
+		http.Error(w, "token has no session", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := h.client.RevokeSession(h.ctx, &pb.SessionReq{
+		Id:        claims.SessionID,
+		UserEmail: claims.Email,
+	})
+	if err != nil {
+		writeSessionError(w, err, "error revoking session")
+		return
+	}
+	h.auth.forgetSession(claims.SessionID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listMySessions(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	lsr, err := h.client.ListSessionsForUser(h.ctx, &pb.ListSessionsReq{
+		UserEmail: claims.Email,
+	})
+	if err != nil {
+		http.Error(w, "error listing sessions", http.StatusInternalServerError)
+		return
+	}
+
+	res := ListSessionRes{Sessions: []SessionRes{}}
+	for _, sr := range lsr.GetSessions() {
+		res.Sessions = append(res.Sessions, toSessionRes(sr, claims.SessionID))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) revokeMySession(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
 
+	id := chi.URLParam(r, "id")
 	_, err := h.client.RevokeSession(h.ctx, &pb.SessionReq{
-		Id: claims.RegisteredClaims.ID,
+		Id:        id,
+		UserEmail: claims.Email,
+	})
+	if err != nil {
+		writeSessionError(w, err, "error revoking session")
+		return
+	}
+	h.auth.forgetSession(id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) revokeAllMySessions(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+
+	_, err := h.client.RevokeAllSessions(h.ctx, &pb.RevokeAllSessionsReq{
+		UserEmail: claims.Email,
 	})
 	if err != nil {
-		http.Error(w, "error revoking session", http.StatusInternalServerError)
+		writeSessionError(w, err, "error revoking sessions")
 		return
 	}
+	h.auth.forgetUser(claims.Email)
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func writeSessionError(w http.ResponseWriter, err error, msg string) {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+	case codes.NotFound:
+		http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+	default:
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+// clientIP is the address the request came from, without its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
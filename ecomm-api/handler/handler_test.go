@@ -0,0 +1,518 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/ecomm-grpc/pricing"
+	"github.com/dhij/ecomm/ecomm-grpc/server"
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+	"github.com/dhij/ecomm/money"
+	"github.com/dhij/ecomm/token"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestHandler serves the routes against an in-process gRPC server backed
+// by a memory storer.
+func newTestHandler(t *testing.T, opts ...Option) (http.Handler, *storer.MemoryStorer) {
+	st := storer.NewMemoryStorer()
+	pricer := pricing.NewPricer(pricing.FlatRateTax{}, pricing.FlatShipping{Price: money.New(0, money.DefaultCurrency)})
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterEcommServer(srv, server.NewServer(st, pricer))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return RegisterRoutes(NewHandler(pb.NewEcommClient(conn), "secret", opts...)), st
+}
+
+func do(t *testing.T, h http.Handler, method, path, accessToken string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buf).Encode(body))
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("User-Agent", "handler-test")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func createUser(t *testing.T, h http.Handler, email string) {
+	createUserReq(t, h, UserReq{Name: "test user", Email: email, Password: "password"})
+}
+
+func createUserReq(t *testing.T, h http.Handler, u UserReq) {
+	rec := do(t, h, http.MethodPost, "/users", "", u)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
+
+func login(t *testing.T, h http.Handler) LoginUserRes {
+	createUser(t, h, "test@example.com")
+	return loginAs(t, h, "test@example.com")
+}
+
+func loginAs(t *testing.T, h http.Handler, email string) LoginUserRes {
+	rec := do(t, h, http.MethodPost, "/users/login", "", LoginUserReq{Email: email, Password: "password"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res LoginUserRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	return res
+}
+
+func renew(t *testing.T, h http.Handler, accessToken, refreshToken string) (*httptest.ResponseRecorder, RenewAccessTokenRes) {
+	rec := do(t, h, http.MethodPost, "/tokens/renew", accessToken, RenewAccessTokenReq{RefreshToken: refreshToken})
+
+	var res RenewAccessTokenRes
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	}
+	return rec, res
+}
+
+func TestRenewAccessToken(t *testing.T) {
+	t.Run("rotates refresh token", func(t *testing.T) {
+		h, st := newTestHandler(t)
+		lr := login(t, h)
+
+		rec, rr := renew(t, h, lr.AccessToken, lr.RefreshToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NotEmpty(t, rr.AccessToken)
+		require.NotEmpty(t, rr.RefreshToken)
+		require.NotEqual(t, lr.RefreshToken, rr.RefreshToken)
+		require.NotEqual(t, lr.SessionID, rr.SessionID)
+		require.WithinDuration(t, time.Now().Add(24*time.Hour), rr.RefreshTokenExpiresAt, time.Minute)
+
+		sess, err := st.GetSession(context.Background(), rr.SessionID)
+		require.NoError(t, err)
+		require.Equal(t, lr.SessionID, sess.ParentID)
+		require.Equal(t, lr.SessionID, sess.FamilyID)
+		require.Equal(t, rr.RefreshToken, sess.RefreshToken)
+
+		// the rotated token keeps renewing
+		rec, next := renew(t, h, rr.AccessToken, rr.RefreshToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NotEqual(t, rr.RefreshToken, next.RefreshToken)
+	})
+
+	t.Run("reused token revokes family", func(t *testing.T) {
+		h, st := newTestHandler(t)
+		lr := login(t, h)
+
+		rec, rr := renew(t, h, lr.AccessToken, lr.RefreshToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		rec, _ = renew(t, h, lr.AccessToken, lr.RefreshToken)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		// the newest token belongs to the revoked family too
+		rec, _ = renew(t, h, rr.AccessToken, rr.RefreshToken)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		for _, id := range []string{lr.SessionID, rr.SessionID} {
+			sess, err := st.GetSession(context.Background(), id)
+			require.NoError(t, err)
+			require.True(t, sess.IsRevoked)
+		}
+	})
+
+	t.Run("revoked session", func(t *testing.T) {
+		h, _ := newTestHandler(t)
+		lr := login(t, h)
+
+		rec := do(t, h, http.MethodPost, "/tokens/revoke", lr.AccessToken, nil)
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+		rec, _ = renew(t, h, lr.AccessToken, lr.RefreshToken)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		h, _ := newTestHandler(t)
+		lr := login(t, h)
+
+		rec, _ := renew(t, h, lr.AccessToken, "not-a-token")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		// an access token doesn't renew
+		rec, _ = renew(t, h, lr.AccessToken, lr.AccessToken)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func listSessions(t *testing.T, h http.Handler, accessToken string) []SessionRes {
+	rec := do(t, h, http.MethodGet, "/me/sessions", accessToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res ListSessionRes
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	return res.Sessions
+}
+
+func TestMySessions(t *testing.T) {
+	h, _ := newTestHandler(t)
+	laptop := login(t, h)
+	phone := loginAs(t, h, "test@example.com")
+	createUser(t, h, "other@example.com")
+	other := loginAs(t, h, "other@example.com")
+
+	// the laptop renews, so its device is listed by the new session
+	rec, renewed := renew(t, h, laptop.AccessToken, laptop.RefreshToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	sessions := listSessions(t, h, renewed.AccessToken)
+	require.Len(t, sessions, 2)
+	require.Equal(t, renewed.SessionID, sessions[0].ID)
+	require.True(t, sessions[0].Current)
+	require.Equal(t, "handler-test", sessions[0].UserAgent)
+	require.Equal(t, "192.0.2.1", sessions[0].ClientIP)
+	require.NotNil(t, sessions[0].LastUsedAt)
+	require.Equal(t, phone.SessionID, sessions[1].ID)
+	require.False(t, sessions[1].Current)
+
+	// another user's session can't be revoked
+	rec = do(t, h, http.MethodDelete, "/me/sessions/"+other.SessionID, renewed.AccessToken, nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Len(t, listSessions(t, h, other.AccessToken), 1)
+
+	rec = do(t, h, http.MethodDelete, "/me/sessions/"+phone.SessionID, renewed.AccessToken, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	rec, _ = renew(t, h, phone.AccessToken, phone.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Len(t, listSessions(t, h, renewed.AccessToken), 1)
+
+	rec = do(t, h, http.MethodDelete, "/me/sessions", renewed.AccessToken, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	rec = do(t, h, http.MethodGet, "/me/sessions", renewed.AccessToken, nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Len(t, listSessions(t, h, other.AccessToken), 1)
+}
+
+func TestLogoutUser(t *testing.T) {
+	h, st := newTestHandler(t)
+	lr := login(t, h)
+
+	rec := do(t, h, http.MethodPost, "/users/logout", lr.AccessToken, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	_, err := st.GetSession(context.Background(), lr.SessionID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	rec, _ = renew(t, h, lr.AccessToken, lr.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	h, st := newTestHandler(t)
+	lr := login(t, h)
+	listSessions(t, h, lr.AccessToken)
+
+	// unknown emails get the same answer
+	rec := do(t, h, http.MethodPost, "/users/password/forgot", "", ForgotPasswordReq{Email: "unknown@example.com"})
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	rec = do(t, h, http.MethodPost, "/users/password/forgot", "", ForgotPasswordReq{Email: "test@example.com"})
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+
+	events, err := st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	reset, err := events[0].PasswordReset()
+	require.NoError(t, err)
+
+	rec = do(t, h, http.MethodPost, "/users/password/reset", "", ResetPasswordReq{Token: "unknown", Password: "new password"})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do(t, h, http.MethodPost, "/users/password/reset", "", ResetPasswordReq{Token: reset.Token, Password: "new password"})
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	// sessions from before the reset are signed out right away
+	rec = do(t, h, http.MethodGet, "/me/sessions", lr.AccessToken, nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = do(t, h, http.MethodPost, "/users/login", "", LoginUserReq{Email: "test@example.com", Password: "password"})
+	require.NotEqual(t, http.StatusOK, rec.Code)
+	rec = do(t, h, http.MethodPost, "/users/login", "", LoginUserReq{Email: "test@example.com", Password: "new password"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = do(t, h, http.MethodPost, "/users/password/reset", "", ResetPasswordReq{Token: reset.Token, Password: "another password"})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Run("logged out", func(t *testing.T) {
+		h, _ := newTestHandler(t)
+		lr := login(t, h)
+		listSessions(t, h, lr.AccessToken)
+
+		rec := do(t, h, http.MethodPost, "/users/logout", lr.AccessToken, nil)
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+		rec = do(t, h, http.MethodGet, "/me/sessions", lr.AccessToken, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("revoked device", func(t *testing.T) {
+		h, _ := newTestHandler(t)
+		laptop := login(t, h)
+		phone := loginAs(t, h, "test@example.com")
+		listSessions(t, h, phone.AccessToken)
+
+		rec := do(t, h, http.MethodDelete, "/me/sessions/"+phone.SessionID, laptop.AccessToken, nil)
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+		rec = do(t, h, http.MethodGet, "/me/sessions", phone.AccessToken, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		listSessions(t, h, laptop.AccessToken)
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		h, st := newTestHandler(t)
+		lr := login(t, h)
+		createUserReq(t, h, UserReq{Name: "admin", Email: "admin@example.com", Password: "password", IsAdmin: true})
+		admin := loginAs(t, h, "admin@example.com")
+		listSessions(t, h, lr.AccessToken)
+
+		u, err := st.GetUser(context.Background(), "test@example.com")
+		require.NoError(t, err)
+		rec := do(t, h, http.MethodDelete, fmt.Sprintf("/users/%d", u.ID), admin.AccessToken, nil)
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+		rec = do(t, h, http.MethodGet, "/me/sessions", lr.AccessToken, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("refresh token", func(t *testing.T) {
+		h, _ := newTestHandler(t)
+		lr := login(t, h)
+
+		rec := do(t, h, http.MethodGet, "/me/sessions", lr.RefreshToken, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		// nor once it has been rotated
+		rec, rr := renew(t, h, "", lr.RefreshToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = do(t, h, http.MethodGet, "/me/sessions", lr.RefreshToken, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		listSessions(t, h, rr.AccessToken)
+	})
+
+	t.Run("rotated session", func(t *testing.T) {
+		h, _ := newTestHandler(t)
+		lr := login(t, h)
+		listSessions(t, h, lr.AccessToken)
+
+		rec, rr := renew(t, h, "", lr.RefreshToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		rec = do(t, h, http.MethodGet, "/me/sessions", lr.AccessToken, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		listSessions(t, h, rr.AccessToken)
+	})
+
+	t.Run("demoted admin", func(t *testing.T) {
+		h, st := newTestHandler(t, WithAuthCacheTTL(0))
+		createUserReq(t, h, UserReq{Name: "admin", Email: "admin@example.com", Password: "password", IsAdmin: true})
+		admin := loginAs(t, h, "admin@example.com")
+
+		rec := do(t, h, http.MethodGet, "/users", admin.AccessToken, nil)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		u, err := st.GetUser(context.Background(), "admin@example.com")
+		require.NoError(t, err)
+		u.IsAdmin = false
+		_, err = st.UpdateUser(context.Background(), u)
+		require.NoError(t, err)
+
+		rec = do(t, h, http.MethodGet, "/users", admin.AccessToken, nil)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		// a session revoked behind the handler's back, as by another
+		// instance, is trusted until the cache entry expires
+		h, st := newTestHandler(t, WithAuthCacheTTL(time.Hour))
+		lr := login(t, h)
+		listSessions(t, h, lr.AccessToken)
+
+		require.NoError(t, st.RevokeSession(context.Background(), lr.SessionID))
+		listSessions(t, h, lr.AccessToken)
+
+		h, st = newTestHandler(t, WithAuthCacheTTL(0))
+		lr = login(t, h)
+		listSessions(t, h, lr.AccessToken)
+
+		require.NoError(t, st.RevokeSession(context.Background(), lr.SessionID))
+		rec := do(t, h, http.MethodGet, "/me/sessions", lr.AccessToken, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("token without session", func(t *testing.T) {
+		h, st := newTestHandler(t)
+		login(t, h)
+
+		u, err := st.GetUser(context.Background(), "test@example.com")
+		require.NoError(t, err)
+		accessToken, _, err := token.NewJWTMaker("secret").CreateToken(u.ID, u.Email, false, "unknown", time.Minute)
+		require.NoError(t, err)
+		rec := do(t, h, http.MethodGet, "/me/sessions", accessToken, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	getJWKS := func(t *testing.T, h http.Handler) token.JWKS {
+		rec := do(t, h, http.MethodGet, "/.well-known/jwks.json", "", nil)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var res token.JWKS
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res
+	}
+
+	t.Run("secret key", func(t *testing.T) {
+		h, _ := newTestHandler(t)
+		require.Empty(t, getJWKS(t, h).Keys)
+	})
+
+	t.Run("signing keys", func(t *testing.T) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		maker, err := token.NewKeyMaker(&token.Key{ID: "k1", Signer: key})
+		require.NoError(t, err)
+
+		h, _ := newTestHandler(t, WithTokenMaker(maker))
+		jwks := getJWKS(t, h)
+		require.Len(t, jwks.Keys, 1)
+		require.Equal(t, "k1", jwks.Keys[0].Kid)
+
+		// tokens verify with the published key
+		lr := login(t, h)
+		x, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].X)
+		require.NoError(t, err)
+		_, err = jwt.Parse(lr.AccessToken, func(*jwt.Token) (interface{}, error) {
+			return ed25519.PublicKey(x), nil
+		}, jwt.WithValidMethods([]string{jwks.Keys[0].Alg}))
+		require.NoError(t, err)
+
+		listSessions(t, h, lr.AccessToken)
+		rec, _ := renew(t, h, lr.AccessToken, lr.RefreshToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		// tokens signed with the secret key are no longer accepted
+		hs, _, err := token.NewJWTMaker("secret").CreateToken(1, "test@example.com", false, lr.SessionID, time.Minute)
+		require.NoError(t, err)
+		rec = do(t, h, http.MethodGet, "/me/sessions", hs, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("switching from the secret key", func(t *testing.T) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		maker, err := token.NewKeyMaker(&token.Key{ID: "k1", Signer: key})
+		require.NoError(t, err)
+		fallback := token.NewFallbackMaker(maker, token.NewJWTMaker("secret"), time.Now().Add(time.Hour))
+
+		h, _ := newTestHandler(t, WithTokenMaker(fallback))
+		require.Len(t, getJWKS(t, h).Keys, 1)
+
+		// tokens signed with the secret key before the switch are still
+		// accepted, new ones are signed with the key
+		lr := login(t, h)
+		hs, _, err := token.NewJWTMaker("secret").CreateToken(1, "test@example.com", false, lr.SessionID, time.Minute)
+		require.NoError(t, err)
+		listSessions(t, h, hs)
+
+		tok, err := jwt.Parse(lr.AccessToken, func(*jwt.Token) (interface{}, error) {
+			return key.Public(), nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}))
+		require.NoError(t, err)
+		require.Equal(t, "k1", tok.Header["kid"])
+	})
+}
+
+func TestUnsubscribe(t *testing.T) {
+	h, st := newTestHandler(t)
+	createUser(t, h, "test@example.com")
+	u, err := st.GetUser(context.Background(), "test@example.com")
+	require.NoError(t, err)
+
+	tok, err := token.NewJWTMaker("secret").CreateUnsubscribeToken(u.ID, string(storer.OrderNotification(storer.Shipped)), time.Hour)
+	require.NoError(t, err)
+	link := "/unsubscribe?token=" + url.QueryEscape(tok)
+
+	emailEnabled := func(t *testing.T) bool {
+		prefs, err := st.ListNotificationPreferences(context.Background(), u.ID)
+		require.NoError(t, err)
+		for _, p := range prefs {
+			if p.Type == storer.OrderNotification(storer.Shipped) && p.Channel == emailChannel {
+				return p.Enabled
+			}
+		}
+		return true
+	}
+
+	t.Run("link asks to confirm", func(t *testing.T) {
+		rec := do(t, h, http.MethodGet, link, "", nil)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), `<form method="post">`)
+		require.Contains(t, rec.Body.String(), tok)
+		require.True(t, emailEnabled(t))
+	})
+
+	t.Run("invalid link", func(t *testing.T) {
+		rec := do(t, h, http.MethodGet, "/unsubscribe?token=not-a-token", "", nil)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("confirmation", func(t *testing.T) {
+		form := url.Values{"token": {tok}}
+		req := httptest.NewRequest(http.MethodPost, link, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.False(t, emailEnabled(t))
+	})
+
+	t.Run("one-click", func(t *testing.T) {
+		require.NoError(t, st.SetNotificationPreferences(context.Background(), u.ID, []*storer.NotificationPreference{
+			{Type: storer.OrderNotification(storer.Shipped), Channel: emailChannel, Enabled: true},
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, link, strings.NewReader("List-Unsubscribe=One-Click"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.False(t, emailEnabled(t))
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/money"
 )
 
 func toPBProductReq(p ProductReq) *pb.ProductReq {
@@ -14,9 +15,7 @@ func toPBProductReq(p ProductReq) *pb.ProductReq {
 		Image:        p.Image,
 		Category:     p.Category,
 		Description:  p.Description,
-		Rating:       p.Rating,
-		NumReviews:   p.NumReviews,
-		Price:        p.Price,
+		Price:        toPBMoney(p.Price),
 		CountInStock: p.CountInStock,
 	}
 }
@@ -29,17 +28,41 @@ func toProductRes(p *pb.ProductRes) ProductRes {
 		Description:  p.Description,
 		Rating:       p.Rating,
 		NumReviews:   p.NumReviews,
-		Price:        p.Price,
+		Price:        toMoney(p.Price),
 		CountInStock: p.CountInStock,
 	}
 }
 
+func toPBMoney(m *money.Money) *pb.Money {
+	if m == nil {
+		return nil
+	}
+
+	return &pb.Money{
+		Amount:   m.Amount,
+		Currency: m.Currency,
+	}
+}
+
+func toMoney(m *pb.Money) money.Money {
+	return money.New(m.GetAmount(), m.GetCurrency())
+}
+
+func toMoneyPtr(m *pb.Money) *money.Money {
+	if m == nil {
+		return nil
+	}
+
+	mm := toMoney(m)
+	return &mm
+}
+
 func toPBOrderReq(o OrderReq) *pb.OrderReq {
 	return &pb.OrderReq{
 		PaymentMethod: o.PaymentMethod,
-		TaxPrice:      o.TaxPrice,
-		ShippingPrice: o.ShippingPrice,
-		TotalPrice:    o.TotalPrice,
+		TaxPrice:      toPBMoney(o.TaxPrice),
+		ShippingPrice: toPBMoney(o.ShippingPrice),
+		TotalPrice:    toPBMoney(o.TotalPrice),
 		Items:         toPBOrderItems(o.Items),
 	}
 }
@@ -51,7 +74,7 @@ func toPBOrderItems(oi []*OrderItem) []*pb.OrderItem {
 			Name:      i.Name,
 			Quantity:  i.Quantity,
 			Image:     i.Image,
-			Price:     i.Price,
+			Price:     toPBMoney(i.Price),
 			ProductId: i.ProductID,
 		})
 	}
@@ -61,34 +84,147 @@ func toPBOrderItems(oi []*OrderItem) []*pb.OrderItem {
 type OrderStatus string
 
 const (
-	Pending   OrderStatus = "pending"
-	Shipped   OrderStatus = "shipped"
-	Delivered OrderStatus = "delivered"
+	Pending         OrderStatus = "pending"
+	Paid            OrderStatus = "paid"
+	Processing      OrderStatus = "processing"
+	Shipped         OrderStatus = "shipped"
+	Delivered       OrderStatus = "delivered"
+	Cancelled       OrderStatus = "cancelled"
+	RefundRequested OrderStatus = "refund_requested"
+	Refunded        OrderStatus = "refunded"
 )
 
 func toPBOrderStatus(s OrderStatus) (pb.OrderStatus, error) {
 	switch s {
 	case Pending:
 		return pb.OrderStatus_PENDING, nil
+	case Paid:
+		return pb.OrderStatus_PAID, nil
+	case Processing:
+		return pb.OrderStatus_PROCESSING, nil
 	case Shipped:
 		return pb.OrderStatus_SHIPPED, nil
 	case Delivered:
 		return pb.OrderStatus_DELIVERED, nil
+	case Cancelled:
+		return pb.OrderStatus_CANCELLED, nil
+	case RefundRequested:
+		return pb.OrderStatus_REFUND_REQUESTED, nil
+	case Refunded:
+		return pb.OrderStatus_REFUNDED, nil
 	default:
 		return 0, fmt.Errorf("unknown order status: %s", s)
 	}
 }
 
+func toOrderStatusChanges(cs []*pb.OrderStatusChange) []OrderStatusChange {
+	res := make([]OrderStatusChange, 0, len(cs))
+	for _, c := range cs {
+		res = append(res, OrderStatusChange{
+			FromStatus: strings.ToLower(c.GetFromStatus().String()),
+			ToStatus:   strings.ToLower(c.GetToStatus().String()),
+			ActorID:    c.GetActorId(),
+			ActorRole:  c.GetActorRole(),
+			Reason:     c.GetReason(),
+			CreatedAt:  c.GetCreatedAt().AsTime(),
+		})
+	}
+	return res
+}
+
+func toNotificationStateRes(ns *pb.NotificationStateRes) NotificationStateRes {
+	res := NotificationStateRes{
+		ID:          ns.GetId(),
+		OrderID:     ns.GetOrderId(),
+		UserEmail:   ns.GetUserEmail(),
+		OrderStatus: strings.ToLower(ns.GetOrderStatus().String()),
+		State:       strings.ToLower(ns.GetState().String()),
+		Message:     ns.GetMessage(),
+		RequestedAt: ns.GetRequestedAt().AsTime(),
+	}
+	if ns.GetCompletedAt() != nil {
+		t := ns.GetCompletedAt().AsTime()
+		res.CompletedAt = &t
+	}
+	for _, a := range ns.GetAttempts() {
+		res.Attempts = append(res.Attempts, NotificationAttempt{
+			Attempt:     a.GetAttempt(),
+			Succeeded:   a.GetSucceeded(),
+			Message:     a.GetMessage(),
+			AttemptedAt: a.GetAttemptedAt().AsTime(),
+		})
+	}
+	for _, r := range ns.GetReplays() {
+		res.Replays = append(res.Replays, NotificationReplay{
+			ActorID:         r.GetActorId(),
+			PreviousMessage: r.GetPreviousMessage(),
+			CreatedAt:       r.GetCreatedAt().AsTime(),
+		})
+	}
+
+	return res
+}
+
+func toPBNotificationPreferences(prefs []NotificationPreference) []*pb.NotificationPreference {
+	res := make([]*pb.NotificationPreference, 0, len(prefs))
+	for _, p := range prefs {
+		res = append(res, &pb.NotificationPreference{
+			Type:    p.Type,
+			Channel: p.Channel,
+			Enabled: p.Enabled,
+		})
+	}
+	return res
+}
+
+func toNotificationPreferencesRes(prefs *pb.NotificationPreferencesRes) NotificationPreferencesRes {
+	res := NotificationPreferencesRes{
+		Preferences: []NotificationPreference{},
+	}
+	for _, p := range prefs.GetPreferences() {
+		res.Preferences = append(res.Preferences, NotificationPreference{
+			Type:    p.GetType(),
+			Channel: p.GetChannel(),
+			Enabled: p.GetEnabled(),
+		})
+	}
+	return res
+}
+
 func toOrderRes(o *pb.OrderRes) OrderRes {
-	return OrderRes{
+	res := OrderRes{
 		ID:            o.Id,
 		PaymentMethod: o.PaymentMethod,
-		TaxPrice:      o.TaxPrice,
-		ShippingPrice: o.ShippingPrice,
-		TotalPrice:    o.TotalPrice,
+		SubtotalPrice: toMoney(o.SubtotalPrice),
+		TaxPrice:      toMoney(o.TaxPrice),
+		ShippingPrice: toMoney(o.ShippingPrice),
+		TotalPrice:    toMoney(o.TotalPrice),
+		PriceLines:    toPriceLines(o.PriceLines),
 		Items:         toOrderItems(o.Items),
+		UserID:        o.UserId,
 		Status:        strings.ToLower(o.GetStatus().String()),
+		CreatedAt:     o.GetCreatedAt().AsTime(),
+	}
+	if o.GetUpdatedAt() != nil {
+		t := o.GetUpdatedAt().AsTime()
+		res.UpdatedAt = &t
+	}
+
+	return res
+}
+
+func toPriceLines(pl []*pb.PriceLine) []*PriceLine {
+	var res []*PriceLine
+	for _, l := range pl {
+		res = append(res, &PriceLine{
+			ProductID: l.ProductId,
+			Name:      l.Name,
+			Quantity:  l.Quantity,
+			UnitPrice: toMoney(l.UnitPrice),
+			LineTotal: toMoney(l.LineTotal),
+		})
 	}
+	return res
 }
 
 func toOrderItems(oi []*pb.OrderItem) []*OrderItem {
@@ -98,19 +234,61 @@ func toOrderItems(oi []*pb.OrderItem) []*OrderItem {
 			Name:      i.Name,
 			Quantity:  i.Quantity,
 			Image:     i.Image,
-			Price:     i.Price,
+			Price:     toMoneyPtr(i.Price),
 			ProductID: i.ProductId,
 		})
 	}
 	return res
 }
 
+func toCartRes(c *pb.CartRes) CartRes {
+	res := CartRes{
+		Items:         []CartItem{},
+		SubtotalPrice: toMoney(c.GetSubtotalPrice()),
+	}
+	for _, ci := range c.GetItems() {
+		res.Items = append(res.Items, CartItem{
+			ProductID: ci.GetProductId(),
+			Name:      ci.GetName(),
+			Image:     ci.GetImage(),
+			Quantity:  ci.GetQuantity(),
+			UnitPrice: toMoney(ci.GetUnitPrice()),
+			LineTotal: toMoney(ci.GetLineTotal()),
+		})
+	}
+	if c.GetUpdatedAt() != nil {
+		t := c.GetUpdatedAt().AsTime()
+		res.UpdatedAt = &t
+	}
+
+	return res
+}
+
+func toReviewRes(r *pb.ReviewRes) ReviewRes {
+	res := ReviewRes{
+		ID:        r.GetId(),
+		ProductID: r.GetProductId(),
+		UserID:    r.GetUserId(),
+		Stars:     r.GetStars(),
+		Title:     r.GetTitle(),
+		Body:      r.GetBody(),
+		CreatedAt: r.GetCreatedAt().AsTime(),
+	}
+	if r.GetUpdatedAt() != nil {
+		t := r.GetUpdatedAt().AsTime()
+		res.UpdatedAt = &t
+	}
+
+	return res
+}
+
 func toPBUserReq(u UserReq) *pb.UserReq {
 	return &pb.UserReq{
 		Name:     u.Name,
 		Email:    u.Email,
 		Password: u.Password,
 		IsAdmin:  u.IsAdmin,
+		Locale:   u.Locale,
 	}
 }
 
@@ -119,5 +297,81 @@ func toUserRes(u *pb.UserRes) UserRes {
 		Name:    u.Name,
 		Email:   u.Email,
 		IsAdmin: u.IsAdmin,
+		Locale:  u.Locale,
+	}
+}
+
+func toPBWebhookReq(id int64, wr WebhookReq) *pb.WebhookReq {
+	return &pb.WebhookReq{
+		Id:         id,
+		Url:        wr.URL,
+		EventTypes: wr.EventTypes,
+		Secret:     wr.Secret,
+		Active:     wr.Active,
+	}
+}
+
+func toWebhookRes(wr *pb.WebhookRes) WebhookRes {
+	res := WebhookRes{
+		ID:         wr.GetId(),
+		URL:        wr.GetUrl(),
+		EventTypes: wr.GetEventTypes(),
+		Secret:     wr.GetSecret(),
+		Active:     wr.GetActive(),
+		CreatedAt:  wr.GetCreatedAt().AsTime(),
+	}
+	if wr.GetUpdatedAt() != nil {
+		t := wr.GetUpdatedAt().AsTime()
+		res.UpdatedAt = &t
+	}
+
+	return res
+}
+
+func toWebhookDeliveryRes(d *pb.WebhookDeliveryRes) WebhookDeliveryRes {
+	res := WebhookDeliveryRes{
+		ID:            d.GetId(),
+		WebhookID:     d.GetWebhookId(),
+		EventID:       d.GetEventId(),
+		EventType:     d.GetEventType(),
+		Payload:       d.GetPayload(),
+		State:         strings.ToLower(strings.TrimPrefix(d.GetState().String(), "DELIVERY_")),
+		Attempts:      d.GetAttempts(),
+		NextAttemptAt: d.GetNextAttemptAt().AsTime(),
+		CreatedAt:     d.GetCreatedAt().AsTime(),
+	}
+	if d.GetCompletedAt() != nil {
+		t := d.GetCompletedAt().AsTime()
+		res.CompletedAt = &t
 	}
+	for _, a := range d.GetAttemptLog() {
+		res.AttemptLog = append(res.AttemptLog, WebhookDeliveryAttempt{
+			Attempt:     a.GetAttempt(),
+			StatusCode:  a.GetStatusCode(),
+			Succeeded:   a.GetSucceeded(),
+			Message:     a.GetMessage(),
+			AttemptedAt: a.GetAttemptedAt().AsTime(),
+		})
+	}
+
+	return res
+}
+
+// toSessionRes maps a session, flagging it when it is the one currentID
+// belongs to.
+func toSessionRes(sr *pb.SessionRes, currentID string) SessionRes {
+	res := SessionRes{
+		ID:        sr.GetId(),
+		UserAgent: sr.GetUserAgent(),
+		ClientIP:  sr.GetClientIp(),
+		Current:   sr.GetId() == currentID,
+		CreatedAt: sr.GetCreatedAt().AsTime(),
+		ExpiresAt: sr.GetExpiresAt().AsTime(),
+	}
+	if sr.GetLastUsedAt() != nil {
+		t := sr.GetLastUsedAt().AsTime()
+		res.LastUsedAt = &t
+	}
+
+	return res
 }
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -11,14 +12,13 @@ import (
 
 type authKey struct{}
 
-func GetAuthMiddlewareFunc(tokenMaker *token.JWTMaker) func(http.Handler) http.Handler {
+func GetAuthMiddlewareFunc(tokenMaker token.Maker, auth *authCache) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// read the authorization header
-			// verify the token
-			claims, err := verifyClaimsFromAuthHeader(r, tokenMaker)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("error verifying token: %v", err), http.StatusUnauthorized)
+			// verify the token and its session
+			claims, ok := authenticate(w, r, tokenMaker, auth)
+			if !ok {
 				return
 			}
 
@@ -29,14 +29,13 @@ func GetAuthMiddlewareFunc(tokenMaker *token.JWTMaker) func(http.Handler) http.H
 	}
 }
 
-func GetAdminMiddlewareFunc(tokenMaker *token.JWTMaker) func(http.Handler) http.Handler {
+func GetAdminMiddlewareFunc(tokenMaker token.Maker, auth *authCache) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// read the authorization header
-			// verify the token
-			claims, err := verifyClaimsFromAuthHeader(r, tokenMaker)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("error verifying token: %v", err), http.StatusUnauthorized)
+			// verify the token and its session
+			claims, ok := authenticate(w, r, tokenMaker, auth)
+			if !ok {
 				return
 			}
 
@@ -52,7 +51,40 @@ func GetAdminMiddlewareFunc(tokenMaker *token.JWTMaker) func(http.Handler) http.
 	}
 }
 
-func verifyClaimsFromAuthHeader(r *http.Request, tokenMaker *token.JWTMaker) (*token.UserClaims, error) {
+// authenticate verifies the access token of r and checks that its session
+// isn't revoked or rotated and its user still exists. The returned claims
+// carry whether the user is an admin now rather than when the token was
+// issued. The checks go through auth, so a session revoked or a user
+// changed through another instance of the API may still pass for up to the
+// cache TTL. On failure the error is written to w.
+func authenticate(w http.ResponseWriter, r *http.Request, tokenMaker token.Maker, auth *authCache) (*token.UserClaims, bool) {
+	claims, err := verifyClaimsFromAuthHeader(r, tokenMaker)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error verifying token: %v", err), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	// refresh tokens only renew access tokens
+	if claims.TokenType() != token.AccessToken {
+		http.Error(w, "error verifying token: not an access token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	isAdmin, err := auth.check(r.Context(), claims)
+	if err != nil {
+		if errors.Is(err, errNoSession) || errors.Is(err, errSessionRevoked) || errors.Is(err, errSessionRotated) || errors.Is(err, errUserNotFound) {
+			http.Error(w, fmt.Sprintf("error verifying token: %v", err), http.StatusUnauthorized)
+			return nil, false
+		}
+		http.Error(w, "error verifying session", http.StatusInternalServerError)
+		return nil, false
+	}
+	claims.IsAdmin = isAdmin
+
+	return claims, true
+}
+
+func verifyClaimsFromAuthHeader(r *http.Request, tokenMaker token.Maker) (*token.UserClaims, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		return nil, fmt.Errorf("authorization header is missing")
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/money"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// pageParams holds the ?limit=&cursor=&sort= query parameters shared by the
+// list endpoints.
+type pageParams struct {
+	limit  int32
+	cursor string
+	sort   string
+}
+
+func parsePageParams(q url.Values) (pageParams, error) {
+	pp := pageParams{
+		cursor: q.Get("cursor"),
+		sort:   q.Get("sort"),
+	}
+	if l := q.Get("limit"); l != "" {
+		i, err := strconv.ParseInt(l, 10, 32)
+		if err != nil || i < 0 {
+			return pageParams{}, fmt.Errorf("invalid limit %q", l)
+		}
+		pp.limit = int32(i)
+	}
+
+	return pp, nil
+}
+
+func parseMoneyParam(q url.Values, key string) (*pb.Money, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+
+	m, err := money.Parse(v, money.DefaultCurrency)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	return toPBMoney(&m), nil
+}
+
+func parseBoolParam(q url.Values, key string) (*bool, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", key, v)
+	}
+
+	return &b, nil
+}
+
+func parseTimeParam(q url.Values, key string) (*timestamppb.Timestamp, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: expected RFC 3339", key, v)
+	}
+
+	return timestamppb.New(t), nil
+}
+
+func toPBListProductsReq(q url.Values) (*pb.ListProductsReq, error) {
+	pp, err := parsePageParams(q)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &pb.ListProductsReq{
+		PageSize:  pp.limit,
+		PageToken: pp.cursor,
+		Sort:      pp.sort,
+		Category:  q.Get("category"),
+	}
+	if req.MinPrice, err = parseMoneyParam(q, "min_price"); err != nil {
+		return nil, err
+	}
+	if req.MaxPrice, err = parseMoneyParam(q, "max_price"); err != nil {
+		return nil, err
+	}
+	inStock, err := parseBoolParam(q, "in_stock")
+	if err != nil {
+		return nil, err
+	}
+	req.InStock = inStock != nil && *inStock
+
+	return req, nil
+}
+
+func toPBListOrdersReq(q url.Values) (*pb.ListOrdersReq, error) {
+	pp, err := parsePageParams(q)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &pb.ListOrdersReq{
+		PageSize:  pp.limit,
+		PageToken: pp.cursor,
+		Sort:      pp.sort,
+	}
+	if s := q.Get("status"); s != "" {
+		ps, err := toPBOrderStatus(OrderStatus(s))
+		if err != nil {
+			return nil, err
+		}
+		req.Status = &ps
+	}
+	if u := q.Get("user_id"); u != "" {
+		req.UserId, err = strconv.ParseInt(u, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user_id %q", u)
+		}
+	}
+	if req.CreatedAfter, err = parseTimeParam(q, "created_after"); err != nil {
+		return nil, err
+	}
+	if req.CreatedBefore, err = parseTimeParam(q, "created_before"); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func toPBListUsersReq(q url.Values) (*pb.ListUsersReq, error) {
+	pp, err := parsePageParams(q)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &pb.ListUsersReq{
+		PageSize:  pp.limit,
+		PageToken: pp.cursor,
+		Sort:      pp.sort,
+	}
+	if req.IsAdmin, err = parseBoolParam(q, "is_admin"); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func toPBListNotificationStatesReq(q url.Values) (*pb.ListNotificationStatesReq, error) {
+	pp, err := parsePageParams(q)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &pb.ListNotificationStatesReq{
+		PageSize:  pp.limit,
+		PageToken: pp.cursor,
+		Sort:      pp.sort,
+	}
+	if s := q.Get("state"); s != "" {
+		v, ok := pb.NotificationDeliveryState_value[strings.ToUpper(s)]
+		if !ok {
+			return nil, fmt.Errorf("invalid state %q", s)
+		}
+		state := pb.NotificationDeliveryState(v)
+		req.State = &state
+	}
+	if o := q.Get("order_id"); o != "" {
+		req.OrderId, err = strconv.ParseInt(o, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid order_id %q", o)
+		}
+	}
+	if req.RequestedAfter, err = parseTimeParam(q, "requested_after"); err != nil {
+		return nil, err
+	}
+	if req.RequestedBefore, err = parseTimeParam(q, "requested_before"); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
@@ -10,43 +10,96 @@ var r *chi.Mux
 
 func RegisterRoutes(handler *handler) *chi.Mux {
 	r = chi.NewRouter()
-	tokenMaker := handler.TokenMaker
+	tokenMaker, auth := handler.TokenMaker, handler.auth
 
 	r.Route("/products", func(r chi.Router) {
-		r.With(GetAdminMiddlewareFunc(tokenMaker)).Post("/", handler.createProduct)
+		r.With(GetAdminMiddlewareFunc(tokenMaker, auth)).Post("/", handler.createProduct)
 		r.Get("/", handler.listProducts)
 
 		r.Route("/{id}", func(r chi.Router) {
 			r.Get("/", handler.getProduct)
 			r.Group(func(r chi.Router) {
-				r.Use(GetAdminMiddlewareFunc(tokenMaker))
+				r.Use(GetAdminMiddlewareFunc(tokenMaker, auth))
 				r.Patch("/", handler.updateProduct)
 				r.Delete("/", handler.deleteProduct)
 			})
+
+			r.Route("/reviews", func(r chi.Router) {
+				r.Get("/", handler.listReviews)
+				r.Group(func(r chi.Router) {
+					r.Use(GetAuthMiddlewareFunc(tokenMaker, auth))
+					r.Post("/", handler.createReview)
+					r.Patch("/{reviewID}", handler.updateReview)
+					r.Delete("/{reviewID}", handler.deleteReview)
+				})
+			})
 		})
 	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(GetAuthMiddlewareFunc(tokenMaker))
-		r.Get("/myorder", handler.getOrder)
+		r.Use(GetAuthMiddlewareFunc(tokenMaker, auth))
+		r.Get("/me/orders", handler.listMyOrders)
+		r.Get("/me/notification-preferences", handler.getNotificationPreferences)
+		r.Put("/me/notification-preferences", handler.updateNotificationPreferences)
+		r.Get("/me/sessions", handler.listMySessions)
+		r.Delete("/me/sessions", handler.revokeAllMySessions)
+		r.Delete("/me/sessions/{id}", handler.revokeMySession)
 
 		r.Route("/orders", func(r chi.Router) {
 			r.Post("/", handler.createOrder)
-			r.With(GetAdminMiddlewareFunc(tokenMaker)).Get("/", handler.listOrders)
-			r.With(GetAuthMiddlewareFunc(tokenMaker)).Patch("/status", handler.updateOrderStatus)
+			r.With(GetAdminMiddlewareFunc(tokenMaker, auth)).Get("/", handler.listOrders)
+			r.With(GetAuthMiddlewareFunc(tokenMaker, auth)).Patch("/status", handler.updateOrderStatus)
 
 			r.Route("/{id}", func(r chi.Router) {
+				r.Get("/", handler.getOrder)
+				r.Get("/history", handler.getOrderHistory)
 				r.Delete("/", handler.deleteOrder)
 			})
 		})
 	})
 
+	r.Route("/cart", func(r chi.Router) {
+		r.Use(GetAuthMiddlewareFunc(tokenMaker, auth))
+		r.Get("/", handler.getCart)
+		r.Post("/items", handler.addToCart)
+		r.Patch("/items/{productID}", handler.updateCartItem)
+		r.Delete("/items/{productID}", handler.removeCartItem)
+		r.Post("/checkout", handler.checkout)
+	})
+
+	r.Route("/notifications", func(r chi.Router) {
+		r.Use(GetAdminMiddlewareFunc(tokenMaker, auth))
+		r.Get("/", handler.listNotifications)
+		r.Get("/{id}", handler.getNotification)
+		r.Post("/replay", handler.replayNotifications)
+	})
+
+	r.Route("/webhooks", func(r chi.Router) {
+		r.Use(GetAdminMiddlewareFunc(tokenMaker, auth))
+		r.Get("/", handler.listWebhooks)
+		r.Post("/", handler.createWebhook)
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", handler.getWebhook)
+			r.Patch("/", handler.updateWebhook)
+			r.Delete("/", handler.deleteWebhook)
+			r.Get("/deliveries", handler.listWebhookDeliveries)
+			r.Get("/deliveries/{deliveryID}", handler.getWebhookDelivery)
+		})
+	})
+
+	r.Get("/.well-known/jwks.json", handler.getJWKS)
+
+	r.Get("/unsubscribe", handler.confirmUnsubscribe)
+	r.Post("/unsubscribe", handler.unsubscribe)
+
 	r.Route("/users", func(r chi.Router) {
 		r.Post("/", handler.createUser)
 		r.Post("/login", handler.loginUser)
+		r.Post("/password/forgot", handler.forgotPassword)
+		r.Post("/password/reset", handler.resetPassword)
 
 		r.Group(func(r chi.Router) {
-			r.Use(GetAdminMiddlewareFunc(tokenMaker))
+			r.Use(GetAdminMiddlewareFunc(tokenMaker, auth))
 			r.Get("/", handler.listUsers)
 			r.Route("/{id}", func(r chi.Router) {
 				r.Delete("/", handler.deleteUser)
@@ -54,18 +107,17 @@ func RegisterRoutes(handler *handler) *chi.Mux {
 		})
 
 		r.Group(func(r chi.Router) {
-			r.Use(GetAuthMiddlewareFunc(tokenMaker))
+			r.Use(GetAuthMiddlewareFunc(tokenMaker, auth))
 			r.Patch("/", handler.updateUser)
 			r.Post("/logout", handler.logoutUser)
 		})
 	})
 
-	r.Group(func(r chi.Router) {
-		r.Use(GetAuthMiddlewareFunc(tokenMaker))
-		r.Route("/tokens", func(r chi.Router) {
-			r.Post("/renew", handler.renewAccessToken)
-			r.Post("/revoke", handler.revokeSession)
-		})
+	r.Route("/tokens", func(r chi.Router) {
+		// the refresh token authenticates renewals, so that they work once
+		// the access token of the rotated session is no longer accepted
+		r.Post("/renew", handler.renewAccessToken)
+		r.With(GetAuthMiddlewareFunc(tokenMaker, auth)).Post("/revoke", handler.revokeSession)
 	})
 
 	return r
@@ -1,78 +1,266 @@
 package handler
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/dhij/ecomm/money"
+)
 
 type ProductReq struct {
-	ID           int64   `json:"id"`
-	Name         string  `json:"name"`
-	Image        string  `json:"image"`
-	Category     string  `json:"category"`
-	Description  string  `json:"description"`
-	Rating       int64   `json:"rating"`
-	NumReviews   int64   `json:"num_reviews"`
-	Price        float32 `json:"price"`
-	CountInStock int64   `json:"count_in_stock"`
+	ID           int64        `json:"id"`
+	Name         string       `json:"name"`
+	Image        string       `json:"image"`
+	Category     string       `json:"category"`
+	Description  string       `json:"description"`
+	Price        *money.Money `json:"price"`
+	CountInStock int64        `json:"count_in_stock"`
 }
 
 type ProductRes struct {
-	ID           int64      `json:"id"`
-	Name         string     `json:"name"`
-	Image        string     `json:"image"`
-	Category     string     `json:"category"`
-	Description  string     `json:"description"`
-	Rating       int64      `json:"rating"`
-	NumReviews   int64      `json:"num_reviews"`
-	Price        float32    `json:"price"`
-	CountInStock int64      `json:"count_in_stock"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    *time.Time `json:"updated_at"`
+	ID           int64       `json:"id"`
+	Name         string      `json:"name"`
+	Image        string      `json:"image"`
+	Category     string      `json:"category"`
+	Description  string      `json:"description"`
+	Rating       float64     `json:"rating"`
+	NumReviews   int64       `json:"num_reviews"`
+	Price        money.Money `json:"price"`
+	CountInStock int64       `json:"count_in_stock"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    *time.Time  `json:"updated_at"`
+}
+
+type ListProductRes struct {
+	Products   []ProductRes `json:"products"`
+	NextCursor string       `json:"next_cursor,omitempty"`
 }
 
 type OrderReq struct {
 	ID            int64        `json:"id"`
 	Items         []*OrderItem `json:"items"`
 	PaymentMethod string       `json:"payment_method"`
-	TaxPrice      float32      `json:"tax_price"`
-	ShippingPrice float32      `json:"shipping_price"`
-	TotalPrice    float32      `json:"total_price"`
+	TaxPrice      *money.Money `json:"tax_price,omitempty"`
+	ShippingPrice *money.Money `json:"shipping_price,omitempty"`
+	TotalPrice    *money.Money `json:"total_price,omitempty"`
 	Status        string       `json:"status"`
+	Reason        string       `json:"reason,omitempty"`
 }
 
 type OrderItem struct {
-	Name      string  `json:"name"`
-	Quantity  int64   `json:"quantity"`
-	Image     string  `json:"image"`
-	Price     float32 `json:"price"`
-	ProductID int64   `json:"product_id"`
+	Name      string       `json:"name"`
+	Quantity  int64        `json:"quantity"`
+	Image     string       `json:"image"`
+	Price     *money.Money `json:"price,omitempty"`
+	ProductID int64        `json:"product_id"`
 }
 
 type OrderRes struct {
 	ID            int64        `json:"id"`
 	Items         []*OrderItem `json:"items"`
 	PaymentMethod string       `json:"payment_method"`
-	TaxPrice      float32      `json:"tax_price"`
-	ShippingPrice float32      `json:"shipping_price"`
-	TotalPrice    float32      `json:"total_price"`
+	SubtotalPrice money.Money  `json:"subtotal_price"`
+	TaxPrice      money.Money  `json:"tax_price"`
+	ShippingPrice money.Money  `json:"shipping_price"`
+	TotalPrice    money.Money  `json:"total_price"`
+	PriceLines    []*PriceLine `json:"price_lines"`
+	UserID        int64        `json:"user_id"`
 	Status        string       `json:"status"`
 	CreatedAt     time.Time    `json:"created_at"`
 	UpdatedAt     *time.Time   `json:"updated_at"`
 }
 
+type ListOrderRes struct {
+	Orders     []OrderRes `json:"orders"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+type OrderStatusChange struct {
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ActorID    int64     `json:"actor_id"`
+	ActorRole  string    `json:"actor_role"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type OrderHistoryRes struct {
+	Changes []OrderStatusChange `json:"changes"`
+}
+
+type NotificationAttempt struct {
+	Attempt     int64     `json:"attempt"`
+	Succeeded   bool      `json:"succeeded"`
+	Message     string    `json:"message,omitempty"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+type NotificationReplay struct {
+	ActorID         int64     `json:"actor_id"`
+	PreviousMessage string    `json:"previous_message,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type NotificationStateRes struct {
+	ID          int64                 `json:"id"`
+	OrderID     int64                 `json:"order_id"`
+	UserEmail   string                `json:"user_email"`
+	OrderStatus string                `json:"order_status"`
+	State       string                `json:"state"`
+	Message     string                `json:"message,omitempty"`
+	RequestedAt time.Time             `json:"requested_at"`
+	CompletedAt *time.Time            `json:"completed_at"`
+	Attempts    []NotificationAttempt `json:"attempts,omitempty"`
+	Replays     []NotificationReplay  `json:"replays,omitempty"`
+}
+
+type ListNotificationStateRes struct {
+	Notifications []NotificationStateRes `json:"notifications"`
+	NextCursor    string                 `json:"next_cursor,omitempty"`
+}
+
+type ReplayNotificationsReq struct {
+	IDs []int64 `json:"ids"`
+}
+
+type ReplayNotificationsRes struct {
+	Replayed []int64 `json:"replayed"`
+}
+
+type NotificationPreference struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Enabled bool   `json:"enabled"`
+}
+
+type NotificationPreferencesReq struct {
+	Preferences []NotificationPreference `json:"preferences"`
+}
+
+type NotificationPreferencesRes struct {
+	Preferences []NotificationPreference `json:"preferences"`
+}
+
+type WebhookReq struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret"`
+	Active     *bool    `json:"active"`
+}
+
+type WebhookRes struct {
+	ID         int64      `json:"id"`
+	URL        string     `json:"url"`
+	EventTypes []string   `json:"event_types"`
+	Secret     string     `json:"secret"`
+	Active     bool       `json:"active"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at"`
+}
+
+type ListWebhookRes struct {
+	Webhooks []WebhookRes `json:"webhooks"`
+}
+
+type WebhookDeliveryAttempt struct {
+	Attempt     int64     `json:"attempt"`
+	StatusCode  int32     `json:"status_code,omitempty"`
+	Succeeded   bool      `json:"succeeded"`
+	Message     string    `json:"message,omitempty"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+type WebhookDeliveryRes struct {
+	ID            int64                    `json:"id"`
+	WebhookID     int64                    `json:"webhook_id"`
+	EventID       int64                    `json:"event_id"`
+	EventType     string                   `json:"event_type"`
+	Payload       json.RawMessage          `json:"payload"`
+	State         string                   `json:"state"`
+	Attempts      int64                    `json:"attempts"`
+	NextAttemptAt time.Time                `json:"next_attempt_at"`
+	CreatedAt     time.Time                `json:"created_at"`
+	CompletedAt   *time.Time               `json:"completed_at"`
+	AttemptLog    []WebhookDeliveryAttempt `json:"attempt_log,omitempty"`
+}
+
+type ListWebhookDeliveryRes struct {
+	Deliveries []WebhookDeliveryRes `json:"deliveries"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+type PriceLine struct {
+	ProductID int64       `json:"product_id"`
+	Name      string      `json:"name"`
+	Quantity  int64       `json:"quantity"`
+	UnitPrice money.Money `json:"unit_price"`
+	LineTotal money.Money `json:"line_total"`
+}
+
+type CartItemReq struct {
+	ProductID int64 `json:"product_id"`
+	Quantity  int64 `json:"quantity"`
+}
+
+type CartItem struct {
+	ProductID int64       `json:"product_id"`
+	Name      string      `json:"name"`
+	Image     string      `json:"image"`
+	Quantity  int64       `json:"quantity"`
+	UnitPrice money.Money `json:"unit_price"`
+	LineTotal money.Money `json:"line_total"`
+}
+
+type CartRes struct {
+	Items         []CartItem  `json:"items"`
+	SubtotalPrice money.Money `json:"subtotal_price"`
+	UpdatedAt     *time.Time  `json:"updated_at"`
+}
+
+type CheckoutReq struct {
+	PaymentMethod string `json:"payment_method"`
+}
+
+type ReviewReq struct {
+	Stars int64  `json:"stars"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type ReviewRes struct {
+	ID        int64      `json:"id"`
+	ProductID int64      `json:"product_id"`
+	UserID    int64      `json:"user_id"`
+	Stars     int64      `json:"stars"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+type ListReviewRes struct {
+	Reviews    []ReviewRes `json:"reviews"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
 type UserReq struct {
 	Name     string `json:"name"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	IsAdmin  bool   `json:"is_admin"`
+	Locale   string `json:"locale"`
 }
 
 type UserRes struct {
 	Name    string `json:"name"`
 	Email   string `json:"email"`
 	IsAdmin bool   `json:"is_admin"`
+	Locale  string `json:"locale"`
 }
 
 type ListUserRes struct {
-	Users []UserRes `json:"users"`
+	Users      []UserRes `json:"users"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
 
 type LoginUserReq struct {
@@ -80,6 +268,15 @@ type LoginUserReq struct {
 	Password string `json:"password"`
 }
 
+type ForgotPasswordReq struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordReq struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 type LoginUserRes struct {
 	SessionID             string    `json:"session_id"`
 	AccessToken           string    `json:"access_token"`
@@ -89,11 +286,28 @@ type LoginUserRes struct {
 	User                  UserRes   `json:"user"`
 }
 
+type SessionRes struct {
+	ID         string     `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	ClientIP   string     `json:"client_ip"`
+	Current    bool       `json:"current"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+}
+
+type ListSessionRes struct {
+	Sessions []SessionRes `json:"sessions"`
+}
+
 type RenewAccessTokenReq struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
 type RenewAccessTokenRes struct {
-	AccessToken          string    `json:"access_token"`
-	AccessTokenExpiresAt time.Time `json:"access_token_expires_at"`
+	SessionID             string    `json:"session_id"`
+	AccessToken           string    `json:"access_token"`
+	RefreshToken          string    `json:"refresh_token"`
+	AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
 }
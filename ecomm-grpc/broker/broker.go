@@ -0,0 +1,93 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+)
+
+// Broker wakes subscribers when notification events are enqueued. It does not
+// hand the events out itself: subscribers claim them from the queue, so that
+// leases still decide which worker sends which event.
+//
+// Every publish advances a cursor. A subscriber that waits with the cursor it
+// last saw returns as soon as anything was published after it, which is how a
+// subscription resumes after a reconnect without missing events.
+type Broker struct {
+	mu    sync.Mutex
+	epoch int64
+	seq   int64
+	ready chan struct{}
+}
+
+func New() *Broker {
+	return &Broker{
+		epoch: time.Now().UnixNano(),
+		ready: make(chan struct{}),
+	}
+}
+
+// Publish wakes the subscribers for the enqueued events. It matches the hook
+// set by storer.WithOnEnqueue.
+func (b *Broker) Publish(evs ...*storer.NotificationEvent) {
+	if len(evs) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq += int64(len(evs))
+	close(b.ready)
+	b.ready = make(chan struct{})
+}
+
+// Cursor returns the cursor of the latest publish.
+func (b *Broker) Cursor() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.cursor()
+}
+
+func (b *Broker) cursor() string {
+	return fmt.Sprintf("%d.%d", b.epoch, b.seq)
+}
+
+// Wait blocks until events are published after cursor or ctx is done, and
+// returns the cursor to wait with next. An empty or unknown cursor, e.g. one
+// handed out before a restart, returns straight away since events may have
+// been missed.
+func (b *Broker) Wait(ctx context.Context, cursor string) (string, error) {
+	for {
+		b.mu.Lock()
+		seq, ok := b.parseCursor(cursor)
+		if !ok || seq < b.seq {
+			next := b.cursor()
+			b.mu.Unlock()
+			return next, nil
+		}
+		ready := b.ready
+		b.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// parseCursor returns the sequence number of a cursor handed out by b.
+func (b *Broker) parseCursor(cursor string) (int64, bool) {
+	var epoch, seq int64
+	_, err := fmt.Sscanf(cursor, "%d.%d", &epoch, &seq)
+	if err != nil || epoch != b.epoch || seq > b.seq {
+		return 0, false
+	}
+
+	return seq, true
+}
@@ -0,0 +1,57 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+	b := New()
+
+	// an empty cursor may have missed events
+	cursor, err := b.Wait(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, b.Cursor(), cursor)
+
+	// a cursor from before a restart may have missed events too
+	next, err := New().Wait(ctx, cursor)
+	require.NoError(t, err)
+	require.NotEqual(t, cursor, next)
+
+	done := make(chan string)
+	go func() {
+		next, _ := b.Wait(ctx, cursor)
+		done <- next
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("wait returned before a publish")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	b.Publish()
+	b.Publish(&storer.NotificationEvent{ID: 1})
+	next = <-done
+	require.NotEqual(t, cursor, next)
+	require.Equal(t, b.Cursor(), next)
+
+	// events published while no one was waiting are not missed
+	b.Publish(&storer.NotificationEvent{ID: 2})
+	_, err = b.Wait(ctx, next)
+	require.NoError(t, err)
+}
+
+func TestWaitCancelled(t *testing.T) {
+	b := New()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := b.Wait(ctx, b.Cursor())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
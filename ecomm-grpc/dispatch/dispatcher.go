@@ -0,0 +1,191 @@
+package dispatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+	"github.com/dhij/ecomm/webhook"
+)
+
+const (
+	defaultBatchSize     = 20
+	defaultLeaseDuration = time.Minute
+	defaultTimeout       = 10 * time.Second
+
+	// maxMessageSize fits the message column of the delivery log
+	maxMessageSize = 512
+	// maxResponseSnippet is how much of a failed response is logged
+	maxResponseSnippet = 256
+)
+
+// Dispatcher delivers the webhook deliveries queued by the outbox relay. It
+// leases deliveries like the notification workers lease notification events,
+// so several dispatchers can share the queue.
+type Dispatcher struct {
+	storer        storer.Storer
+	client        *http.Client
+	workerID      string
+	batchSize     int
+	leaseDuration time.Duration
+}
+
+type Option func(*Dispatcher)
+
+// WithHTTPClient sets the client deliveries are sent with. It defaults to a
+// client that gives up after 10 seconds.
+func WithHTTPClient(c *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = c
+	}
+}
+
+// WithWorkerID sets the id deliveries are leased under. It defaults to the
+// host name and process id.
+func WithWorkerID(id string) Option {
+	return func(d *Dispatcher) {
+		d.workerID = id
+	}
+}
+
+func NewDispatcher(st storer.Storer, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		storer:        st,
+		client:        &http.Client{Timeout: defaultTimeout},
+		workerID:      defaultWorkerID(),
+		batchSize:     defaultBatchSize,
+		leaseDuration: defaultLeaseDuration,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "ecomm-grpc"
+	}
+	return fmt.Sprintf("%s-%d-webhooks", host, os.Getpid())
+}
+
+// Run dispatches due deliveries every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := d.Dispatch(ctx)
+		if err != nil {
+			log.Printf("error dispatching webhook deliveries: %v", err)
+		}
+		if n == d.batchSize && ctx.Err() == nil {
+			// more deliveries are likely due
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch claims a batch of due deliveries, sends them concurrently and
+// records the outcome of each. It returns the number of deliveries claimed.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	deliveries, err := d.storer.ClaimWebhookDeliveries(ctx, d.workerID, d.batchSize, d.leaseDuration)
+	if err != nil {
+		return 0, err
+	}
+
+	webhooks := make(map[int64]*storer.Webhook)
+	var wg sync.WaitGroup
+	for _, del := range deliveries {
+		w, ok := webhooks[del.WebhookID]
+		if !ok {
+			w, err = d.storer.GetWebhook(ctx, del.WebhookID)
+			if err != nil {
+				// deleted since the claim, together with its deliveries
+				log.Printf("error getting webhook %d: %v", del.WebhookID, err)
+				continue
+			}
+			webhooks[w.ID] = w
+		}
+
+		wg.Add(1)
+		go func(del *storer.WebhookDelivery, w *storer.Webhook) {
+			defer wg.Done()
+
+			a := d.deliver(ctx, del, w)
+			_, err := d.storer.RecordWebhookDeliveryAttempt(ctx, del, a)
+			if err != nil {
+				log.Printf("error recording webhook delivery %d: %v", del.ID, err)
+			}
+		}(del, w)
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+// deliver POSTs a delivery to its webhook. Any 2xx response is a success.
+func (d *Dispatcher) deliver(ctx context.Context, del *storer.WebhookDelivery, w *storer.Webhook) *storer.WebhookDeliveryAttempt {
+	body, err := json.Marshal(webhook.Event{
+		ID:         del.OutboxEventID,
+		Type:       string(del.EventType),
+		OccurredAt: del.OccurredAt,
+		Data:       del.Payload,
+	})
+	if err != nil {
+		return failedAttempt(0, fmt.Sprintf("error encoding event: %v", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return failedAttempt(0, fmt.Sprintf("error creating request: %v", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	webhook.SetHeaders(req.Header, w.Secret, strconv.FormatInt(del.ID, 10), time.Now(), body)
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return failedAttempt(0, err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseSnippet))
+		return failedAttempt(res.StatusCode, fmt.Sprintf("%s: %s", res.Status, snippet))
+	}
+
+	return &storer.WebhookDeliveryAttempt{
+		StatusCode: res.StatusCode,
+		Succeeded:  true,
+		Message:    res.Status,
+	}
+}
+
+func failedAttempt(statusCode int, msg string) *storer.WebhookDeliveryAttempt {
+	if len(msg) > maxMessageSize {
+		msg = strings.ToValidUTF8(msg[:maxMessageSize], "")
+	}
+
+	return &storer.WebhookDeliveryAttempt{
+		StatusCode: statusCode,
+		Message:    msg,
+	}
+}
@@ -0,0 +1,193 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/dhij/ecomm/ecomm-grpc/outbox"
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+	"github.com/dhij/ecomm/money"
+	"github.com/dhij/ecomm/webhook"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "whsec_test"
+
+// receiver is a partner endpoint that verifies deliveries and answers them
+// with the status codes it is given, then with 200.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	ids      []string
+	events   []webhook.Event
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := webhook.VerifyRequest(secret, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var ev webhook.Event
+	if err := json.Unmarshal(body, &ev); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.ids = append(rc.ids, r.Header.Get(webhook.IDHeader))
+	rc.events = append(rc.events, ev)
+	if len(rc.statuses) > 0 {
+		status := rc.statuses[0]
+		rc.statuses = rc.statuses[1:]
+		http.Error(w, "try again later", status)
+	}
+}
+
+// seedOrderEvents creates an order, which writes an OrderCreated event, and
+// relays it to the webhooks.
+func seedOrderEvents(t *testing.T, st storer.Storer) *storer.Order {
+	ctx := context.Background()
+
+	u, err := st.CreateUser(ctx, &storer.User{Name: "test user", Email: "test@example.com", Password: "password"})
+	require.NoError(t, err)
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "test product", Image: "test.jpg", Price: money.New(1000, "USD"), CountInStock: 10})
+	require.NoError(t, err)
+	o, err := st.CreateOrder(ctx, &storer.Order{
+		PaymentMethod: "card",
+		TotalPrice:    money.New(1000, "USD"),
+		UserID:        u.ID,
+		Items:         []storer.OrderItem{{Name: p.Name, Quantity: 1, Image: p.Image, Price: p.Price, ProductID: p.ID}},
+	})
+	require.NoError(t, err)
+
+	_, err = outbox.NewRelay(st, outbox.NewWebhookConsumer(st)).Publish(ctx)
+	require.NoError(t, err)
+
+	return o
+}
+
+func TestDispatch(t *testing.T) {
+	tcs := []struct {
+		name     string
+		secret   string
+		statuses []int
+		// dispatches is how many times the queue is dispatched
+		dispatches int
+		state      storer.WebhookDeliveryState
+		codes      []int
+	}{
+		{
+			name:       "delivered",
+			secret:     secret,
+			dispatches: 1,
+			state:      storer.DeliverySucceeded,
+			codes:      []int{http.StatusOK},
+		},
+		{
+			name:       "delivered after retry",
+			secret:     secret,
+			statuses:   []int{http.StatusServiceUnavailable},
+			dispatches: 2,
+			state:      storer.DeliverySucceeded,
+			codes:      []int{http.StatusServiceUnavailable, http.StatusOK},
+		},
+		{
+			name:       "given up",
+			secret:     secret,
+			statuses:   []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			dispatches: 3,
+			state:      storer.DeliveryFailed,
+			codes:      []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+		},
+		{
+			name:       "rejected signature",
+			secret:     "whsec_other",
+			dispatches: 3,
+			state:      storer.DeliveryFailed,
+			codes:      []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			rc := &receiver{statuses: tc.statuses}
+			srv := httptest.NewServer(rc)
+			defer srv.Close()
+
+			st := storer.NewMemoryStorer(storer.WithRetryPolicy(storer.RetryPolicy{MaxAttempts: 3, Multiplier: 1}))
+			w, err := st.CreateWebhook(ctx, &storer.Webhook{URL: srv.URL, EventTypes: storer.WebhookEventTypes{storer.OrderCreated}, Secret: tc.secret, Active: true})
+			require.NoError(t, err)
+			o := seedOrderEvents(t, st)
+
+			d := NewDispatcher(st, WithHTTPClient(srv.Client()), WithWorkerID("worker-1"))
+			for i := 0; i < tc.dispatches; i++ {
+				n, err := d.Dispatch(ctx)
+				require.NoError(t, err)
+				require.Equal(t, 1, n)
+			}
+
+			// nothing is left to deliver
+			n, err := d.Dispatch(ctx)
+			require.NoError(t, err)
+			require.Zero(t, n)
+
+			deliveries, _, err := st.ListWebhookDeliveries(ctx, w.ID, storer.Page{})
+			require.NoError(t, err)
+			require.Len(t, deliveries, 1)
+			require.Equal(t, tc.state, deliveries[0].State)
+
+			attempts, err := st.ListWebhookDeliveryAttempts(ctx, deliveries[0].ID)
+			require.NoError(t, err)
+			require.Len(t, attempts, len(tc.codes))
+			for i, code := range tc.codes {
+				require.Equal(t, code, attempts[i].StatusCode)
+				require.Equal(t, code == http.StatusOK, attempts[i].Succeeded)
+			}
+
+			// every attempt carries the same idempotency id and event
+			for i := range rc.ids {
+				require.Equal(t, rc.ids[0], rc.ids[i])
+				require.Equal(t, storer.OrderCreated, storer.OutboxEventType(rc.events[i].Type))
+
+				var oe storer.OrderEvent
+				require.NoError(t, json.Unmarshal(rc.events[i].Data, &oe))
+				require.Equal(t, o.ID, oe.OrderID)
+				require.Equal(t, storer.Pending, oe.Status)
+			}
+		})
+	}
+}
+
+func TestDispatchUnreachable(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	st := storer.NewMemoryStorer(storer.WithRetryPolicy(storer.RetryPolicy{MaxAttempts: 1, Multiplier: 1}))
+	w, err := st.CreateWebhook(ctx, &storer.Webhook{URL: srv.URL, EventTypes: storer.WebhookEventTypes{storer.OrderCreated}, Secret: secret, Active: true})
+	require.NoError(t, err)
+	seedOrderEvents(t, st)
+
+	_, err = NewDispatcher(st).Dispatch(ctx)
+	require.NoError(t, err)
+
+	deliveries, _, err := st.ListWebhookDeliveries(ctx, w.ID, storer.Page{})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, storer.DeliveryFailed, deliveries[0].State)
+
+	attempts, err := st.ListWebhookDeliveryAttempts(ctx, deliveries[0].ID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	require.Zero(t, attempts[0].StatusCode)
+	require.Contains(t, attempts[0].Message, "connection refused")
+}
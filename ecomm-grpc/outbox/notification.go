@@ -0,0 +1,62 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+)
+
+// NotificationConsumer enqueues a notification to the owner of an order for
+// every order event. Events of orders or owners that have been deleted since
+// have nobody to notify and are done.
+type NotificationConsumer struct {
+	storer storer.Storer
+}
+
+func NewNotificationConsumer(st storer.Storer) *NotificationConsumer {
+	return &NotificationConsumer{storer: st}
+}
+
+func (c *NotificationConsumer) Consume(ctx context.Context, ev *storer.OutboxEvent) error {
+	if ev.EventType != storer.OrderCreated && ev.EventType != storer.OrderStatusChanged {
+		return nil
+	}
+
+	oe, err := ev.OrderEvent()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.storer.GetOrder(ctx, oe.OrderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting order: %w", err)
+	}
+
+	// the owner of the order is not the actor when an admin moves it along
+	owner, err := c.storer.GetUserByID(ctx, oe.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting order owner: %w", err)
+	}
+
+	_, err = c.storer.EnqueueNotificationEvent(ctx, &storer.NotificationEvent{
+		UserEmail:     owner.Email,
+		OrderStatus:   oe.Status,
+		OrderID:       storer.OptionalID(oe.OrderID),
+		OutboxEventID: ev.ID,
+	})
+	if errors.Is(err, storer.ErrAlreadyEnqueued) {
+		// delivered before the relay stopped short of marking it published
+		return nil
+	}
+
+	return err
+}
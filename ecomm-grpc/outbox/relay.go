@@ -0,0 +1,135 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+)
+
+const (
+	defaultBatchSize = 100
+	// defaultMaxAttempts is how often an event may fail before it is parked
+	defaultMaxAttempts = 10
+	// maxErrorSize fits the last_error column of the outbox
+	maxErrorSize = 512
+)
+
+// Consumer handles outbox events. Events are delivered at least once: an event
+// is delivered again when the relay stops before marking it published, so
+// consumers must be idempotent.
+type Consumer interface {
+	Consume(ctx context.Context, ev *storer.OutboxEvent) error
+}
+
+// Relay delivers the events in the outbox to its consumers.
+type Relay struct {
+	storer      storer.Storer
+	consumers   []Consumer
+	batchSize   int
+	maxAttempts int64
+}
+
+func NewRelay(st storer.Storer, consumers ...Consumer) *Relay {
+	return &Relay{
+		storer:      st,
+		consumers:   consumers,
+		batchSize:   defaultBatchSize,
+		maxAttempts: defaultMaxAttempts,
+	}
+}
+
+// Run publishes pending events every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.Publish(ctx)
+		if err != nil {
+			log.Printf("error publishing outbox events: %v", err)
+		}
+		if n == r.batchSize && ctx.Err() == nil {
+			// more events are likely pending
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish delivers pending events to every consumer, oldest first, and marks
+// each published once all consumers have handled it. It stops at the first
+// event that fails so that consumers see the events of an order in order; the
+// event is delivered again by the next call. An event that has failed
+// maxAttempts times is parked instead, so that it no longer holds up the
+// events behind it. It returns the number of events published.
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	events, err := r.storer.ListOutboxEvents(ctx, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, ev := range events {
+		err := r.consume(ctx, ev)
+		if err != nil {
+			parked, ferr := r.fail(ctx, ev, err)
+			if ferr != nil {
+				return n, errors.Join(err, ferr)
+			}
+			if !parked {
+				return n, err
+			}
+			log.Printf("parked outbox event %d: %v", ev.ID, err)
+			continue
+		}
+
+		err = r.storer.MarkOutboxEventPublished(ctx, ev.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// deleted along with its order
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+func (r *Relay) consume(ctx context.Context, ev *storer.OutboxEvent) error {
+	for _, c := range r.consumers {
+		err := c.Consume(ctx, ev)
+		if err != nil {
+			return fmt.Errorf("error consuming outbox event %d: %w", ev.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// fail records that ev failed with err and reports whether it is parked.
+func (r *Relay) fail(ctx context.Context, ev *storer.OutboxEvent, err error) (bool, error) {
+	msg := err.Error()
+	if len(msg) > maxErrorSize {
+		msg = strings.ToValidUTF8(msg[:maxErrorSize], "")
+	}
+
+	failed, err := r.storer.FailOutboxEvent(ctx, ev.ID, msg, r.maxAttempts)
+	if err != nil {
+		return false, err
+	}
+
+	return failed.ParkedAt != nil, nil
+}
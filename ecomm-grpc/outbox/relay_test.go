@@ -0,0 +1,240 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+	"github.com/dhij/ecomm/money"
+	"github.com/stretchr/testify/require"
+)
+
+var errCrash = errors.New("crash")
+
+// crashingStorer fails one call to a method, as if the relay crashed there.
+// With afterWrite the call still takes effect before failing, as if the relay
+// crashed after the write committed but before it saw the result.
+type crashingStorer struct {
+	storer.Storer
+	method     string
+	skip       int
+	afterWrite bool
+	crashed    bool
+}
+
+func (cs *crashingStorer) crash(method string) bool {
+	if cs.crashed || method != cs.method {
+		return false
+	}
+	if cs.skip > 0 {
+		cs.skip--
+		return false
+	}
+	cs.crashed = true
+	return true
+}
+
+func (cs *crashingStorer) ListOutboxEvents(ctx context.Context, limit int) ([]*storer.OutboxEvent, error) {
+	if cs.crash("ListOutboxEvents") {
+		return nil, errCrash
+	}
+	return cs.Storer.ListOutboxEvents(ctx, limit)
+}
+
+func (cs *crashingStorer) EnqueueNotificationEvent(ctx context.Context, ne *storer.NotificationEvent) (*storer.NotificationEvent, error) {
+	if !cs.crash("EnqueueNotificationEvent") {
+		return cs.Storer.EnqueueNotificationEvent(ctx, ne)
+	}
+	if cs.afterWrite {
+		_, err := cs.Storer.EnqueueNotificationEvent(ctx, ne)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errCrash
+}
+
+func (cs *crashingStorer) MarkOutboxEventPublished(ctx context.Context, id int64) error {
+	if !cs.crash("MarkOutboxEventPublished") {
+		return cs.Storer.MarkOutboxEventPublished(ctx, id)
+	}
+	if cs.afterWrite {
+		err := cs.Storer.MarkOutboxEventPublished(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+	return errCrash
+}
+
+// seedOrderEvents creates an order and moves it to paid, which writes an
+// OrderCreated and an OrderStatusChanged event.
+func seedOrderEvents(t *testing.T, st storer.Storer) *storer.User {
+	ctx := context.Background()
+
+	u, err := st.CreateUser(ctx, &storer.User{Name: "test user", Email: "test@example.com", Password: "password"})
+	require.NoError(t, err)
+	p, err := st.CreateProduct(ctx, &storer.Product{Name: "test product", Image: "test.jpg", Price: money.New(1000, "USD"), CountInStock: 10})
+	require.NoError(t, err)
+
+	o, err := st.CreateOrder(ctx, &storer.Order{
+		PaymentMethod: "card",
+		TotalPrice:    money.New(1000, "USD"),
+		UserID:        u.ID,
+		Items:         []storer.OrderItem{{Name: p.Name, Quantity: 1, Image: p.Image, Price: p.Price, ProductID: p.ID}},
+	})
+	require.NoError(t, err)
+
+	_, err = st.UpdateOrderStatus(ctx, &storer.OrderStatusChange{OrderID: o.ID, ToStatus: storer.Paid, ActorID: u.ID, ActorRole: storer.AdminActor})
+	require.NoError(t, err)
+
+	return u
+}
+
+func TestRelayCrashRecovery(t *testing.T) {
+	tcs := []struct {
+		name       string
+		method     string
+		skip       int
+		afterWrite bool
+	}{
+		{name: "before listing events", method: "ListOutboxEvents"},
+		{name: "before enqueuing first event", method: "EnqueueNotificationEvent"},
+		{name: "after enqueuing first event", method: "EnqueueNotificationEvent", afterWrite: true},
+		{name: "before enqueuing second event", method: "EnqueueNotificationEvent", skip: 1},
+		{name: "after enqueuing second event", method: "EnqueueNotificationEvent", skip: 1, afterWrite: true},
+		{name: "before marking first event published", method: "MarkOutboxEventPublished"},
+		{name: "after marking first event published", method: "MarkOutboxEventPublished", afterWrite: true},
+		{name: "before marking second event published", method: "MarkOutboxEventPublished", skip: 1},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := storer.NewMemoryStorer()
+			u := seedOrderEvents(t, st)
+
+			cs := &crashingStorer{Storer: st, method: tc.method, skip: tc.skip, afterWrite: tc.afterWrite}
+			relay := NewRelay(cs, NewNotificationConsumer(cs))
+
+			_, err := relay.Publish(ctx)
+			require.ErrorIs(t, err, errCrash)
+
+			// the restarted relay picks up where the crashed one stopped
+			relay = NewRelay(st, NewNotificationConsumer(st))
+			_, err = relay.Publish(ctx)
+			require.NoError(t, err)
+
+			pending, err := st.ListOutboxEvents(ctx, 10)
+			require.NoError(t, err)
+			require.Empty(t, pending)
+
+			// exactly one notification per event, in the order of the events
+			states, _, err := st.ListNotificationStates(ctx, storer.NotificationStateFilter{})
+			require.NoError(t, err)
+			require.Len(t, states, 2)
+			for i, status := range []storer.OrderStatus{storer.Pending, storer.Paid} {
+				require.Equal(t, status, states[i].OrderStatus)
+				require.Equal(t, u.Email, states[i].UserEmail)
+				require.NotNil(t, states[i].OutboxEventID)
+				require.Equal(t, int64(i+1), *states[i].OutboxEventID)
+			}
+		})
+	}
+}
+
+func TestRelayPublish(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	seedOrderEvents(t, st)
+
+	relay := NewRelay(st, NewNotificationConsumer(st))
+	n, err := relay.Publish(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	// published events are not delivered again
+	n, err = relay.Publish(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	states, _, err := st.ListNotificationStates(ctx, storer.NotificationStateFilter{})
+	require.NoError(t, err)
+	require.Len(t, states, 2)
+}
+
+// failingConsumer fails every event with the given ID.
+type failingConsumer struct {
+	id int64
+}
+
+func (c *failingConsumer) Consume(ctx context.Context, ev *storer.OutboxEvent) error {
+	if ev.ID == c.id {
+		return errors.New("poison event")
+	}
+	return nil
+}
+
+func TestRelayParksFailingEvents(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	seedOrderEvents(t, st)
+
+	relay := NewRelay(st, &failingConsumer{id: 1}, NewNotificationConsumer(st))
+	relay.maxAttempts = 2
+
+	// the failing event holds up the events behind it until it is parked
+	n, err := relay.Publish(ctx)
+	require.Error(t, err)
+	require.Zero(t, n)
+
+	n, err = relay.Publish(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	pending, err := st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	states, _, err := st.ListNotificationStates(ctx, storer.NotificationStateFilter{})
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	require.Equal(t, storer.Paid, states[0].OrderStatus)
+}
+
+// deletingStorer deletes the order of the events it lists, as if the order was
+// deleted while the relay delivered them.
+type deletingStorer struct {
+	storer.Storer
+}
+
+func (ds *deletingStorer) ListOutboxEvents(ctx context.Context, limit int) ([]*storer.OutboxEvent, error) {
+	events, err := ds.Storer.ListOutboxEvents(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range events {
+		err := ds.Storer.DeleteOrder(ctx, ev.AggregateID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func TestRelayDeletedOrder(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	seedOrderEvents(t, st)
+
+	ds := &deletingStorer{Storer: st}
+	relay := NewRelay(ds, NewNotificationConsumer(ds))
+	n, err := relay.Publish(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	states, _, err := st.ListNotificationStates(ctx, storer.NotificationStateFilter{})
+	require.NoError(t, err)
+	require.Empty(t, states)
+}
@@ -0,0 +1,23 @@
+package outbox
+
+import (
+	"context"
+
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+)
+
+// WebhookConsumer queues a delivery of every event to the webhooks subscribed
+// to its type.
+type WebhookConsumer struct {
+	storer storer.Storer
+}
+
+func NewWebhookConsumer(st storer.Storer) *WebhookConsumer {
+	return &WebhookConsumer{storer: st}
+}
+
+func (c *WebhookConsumer) Consume(ctx context.Context, ev *storer.OutboxEvent) error {
+	// deliveries already queued for the event are not queued again
+	_, err := c.storer.EnqueueWebhookDeliveries(ctx, ev)
+	return err
+}
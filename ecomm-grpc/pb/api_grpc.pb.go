@@ -24,25 +24,59 @@ const _ = grpc.SupportPackageIsVersion7
 type EcommClient interface {
 	CreateProduct(ctx context.Context, in *ProductReq, opts ...grpc.CallOption) (*ProductRes, error)
 	GetProduct(ctx context.Context, in *ProductReq, opts ...grpc.CallOption) (*ProductRes, error)
-	ListProducts(ctx context.Context, in *ProductReq, opts ...grpc.CallOption) (*ListProductRes, error)
+	ListProducts(ctx context.Context, in *ListProductsReq, opts ...grpc.CallOption) (*ListProductRes, error)
 	UpdateProduct(ctx context.Context, in *ProductReq, opts ...grpc.CallOption) (*ProductRes, error)
 	DeleteProduct(ctx context.Context, in *ProductReq, opts ...grpc.CallOption) (*ProductRes, error)
 	CreateOrder(ctx context.Context, in *OrderReq, opts ...grpc.CallOption) (*OrderRes, error)
-	GetOrder(ctx context.Context, in *OrderReq, opts ...grpc.CallOption) (*OrderRes, error)
-	ListOrders(ctx context.Context, in *OrderReq, opts ...grpc.CallOption) (*ListOrderRes, error)
-	UpdateOrderStatus(ctx context.Context, in *OrderReq, opts ...grpc.CallOption) (*OrderRes, error)
+	GetOrder(ctx context.Context, in *GetOrderReq, opts ...grpc.CallOption) (*OrderRes, error)
+	ListOrders(ctx context.Context, in *ListOrdersReq, opts ...grpc.CallOption) (*ListOrderRes, error)
+	ListOrdersForUser(ctx context.Context, in *ListOrdersForUserReq, opts ...grpc.CallOption) (*ListOrderRes, error)
+	UpdateOrderStatus(ctx context.Context, in *UpdateOrderStatusReq, opts ...grpc.CallOption) (*OrderRes, error)
+	ListOrderStatusHistory(ctx context.Context, in *GetOrderReq, opts ...grpc.CallOption) (*ListOrderStatusHistoryRes, error)
 	DeleteOrder(ctx context.Context, in *OrderReq, opts ...grpc.CallOption) (*OrderRes, error)
+	AddToCart(ctx context.Context, in *CartReq, opts ...grpc.CallOption) (*CartRes, error)
+	UpdateCartItem(ctx context.Context, in *CartReq, opts ...grpc.CallOption) (*CartRes, error)
+	RemoveCartItem(ctx context.Context, in *CartReq, opts ...grpc.CallOption) (*CartRes, error)
+	GetCart(ctx context.Context, in *CartReq, opts ...grpc.CallOption) (*CartRes, error)
+	Checkout(ctx context.Context, in *CheckoutReq, opts ...grpc.CallOption) (*OrderRes, error)
+	CreateReview(ctx context.Context, in *ReviewReq, opts ...grpc.CallOption) (*ReviewRes, error)
+	ListReviews(ctx context.Context, in *ListReviewsReq, opts ...grpc.CallOption) (*ListReviewsRes, error)
+	UpdateReview(ctx context.Context, in *ReviewReq, opts ...grpc.CallOption) (*ReviewRes, error)
+	DeleteReview(ctx context.Context, in *ReviewReq, opts ...grpc.CallOption) (*ReviewRes, error)
 	CreateUser(ctx context.Context, in *UserReq, opts ...grpc.CallOption) (*UserRes, error)
 	GetUser(ctx context.Context, in *UserReq, opts ...grpc.CallOption) (*UserRes, error)
-	ListUsers(ctx context.Context, in *UserReq, opts ...grpc.CallOption) (*ListUserRes, error)
+	ListUsers(ctx context.Context, in *ListUsersReq, opts ...grpc.CallOption) (*ListUserRes, error)
 	UpdateUser(ctx context.Context, in *UserReq, opts ...grpc.CallOption) (*UserRes, error)
 	DeleteUser(ctx context.Context, in *UserReq, opts ...grpc.CallOption) (*UserRes, error)
 	CreateSession(ctx context.Context, in *SessionReq, opts ...grpc.CallOption) (*SessionRes, error)
 	GetSession(ctx context.Context, in *SessionReq, opts ...grpc.CallOption) (*SessionRes, error)
+	RotateSession(ctx context.Context, in *RotateSessionReq, opts ...grpc.CallOption) (*SessionRes, error)
+	ListSessionsForUser(ctx context.Context, in *ListSessionsReq, opts ...grpc.CallOption) (*ListSessionsRes, error)
 	RevokeSession(ctx context.Context, in *SessionReq, opts ...grpc.CallOption) (*SessionRes, error)
+	RevokeAllSessions(ctx context.Context, in *RevokeAllSessionsReq, opts ...grpc.CallOption) (*RevokeAllSessionsRes, error)
 	DeleteSession(ctx context.Context, in *SessionReq, opts ...grpc.CallOption) (*SessionRes, error)
+	RequestPasswordReset(ctx context.Context, in *RequestPasswordResetReq, opts ...grpc.CallOption) (*RequestPasswordResetRes, error)
+	ResetPassword(ctx context.Context, in *ResetPasswordReq, opts ...grpc.CallOption) (*ResetPasswordRes, error)
+	// Deprecated: Do not use.
+	// ListNotificationEvents is read-only; events must be claimed with
+	// ClaimNotificationEvents before they can be acknowledged or nacked.
 	ListNotificationEvents(ctx context.Context, in *ListNotificationEventsReq, opts ...grpc.CallOption) (*ListNotificationEventsRes, error)
+	ClaimNotificationEvents(ctx context.Context, in *ClaimNotificationEventsReq, opts ...grpc.CallOption) (*ClaimNotificationEventsRes, error)
+	SubscribeNotificationEvents(ctx context.Context, in *SubscribeNotificationEventsReq, opts ...grpc.CallOption) (Ecomm_SubscribeNotificationEventsClient, error)
 	UpdateNotificationEvent(ctx context.Context, in *UpdateNotificationEventReq, opts ...grpc.CallOption) (*UpdateNotificationEventRes, error)
+	UpdateNotificationEvents(ctx context.Context, in *UpdateNotificationEventsReq, opts ...grpc.CallOption) (*UpdateNotificationEventsRes, error)
+	ListNotificationStates(ctx context.Context, in *ListNotificationStatesReq, opts ...grpc.CallOption) (*ListNotificationStatesRes, error)
+	GetNotificationState(ctx context.Context, in *NotificationStateReq, opts ...grpc.CallOption) (*NotificationStateRes, error)
+	ReplayNotifications(ctx context.Context, in *ReplayNotificationsReq, opts ...grpc.CallOption) (*ReplayNotificationsRes, error)
+	GetNotificationPreferences(ctx context.Context, in *NotificationPreferencesReq, opts ...grpc.CallOption) (*NotificationPreferencesRes, error)
+	UpdateNotificationPreferences(ctx context.Context, in *UpdateNotificationPreferencesReq, opts ...grpc.CallOption) (*NotificationPreferencesRes, error)
+	CreateWebhook(ctx context.Context, in *WebhookReq, opts ...grpc.CallOption) (*WebhookRes, error)
+	GetWebhook(ctx context.Context, in *WebhookReq, opts ...grpc.CallOption) (*WebhookRes, error)
+	ListWebhooks(ctx context.Context, in *ListWebhooksReq, opts ...grpc.CallOption) (*ListWebhooksRes, error)
+	UpdateWebhook(ctx context.Context, in *WebhookReq, opts ...grpc.CallOption) (*WebhookRes, error)
+	DeleteWebhook(ctx context.Context, in *WebhookReq, opts ...grpc.CallOption) (*WebhookRes, error)
+	ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesReq, opts ...grpc.CallOption) (*ListWebhookDeliveriesRes, error)
+	GetWebhookDelivery(ctx context.Context, in *WebhookDeliveryReq, opts ...grpc.CallOption) (*WebhookDeliveryRes, error)
 }
 
 type ecommClient struct {
@@ -71,7 +105,7 @@ func (c *ecommClient) GetProduct(ctx context.Context, in *ProductReq, opts ...gr
 	return out, nil
 }
 
-func (c *ecommClient) ListProducts(ctx context.Context, in *ProductReq, opts ...grpc.CallOption) (*ListProductRes, error) {
+func (c *ecommClient) ListProducts(ctx context.Context, in *ListProductsReq, opts ...grpc.CallOption) (*ListProductRes, error) {
 	out := new(ListProductRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/ListProducts", in, out, opts...)
 	if err != nil {
@@ -107,7 +141,7 @@ func (c *ecommClient) CreateOrder(ctx context.Context, in *OrderReq, opts ...grp
 	return out, nil
 }
 
-func (c *ecommClient) GetOrder(ctx context.Context, in *OrderReq, opts ...grpc.CallOption) (*OrderRes, error) {
+func (c *ecommClient) GetOrder(ctx context.Context, in *GetOrderReq, opts ...grpc.CallOption) (*OrderRes, error) {
 	out := new(OrderRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/GetOrder", in, out, opts...)
 	if err != nil {
@@ -116,7 +150,7 @@ func (c *ecommClient) GetOrder(ctx context.Context, in *OrderReq, opts ...grpc.C
 	return out, nil
 }
 
-func (c *ecommClient) ListOrders(ctx context.Context, in *OrderReq, opts ...grpc.CallOption) (*ListOrderRes, error) {
+func (c *ecommClient) ListOrders(ctx context.Context, in *ListOrdersReq, opts ...grpc.CallOption) (*ListOrderRes, error) {
 	out := new(ListOrderRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/ListOrders", in, out, opts...)
 	if err != nil {
@@ -125,7 +159,16 @@ func (c *ecommClient) ListOrders(ctx context.Context, in *OrderReq, opts ...grpc
 	return out, nil
 }
 
-func (c *ecommClient) UpdateOrderStatus(ctx context.Context, in *OrderReq, opts ...grpc.CallOption) (*OrderRes, error) {
+func (c *ecommClient) ListOrdersForUser(ctx context.Context, in *ListOrdersForUserReq, opts ...grpc.CallOption) (*ListOrderRes, error) {
+	out := new(ListOrderRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ListOrdersForUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) UpdateOrderStatus(ctx context.Context, in *UpdateOrderStatusReq, opts ...grpc.CallOption) (*OrderRes, error) {
 	out := new(OrderRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/UpdateOrderStatus", in, out, opts...)
 	if err != nil {
@@ -134,6 +177,15 @@ func (c *ecommClient) UpdateOrderStatus(ctx context.Context, in *OrderReq, opts
 	return out, nil
 }
 
+func (c *ecommClient) ListOrderStatusHistory(ctx context.Context, in *GetOrderReq, opts ...grpc.CallOption) (*ListOrderStatusHistoryRes, error) {
+	out := new(ListOrderStatusHistoryRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ListOrderStatusHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *ecommClient) DeleteOrder(ctx context.Context, in *OrderReq, opts ...grpc.CallOption) (*OrderRes, error) {
 	out := new(OrderRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/DeleteOrder", in, out, opts...)
@@ -143,6 +195,87 @@ func (c *ecommClient) DeleteOrder(ctx context.Context, in *OrderReq, opts ...grp
 	return out, nil
 }
 
+func (c *ecommClient) AddToCart(ctx context.Context, in *CartReq, opts ...grpc.CallOption) (*CartRes, error) {
+	out := new(CartRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/AddToCart", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) UpdateCartItem(ctx context.Context, in *CartReq, opts ...grpc.CallOption) (*CartRes, error) {
+	out := new(CartRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/UpdateCartItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) RemoveCartItem(ctx context.Context, in *CartReq, opts ...grpc.CallOption) (*CartRes, error) {
+	out := new(CartRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/RemoveCartItem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) GetCart(ctx context.Context, in *CartReq, opts ...grpc.CallOption) (*CartRes, error) {
+	out := new(CartRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/GetCart", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) Checkout(ctx context.Context, in *CheckoutReq, opts ...grpc.CallOption) (*OrderRes, error) {
+	out := new(OrderRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/Checkout", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) CreateReview(ctx context.Context, in *ReviewReq, opts ...grpc.CallOption) (*ReviewRes, error) {
+	out := new(ReviewRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/CreateReview", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) ListReviews(ctx context.Context, in *ListReviewsReq, opts ...grpc.CallOption) (*ListReviewsRes, error) {
+	out := new(ListReviewsRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ListReviews", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) UpdateReview(ctx context.Context, in *ReviewReq, opts ...grpc.CallOption) (*ReviewRes, error) {
+	out := new(ReviewRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/UpdateReview", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) DeleteReview(ctx context.Context, in *ReviewReq, opts ...grpc.CallOption) (*ReviewRes, error) {
+	out := new(ReviewRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/DeleteReview", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *ecommClient) CreateUser(ctx context.Context, in *UserReq, opts ...grpc.CallOption) (*UserRes, error) {
 	out := new(UserRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/CreateUser", in, out, opts...)
@@ -161,7 +294,7 @@ func (c *ecommClient) GetUser(ctx context.Context, in *UserReq, opts ...grpc.Cal
 	return out, nil
 }
 
-func (c *ecommClient) ListUsers(ctx context.Context, in *UserReq, opts ...grpc.CallOption) (*ListUserRes, error) {
+func (c *ecommClient) ListUsers(ctx context.Context, in *ListUsersReq, opts ...grpc.CallOption) (*ListUserRes, error) {
 	out := new(ListUserRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/ListUsers", in, out, opts...)
 	if err != nil {
@@ -206,6 +339,24 @@ func (c *ecommClient) GetSession(ctx context.Context, in *SessionReq, opts ...gr
 	return out, nil
 }
 
+func (c *ecommClient) RotateSession(ctx context.Context, in *RotateSessionReq, opts ...grpc.CallOption) (*SessionRes, error) {
+	out := new(SessionRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/RotateSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) ListSessionsForUser(ctx context.Context, in *ListSessionsReq, opts ...grpc.CallOption) (*ListSessionsRes, error) {
+	out := new(ListSessionsRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ListSessionsForUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *ecommClient) RevokeSession(ctx context.Context, in *SessionReq, opts ...grpc.CallOption) (*SessionRes, error) {
 	out := new(SessionRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/RevokeSession", in, out, opts...)
@@ -215,6 +366,15 @@ func (c *ecommClient) RevokeSession(ctx context.Context, in *SessionReq, opts ..
 	return out, nil
 }
 
+func (c *ecommClient) RevokeAllSessions(ctx context.Context, in *RevokeAllSessionsReq, opts ...grpc.CallOption) (*RevokeAllSessionsRes, error) {
+	out := new(RevokeAllSessionsRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/RevokeAllSessions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *ecommClient) DeleteSession(ctx context.Context, in *SessionReq, opts ...grpc.CallOption) (*SessionRes, error) {
 	out := new(SessionRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/DeleteSession", in, out, opts...)
@@ -224,6 +384,25 @@ func (c *ecommClient) DeleteSession(ctx context.Context, in *SessionReq, opts ..
 	return out, nil
 }
 
+func (c *ecommClient) RequestPasswordReset(ctx context.Context, in *RequestPasswordResetReq, opts ...grpc.CallOption) (*RequestPasswordResetRes, error) {
+	out := new(RequestPasswordResetRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/RequestPasswordReset", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) ResetPassword(ctx context.Context, in *ResetPasswordReq, opts ...grpc.CallOption) (*ResetPasswordRes, error) {
+	out := new(ResetPasswordRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ResetPassword", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Deprecated: Do not use.
 func (c *ecommClient) ListNotificationEvents(ctx context.Context, in *ListNotificationEventsReq, opts ...grpc.CallOption) (*ListNotificationEventsRes, error) {
 	out := new(ListNotificationEventsRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/ListNotificationEvents", in, out, opts...)
@@ -233,6 +412,47 @@ func (c *ecommClient) ListNotificationEvents(ctx context.Context, in *ListNotifi
 	return out, nil
 }
 
+func (c *ecommClient) ClaimNotificationEvents(ctx context.Context, in *ClaimNotificationEventsReq, opts ...grpc.CallOption) (*ClaimNotificationEventsRes, error) {
+	out := new(ClaimNotificationEventsRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ClaimNotificationEvents", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) SubscribeNotificationEvents(ctx context.Context, in *SubscribeNotificationEventsReq, opts ...grpc.CallOption) (Ecomm_SubscribeNotificationEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Ecomm_ServiceDesc.Streams[0], "/pb.ecomm/SubscribeNotificationEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ecommSubscribeNotificationEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Ecomm_SubscribeNotificationEventsClient interface {
+	Recv() (*SubscribeNotificationEventsRes, error)
+	grpc.ClientStream
+}
+
+type ecommSubscribeNotificationEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *ecommSubscribeNotificationEventsClient) Recv() (*SubscribeNotificationEventsRes, error) {
+	m := new(SubscribeNotificationEventsRes)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *ecommClient) UpdateNotificationEvent(ctx context.Context, in *UpdateNotificationEventReq, opts ...grpc.CallOption) (*UpdateNotificationEventRes, error) {
 	out := new(UpdateNotificationEventRes)
 	err := c.cc.Invoke(ctx, "/pb.ecomm/UpdateNotificationEvent", in, out, opts...)
@@ -242,31 +462,182 @@ func (c *ecommClient) UpdateNotificationEvent(ctx context.Context, in *UpdateNot
 	return out, nil
 }
 
+func (c *ecommClient) UpdateNotificationEvents(ctx context.Context, in *UpdateNotificationEventsReq, opts ...grpc.CallOption) (*UpdateNotificationEventsRes, error) {
+	out := new(UpdateNotificationEventsRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/UpdateNotificationEvents", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) ListNotificationStates(ctx context.Context, in *ListNotificationStatesReq, opts ...grpc.CallOption) (*ListNotificationStatesRes, error) {
+	out := new(ListNotificationStatesRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ListNotificationStates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) GetNotificationState(ctx context.Context, in *NotificationStateReq, opts ...grpc.CallOption) (*NotificationStateRes, error) {
+	out := new(NotificationStateRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/GetNotificationState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) ReplayNotifications(ctx context.Context, in *ReplayNotificationsReq, opts ...grpc.CallOption) (*ReplayNotificationsRes, error) {
+	out := new(ReplayNotificationsRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ReplayNotifications", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) GetNotificationPreferences(ctx context.Context, in *NotificationPreferencesReq, opts ...grpc.CallOption) (*NotificationPreferencesRes, error) {
+	out := new(NotificationPreferencesRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/GetNotificationPreferences", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) UpdateNotificationPreferences(ctx context.Context, in *UpdateNotificationPreferencesReq, opts ...grpc.CallOption) (*NotificationPreferencesRes, error) {
+	out := new(NotificationPreferencesRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/UpdateNotificationPreferences", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) CreateWebhook(ctx context.Context, in *WebhookReq, opts ...grpc.CallOption) (*WebhookRes, error) {
+	out := new(WebhookRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/CreateWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) GetWebhook(ctx context.Context, in *WebhookReq, opts ...grpc.CallOption) (*WebhookRes, error) {
+	out := new(WebhookRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/GetWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) ListWebhooks(ctx context.Context, in *ListWebhooksReq, opts ...grpc.CallOption) (*ListWebhooksRes, error) {
+	out := new(ListWebhooksRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ListWebhooks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) UpdateWebhook(ctx context.Context, in *WebhookReq, opts ...grpc.CallOption) (*WebhookRes, error) {
+	out := new(WebhookRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/UpdateWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) DeleteWebhook(ctx context.Context, in *WebhookReq, opts ...grpc.CallOption) (*WebhookRes, error) {
+	out := new(WebhookRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/DeleteWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesReq, opts ...grpc.CallOption) (*ListWebhookDeliveriesRes, error) {
+	out := new(ListWebhookDeliveriesRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/ListWebhookDeliveries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ecommClient) GetWebhookDelivery(ctx context.Context, in *WebhookDeliveryReq, opts ...grpc.CallOption) (*WebhookDeliveryRes, error) {
+	out := new(WebhookDeliveryRes)
+	err := c.cc.Invoke(ctx, "/pb.ecomm/GetWebhookDelivery", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // EcommServer is the server API for Ecomm service.
 // All implementations must embed UnimplementedEcommServer
 // for forward compatibility
 type EcommServer interface {
 	CreateProduct(context.Context, *ProductReq) (*ProductRes, error)
 	GetProduct(context.Context, *ProductReq) (*ProductRes, error)
-	ListProducts(context.Context, *ProductReq) (*ListProductRes, error)
+	ListProducts(context.Context, *ListProductsReq) (*ListProductRes, error)
 	UpdateProduct(context.Context, *ProductReq) (*ProductRes, error)
 	DeleteProduct(context.Context, *ProductReq) (*ProductRes, error)
 	CreateOrder(context.Context, *OrderReq) (*OrderRes, error)
-	GetOrder(context.Context, *OrderReq) (*OrderRes, error)
-	ListOrders(context.Context, *OrderReq) (*ListOrderRes, error)
-	UpdateOrderStatus(context.Context, *OrderReq) (*OrderRes, error)
+	GetOrder(context.Context, *GetOrderReq) (*OrderRes, error)
+	ListOrders(context.Context, *ListOrdersReq) (*ListOrderRes, error)
+	ListOrdersForUser(context.Context, *ListOrdersForUserReq) (*ListOrderRes, error)
+	UpdateOrderStatus(context.Context, *UpdateOrderStatusReq) (*OrderRes, error)
+	ListOrderStatusHistory(context.Context, *GetOrderReq) (*ListOrderStatusHistoryRes, error)
 	DeleteOrder(context.Context, *OrderReq) (*OrderRes, error)
+	AddToCart(context.Context, *CartReq) (*CartRes, error)
+	UpdateCartItem(context.Context, *CartReq) (*CartRes, error)
+	RemoveCartItem(context.Context, *CartReq) (*CartRes, error)
+	GetCart(context.Context, *CartReq) (*CartRes, error)
+	Checkout(context.Context, *CheckoutReq) (*OrderRes, error)
+	CreateReview(context.Context, *ReviewReq) (*ReviewRes, error)
+	ListReviews(context.Context, *ListReviewsReq) (*ListReviewsRes, error)
+	UpdateReview(context.Context, *ReviewReq) (*ReviewRes, error)
+	DeleteReview(context.Context, *ReviewReq) (*ReviewRes, error)
 	CreateUser(context.Context, *UserReq) (*UserRes, error)
 	GetUser(context.Context, *UserReq) (*UserRes, error)
-	ListUsers(context.Context, *UserReq) (*ListUserRes, error)
+	ListUsers(context.Context, *ListUsersReq) (*ListUserRes, error)
 	UpdateUser(context.Context, *UserReq) (*UserRes, error)
 	DeleteUser(context.Context, *UserReq) (*UserRes, error)
 	CreateSession(context.Context, *SessionReq) (*SessionRes, error)
 	GetSession(context.Context, *SessionReq) (*SessionRes, error)
+	RotateSession(context.Context, *RotateSessionReq) (*SessionRes, error)
+	ListSessionsForUser(context.Context, *ListSessionsReq) (*ListSessionsRes, error)
 	RevokeSession(context.Context, *SessionReq) (*SessionRes, error)
+	RevokeAllSessions(context.Context, *RevokeAllSessionsReq) (*RevokeAllSessionsRes, error)
 	DeleteSession(context.Context, *SessionReq) (*SessionRes, error)
+	RequestPasswordReset(context.Context, *RequestPasswordResetReq) (*RequestPasswordResetRes, error)
+	ResetPassword(context.Context, *ResetPasswordReq) (*ResetPasswordRes, error)
+	// Deprecated: Do not use.
+	// ListNotificationEvents is read-only; events must be claimed with
+	// ClaimNotificationEvents before they can be acknowledged or nacked.
 	ListNotificationEvents(context.Context, *ListNotificationEventsReq) (*ListNotificationEventsRes, error)
+	ClaimNotificationEvents(context.Context, *ClaimNotificationEventsReq) (*ClaimNotificationEventsRes, error)
+	SubscribeNotificationEvents(*SubscribeNotificationEventsReq, Ecomm_SubscribeNotificationEventsServer) error
 	UpdateNotificationEvent(context.Context, *UpdateNotificationEventReq) (*UpdateNotificationEventRes, error)
+	UpdateNotificationEvents(context.Context, *UpdateNotificationEventsReq) (*UpdateNotificationEventsRes, error)
+	ListNotificationStates(context.Context, *ListNotificationStatesReq) (*ListNotificationStatesRes, error)
+	GetNotificationState(context.Context, *NotificationStateReq) (*NotificationStateRes, error)
+	ReplayNotifications(context.Context, *ReplayNotificationsReq) (*ReplayNotificationsRes, error)
+	GetNotificationPreferences(context.Context, *NotificationPreferencesReq) (*NotificationPreferencesRes, error)
+	UpdateNotificationPreferences(context.Context, *UpdateNotificationPreferencesReq) (*NotificationPreferencesRes, error)
+	CreateWebhook(context.Context, *WebhookReq) (*WebhookRes, error)
+	GetWebhook(context.Context, *WebhookReq) (*WebhookRes, error)
+	ListWebhooks(context.Context, *ListWebhooksReq) (*ListWebhooksRes, error)
+	UpdateWebhook(context.Context, *WebhookReq) (*WebhookRes, error)
+	DeleteWebhook(context.Context, *WebhookReq) (*WebhookRes, error)
+	ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesReq) (*ListWebhookDeliveriesRes, error)
+	GetWebhookDelivery(context.Context, *WebhookDeliveryReq) (*WebhookDeliveryRes, error)
 	mustEmbedUnimplementedEcommServer()
 }
 
@@ -280,7 +651,7 @@ func (UnimplementedEcommServer) CreateProduct(context.Context, *ProductReq) (*Pr
 func (UnimplementedEcommServer) GetProduct(context.Context, *ProductReq) (*ProductRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetProduct not implemented")
 }
-func (UnimplementedEcommServer) ListProducts(context.Context, *ProductReq) (*ListProductRes, error) {
+func (UnimplementedEcommServer) ListProducts(context.Context, *ListProductsReq) (*ListProductRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListProducts not implemented")
 }
 func (UnimplementedEcommServer) UpdateProduct(context.Context, *ProductReq) (*ProductRes, error) {
@@ -292,25 +663,58 @@ func (UnimplementedEcommServer) DeleteProduct(context.Context, *ProductReq) (*Pr
 func (UnimplementedEcommServer) CreateOrder(context.Context, *OrderReq) (*OrderRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateOrder not implemented")
 }
-func (UnimplementedEcommServer) GetOrder(context.Context, *OrderReq) (*OrderRes, error) {
+func (UnimplementedEcommServer) GetOrder(context.Context, *GetOrderReq) (*OrderRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetOrder not implemented")
 }
-func (UnimplementedEcommServer) ListOrders(context.Context, *OrderReq) (*ListOrderRes, error) {
+func (UnimplementedEcommServer) ListOrders(context.Context, *ListOrdersReq) (*ListOrderRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListOrders not implemented")
 }
-func (UnimplementedEcommServer) UpdateOrderStatus(context.Context, *OrderReq) (*OrderRes, error) {
+func (UnimplementedEcommServer) ListOrdersForUser(context.Context, *ListOrdersForUserReq) (*ListOrderRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOrdersForUser not implemented")
+}
+func (UnimplementedEcommServer) UpdateOrderStatus(context.Context, *UpdateOrderStatusReq) (*OrderRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateOrderStatus not implemented")
 }
+func (UnimplementedEcommServer) ListOrderStatusHistory(context.Context, *GetOrderReq) (*ListOrderStatusHistoryRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOrderStatusHistory not implemented")
+}
 func (UnimplementedEcommServer) DeleteOrder(context.Context, *OrderReq) (*OrderRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteOrder not implemented")
 }
+func (UnimplementedEcommServer) AddToCart(context.Context, *CartReq) (*CartRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddToCart not implemented")
+}
+func (UnimplementedEcommServer) UpdateCartItem(context.Context, *CartReq) (*CartRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateCartItem not implemented")
+}
+func (UnimplementedEcommServer) RemoveCartItem(context.Context, *CartReq) (*CartRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveCartItem not implemented")
+}
+func (UnimplementedEcommServer) GetCart(context.Context, *CartReq) (*CartRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCart not implemented")
+}
+func (UnimplementedEcommServer) Checkout(context.Context, *CheckoutReq) (*OrderRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Checkout not implemented")
+}
+func (UnimplementedEcommServer) CreateReview(context.Context, *ReviewReq) (*ReviewRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateReview not implemented")
+}
+func (UnimplementedEcommServer) ListReviews(context.Context, *ListReviewsReq) (*ListReviewsRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListReviews not implemented")
+}
+func (UnimplementedEcommServer) UpdateReview(context.Context, *ReviewReq) (*ReviewRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateReview not implemented")
+}
+func (UnimplementedEcommServer) DeleteReview(context.Context, *ReviewReq) (*ReviewRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteReview not implemented")
+}
 func (UnimplementedEcommServer) CreateUser(context.Context, *UserReq) (*UserRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateUser not implemented")
 }
 func (UnimplementedEcommServer) GetUser(context.Context, *UserReq) (*UserRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
 }
-func (UnimplementedEcommServer) ListUsers(context.Context, *UserReq) (*ListUserRes, error) {
+func (UnimplementedEcommServer) ListUsers(context.Context, *ListUsersReq) (*ListUserRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
 }
 func (UnimplementedEcommServer) UpdateUser(context.Context, *UserReq) (*UserRes, error) {
@@ -325,18 +729,78 @@ func (UnimplementedEcommServer) CreateSession(context.Context, *SessionReq) (*Se
 func (UnimplementedEcommServer) GetSession(context.Context, *SessionReq) (*SessionRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetSession not implemented")
 }
+func (UnimplementedEcommServer) RotateSession(context.Context, *RotateSessionReq) (*SessionRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateSession not implemented")
+}
+func (UnimplementedEcommServer) ListSessionsForUser(context.Context, *ListSessionsReq) (*ListSessionsRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessionsForUser not implemented")
+}
 func (UnimplementedEcommServer) RevokeSession(context.Context, *SessionReq) (*SessionRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RevokeSession not implemented")
 }
+func (UnimplementedEcommServer) RevokeAllSessions(context.Context, *RevokeAllSessionsReq) (*RevokeAllSessionsRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeAllSessions not implemented")
+}
 func (UnimplementedEcommServer) DeleteSession(context.Context, *SessionReq) (*SessionRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteSession not implemented")
 }
+func (UnimplementedEcommServer) RequestPasswordReset(context.Context, *RequestPasswordResetReq) (*RequestPasswordResetRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestPasswordReset not implemented")
+}
+func (UnimplementedEcommServer) ResetPassword(context.Context, *ResetPasswordReq) (*ResetPasswordRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResetPassword not implemented")
+}
 func (UnimplementedEcommServer) ListNotificationEvents(context.Context, *ListNotificationEventsReq) (*ListNotificationEventsRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListNotificationEvents not implemented")
 }
+func (UnimplementedEcommServer) ClaimNotificationEvents(context.Context, *ClaimNotificationEventsReq) (*ClaimNotificationEventsRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClaimNotificationEvents not implemented")
+}
+func (UnimplementedEcommServer) SubscribeNotificationEvents(*SubscribeNotificationEventsReq, Ecomm_SubscribeNotificationEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeNotificationEvents not implemented")
+}
 func (UnimplementedEcommServer) UpdateNotificationEvent(context.Context, *UpdateNotificationEventReq) (*UpdateNotificationEventRes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateNotificationEvent not implemented")
 }
+func (UnimplementedEcommServer) UpdateNotificationEvents(context.Context, *UpdateNotificationEventsReq) (*UpdateNotificationEventsRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateNotificationEvents not implemented")
+}
+func (UnimplementedEcommServer) ListNotificationStates(context.Context, *ListNotificationStatesReq) (*ListNotificationStatesRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNotificationStates not implemented")
+}
+func (UnimplementedEcommServer) GetNotificationState(context.Context, *NotificationStateReq) (*NotificationStateRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNotificationState not implemented")
+}
+func (UnimplementedEcommServer) ReplayNotifications(context.Context, *ReplayNotificationsReq) (*ReplayNotificationsRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplayNotifications not implemented")
+}
+func (UnimplementedEcommServer) GetNotificationPreferences(context.Context, *NotificationPreferencesReq) (*NotificationPreferencesRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNotificationPreferences not implemented")
+}
+func (UnimplementedEcommServer) UpdateNotificationPreferences(context.Context, *UpdateNotificationPreferencesReq) (*NotificationPreferencesRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateNotificationPreferences not implemented")
+}
+func (UnimplementedEcommServer) CreateWebhook(context.Context, *WebhookReq) (*WebhookRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateWebhook not implemented")
+}
+func (UnimplementedEcommServer) GetWebhook(context.Context, *WebhookReq) (*WebhookRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWebhook not implemented")
+}
+func (UnimplementedEcommServer) ListWebhooks(context.Context, *ListWebhooksReq) (*ListWebhooksRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhooks not implemented")
+}
+func (UnimplementedEcommServer) UpdateWebhook(context.Context, *WebhookReq) (*WebhookRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateWebhook not implemented")
+}
+func (UnimplementedEcommServer) DeleteWebhook(context.Context, *WebhookReq) (*WebhookRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteWebhook not implemented")
+}
+func (UnimplementedEcommServer) ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesReq) (*ListWebhookDeliveriesRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhookDeliveries not implemented")
+}
+func (UnimplementedEcommServer) GetWebhookDelivery(context.Context, *WebhookDeliveryReq) (*WebhookDeliveryRes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWebhookDelivery not implemented")
+}
 func (UnimplementedEcommServer) mustEmbedUnimplementedEcommServer() {}
 
 // UnsafeEcommServer may be embedded to opt out of forward compatibility for this service.
@@ -387,7 +851,7 @@ func _Ecomm_GetProduct_Handler(srv interface{}, ctx context.Context, dec func(in
 }
 
 func _Ecomm_ListProducts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ProductReq)
+	in := new(ListProductsReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -399,7 +863,7 @@ func _Ecomm_ListProducts_Handler(srv interface{}, ctx context.Context, dec func(
 		FullMethod: "/pb.ecomm/ListProducts",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(EcommServer).ListProducts(ctx, req.(*ProductReq))
+		return srv.(EcommServer).ListProducts(ctx, req.(*ListProductsReq))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -459,7 +923,7 @@ func _Ecomm_CreateOrder_Handler(srv interface{}, ctx context.Context, dec func(i
 }
 
 func _Ecomm_GetOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(OrderReq)
+	in := new(GetOrderReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -471,13 +935,13 @@ func _Ecomm_GetOrder_Handler(srv interface{}, ctx context.Context, dec func(inte
 		FullMethod: "/pb.ecomm/GetOrder",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(EcommServer).GetOrder(ctx, req.(*OrderReq))
+		return srv.(EcommServer).GetOrder(ctx, req.(*GetOrderReq))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
 func _Ecomm_ListOrders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(OrderReq)
+	in := new(ListOrdersReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -489,72 +953,270 @@ func _Ecomm_ListOrders_Handler(srv interface{}, ctx context.Context, dec func(in
 		FullMethod: "/pb.ecomm/ListOrders",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(EcommServer).ListOrders(ctx, req.(*OrderReq))
+		return srv.(EcommServer).ListOrders(ctx, req.(*ListOrdersReq))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _Ecomm_UpdateOrderStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(OrderReq)
+func _Ecomm_ListOrdersForUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOrdersForUserReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(EcommServer).UpdateOrderStatus(ctx, in)
+		return srv.(EcommServer).ListOrdersForUser(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/pb.ecomm/UpdateOrderStatus",
+		FullMethod: "/pb.ecomm/ListOrdersForUser",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(EcommServer).UpdateOrderStatus(ctx, req.(*OrderReq))
+		return srv.(EcommServer).ListOrdersForUser(ctx, req.(*ListOrdersForUserReq))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _Ecomm_DeleteOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(OrderReq)
+func _Ecomm_UpdateOrderStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateOrderStatusReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(EcommServer).DeleteOrder(ctx, in)
+		return srv.(EcommServer).UpdateOrderStatus(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/pb.ecomm/DeleteOrder",
+		FullMethod: "/pb.ecomm/UpdateOrderStatus",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(EcommServer).DeleteOrder(ctx, req.(*OrderReq))
+		return srv.(EcommServer).UpdateOrderStatus(ctx, req.(*UpdateOrderStatusReq))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _Ecomm_CreateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(UserReq)
+func _Ecomm_ListOrderStatusHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOrderReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(EcommServer).CreateUser(ctx, in)
+		return srv.(EcommServer).ListOrderStatusHistory(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/pb.ecomm/CreateUser",
+		FullMethod: "/pb.ecomm/ListOrderStatusHistory",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(EcommServer).CreateUser(ctx, req.(*UserReq))
+		return srv.(EcommServer).ListOrderStatusHistory(ctx, req.(*GetOrderReq))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _Ecomm_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(UserReq)
+func _Ecomm_DeleteOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OrderReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(EcommServer).GetUser(ctx, in)
+		return srv.(EcommServer).DeleteOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/DeleteOrder",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).DeleteOrder(ctx, req.(*OrderReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_AddToCart_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CartReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).AddToCart(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/AddToCart",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).AddToCart(ctx, req.(*CartReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_UpdateCartItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CartReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).UpdateCartItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/UpdateCartItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).UpdateCartItem(ctx, req.(*CartReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_RemoveCartItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CartReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).RemoveCartItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/RemoveCartItem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).RemoveCartItem(ctx, req.(*CartReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_GetCart_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CartReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).GetCart(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/GetCart",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).GetCart(ctx, req.(*CartReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_Checkout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckoutReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).Checkout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/Checkout",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).Checkout(ctx, req.(*CheckoutReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_CreateReview_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReviewReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).CreateReview(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/CreateReview",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).CreateReview(ctx, req.(*ReviewReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_ListReviews_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListReviewsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).ListReviews(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/ListReviews",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).ListReviews(ctx, req.(*ListReviewsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_UpdateReview_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReviewReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).UpdateReview(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/UpdateReview",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).UpdateReview(ctx, req.(*ReviewReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_DeleteReview_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReviewReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).DeleteReview(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/DeleteReview",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).DeleteReview(ctx, req.(*ReviewReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_CreateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).CreateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/CreateUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).CreateUser(ctx, req.(*UserReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).GetUser(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
@@ -567,7 +1229,7 @@ func _Ecomm_GetUser_Handler(srv interface{}, ctx context.Context, dec func(inter
 }
 
 func _Ecomm_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(UserReq)
+	in := new(ListUsersReq)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -579,7 +1241,7 @@ func _Ecomm_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(int
 		FullMethod: "/pb.ecomm/ListUsers",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(EcommServer).ListUsers(ctx, req.(*UserReq))
+		return srv.(EcommServer).ListUsers(ctx, req.(*ListUsersReq))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -656,6 +1318,42 @@ func _Ecomm_GetSession_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Ecomm_RotateSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateSessionReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).RotateSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/RotateSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).RotateSession(ctx, req.(*RotateSessionReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_ListSessionsForUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).ListSessionsForUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/ListSessionsForUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).ListSessionsForUser(ctx, req.(*ListSessionsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Ecomm_RevokeSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(SessionReq)
 	if err := dec(in); err != nil {
@@ -674,6 +1372,24 @@ func _Ecomm_RevokeSession_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Ecomm_RevokeAllSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeAllSessionsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).RevokeAllSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/RevokeAllSessions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).RevokeAllSessions(ctx, req.(*RevokeAllSessionsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Ecomm_DeleteSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(SessionReq)
 	if err := dec(in); err != nil {
@@ -692,6 +1408,42 @@ func _Ecomm_DeleteSession_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Ecomm_RequestPasswordReset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestPasswordResetReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).RequestPasswordReset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/RequestPasswordReset",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).RequestPasswordReset(ctx, req.(*RequestPasswordResetReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_ResetPassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetPasswordReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).ResetPassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/ResetPassword",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).ResetPassword(ctx, req.(*ResetPasswordReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Ecomm_ListNotificationEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ListNotificationEventsReq)
 	if err := dec(in); err != nil {
@@ -710,6 +1462,45 @@ func _Ecomm_ListNotificationEvents_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Ecomm_ClaimNotificationEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClaimNotificationEventsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).ClaimNotificationEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/ClaimNotificationEvents",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).ClaimNotificationEvents(ctx, req.(*ClaimNotificationEventsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_SubscribeNotificationEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeNotificationEventsReq)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EcommServer).SubscribeNotificationEvents(m, &ecommSubscribeNotificationEventsServer{stream})
+}
+
+type Ecomm_SubscribeNotificationEventsServer interface {
+	Send(*SubscribeNotificationEventsRes) error
+	grpc.ServerStream
+}
+
+type ecommSubscribeNotificationEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *ecommSubscribeNotificationEventsServer) Send(m *SubscribeNotificationEventsRes) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _Ecomm_UpdateNotificationEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UpdateNotificationEventReq)
 	if err := dec(in); err != nil {
@@ -728,6 +1519,240 @@ func _Ecomm_UpdateNotificationEvent_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Ecomm_UpdateNotificationEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateNotificationEventsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).UpdateNotificationEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/UpdateNotificationEvents",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).UpdateNotificationEvents(ctx, req.(*UpdateNotificationEventsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_ListNotificationStates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNotificationStatesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).ListNotificationStates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/ListNotificationStates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).ListNotificationStates(ctx, req.(*ListNotificationStatesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_GetNotificationState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NotificationStateReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).GetNotificationState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/GetNotificationState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).GetNotificationState(ctx, req.(*NotificationStateReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_ReplayNotifications_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplayNotificationsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).ReplayNotifications(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/ReplayNotifications",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).ReplayNotifications(ctx, req.(*ReplayNotificationsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_GetNotificationPreferences_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NotificationPreferencesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).GetNotificationPreferences(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/GetNotificationPreferences",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).GetNotificationPreferences(ctx, req.(*NotificationPreferencesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_UpdateNotificationPreferences_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateNotificationPreferencesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).UpdateNotificationPreferences(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/UpdateNotificationPreferences",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).UpdateNotificationPreferences(ctx, req.(*UpdateNotificationPreferencesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_CreateWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).CreateWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/CreateWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).CreateWebhook(ctx, req.(*WebhookReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_GetWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).GetWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/GetWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).GetWebhook(ctx, req.(*WebhookReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_ListWebhooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhooksReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).ListWebhooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/ListWebhooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).ListWebhooks(ctx, req.(*ListWebhooksReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_UpdateWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).UpdateWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/UpdateWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).UpdateWebhook(ctx, req.(*WebhookReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_DeleteWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).DeleteWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/DeleteWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).DeleteWebhook(ctx, req.(*WebhookReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_ListWebhookDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhookDeliveriesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).ListWebhookDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/ListWebhookDeliveries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).ListWebhookDeliveries(ctx, req.(*ListWebhookDeliveriesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ecomm_GetWebhookDelivery_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookDeliveryReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EcommServer).GetWebhookDelivery(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.ecomm/GetWebhookDelivery",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EcommServer).GetWebhookDelivery(ctx, req.(*WebhookDeliveryReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Ecomm_ServiceDesc is the grpc.ServiceDesc for Ecomm service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -767,14 +1792,58 @@ var Ecomm_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListOrders",
 			Handler:    _Ecomm_ListOrders_Handler,
 		},
+		{
+			MethodName: "ListOrdersForUser",
+			Handler:    _Ecomm_ListOrdersForUser_Handler,
+		},
 		{
 			MethodName: "UpdateOrderStatus",
 			Handler:    _Ecomm_UpdateOrderStatus_Handler,
 		},
+		{
+			MethodName: "ListOrderStatusHistory",
+			Handler:    _Ecomm_ListOrderStatusHistory_Handler,
+		},
 		{
 			MethodName: "DeleteOrder",
 			Handler:    _Ecomm_DeleteOrder_Handler,
 		},
+		{
+			MethodName: "AddToCart",
+			Handler:    _Ecomm_AddToCart_Handler,
+		},
+		{
+			MethodName: "UpdateCartItem",
+			Handler:    _Ecomm_UpdateCartItem_Handler,
+		},
+		{
+			MethodName: "RemoveCartItem",
+			Handler:    _Ecomm_RemoveCartItem_Handler,
+		},
+		{
+			MethodName: "GetCart",
+			Handler:    _Ecomm_GetCart_Handler,
+		},
+		{
+			MethodName: "Checkout",
+			Handler:    _Ecomm_Checkout_Handler,
+		},
+		{
+			MethodName: "CreateReview",
+			Handler:    _Ecomm_CreateReview_Handler,
+		},
+		{
+			MethodName: "ListReviews",
+			Handler:    _Ecomm_ListReviews_Handler,
+		},
+		{
+			MethodName: "UpdateReview",
+			Handler:    _Ecomm_UpdateReview_Handler,
+		},
+		{
+			MethodName: "DeleteReview",
+			Handler:    _Ecomm_DeleteReview_Handler,
+		},
 		{
 			MethodName: "CreateUser",
 			Handler:    _Ecomm_CreateUser_Handler,
@@ -803,23 +1872,105 @@ var Ecomm_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetSession",
 			Handler:    _Ecomm_GetSession_Handler,
 		},
+		{
+			MethodName: "RotateSession",
+			Handler:    _Ecomm_RotateSession_Handler,
+		},
+		{
+			MethodName: "ListSessionsForUser",
+			Handler:    _Ecomm_ListSessionsForUser_Handler,
+		},
 		{
 			MethodName: "RevokeSession",
 			Handler:    _Ecomm_RevokeSession_Handler,
 		},
+		{
+			MethodName: "RevokeAllSessions",
+			Handler:    _Ecomm_RevokeAllSessions_Handler,
+		},
 		{
 			MethodName: "DeleteSession",
 			Handler:    _Ecomm_DeleteSession_Handler,
 		},
+		{
+			MethodName: "RequestPasswordReset",
+			Handler:    _Ecomm_RequestPasswordReset_Handler,
+		},
+		{
+			MethodName: "ResetPassword",
+			Handler:    _Ecomm_ResetPassword_Handler,
+		},
 		{
 			MethodName: "ListNotificationEvents",
 			Handler:    _Ecomm_ListNotificationEvents_Handler,
 		},
+		{
+			MethodName: "ClaimNotificationEvents",
+			Handler:    _Ecomm_ClaimNotificationEvents_Handler,
+		},
 		{
 			MethodName: "UpdateNotificationEvent",
 			Handler:    _Ecomm_UpdateNotificationEvent_Handler,
 		},
+		{
+			MethodName: "UpdateNotificationEvents",
+			Handler:    _Ecomm_UpdateNotificationEvents_Handler,
+		},
+		{
+			MethodName: "ListNotificationStates",
+			Handler:    _Ecomm_ListNotificationStates_Handler,
+		},
+		{
+			MethodName: "GetNotificationState",
+			Handler:    _Ecomm_GetNotificationState_Handler,
+		},
+		{
+			MethodName: "ReplayNotifications",
+			Handler:    _Ecomm_ReplayNotifications_Handler,
+		},
+		{
+			MethodName: "GetNotificationPreferences",
+			Handler:    _Ecomm_GetNotificationPreferences_Handler,
+		},
+		{
+			MethodName: "UpdateNotificationPreferences",
+			Handler:    _Ecomm_UpdateNotificationPreferences_Handler,
+		},
+		{
+			MethodName: "CreateWebhook",
+			Handler:    _Ecomm_CreateWebhook_Handler,
+		},
+		{
+			MethodName: "GetWebhook",
+			Handler:    _Ecomm_GetWebhook_Handler,
+		},
+		{
+			MethodName: "ListWebhooks",
+			Handler:    _Ecomm_ListWebhooks_Handler,
+		},
+		{
+			MethodName: "UpdateWebhook",
+			Handler:    _Ecomm_UpdateWebhook_Handler,
+		},
+		{
+			MethodName: "DeleteWebhook",
+			Handler:    _Ecomm_DeleteWebhook_Handler,
+		},
+		{
+			MethodName: "ListWebhookDeliveries",
+			Handler:    _Ecomm_ListWebhookDeliveries_Handler,
+		},
+		{
+			MethodName: "GetWebhookDelivery",
+			Handler:    _Ecomm_GetWebhookDelivery_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeNotificationEvents",
+			Handler:       _Ecomm_SubscribeNotificationEvents_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api.proto",
 }
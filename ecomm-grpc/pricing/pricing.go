@@ -0,0 +1,144 @@
+package pricing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dhij/ecomm/money"
+)
+
+// Line is a single order line priced from the catalogue.
+type Line struct {
+	ProductID int64
+	Name      string
+	Quantity  int64
+	UnitPrice money.Money
+}
+
+func (l Line) Total() money.Money {
+	return l.UnitPrice.Mul(l.Quantity)
+}
+
+// Quote is the server-side price of an order.
+type Quote struct {
+	Lines         []Line
+	SubtotalPrice money.Money
+	TaxPrice      money.Money
+	ShippingPrice money.Money
+	TotalPrice    money.Money
+}
+
+// PricingRule adjusts catalogue prices before totals are computed, e.g. to
+// apply a discount. Rules run in the order they are configured.
+type PricingRule interface {
+	Apply(ctx context.Context, lines []Line) ([]Line, error)
+}
+
+type TaxCalculator interface {
+	Tax(ctx context.Context, lines []Line, subtotal money.Money) (money.Money, error)
+}
+
+type ShippingCalculator interface {
+	Shipping(ctx context.Context, lines []Line, subtotal money.Money) (money.Money, error)
+}
+
+type Pricer struct {
+	rules    []PricingRule
+	tax      TaxCalculator
+	shipping ShippingCalculator
+}
+
+func NewPricer(tax TaxCalculator, shipping ShippingCalculator, rules ...PricingRule) *Pricer {
+	return &Pricer{
+		rules:    rules,
+		tax:      tax,
+		shipping: shipping,
+	}
+}
+
+func (p *Pricer) Quote(ctx context.Context, lines []Line) (*Quote, error) {
+	var err error
+	for _, r := range p.rules {
+		lines, err = r.Apply(ctx, lines)
+		if err != nil {
+			return nil, fmt.Errorf("error applying pricing rule: %w", err)
+		}
+	}
+
+	for _, l := range lines {
+		if l.Quantity <= 0 {
+			return nil, fmt.Errorf("invalid quantity %d for product %d", l.Quantity, l.ProductID)
+		}
+	}
+
+	q := &Quote{Lines: lines}
+	q.SubtotalPrice, err = Subtotal(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	q.TaxPrice, err = p.tax.Tax(ctx, lines, q.SubtotalPrice)
+	if err != nil {
+		return nil, fmt.Errorf("error calculating tax: %w", err)
+	}
+
+	q.ShippingPrice, err = p.shipping.Shipping(ctx, lines, q.SubtotalPrice)
+	if err != nil {
+		return nil, fmt.Errorf("error calculating shipping: %w", err)
+	}
+
+	q.TotalPrice, err = money.Sum(q.SubtotalPrice.Currency, q.SubtotalPrice, q.TaxPrice, q.ShippingPrice)
+	if err != nil {
+		return nil, fmt.Errorf("error calculating total: %w", err)
+	}
+
+	return q, nil
+}
+
+// Subtotal adds up the line totals. All lines must be in the same currency.
+func Subtotal(lines []Line) (money.Money, error) {
+	var subtotal money.Money
+	for _, l := range lines {
+		var err error
+		subtotal, err = subtotal.Add(l.Total())
+		if err != nil {
+			return money.Money{}, fmt.Errorf("error calculating subtotal: %w", err)
+		}
+	}
+
+	return subtotal, nil
+}
+
+// FlatRateTax charges a percentage of the subtotal, expressed in basis points
+// (1300 is 13%) so that the tax is exact to the cent.
+type FlatRateTax struct {
+	BasisPoints int64
+}
+
+func (t FlatRateTax) Tax(ctx context.Context, lines []Line, subtotal money.Money) (money.Money, error) {
+	return subtotal.MulRatio(t.BasisPoints, 10000), nil
+}
+
+// FlatShipping charges Price per order, or nothing once the subtotal reaches
+// FreeOver. A zero FreeOver disables free shipping.
+type FlatShipping struct {
+	Price    money.Money
+	FreeOver money.Money
+}
+
+func (s FlatShipping) Shipping(ctx context.Context, lines []Line, subtotal money.Money) (money.Money, error) {
+	if !s.FreeOver.IsZero() {
+		if s.FreeOver.Currency != subtotal.Currency {
+			return money.Money{}, fmt.Errorf("free shipping threshold is in %s, order is in %s", s.FreeOver.Currency, subtotal.Currency)
+		}
+		if subtotal.Amount >= s.FreeOver.Amount {
+			return money.New(0, subtotal.Currency), nil
+		}
+	}
+
+	if s.Price.Currency != "" && s.Price.Currency != subtotal.Currency {
+		return money.Money{}, fmt.Errorf("shipping price is in %s, order is in %s", s.Price.Currency, subtotal.Currency)
+	}
+
+	return money.New(s.Price.Amount, subtotal.Currency), nil
+}
@@ -0,0 +1,113 @@
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/dhij/ecomm/money"
+	"github.com/stretchr/testify/require"
+)
+
+type halfPriceRule struct {
+	productID int64
+}
+
+func (r halfPriceRule) Apply(ctx context.Context, lines []Line) ([]Line, error) {
+	for i := range lines {
+		if lines[i].ProductID == r.productID {
+			lines[i].UnitPrice = lines[i].UnitPrice.MulRatio(1, 2)
+		}
+	}
+	return lines, nil
+}
+
+type failingRule struct{}
+
+func (failingRule) Apply(ctx context.Context, lines []Line) ([]Line, error) {
+	return nil, fmt.Errorf("rule failed")
+}
+
+func usd(amount int64) money.Money {
+	return money.New(amount, "USD")
+}
+
+func TestQuote(t *testing.T) {
+	lines := func() []Line {
+		return []Line{
+			{ProductID: 1, Name: "test product", Quantity: 3, UnitPrice: usd(1999)},
+			{ProductID: 2, Name: "test product 2", Quantity: 1, UnitPrice: usd(10)},
+		}
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T)
+	}{
+		{
+			name: "tax and shipping",
+			test: func(t *testing.T) {
+				p := NewPricer(FlatRateTax{BasisPoints: 1300}, FlatShipping{Price: usd(500)})
+				q, err := p.Quote(context.Background(), lines())
+				require.NoError(t, err)
+				require.Equal(t, usd(6007), q.SubtotalPrice)
+				require.Equal(t, usd(781), q.TaxPrice)
+				require.Equal(t, usd(500), q.ShippingPrice)
+				require.Equal(t, usd(7288), q.TotalPrice)
+				require.Equal(t, usd(5997), q.Lines[0].Total())
+			},
+		},
+		{
+			name: "free shipping",
+			test: func(t *testing.T) {
+				p := NewPricer(FlatRateTax{}, FlatShipping{Price: usd(500), FreeOver: usd(5000)})
+				q, err := p.Quote(context.Background(), lines())
+				require.NoError(t, err)
+				require.Equal(t, usd(0), q.ShippingPrice)
+				require.Equal(t, q.SubtotalPrice, q.TotalPrice)
+			},
+		},
+		{
+			name: "pricing rules",
+			test: func(t *testing.T) {
+				p := NewPricer(FlatRateTax{}, FlatShipping{}, halfPriceRule{productID: 2})
+				q, err := p.Quote(context.Background(), lines())
+				require.NoError(t, err)
+				require.Equal(t, usd(5), q.Lines[1].UnitPrice)
+				require.Equal(t, usd(6002), q.TotalPrice)
+			},
+		},
+		{
+			name: "failing rule",
+			test: func(t *testing.T) {
+				p := NewPricer(FlatRateTax{}, FlatShipping{}, failingRule{})
+				_, err := p.Quote(context.Background(), lines())
+				require.Error(t, err)
+			},
+		},
+		{
+			name: "invalid quantity",
+			test: func(t *testing.T) {
+				p := NewPricer(FlatRateTax{}, FlatShipping{})
+				ls := lines()
+				ls[0].Quantity = 0
+				_, err := p.Quote(context.Background(), ls)
+				require.Error(t, err)
+			},
+		},
+		{
+			name: "mixed currencies",
+			test: func(t *testing.T) {
+				p := NewPricer(FlatRateTax{}, FlatShipping{})
+				ls := lines()
+				ls[1].UnitPrice = money.New(10, "EUR")
+				_, err := p.Quote(context.Background(), ls)
+				require.Error(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, tc.test)
+	}
+}
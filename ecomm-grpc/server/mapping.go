@@ -1,11 +1,18 @@
 package server
 
 import (
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/ecomm-grpc/pricing"
 	"github.com/dhij/ecomm/ecomm-grpc/storer"
+	"github.com/dhij/ecomm/money"
 	"github.com/dhij/ecomm/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -15,9 +22,7 @@ func toStorerProduct(p *pb.ProductReq) *storer.Product {
 		Image:        p.Image,
 		Category:     p.Category,
 		Description:  p.Description,
-		Rating:       p.Rating,
-		NumReviews:   p.NumReviews,
-		Price:        p.Price,
+		Price:        toMoney(p.Price),
 		CountInStock: p.CountInStock,
 	}
 }
@@ -30,7 +35,7 @@ func toPBProductRes(p *storer.Product) *pb.ProductRes {
 		Description:  p.Description,
 		Rating:       p.Rating,
 		NumReviews:   p.NumReviews,
-		Price:        p.Price,
+		Price:        toPBMoney(p.Price),
 		CountInStock: p.CountInStock,
 		CreatedAt:    timestamppb.New(p.CreatedAt),
 	}
@@ -54,14 +59,8 @@ func patchProductReq(product *storer.Product, p *pb.ProductReq) {
 	if p.Description != "" {
 		product.Description = p.Description
 	}
-	if p.Rating != 0 {
-		product.Rating = p.Rating
-	}
-	if p.NumReviews != 0 {
-		product.NumReviews = p.NumReviews
-	}
-	if p.Price != 0 {
-		product.Price = p.Price
+	if p.Price != nil {
+		product.Price = toMoney(p.Price)
 	}
 	if p.CountInStock != 0 {
 		product.CountInStock = p.CountInStock
@@ -73,29 +72,15 @@ func toTimePtr(t time.Time) *time.Time {
 	return &t
 }
 
-func toStorerOrder(o *pb.OrderReq) *storer.Order {
-	return &storer.Order{
-		PaymentMethod: o.PaymentMethod,
-		TaxPrice:      o.TaxPrice,
-		ShippingPrice: o.ShippingPrice,
-		TotalPrice:    o.TotalPrice,
-		UserID:        o.UserId,
-		Items:         toStorerOrderItems(o.Items),
-	}
+func toMoney(m *pb.Money) money.Money {
+	return money.New(m.GetAmount(), m.GetCurrency())
 }
 
-func toStorerOrderItems(items []*pb.OrderItem) []storer.OrderItem {
-	var res []storer.OrderItem
-	for _, i := range items {
-		res = append(res, storer.OrderItem{
-			Name:      i.Name,
-			Quantity:  i.Quantity,
-			Image:     i.Image,
-			Price:     i.Price,
-			ProductID: i.ProductId,
-		})
+func toPBMoney(m money.Money) *pb.Money {
+	return &pb.Money{
+		Amount:   m.Amount,
+		Currency: m.Currency,
 	}
-	return res
 }
 
 func toPBOrderStatus(os storer.OrderStatus) pb.OrderStatus {
@@ -106,22 +91,51 @@ func toPBOrderStatus(os storer.OrderStatus) pb.OrderStatus {
 		return pb.OrderStatus_SHIPPED
 	case storer.Delivered:
 		return pb.OrderStatus_DELIVERED
+	case storer.Paid:
+		return pb.OrderStatus_PAID
+	case storer.Processing:
+		return pb.OrderStatus_PROCESSING
+	case storer.Cancelled:
+		return pb.OrderStatus_CANCELLED
+	case storer.RefundRequested:
+		return pb.OrderStatus_REFUND_REQUESTED
+	case storer.Refunded:
+		return pb.OrderStatus_REFUNDED
 	default:
 		return 0
 	}
 }
 
+func toStorerOrderStatus(os pb.OrderStatus) storer.OrderStatus {
+	return storer.OrderStatus(strings.ToLower(os.String()))
+}
+
+func toPBOrderStatusChange(c *storer.OrderStatusChange) *pb.OrderStatusChange {
+	return &pb.OrderStatusChange{
+		Id:         c.ID,
+		OrderId:    c.OrderID,
+		FromStatus: toPBOrderStatus(c.FromStatus),
+		ToStatus:   toPBOrderStatus(c.ToStatus),
+		ActorId:    c.ActorID,
+		ActorRole:  string(c.ActorRole),
+		Reason:     c.Reason,
+		CreatedAt:  timestamppb.New(c.CreatedAt),
+	}
+}
+
 func toPBOrderRes(o *storer.Order) *pb.OrderRes {
 	res := &pb.OrderRes{
 		Id:            o.ID,
 		Items:         toPBOrderItems(o.Items),
 		PaymentMethod: o.PaymentMethod,
-		TaxPrice:      o.TaxPrice,
-		ShippingPrice: o.ShippingPrice,
-		TotalPrice:    o.TotalPrice,
+		UserId:        o.UserID,
+		TaxPrice:      toPBMoney(o.TaxPrice),
+		ShippingPrice: toPBMoney(o.ShippingPrice),
+		TotalPrice:    toPBMoney(o.TotalPrice),
 		Status:        toPBOrderStatus(o.Status),
 		CreatedAt:     timestamppb.New(o.CreatedAt),
 	}
+	res.PriceLines, res.SubtotalPrice = toPBPriceLines(o.Items, o.TotalPrice.Currency)
 	if o.UpdatedAt != nil {
 		res.UpdatedAt = timestamppb.New(*o.UpdatedAt)
 	}
@@ -136,19 +150,170 @@ func toPBOrderItems(items []storer.OrderItem) []*pb.OrderItem {
 			Name:      i.Name,
 			Quantity:  i.Quantity,
 			Image:     i.Image,
-			Price:     i.Price,
+			Price:     toPBMoney(i.Price),
 			ProductId: i.ProductID,
 		})
 	}
 	return res
 }
 
+// toPBPriceLines builds the price breakdown of a stored order. Stored items
+// always share the currency of the order.
+func toPBPriceLines(items []storer.OrderItem, currency string) ([]*pb.PriceLine, *pb.Money) {
+	var res []*pb.PriceLine
+	subtotal := money.New(0, currency)
+	for _, i := range items {
+		l := pricing.Line{
+			ProductID: i.ProductID,
+			Name:      i.Name,
+			Quantity:  i.Quantity,
+			UnitPrice: i.Price,
+		}
+		res = append(res, &pb.PriceLine{
+			ProductId: l.ProductID,
+			Name:      l.Name,
+			Quantity:  l.Quantity,
+			UnitPrice: toPBMoney(l.UnitPrice),
+			LineTotal: toPBMoney(l.Total()),
+		})
+		subtotal.Amount += l.Total().Amount
+	}
+	return res, toPBMoney(subtotal)
+}
+
+func toStorerPage(pageSize int32, pageToken, sort string) storer.Page {
+	return storer.Page{
+		Limit:  int(pageSize),
+		Cursor: pageToken,
+		Sort:   sort,
+	}
+}
+
+func toProductFilter(p *pb.ListProductsReq) storer.ProductFilter {
+	f := storer.ProductFilter{
+		Page:     toStorerPage(p.GetPageSize(), p.GetPageToken(), p.GetSort()),
+		Category: p.GetCategory(),
+		InStock:  p.GetInStock(),
+	}
+	if p.GetMinPrice() != nil {
+		m := toMoney(p.GetMinPrice())
+		f.MinPrice = &m
+	}
+	if p.GetMaxPrice() != nil {
+		m := toMoney(p.GetMaxPrice())
+		f.MaxPrice = &m
+	}
+
+	return f
+}
+
+func toOrderFilter(o *pb.ListOrdersReq) storer.OrderFilter {
+	f := storer.OrderFilter{
+		Page:   toStorerPage(o.GetPageSize(), o.GetPageToken(), o.GetSort()),
+		UserID: o.GetUserId(),
+	}
+	if o.Status != nil {
+		f.Status = toStorerOrderStatus(o.GetStatus())
+	}
+	if o.GetCreatedAfter() != nil {
+		f.CreatedAfter = toTimePtr(o.GetCreatedAfter().AsTime())
+	}
+	if o.GetCreatedBefore() != nil {
+		f.CreatedBefore = toTimePtr(o.GetCreatedBefore().AsTime())
+	}
+
+	return f
+}
+
+func toUserFilter(u *pb.ListUsersReq) storer.UserFilter {
+	f := storer.UserFilter{
+		Page: toStorerPage(u.GetPageSize(), u.GetPageToken(), u.GetSort()),
+	}
+	if u.IsAdmin != nil {
+		isAdmin := u.GetIsAdmin()
+		f.IsAdmin = &isAdmin
+	}
+
+	return f
+}
+
+func toPBCartRes(c *storer.Cart) *pb.CartRes {
+	res := &pb.CartRes{
+		Id: c.ID,
+	}
+	subtotal := money.New(0, money.DefaultCurrency)
+	for _, ci := range c.Items {
+		l := pricing.Line{
+			ProductID: ci.ProductID,
+			Name:      ci.Name,
+			Quantity:  ci.Quantity,
+			UnitPrice: ci.Price,
+		}
+		res.Items = append(res.Items, &pb.CartItem{
+			ProductId: l.ProductID,
+			Name:      l.Name,
+			Image:     ci.Image,
+			Quantity:  l.Quantity,
+			UnitPrice: toPBMoney(l.UnitPrice),
+			LineTotal: toPBMoney(l.Total()),
+		})
+		subtotal.Amount += l.Total().Amount
+	}
+	res.SubtotalPrice = toPBMoney(subtotal)
+	if c.UpdatedAt != nil {
+		res.UpdatedAt = timestamppb.New(*c.UpdatedAt)
+	}
+
+	return res
+}
+
+func toPBReviewRes(r *storer.Review) *pb.ReviewRes {
+	res := &pb.ReviewRes{
+		Id:        r.ID,
+		ProductId: r.ProductID,
+		UserId:    r.UserID,
+		Stars:     r.Stars,
+		Title:     r.Title,
+		Body:      r.Body,
+		CreatedAt: timestamppb.New(r.CreatedAt),
+	}
+	if r.UpdatedAt != nil {
+		res.UpdatedAt = timestamppb.New(*r.UpdatedAt)
+	}
+
+	return res
+}
+
+func toPBSessionRes(s *storer.Session) *pb.SessionRes {
+	res := &pb.SessionRes{
+		Id:           s.ID,
+		ParentId:     s.ParentID,
+		FamilyId:     s.FamilyID,
+		UserEmail:    s.UserEmail,
+		RefreshToken: s.RefreshToken,
+		UserAgent:    s.UserAgent,
+		ClientIp:     s.ClientIP,
+		IsRevoked:    s.IsRevoked,
+		CreatedAt:    timestamppb.New(s.CreatedAt),
+		ExpiresAt:    timestamppb.New(s.ExpiresAt),
+	}
+	if s.LastUsedAt != nil {
+		res.LastUsedAt = timestamppb.New(*s.LastUsedAt)
+	}
+	if s.RotatedAt != nil {
+		res.RotatedAt = timestamppb.New(*s.RotatedAt)
+	}
+
+	return res
+}
+
 func toStorerUser(u *pb.UserReq) *storer.User {
 	return &storer.User{
 		Name:     u.Name,
 		Email:    u.Email,
 		Password: u.Password,
 		IsAdmin:  u.IsAdmin,
+		Locale:   u.Locale,
 	}
 }
 
@@ -159,6 +324,7 @@ func toPBUserRes(u *storer.User) *pb.UserRes {
 		Email:    u.Email,
 		Password: u.Password,
 		IsAdmin:  u.IsAdmin,
+		Locale:   u.Locale,
 	}
 }
 
@@ -179,5 +345,307 @@ func patchUserReq(user *storer.User, u *pb.UserReq) {
 	if u.IsAdmin {
 		user.IsAdmin = u.IsAdmin
 	}
+	if u.Locale != "" {
+		user.Locale = u.Locale
+	}
 	user.UpdatedAt = toTimePtr(time.Now())
 }
+
+func toPBNotificationEvent(ne *storer.NotificationEvent) *pb.NotificationEvent {
+	res := &pb.NotificationEvent{
+		Id:               ne.ID,
+		UserEmail:        ne.UserEmail,
+		OrderStatus:      toPBOrderStatus(ne.OrderStatus),
+		OrderId:          int64(ne.OrderID),
+		StateId:          ne.StateID,
+		Attempts:         ne.Attempts,
+		LeasedBy:         ne.LeasedBy,
+		NextAttemptAt:    timestamppb.New(ne.NextAttemptAt),
+		NotificationType: string(ne.NotificationType),
+	}
+	if ne.NotificationType == "" {
+		res.NotificationType = string(storer.OrderNotification(ne.OrderStatus))
+	}
+	if ne.LeasedUntil != nil {
+		res.LeasedUntil = timestamppb.New(*ne.LeasedUntil)
+	}
+	// a payload that doesn't decode leaves the worker nothing to send, which
+	// fails the notification
+	if r, err := ne.PasswordReset(); err == nil {
+		res.PasswordReset = &pb.PasswordResetNotification{
+			Token:     r.Token,
+			ExpiresAt: timestamppb.New(r.ExpiresAt),
+		}
+	}
+
+	return res
+}
+
+func toPBNotificationEvents(evs []*storer.NotificationEvent) []*pb.NotificationEvent {
+	res := make([]*pb.NotificationEvent, 0, len(evs))
+	for _, ev := range evs {
+		res = append(res, toPBNotificationEvent(ev))
+	}
+
+	return res
+}
+
+func toPBNotificationDeliveryState(s storer.NotificationEventState) pb.NotificationDeliveryState {
+	switch s {
+	case storer.Sent:
+		return pb.NotificationDeliveryState_SENT
+	case storer.Failed:
+		return pb.NotificationDeliveryState_FAILED
+	case storer.Suppressed:
+		return pb.NotificationDeliveryState_SUPPRESSED
+	default:
+		return pb.NotificationDeliveryState_NOT_SENT
+	}
+}
+
+func toStorerNotificationState(s pb.NotificationDeliveryState) storer.NotificationEventState {
+	switch s {
+	case pb.NotificationDeliveryState_SENT:
+		return storer.Sent
+	case pb.NotificationDeliveryState_FAILED:
+		return storer.Failed
+	case pb.NotificationDeliveryState_SUPPRESSED:
+		return storer.Suppressed
+	default:
+		return storer.NotSent
+	}
+}
+
+func toPBNotificationStateRes(ns *storer.NotificationState) *pb.NotificationStateRes {
+	res := &pb.NotificationStateRes{
+		Id:          ns.ID,
+		OrderId:     int64(ns.OrderID),
+		UserEmail:   ns.UserEmail,
+		OrderStatus: toPBOrderStatus(ns.OrderStatus),
+		State:       toPBNotificationDeliveryState(ns.State),
+		Message:     ns.Message,
+		RequestedAt: timestamppb.New(ns.RequestedAt),
+	}
+	if ns.CompletedAt != nil {
+		res.CompletedAt = timestamppb.New(*ns.CompletedAt)
+	}
+
+	return res
+}
+
+func toPBNotificationAttempt(a *storer.NotificationAttempt) *pb.NotificationAttempt {
+	return &pb.NotificationAttempt{
+		Attempt:     a.Attempt,
+		Succeeded:   a.Succeeded,
+		Message:     a.Message,
+		AttemptedAt: timestamppb.New(a.AttemptedAt),
+	}
+}
+
+func toPBNotificationReplay(r *storer.NotificationReplay) *pb.NotificationReplay {
+	return &pb.NotificationReplay{
+		ActorId:         r.ActorID,
+		PreviousMessage: r.PreviousMessage,
+		CreatedAt:       timestamppb.New(r.CreatedAt),
+	}
+}
+
+func toNotificationStateFilter(n *pb.ListNotificationStatesReq) storer.NotificationStateFilter {
+	f := storer.NotificationStateFilter{
+		Page:    toStorerPage(n.GetPageSize(), n.GetPageToken(), n.GetSort()),
+		OrderID: n.GetOrderId(),
+	}
+	if n.State != nil {
+		f.State = toStorerNotificationState(n.GetState())
+	}
+	if n.GetRequestedAfter() != nil {
+		f.RequestedAfter = toTimePtr(n.GetRequestedAfter().AsTime())
+	}
+	if n.GetRequestedBefore() != nil {
+		f.RequestedBefore = toTimePtr(n.GetRequestedBefore().AsTime())
+	}
+
+	return f
+}
+
+// toPBNotificationPreferencesRes fills in the types and channels the user has
+// not set a preference for as enabled.
+func toPBNotificationPreferencesRes(prefs []*storer.NotificationPreference) *pb.NotificationPreferencesRes {
+	type key struct {
+		typ     storer.NotificationType
+		channel storer.NotificationChannel
+	}
+	enabled := make(map[key]bool, len(prefs))
+	for _, p := range prefs {
+		enabled[key{p.Type, p.Channel}] = p.Enabled
+	}
+
+	res := &pb.NotificationPreferencesRes{
+		Preferences: make([]*pb.NotificationPreference, 0, len(storer.NotificationTypes)*len(storer.NotificationChannels)),
+	}
+	for _, typ := range storer.NotificationTypes {
+		for _, channel := range storer.NotificationChannels {
+			e, ok := enabled[key{typ, channel}]
+			res.Preferences = append(res.Preferences, &pb.NotificationPreference{
+				Type:    string(typ),
+				Channel: string(channel),
+				Enabled: !ok || e,
+			})
+		}
+	}
+
+	return res
+}
+
+func toStorerNotificationPreferences(prefs []*pb.NotificationPreference) ([]*storer.NotificationPreference, error) {
+	res := make([]*storer.NotificationPreference, 0, len(prefs))
+	for _, p := range prefs {
+		typ := storer.NotificationType(p.GetType())
+		if !slices.Contains(storer.NotificationTypes, typ) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid notification type %q", p.GetType())
+		}
+		channel := storer.NotificationChannel(p.GetChannel())
+		if !slices.Contains(storer.NotificationChannels, channel) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid notification channel %q", p.GetChannel())
+		}
+		res = append(res, &storer.NotificationPreference{
+			Type:    typ,
+			Channel: channel,
+			Enabled: p.GetEnabled(),
+		})
+	}
+
+	return res, nil
+}
+
+func toStorerWebhook(w *pb.WebhookReq) (*storer.Webhook, error) {
+	err := validateWebhookURL(w.GetUrl())
+	if err != nil {
+		return nil, err
+	}
+	types, err := toStorerWebhookEventTypes(w.GetEventTypes())
+	if err != nil {
+		return nil, err
+	}
+
+	return &storer.Webhook{
+		URL:        w.GetUrl(),
+		EventTypes: types,
+		Secret:     w.GetSecret(),
+		Active:     w.Active == nil || w.GetActive(),
+	}, nil
+}
+
+func patchWebhookReq(wh *storer.Webhook, w *pb.WebhookReq) error {
+	if w.GetUrl() != "" {
+		err := validateWebhookURL(w.GetUrl())
+		if err != nil {
+			return err
+		}
+		wh.URL = w.GetUrl()
+	}
+	if len(w.GetEventTypes()) > 0 {
+		types, err := toStorerWebhookEventTypes(w.GetEventTypes())
+		if err != nil {
+			return err
+		}
+		wh.EventTypes = types
+	}
+	if w.GetSecret() != "" {
+		wh.Secret = w.GetSecret()
+	}
+	if w.Active != nil {
+		wh.Active = w.GetActive()
+	}
+	wh.UpdatedAt = toTimePtr(time.Now())
+
+	return nil
+}
+
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return status.Errorf(codes.InvalidArgument, "invalid webhook url %q", rawURL)
+	}
+	return nil
+}
+
+func toStorerWebhookEventTypes(types []string) (storer.WebhookEventTypes, error) {
+	if len(types) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "event types are required")
+	}
+
+	var wt storer.WebhookEventTypes
+	for _, t := range types {
+		typ := storer.OutboxEventType(t)
+		if !slices.Contains(storer.OutboxEventTypes, typ) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid event type %q", t)
+		}
+		if !wt.Has(typ) {
+			wt = append(wt, typ)
+		}
+	}
+
+	return wt, nil
+}
+
+func toPBWebhookRes(w *storer.Webhook) *pb.WebhookRes {
+	types := make([]string, 0, len(w.EventTypes))
+	for _, t := range w.EventTypes {
+		types = append(types, string(t))
+	}
+
+	res := &pb.WebhookRes{
+		Id:         w.ID,
+		Url:        w.URL,
+		EventTypes: types,
+		Secret:     w.Secret,
+		Active:     w.Active,
+		CreatedAt:  timestamppb.New(w.CreatedAt),
+	}
+	if w.UpdatedAt != nil {
+		res.UpdatedAt = timestamppb.New(*w.UpdatedAt)
+	}
+
+	return res
+}
+
+func toPBWebhookDeliveryState(s storer.WebhookDeliveryState) pb.WebhookDeliveryState {
+	switch s {
+	case storer.DeliverySucceeded:
+		return pb.WebhookDeliveryState_DELIVERY_SUCCEEDED
+	case storer.DeliveryFailed:
+		return pb.WebhookDeliveryState_DELIVERY_FAILED
+	default:
+		return pb.WebhookDeliveryState_DELIVERY_PENDING
+	}
+}
+
+func toPBWebhookDeliveryRes(d *storer.WebhookDelivery) *pb.WebhookDeliveryRes {
+	res := &pb.WebhookDeliveryRes{
+		Id:            d.ID,
+		WebhookId:     d.WebhookID,
+		EventId:       d.OutboxEventID,
+		EventType:     string(d.EventType),
+		Payload:       d.Payload,
+		State:         toPBWebhookDeliveryState(d.State),
+		Attempts:      d.Attempts,
+		NextAttemptAt: timestamppb.New(d.NextAttemptAt),
+		CreatedAt:     timestamppb.New(d.CreatedAt),
+	}
+	if d.CompletedAt != nil {
+		res.CompletedAt = timestamppb.New(*d.CompletedAt)
+	}
+
+	return res
+}
+
+func toPBWebhookDeliveryAttempt(a *storer.WebhookDeliveryAttempt) *pb.WebhookDeliveryAttempt {
+	return &pb.WebhookDeliveryAttempt{
+		Attempt:     a.Attempt,
+		StatusCode:  int32(a.StatusCode),
+		Succeeded:   a.Succeeded,
+		Message:     a.Message,
+		AttemptedAt: timestamppb.New(a.AttemptedAt),
+	}
+}
@@ -2,24 +2,62 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"strings"
+	"regexp"
 	"time"
 
+	"github.com/dhij/ecomm/ecomm-grpc/broker"
 	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/ecomm-grpc/pricing"
 	"github.com/dhij/ecomm/ecomm-grpc/storer"
-	"google.golang.org/protobuf/types/known/timestamppb"
+	"github.com/dhij/ecomm/money"
+	"github.com/dhij/ecomm/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 type Server struct {
-	storer *storer.MySQLStorer
+	storer          storer.Storer
+	pricer          *pricing.Pricer
+	verifiedReviews bool
+	broker          *broker.Broker
 	pb.UnimplementedEcommServer
 }
 
-func NewServer(storer *storer.MySQLStorer) *Server {
-	return &Server{
+type Option func(*Server)
+
+// WithVerifiedReviews only lets customers who bought a product review it.
+func WithVerifiedReviews() Option {
+	return func(s *Server) {
+		s.verifiedReviews = true
+	}
+}
+
+// WithBroker enables SubscribeNotificationEvents. The broker must be the one
+// the storer publishes enqueued events to.
+func WithBroker(b *broker.Broker) Option {
+	return func(s *Server) {
+		s.broker = b
+	}
+}
+
+func NewServer(storer storer.Storer, pricer *pricing.Pricer, opts ...Option) *Server {
+	s := &Server{
 		storer: storer,
+		pricer: pricer,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	return s
 }
 
 func (s *Server) CreateProduct(ctx context.Context, req *pb.ProductReq) (*pb.ProductRes, error) {
@@ -40,10 +78,10 @@ func (s *Server) GetProduct(ctx context.Context, p *pb.ProductReq) (*pb.ProductR
 	return toPBProductRes(pr), nil
 }
 
-func (s *Server) ListProducts(ctx context.Context, p *pb.ProductReq) (*pb.ListProductRes, error) {
-	lps, err := s.storer.ListProducts(ctx)
+func (s *Server) ListProducts(ctx context.Context, p *pb.ListProductsReq) (*pb.ListProductRes, error) {
+	lps, next, err := s.storer.ListProducts(ctx, toProductFilter(p))
 	if err != nil {
-		return nil, err
+		return nil, listError(err)
 	}
 
 	lpr := make([]*pb.ProductRes, 0, len(lps))
@@ -52,18 +90,15 @@ func (s *Server) ListProducts(ctx context.Context, p *pb.ProductReq) (*pb.ListPr
 	}
 
 	return &pb.ListProductRes{
-		Products: lpr,
+		Products:      lpr,
+		NextPageToken: next,
 	}, nil
 }
 
 func (s *Server) UpdateProduct(ctx context.Context, p *pb.ProductReq) (*pb.ProductRes, error) {
-	product, err := s.storer.GetProduct(ctx, p.GetId())
-	if err != nil {
-		return nil, err
-	}
-
-	patchProductReq(product, p)
-	pr, err := s.storer.UpdateProduct(ctx, product)
+	pr, err := s.storer.UpdateProduct(ctx, p.GetId(), func(product *storer.Product) {
+		patchProductReq(product, p)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -81,38 +116,134 @@ func (s *Server) DeleteProduct(ctx context.Context, p *pb.ProductReq) (*pb.Produ
 }
 
 func (s *Server) CreateOrder(ctx context.Context, o *pb.OrderReq) (*pb.OrderRes, error) {
-	order, err := s.storer.CreateOrder(ctx, toStorerOrder(o))
+	po, err := s.priceOrder(ctx, o)
 	if err != nil {
 		return nil, err
 	}
+
+	order, err := s.storer.CreateOrder(ctx, po)
+	if err != nil {
+		return nil, createOrderError(err)
+	}
+
+	return orderCreated(order), nil
+}
+
+func createOrderError(err error) error {
+	var stockErr *storer.InsufficientStockError
+	if errors.As(err, &stockErr) {
+		return status.Error(codes.FailedPrecondition, stockErr.Error())
+	}
+	return err
+}
+
+// orderCreated returns a new order. The customer is notified by the outbox
+// relay, from the event stored with the order.
+func orderCreated(order *storer.Order) *pb.OrderRes {
 	order.Status = storer.Pending
+	return toPBOrderRes(order)
+}
 
-	_, err = s.storer.EnqueueNotificationEvent(ctx, &storer.NotificationEvent{
-		UserEmail:   o.GetUserEmail(),
-		OrderStatus: order.Status,
-		OrderID:     order.ID,
-		Attempts:    0,
-	})
+// priceOrder builds the order to store from the catalogue rather than from
+// the prices sent by the client. Client prices are only checked against the
+// computed ones.
+func (s *Server) priceOrder(ctx context.Context, o *pb.OrderReq) (*storer.Order, error) {
+	if len(o.GetItems()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "order has no items")
+	}
+
+	products := make(map[int64]*storer.Product)
+	lines := make([]pricing.Line, 0, len(o.GetItems()))
+	for _, i := range o.GetItems() {
+		if i.GetQuantity() <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid quantity %d for product %d", i.GetQuantity(), i.GetProductId())
+		}
+
+		p, ok := products[i.GetProductId()]
+		if !ok {
+			var err error
+			p, err = s.storer.GetProduct(ctx, i.GetProductId())
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, status.Errorf(codes.InvalidArgument, "product %d not found", i.GetProductId())
+				}
+				return nil, err
+			}
+			products[p.ID] = p
+		}
+
+		if i.GetPrice() != nil && !toMoney(i.GetPrice()).Equal(p.Price) {
+			return nil, status.Errorf(codes.InvalidArgument, "price of product %d is %s, not %s", p.ID, p.Price, toMoney(i.GetPrice()))
+		}
+
+		lines = append(lines, pricing.Line{
+			ProductID: p.ID,
+			Name:      p.Name,
+			Quantity:  i.GetQuantity(),
+			UnitPrice: p.Price,
+		})
+	}
+
+	q, err := s.pricer.Quote(ctx, lines)
 	if err != nil {
 		return nil, err
 	}
 
-	return toPBOrderRes(order), nil
+	for _, c := range []struct {
+		name     string
+		client   *pb.Money
+		computed money.Money
+	}{
+		{"tax price", o.GetTaxPrice(), q.TaxPrice},
+		{"shipping price", o.GetShippingPrice(), q.ShippingPrice},
+		{"total price", o.GetTotalPrice(), q.TotalPrice},
+	} {
+		if c.client != nil && !toMoney(c.client).Equal(c.computed) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is %s, not %s", c.name, c.computed, toMoney(c.client))
+		}
+	}
+
+	order := &storer.Order{
+		PaymentMethod: o.GetPaymentMethod(),
+		TaxPrice:      q.TaxPrice,
+		ShippingPrice: q.ShippingPrice,
+		TotalPrice:    q.TotalPrice,
+		UserID:        o.GetUserId(),
+	}
+	for _, l := range q.Lines {
+		order.Items = append(order.Items, storer.OrderItem{
+			Name:      l.Name,
+			Quantity:  l.Quantity,
+			Image:     products[l.ProductID].Image,
+			Price:     l.UnitPrice,
+			ProductID: l.ProductID,
+		})
+	}
+
+	return order, nil
 }
 
-func (s *Server) GetOrder(ctx context.Context, o *pb.OrderReq) (*pb.OrderRes, error) {
-	order, err := s.storer.GetOrder(ctx, o.GetUserId())
+func (s *Server) GetOrder(ctx context.Context, o *pb.GetOrderReq) (*pb.OrderRes, error) {
+	order, err := s.storer.GetOrder(ctx, o.GetId())
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "order %d not found", o.GetId())
+		}
 		return nil, err
 	}
 
+	// report someone else's order as missing rather than leak that it exists
+	if !o.GetIsAdmin() && order.UserID != o.GetUserId() {
+		return nil, status.Errorf(codes.NotFound, "order %d not found", o.GetId())
+	}
+
 	return toPBOrderRes(order), nil
 }
 
-func (s *Server) ListOrders(ctx context.Context, o *pb.OrderReq) (*pb.ListOrderRes, error) {
-	orders, err := s.storer.ListOrders(ctx)
+func (s *Server) ListOrders(ctx context.Context, o *pb.ListOrdersReq) (*pb.ListOrderRes, error) {
+	orders, next, err := s.storer.ListOrders(ctx, toOrderFilter(o))
 	if err != nil {
-		return nil, err
+		return nil, listError(err)
 	}
 
 	lor := make([]*pb.OrderRes, 0, len(orders))
@@ -121,45 +252,106 @@ func (s *Server) ListOrders(ctx context.Context, o *pb.OrderReq) (*pb.ListOrderR
 	}
 
 	return &pb.ListOrderRes{
-		Orders: lor,
+		Orders:        lor,
+		NextPageToken: next,
 	}, nil
 }
 
-func (s *Server) UpdateOrderStatus(ctx context.Context, o *pb.OrderReq) (*pb.OrderRes, error) {
-	// vadliate the order req
-	order, err := s.storer.GetOrderStatusByID(ctx, o.GetId())
+func (s *Server) ListOrdersForUser(ctx context.Context, o *pb.ListOrdersForUserReq) (*pb.ListOrderRes, error) {
+	orders, next, err := s.storer.ListOrdersForUser(ctx, o.GetUserId(), toStorerPage(o.GetPageSize(), o.GetPageToken(), o.GetSort()))
+	if err != nil {
+		return nil, listError(err)
+	}
+
+	lor := make([]*pb.OrderRes, 0, len(orders))
+	for _, order := range orders {
+		lor = append(lor, toPBOrderRes(order))
+	}
+
+	return &pb.ListOrderRes{
+		Orders:        lor,
+		NextPageToken: next,
+	}, nil
+}
+
+// customerTransitions are the status changes customers may make to their own
+// orders. Every other transition is made by admins.
+var customerTransitions = map[storer.OrderStatus]storer.OrderStatus{
+	storer.Pending:   storer.Cancelled,
+	storer.Delivered: storer.RefundRequested,
+}
+
+func (s *Server) UpdateOrderStatus(ctx context.Context, o *pb.UpdateOrderStatusReq) (*pb.OrderRes, error) {
+	order, err := s.getOrderFor(ctx, o.GetId(), o.GetUserId(), o.GetIsAdmin())
 	if err != nil {
 		return nil, err
 	}
 
-	if o.GetUserId() != order.UserID {
-		return nil, fmt.Errorf("order %d does not belong to user %d", o.GetId(), o.GetUserId())
+	to := toStorerOrderStatus(o.GetStatus())
+	role := storer.AdminActor
+	if !o.GetIsAdmin() {
+		role = storer.CustomerActor
+		if next, ok := customerTransitions[order.Status]; !ok || next != to {
+			return nil, status.Errorf(codes.PermissionDenied, "customers cannot move an order from %s to %s", order.Status, to)
+		}
 	}
 
-	sOrderStatus := storer.OrderStatus(strings.ToLower(o.GetStatus().String()))
-	if sOrderStatus == order.Status {
-		return nil, fmt.Errorf("order status is already %s", order.Status)
+	updated, err := s.storer.UpdateOrderStatus(ctx, &storer.OrderStatusChange{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   to,
+		ActorID:    o.GetUserId(),
+		ActorRole:  role,
+		Reason:     o.GetReason(),
+	})
+	if err != nil {
+		var transitionErr *storer.InvalidTransitionError
+		if errors.As(err, &transitionErr) || errors.Is(err, storer.ErrOrderStatusChanged) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, err
 	}
 
-	order.Status = sOrderStatus
-	order.UpdatedAt = toTimePtr(time.Now())
-	or, err := s.storer.UpdateOrderStatus(ctx, order)
+	return toPBOrderRes(updated), nil
+}
+
+func (s *Server) ListOrderStatusHistory(ctx context.Context, o *pb.GetOrderReq) (*pb.ListOrderStatusHistoryRes, error) {
+	order, err := s.getOrderFor(ctx, o.GetId(), o.GetUserId(), o.GetIsAdmin())
 	if err != nil {
 		return nil, err
 	}
 
-	// enqueue notification event
-	_, err = s.storer.EnqueueNotificationEvent(ctx, &storer.NotificationEvent{
-		UserEmail:   o.GetUserEmail(),
-		OrderStatus: order.Status,
-		OrderID:     order.ID,
-		Attempts:    0,
-	})
+	changes, err := s.storer.ListOrderStatusHistory(ctx, order.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	return toPBOrderRes(or), nil
+	res := make([]*pb.OrderStatusChange, 0, len(changes))
+	for _, c := range changes {
+		res = append(res, toPBOrderStatusChange(c))
+	}
+
+	return &pb.ListOrderStatusHistoryRes{
+		Changes: res,
+	}, nil
+}
+
+// getOrderFor returns the status of an order if userID may see it. Orders of
+// other customers are reported as missing rather than leak that they exist.
+func (s *Server) getOrderFor(ctx context.Context, id, userID int64, isAdmin bool) (*storer.Order, error) {
+	order, err := s.storer.GetOrderStatusByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "order %d not found", id)
+		}
+		return nil, err
+	}
+
+	if !isAdmin && order.UserID != userID {
+		return nil, status.Errorf(codes.NotFound, "order %d not found", id)
+	}
+
+	return order, nil
 }
 
 func (s *Server) DeleteOrder(ctx context.Context, o *pb.OrderReq) (*pb.OrderRes, error) {
@@ -171,8 +363,250 @@ func (s *Server) DeleteOrder(ctx context.Context, o *pb.OrderReq) (*pb.OrderRes,
 	return &pb.OrderRes{}, nil
 }
 
+func (s *Server) GetCart(ctx context.Context, c *pb.CartReq) (*pb.CartRes, error) {
+	cart, err := s.storer.GetCart(ctx, c.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBCartRes(cart), nil
+}
+
+func (s *Server) AddToCart(ctx context.Context, c *pb.CartReq) (*pb.CartRes, error) {
+	if c.GetQuantity() <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid quantity %d", c.GetQuantity())
+	}
+
+	_, err := s.storer.GetProduct(ctx, c.GetProductId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "product %d not found", c.GetProductId())
+		}
+		return nil, err
+	}
+
+	cart, err := s.storer.AddToCart(ctx, c.GetUserId(), c.GetProductId(), c.GetQuantity())
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBCartRes(cart), nil
+}
+
+func (s *Server) UpdateCartItem(ctx context.Context, c *pb.CartReq) (*pb.CartRes, error) {
+	if c.GetQuantity() <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid quantity %d", c.GetQuantity())
+	}
+
+	cart, err := s.storer.UpdateCartItem(ctx, c.GetUserId(), c.GetProductId(), c.GetQuantity())
+	if err != nil {
+		return nil, cartItemError(err, c.GetProductId())
+	}
+
+	return toPBCartRes(cart), nil
+}
+
+func (s *Server) RemoveCartItem(ctx context.Context, c *pb.CartReq) (*pb.CartRes, error) {
+	cart, err := s.storer.RemoveCartItem(ctx, c.GetUserId(), c.GetProductId())
+	if err != nil {
+		return nil, cartItemError(err, c.GetProductId())
+	}
+
+	return toPBCartRes(cart), nil
+}
+
+func cartItemError(err error, productID int64) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Errorf(codes.NotFound, "product %d is not in the cart", productID)
+	}
+	return err
+}
+
+// Checkout prices the cart like any other order, snapshotting the name, image
+// and price of each product, and creates the order while emptying the cart.
+func (s *Server) Checkout(ctx context.Context, c *pb.CheckoutReq) (*pb.OrderRes, error) {
+	cart, err := s.storer.GetCart(ctx, c.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	if len(cart.Items) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "cart is empty")
+	}
+
+	req := &pb.OrderReq{
+		PaymentMethod: c.GetPaymentMethod(),
+		UserId:        c.GetUserId(),
+		UserEmail:     c.GetUserEmail(),
+	}
+	for _, ci := range cart.Items {
+		req.Items = append(req.Items, &pb.OrderItem{
+			ProductId: ci.ProductID,
+			Quantity:  ci.Quantity,
+		})
+	}
+
+	po, err := s.priceOrder(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := s.storer.Checkout(ctx, cart, po)
+	if err != nil {
+		if errors.Is(err, storer.ErrCartChanged) {
+			return nil, status.Error(codes.Aborted, "cart changed during checkout")
+		}
+		return nil, createOrderError(err)
+	}
+
+	return orderCreated(order), nil
+}
+
+func (s *Server) CreateReview(ctx context.Context, r *pb.ReviewReq) (*pb.ReviewRes, error) {
+	if err := validateStars(r.GetStars()); err != nil {
+		return nil, err
+	}
+
+	if s.verifiedReviews {
+		purchased, err := s.storer.HasPurchased(ctx, r.GetUserId(), r.GetProductId())
+		if err != nil {
+			return nil, err
+		}
+		if !purchased {
+			return nil, status.Errorf(codes.PermissionDenied, "only customers who bought product %d may review it", r.GetProductId())
+		}
+	}
+
+	rv, err := s.storer.CreateReview(ctx, &storer.Review{
+		UserID:    r.GetUserId(),
+		ProductID: r.GetProductId(),
+		Stars:     r.GetStars(),
+		Title:     r.GetTitle(),
+		Body:      r.GetBody(),
+	})
+	if err != nil {
+		if errors.Is(err, storer.ErrReviewExists) {
+			return nil, status.Errorf(codes.AlreadyExists, "product %d already reviewed", r.GetProductId())
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "product %d not found", r.GetProductId())
+		}
+		return nil, err
+	}
+
+	return toPBReviewRes(rv), nil
+}
+
+func (s *Server) ListReviews(ctx context.Context, r *pb.ListReviewsReq) (*pb.ListReviewsRes, error) {
+	reviews, next, err := s.storer.ListReviews(ctx, r.GetProductId(), toStorerPage(r.GetPageSize(), r.GetPageToken(), r.GetSort()))
+	if err != nil {
+		return nil, listError(err)
+	}
+
+	lrr := make([]*pb.ReviewRes, 0, len(reviews))
+	for _, rv := range reviews {
+		lrr = append(lrr, toPBReviewRes(rv))
+	}
+
+	return &pb.ListReviewsRes{
+		Reviews:       lrr,
+		NextPageToken: next,
+	}, nil
+}
+
+// UpdateReview lets the author of a review change it. Zero-valued fields are
+// left as they are.
+func (s *Server) UpdateReview(ctx context.Context, r *pb.ReviewReq) (*pb.ReviewRes, error) {
+	rv, err := s.getReviewFor(ctx, r.GetId(), r.GetProductId(), r.GetUserId(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.GetStars() != 0 {
+		if err := validateStars(r.GetStars()); err != nil {
+			return nil, err
+		}
+		rv.Stars = r.GetStars()
+	}
+	if r.GetTitle() != "" {
+		rv.Title = r.GetTitle()
+	}
+	if r.GetBody() != "" {
+		rv.Body = r.GetBody()
+	}
+	rv.UpdatedAt = toTimePtr(time.Now())
+
+	updated, err := s.storer.UpdateReview(ctx, rv)
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBReviewRes(updated), nil
+}
+
+// DeleteReview lets the author of a review or an admin remove it.
+func (s *Server) DeleteReview(ctx context.Context, r *pb.ReviewReq) (*pb.ReviewRes, error) {
+	rv, err := s.getReviewFor(ctx, r.GetId(), r.GetProductId(), r.GetUserId(), r.GetIsAdmin())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.storer.DeleteReview(ctx, rv.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ReviewRes{}, nil
+}
+
+// getReviewFor returns a review of a product if userID may change it.
+func (s *Server) getReviewFor(ctx context.Context, id, productID, userID int64, isAdmin bool) (*storer.Review, error) {
+	rv, err := s.storer.GetReview(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "review %d not found", id)
+		}
+		return nil, err
+	}
+	if rv.ProductID != productID {
+		return nil, status.Errorf(codes.NotFound, "review %d not found", id)
+	}
+
+	if !isAdmin && rv.UserID != userID {
+		return nil, status.Errorf(codes.PermissionDenied, "review %d belongs to another user", id)
+	}
+
+	return rv, nil
+}
+
+func validateStars(stars int64) error {
+	if stars < storer.MinReviewStars || stars > storer.MaxReviewStars {
+		return status.Errorf(codes.InvalidArgument, "stars must be between %d and %d", storer.MinReviewStars, storer.MaxReviewStars)
+	}
+	return nil
+}
+
+// defaultLocale is the locale of users who did not choose one.
+const defaultLocale = "en"
+
+var localeRe = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+func validateLocale(locale string) error {
+	if !localeRe.MatchString(locale) {
+		return status.Errorf(codes.InvalidArgument, "invalid locale %q", locale)
+	}
+	return nil
+}
+
 func (s *Server) CreateUser(ctx context.Context, u *pb.UserReq) (*pb.UserRes, error) {
-	user, err := s.storer.CreateUser(ctx, toStorerUser(u))
+	nu := toStorerUser(u)
+	if nu.Locale == "" {
+		nu.Locale = defaultLocale
+	}
+	if err := validateLocale(nu.Locale); err != nil {
+		return nil, err
+	}
+
+	user, err := s.storer.CreateUser(ctx, nu)
 	if err != nil {
 		return nil, err
 	}
@@ -183,16 +617,19 @@ func (s *Server) CreateUser(ctx context.Context, u *pb.UserReq) (*pb.UserRes, er
 func (s *Server) GetUser(ctx context.Context, u *pb.UserReq) (*pb.UserRes, error) {
 	user, err := s.storer.GetUser(ctx, u.GetEmail())
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "user %s not found", u.GetEmail())
+		}
 		return nil, err
 	}
 
 	return toPBUserRes(user), nil
 }
 
-func (s *Server) ListUsers(ctx context.Context, u *pb.UserReq) (*pb.ListUserRes, error) {
-	users, err := s.storer.ListUsers(ctx)
+func (s *Server) ListUsers(ctx context.Context, u *pb.ListUsersReq) (*pb.ListUserRes, error) {
+	users, next, err := s.storer.ListUsers(ctx, toUserFilter(u))
 	if err != nil {
-		return nil, err
+		return nil, listError(err)
 	}
 
 	lur := make([]*pb.UserRes, 0, len(users))
@@ -201,11 +638,18 @@ func (s *Server) ListUsers(ctx context.Context, u *pb.UserReq) (*pb.ListUserRes,
 	}
 
 	return &pb.ListUserRes{
-		Users: lur,
+		Users:         lur,
+		NextPageToken: next,
 	}, nil
 }
 
 func (s *Server) UpdateUser(ctx context.Context, u *pb.UserReq) (*pb.UserRes, error) {
+	if u.GetLocale() != "" {
+		if err := validateLocale(u.GetLocale()); err != nil {
+			return nil, err
+		}
+	}
+
 	user, err := s.storer.GetUser(ctx, u.GetEmail())
 	if err != nil {
 		return nil, err
@@ -230,42 +674,89 @@ func (s *Server) DeleteUser(ctx context.Context, u *pb.UserReq) (*pb.UserRes, er
 }
 
 func (s *Server) CreateSession(ctx context.Context, sr *pb.SessionReq) (*pb.SessionRes, error) {
+	now := time.Now()
 	sess, err := s.storer.CreateSession(ctx, &storer.Session{
 		ID:           sr.GetId(),
 		UserEmail:    sr.GetUserEmail(),
 		RefreshToken: sr.GetRefreshToken(),
+		UserAgent:    sr.GetUserAgent(),
+		ClientIP:     sr.GetClientIp(),
 		IsRevoked:    sr.GetIsRevoked(),
+		LastUsedAt:   &now,
 		ExpiresAt:    sr.GetExpiresAt().AsTime(),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &pb.SessionRes{
-		Id:           sess.ID,
-		UserEmail:    sess.UserEmail,
-		RefreshToken: sess.RefreshToken,
-		IsRevoked:    sess.IsRevoked,
-		ExpiresAt:    timestamppb.New(sess.ExpiresAt),
-	}, nil
+	return toPBSessionRes(sess), nil
 }
 
 func (s *Server) GetSession(ctx context.Context, sr *pb.SessionReq) (*pb.SessionRes, error) {
 	sess, err := s.storer.GetSession(ctx, sr.GetId())
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "session %s not found", sr.GetId())
+		}
 		return nil, err
 	}
 
-	return &pb.SessionRes{
-		Id:           sess.ID,
-		UserEmail:    sess.UserEmail,
-		RefreshToken: sess.RefreshToken,
-		IsRevoked:    sess.IsRevoked,
-		ExpiresAt:    timestamppb.New(sess.ExpiresAt),
-	}, nil
+	return toPBSessionRes(sess), nil
+}
+
+// RotateSession replaces a session with a new one in its family. A session
+// that is revoked or was already rotated is Unauthenticated; in the latter
+// case its whole family is revoked.
+func (s *Server) RotateSession(ctx context.Context, sr *pb.RotateSessionReq) (*pb.SessionRes, error) {
+	now := time.Now()
+	sess, err := s.storer.RotateSession(ctx, sr.GetId(), &storer.Session{
+		ID:           sr.GetNextId(),
+		RefreshToken: sr.GetRefreshToken(),
+		UserAgent:    sr.GetUserAgent(),
+		ClientIP:     sr.GetClientIp(),
+		LastUsedAt:   &now,
+		ExpiresAt:    sr.GetExpiresAt().AsTime(),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, status.Errorf(codes.NotFound, "session %s not found", sr.GetId())
+		case errors.Is(err, storer.ErrSessionRevoked), errors.Is(err, storer.ErrRefreshTokenReused):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, err
+	}
+
+	return toPBSessionRes(sess), nil
 }
 
+func (s *Server) ListSessionsForUser(ctx context.Context, lsr *pb.ListSessionsReq) (*pb.ListSessionsRes, error) {
+	sessions, err := s.storer.ListSessionsForUser(ctx, lsr.GetUserEmail())
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.ListSessionsRes{}
+	for _, sess := range sessions {
+		res.Sessions = append(res.Sessions, toPBSessionRes(sess))
+	}
+
+	return res, nil
+}
+
+// RevokeSession revokes a session. When a user email is given, sessions of
+// other users are NotFound.
 func (s *Server) RevokeSession(ctx context.Context, sr *pb.SessionReq) (*pb.SessionRes, error) {
+	if sr.GetUserEmail() != "" {
+		sess, err := s.storer.GetSession(ctx, sr.GetId())
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if err != nil || sess.UserEmail != sr.GetUserEmail() {
+			return nil, status.Errorf(codes.NotFound, "session %s not found", sr.GetId())
+		}
+	}
+
 	err := s.storer.RevokeSession(ctx, sr.GetId())
 	if err != nil {
 		return nil, err
@@ -274,6 +765,19 @@ func (s *Server) RevokeSession(ctx context.Context, sr *pb.SessionReq) (*pb.Sess
 	return &pb.SessionRes{}, nil
 }
 
+func (s *Server) RevokeAllSessions(ctx context.Context, rr *pb.RevokeAllSessionsReq) (*pb.RevokeAllSessionsRes, error) {
+	if rr.GetUserEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user email is required")
+	}
+
+	err := s.storer.RevokeAllSessions(ctx, rr.GetUserEmail())
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.RevokeAllSessionsRes{}, nil
+}
+
 func (s *Server) DeleteSession(ctx context.Context, sr *pb.SessionReq) (*pb.SessionRes, error) {
 	err := s.storer.DeleteSession(ctx, sr.GetId())
 	if err != nil {
@@ -283,6 +787,91 @@ func (s *Server) DeleteSession(ctx context.Context, sr *pb.SessionReq) (*pb.Sess
 	return &pb.SessionRes{}, nil
 }
 
+const passwordResetTokenTTL = time.Hour
+
+// RequestPasswordReset emails the user a single use token to reset their
+// password with. Unknown emails succeed too, so that callers can't tell who
+// has an account.
+func (s *Server) RequestPasswordReset(ctx context.Context, rr *pb.RequestPasswordResetReq) (*pb.RequestPasswordResetRes, error) {
+	user, err := s.storer.GetUser(ctx, rr.GetEmail())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &pb.RequestPasswordResetRes{}, nil
+		}
+		return nil, err
+	}
+
+	tok, err := newPasswordResetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	reset := storer.PasswordReset{Token: tok, ExpiresAt: time.Now().Add(passwordResetTokenTTL)}
+	ne, err := storer.NewPasswordResetNotification(user.Email, reset)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.storer.CreatePasswordResetToken(ctx, &storer.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashPasswordResetToken(tok),
+		ExpiresAt: reset.ExpiresAt,
+	}, ne)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.RequestPasswordResetRes{}, nil
+}
+
+// ResetPassword sets a new password with a token sent by RequestPasswordReset.
+// A token that is unknown, expired or used is InvalidArgument.
+func (s *Server) ResetPassword(ctx context.Context, rr *pb.ResetPasswordReq) (*pb.ResetPasswordRes, error) {
+	if rr.GetToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	if rr.GetPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "password is required")
+	}
+
+	hashed, err := util.HashPassword(rr.GetPassword())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.storer.ResetPassword(ctx, hashPasswordResetToken(rr.GetToken()), hashed)
+	if err != nil {
+		if errors.Is(err, storer.ErrPasswordResetTokenInvalid) {
+			return nil, status.Error(codes.InvalidArgument, "password reset token is invalid or expired")
+		}
+		return nil, err
+	}
+
+	return &pb.ResetPasswordRes{UserEmail: user.Email}, nil
+}
+
+func newPasswordResetToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("error generating password reset token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashPasswordResetToken is what is stored of a token, so that reading the
+// database doesn't give away tokens that can still be used.
+func hashPasswordResetToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListNotificationEvents lists the events that are due to be attempted and not
+// leased to a worker.
+//
+// Deprecated: the list is read-only and events must be claimed with
+// ClaimNotificationEvents before they can be acknowledged or nacked.
 func (s *Server) ListNotificationEvents(ctx context.Context, lnr *pb.ListNotificationEventsReq) (*pb.ListNotificationEventsRes, error) {
 	notificationEvents, err := s.storer.ListNotificationEvents(ctx)
 	if err != nil {
@@ -291,14 +880,7 @@ func (s *Server) ListNotificationEvents(ctx context.Context, lnr *pb.ListNotific
 
 	lners := make([]*pb.NotificationEvent, 0, len(notificationEvents))
 	for _, ne := range notificationEvents {
-		lners = append(lners, &pb.NotificationEvent{
-			Id:          ne.ID,
-			UserEmail:   ne.UserEmail,
-			OrderStatus: toPBOrderStatus(ne.OrderStatus),
-			OrderId:     ne.OrderID,
-			StateId:     ne.StateID,
-			Attempts:    ne.Attempts,
-		})
+		lners = append(lners, toPBNotificationEvent(ne))
 	}
 
 	return &pb.ListNotificationEventsRes{
@@ -306,31 +888,441 @@ func (s *Server) ListNotificationEvents(ctx context.Context, lnr *pb.ListNotific
 	}, nil
 }
 
+// maxClaimBatchSize caps how many events a worker may lease at once.
+const maxClaimBatchSize = 100
+
+func checkClaim(workerID string, batchSize int32, leaseDuration *durationpb.Duration) (time.Duration, error) {
+	if workerID == "" {
+		return 0, status.Error(codes.InvalidArgument, "worker id is required")
+	}
+	if batchSize < 1 || batchSize > maxClaimBatchSize {
+		return 0, status.Errorf(codes.InvalidArgument, "batch size must be between 1 and %d", maxClaimBatchSize)
+	}
+	lease := leaseDuration.AsDuration()
+	if lease <= 0 {
+		return 0, status.Error(codes.InvalidArgument, "lease duration must be positive")
+	}
+
+	return lease, nil
+}
+
+func (s *Server) ClaimNotificationEvents(ctx context.Context, cnr *pb.ClaimNotificationEventsReq) (*pb.ClaimNotificationEventsRes, error) {
+	lease, err := checkClaim(cnr.GetWorkerId(), cnr.GetBatchSize(), cnr.GetLeaseDuration())
+	if err != nil {
+		return nil, err
+	}
+
+	notificationEvents, err := s.storer.ClaimNotificationEvents(ctx, cnr.GetWorkerId(), int(cnr.GetBatchSize()), lease)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ClaimNotificationEventsRes{
+		Events: toPBNotificationEvents(notificationEvents),
+	}, nil
+}
+
+// SubscribeNotificationEvents claims events for a worker whenever events are
+// enqueued and streams them to it. Every response carries the cursor to
+// resubscribe with; a response may have no events when another worker
+// claimed them first. Events that become due for a retry are not pushed, so
+// workers keep polling ClaimNotificationEvents as a fallback.
+func (s *Server) SubscribeNotificationEvents(req *pb.SubscribeNotificationEventsReq, stream pb.Ecomm_SubscribeNotificationEventsServer) error {
+	if s.broker == nil {
+		return status.Error(codes.Unimplemented, "notification subscriptions are not enabled")
+	}
+	lease, err := checkClaim(req.GetWorkerId(), req.GetBatchSize(), req.GetLeaseDuration())
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	cursor := req.GetCursor()
+	for {
+		cursor, err = s.broker.Wait(ctx, cursor)
+		if err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		// claim until the queue is drained, a batch at a time
+		for {
+			events, err := s.storer.ClaimNotificationEvents(ctx, req.GetWorkerId(), int(req.GetBatchSize()), lease)
+			if err != nil {
+				return err
+			}
+
+			err = stream.Send(&pb.SubscribeNotificationEventsRes{
+				Events: toPBNotificationEvents(events),
+				Cursor: cursor,
+			})
+			if err != nil {
+				return err
+			}
+
+			if len(events) < int(req.GetBatchSize()) {
+				break
+			}
+		}
+	}
+}
+
 func (s *Server) UpdateNotificationEvent(ctx context.Context, unr *pb.UpdateNotificationEventReq) (*pb.UpdateNotificationEventRes, error) {
+	u, err := toNotificationUpdate(unr)
+	if err != nil {
+		return nil, err
+	}
+
+	succeeded, err := s.storer.UpdateNotificationEvent(ctx, u.Event, u.State, u.ResponseType)
+	if err != nil {
+		return nil, updateNotificationError(err)
+	}
+
+	return &pb.UpdateNotificationEventRes{
+		Succeeded: succeeded,
+	}, nil
+}
+
+// UpdateNotificationEvents records the outcome of several events in one
+// transaction, so that the events sent together in a digest are acked
+// together.
+func (s *Server) UpdateNotificationEvents(ctx context.Context, unr *pb.UpdateNotificationEventsReq) (*pb.UpdateNotificationEventsRes, error) {
+	if len(unr.GetEvents()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "events are required")
+	}
+
+	updates := make([]*storer.NotificationUpdate, 0, len(unr.GetEvents()))
+	for _, ev := range unr.GetEvents() {
+		u, err := toNotificationUpdate(ev)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, u)
+	}
+
+	succeeded, err := s.storer.UpdateNotificationEvents(ctx, updates)
+	if err != nil {
+		return nil, updateNotificationError(err)
+	}
+
+	res := &pb.UpdateNotificationEventsRes{}
+	for _, sc := range succeeded {
+		res.Results = append(res.Results, &pb.UpdateNotificationEventRes{Succeeded: sc})
+	}
+
+	return res, nil
+}
+
+func toNotificationUpdate(unr *pb.UpdateNotificationEventReq) (*storer.NotificationUpdate, error) {
 	var responseType storer.NotificationResponseType
 	switch unr.ResponseType {
 	case pb.NotificationResponseType_SUCCESS:
 		responseType = storer.NotificationSucess
 	case pb.NotificationResponseType_FAILURE:
 		responseType = storer.NotificationFailure
+	case pb.NotificationResponseType_OPTED_OUT:
+		responseType = storer.NotificationSuppressed
 	default:
 		return nil, fmt.Errorf("invalid response type %s", unr.ResponseType)
 	}
 
-	succeeded, err := s.storer.UpdateNotificationEvent(ctx,
-		&storer.NotificationEvent{
-			ID:      unr.GetId(),
-			StateID: unr.GetStateId(),
+	return &storer.NotificationUpdate{
+		Event: &storer.NotificationEvent{
+			ID:       unr.GetId(),
+			StateID:  unr.GetStateId(),
+			LeasedBy: unr.GetWorkerId(),
 		},
-		&storer.NotificationState{
+		State: &storer.NotificationState{
 			Message: unr.GetMessage(),
 		},
-		responseType)
+		ResponseType: responseType,
+	}, nil
+}
+
+func updateNotificationError(err error) error {
+	if errors.Is(err, storer.ErrNotLeaseHolder) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return err
+}
+
+func (s *Server) ListNotificationStates(ctx context.Context, n *pb.ListNotificationStatesReq) (*pb.ListNotificationStatesRes, error) {
+	states, next, err := s.storer.ListNotificationStates(ctx, toNotificationStateFilter(n))
 	if err != nil {
+		return nil, listError(err)
+	}
+
+	lns := make([]*pb.NotificationStateRes, 0, len(states))
+	for _, ns := range states {
+		lns = append(lns, toPBNotificationStateRes(ns))
+	}
+
+	return &pb.ListNotificationStatesRes{
+		States:        lns,
+		NextPageToken: next,
+	}, nil
+}
+
+// GetNotificationState returns a notification together with the outcome of
+// every attempt at sending it and every time it was replayed.
+func (s *Server) GetNotificationState(ctx context.Context, n *pb.NotificationStateReq) (*pb.NotificationStateRes, error) {
+	ns, err := s.storer.GetNotificationState(ctx, n.GetId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "notification %d not found", n.GetId())
+		}
 		return nil, err
 	}
 
-	return &pb.UpdateNotificationEventRes{
-		Succeeded: succeeded,
+	attempts, err := s.storer.ListNotificationAttempts(ctx, ns.ID)
+	if err != nil {
+		return nil, err
+	}
+	replays, err := s.storer.ListNotificationReplays(ctx, ns.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := toPBNotificationStateRes(ns)
+	for _, a := range attempts {
+		res.Attempts = append(res.Attempts, toPBNotificationAttempt(a))
+	}
+	for _, r := range replays {
+		res.Replays = append(res.Replays, toPBNotificationReplay(r))
+	}
+
+	return res, nil
+}
+
+// maxReplayBatchSize caps how many notifications are replayed at once.
+const maxReplayBatchSize = 100
+
+func (s *Server) ReplayNotifications(ctx context.Context, n *pb.ReplayNotificationsReq) (*pb.ReplayNotificationsRes, error) {
+	if n.GetActorId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "actor id is required")
+	}
+	if len(n.GetIds()) == 0 || len(n.GetIds()) > maxReplayBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "between 1 and %d ids must be given", maxReplayBatchSize)
+	}
+
+	events, err := s.storer.ReplayNotifications(ctx, n.GetIds(), n.GetActorId())
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, status.Error(codes.NotFound, err.Error())
+		case errors.Is(err, storer.ErrNotReplayable):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, err
+	}
+
+	res := &pb.ReplayNotificationsRes{
+		Events: make([]*pb.NotificationEvent, 0, len(events)),
+	}
+	for _, ev := range events {
+		res.Events = append(res.Events, toPBNotificationEvent(ev))
+	}
+
+	return res, nil
+}
+
+// GetNotificationPreferences returns whether every type of notification is
+// enabled on every channel for a user.
+func (s *Server) GetNotificationPreferences(ctx context.Context, n *pb.NotificationPreferencesReq) (*pb.NotificationPreferencesRes, error) {
+	_, err := s.storer.GetUserByID(ctx, n.GetUserId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "user %d not found", n.GetUserId())
+		}
+		return nil, err
+	}
+
+	return s.notificationPreferences(ctx, n.GetUserId())
+}
+
+// UpdateNotificationPreferences turns the given types of notifications on or
+// off. Preferences that are not given are left as they are.
+func (s *Server) UpdateNotificationPreferences(ctx context.Context, n *pb.UpdateNotificationPreferencesReq) (*pb.NotificationPreferencesRes, error) {
+	if len(n.GetPreferences()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "preferences are required")
+	}
+	prefs, err := toStorerNotificationPreferences(n.GetPreferences())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.storer.GetUserByID(ctx, n.GetUserId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "user %d not found", n.GetUserId())
+		}
+		return nil, err
+	}
+
+	err = s.storer.SetNotificationPreferences(ctx, n.GetUserId(), prefs)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.notificationPreferences(ctx, n.GetUserId())
+}
+
+func (s *Server) notificationPreferences(ctx context.Context, userID int64) (*pb.NotificationPreferencesRes, error) {
+	prefs, err := s.storer.ListNotificationPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBNotificationPreferencesRes(prefs), nil
+}
+
+func (s *Server) CreateWebhook(ctx context.Context, w *pb.WebhookReq) (*pb.WebhookRes, error) {
+	wh, err := toStorerWebhook(w)
+	if err != nil {
+		return nil, err
+	}
+	if wh.Secret == "" {
+		wh.Secret, err = newWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wh, err = s.storer.CreateWebhook(ctx, wh)
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBWebhookRes(wh), nil
+}
+
+func (s *Server) GetWebhook(ctx context.Context, w *pb.WebhookReq) (*pb.WebhookRes, error) {
+	wh, err := s.getWebhook(ctx, w.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBWebhookRes(wh), nil
+}
+
+func (s *Server) ListWebhooks(ctx context.Context, w *pb.ListWebhooksReq) (*pb.ListWebhooksRes, error) {
+	webhooks, err := s.storer.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lw := make([]*pb.WebhookRes, 0, len(webhooks))
+	for _, wh := range webhooks {
+		lw = append(lw, toPBWebhookRes(wh))
+	}
+
+	return &pb.ListWebhooksRes{Webhooks: lw}, nil
+}
+
+func (s *Server) UpdateWebhook(ctx context.Context, w *pb.WebhookReq) (*pb.WebhookRes, error) {
+	wh, err := s.getWebhook(ctx, w.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = patchWebhookReq(wh, w)
+	if err != nil {
+		return nil, err
+	}
+	wh, err = s.storer.UpdateWebhook(ctx, wh)
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBWebhookRes(wh), nil
+}
+
+// DeleteWebhook deletes a webhook together with its deliveries and their
+// attempts.
+func (s *Server) DeleteWebhook(ctx context.Context, w *pb.WebhookReq) (*pb.WebhookRes, error) {
+	_, err := s.getWebhook(ctx, w.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.storer.DeleteWebhook(ctx, w.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.WebhookRes{}, nil
+}
+
+func (s *Server) ListWebhookDeliveries(ctx context.Context, w *pb.ListWebhookDeliveriesReq) (*pb.ListWebhookDeliveriesRes, error) {
+	_, err := s.getWebhook(ctx, w.GetWebhookId())
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, next, err := s.storer.ListWebhookDeliveries(ctx, w.GetWebhookId(), toStorerPage(w.GetPageSize(), w.GetPageToken(), w.GetSort()))
+	if err != nil {
+		return nil, listError(err)
+	}
+
+	ld := make([]*pb.WebhookDeliveryRes, 0, len(deliveries))
+	for _, d := range deliveries {
+		ld = append(ld, toPBWebhookDeliveryRes(d))
+	}
+
+	return &pb.ListWebhookDeliveriesRes{
+		Deliveries:    ld,
+		NextPageToken: next,
 	}, nil
 }
+
+// GetWebhookDelivery returns a delivery together with the outcome of every
+// attempt at it.
+func (s *Server) GetWebhookDelivery(ctx context.Context, w *pb.WebhookDeliveryReq) (*pb.WebhookDeliveryRes, error) {
+	d, err := s.storer.GetWebhookDelivery(ctx, w.GetId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "webhook delivery %d not found", w.GetId())
+		}
+		return nil, err
+	}
+
+	attempts, err := s.storer.ListWebhookDeliveryAttempts(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := toPBWebhookDeliveryRes(d)
+	for _, a := range attempts {
+		res.AttemptLog = append(res.AttemptLog, toPBWebhookDeliveryAttempt(a))
+	}
+
+	return res, nil
+}
+
+func (s *Server) getWebhook(ctx context.Context, id int64) (*storer.Webhook, error) {
+	wh, err := s.storer.GetWebhook(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "webhook %d not found", id)
+		}
+		return nil, err
+	}
+
+	return wh, nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("error generating webhook secret: %w", err)
+	}
+
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func listError(err error) error {
+	if errors.Is(err, storer.ErrInvalidListParams) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,822 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/broker"
+	"github.com/dhij/ecomm/ecomm-grpc/outbox"
+	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/ecomm-grpc/pricing"
+	"github.com/dhij/ecomm/ecomm-grpc/storer"
+	"github.com/dhij/ecomm/money"
+	"github.com/dhij/ecomm/util"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func usd(amount int64) money.Money {
+	return money.New(amount, money.DefaultCurrency)
+}
+
+func pbUSD(amount int64) *pb.Money {
+	return toPBMoney(usd(amount))
+}
+
+func newTestServer(t *testing.T) (*Server, *storer.MemoryStorer) {
+	st := storer.NewMemoryStorer()
+	pricer := pricing.NewPricer(pricing.FlatRateTax{BasisPoints: 1000}, pricing.FlatShipping{Price: usd(500)})
+	return NewServer(st, pricer), st
+}
+
+// publishOutbox relays the pending outbox events, which enqueues the order
+// notifications.
+func publishOutbox(t *testing.T, st storer.Storer) {
+	_, err := outbox.NewRelay(st, outbox.NewNotificationConsumer(st)).Publish(context.Background())
+	require.NoError(t, err)
+}
+
+func seedCatalogue(t *testing.T, st storer.Storer) (*storer.User, *storer.Product) {
+	ctx := context.Background()
+	u, err := st.CreateUser(ctx, &storer.User{Name: "test user", Email: "test@example.com", Password: "password"})
+	require.NoError(t, err)
+
+	p, err := st.CreateProduct(ctx, &storer.Product{
+		Name:         "test product",
+		Image:        "test.jpg",
+		Category:     "test category",
+		Price:        usd(2000),
+		CountInStock: 10,
+	})
+	require.NoError(t, err)
+
+	return u, p
+}
+
+func TestCreateOrderPricing(t *testing.T) {
+	tcs := []struct {
+		name string
+		req  func(u *storer.User, p *storer.Product) *pb.OrderReq
+		code codes.Code
+	}{
+		{
+			name: "prices from catalogue",
+			req: func(u *storer.User, p *storer.Product) *pb.OrderReq {
+				return &pb.OrderReq{
+					UserId:    u.ID,
+					UserEmail: u.Email,
+					Items:     []*pb.OrderItem{{ProductId: p.ID, Quantity: 2, Name: "cheap", Image: "fake.jpg"}},
+				}
+			},
+			code: codes.OK,
+		},
+		{
+			name: "matching client totals",
+			req: func(u *storer.User, p *storer.Product) *pb.OrderReq {
+				return &pb.OrderReq{
+					UserId:        u.ID,
+					UserEmail:     u.Email,
+					Items:         []*pb.OrderItem{{ProductId: p.ID, Quantity: 2, Price: pbUSD(2000)}},
+					TaxPrice:      pbUSD(400),
+					ShippingPrice: pbUSD(500),
+					TotalPrice:    pbUSD(4900),
+				}
+			},
+			code: codes.OK,
+		},
+		{
+			name: "mismatched item price",
+			req: func(u *storer.User, p *storer.Product) *pb.OrderReq {
+				return &pb.OrderReq{
+					UserId: u.ID,
+					Items:  []*pb.OrderItem{{ProductId: p.ID, Quantity: 2, Price: pbUSD(1)}},
+				}
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "mismatched total",
+			req: func(u *storer.User, p *storer.Product) *pb.OrderReq {
+				return &pb.OrderReq{
+					UserId:     u.ID,
+					Items:      []*pb.OrderItem{{ProductId: p.ID, Quantity: 2}},
+					TotalPrice: pbUSD(1),
+				}
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "unknown product",
+			req: func(u *storer.User, p *storer.Product) *pb.OrderReq {
+				return &pb.OrderReq{
+					UserId: u.ID,
+					Items:  []*pb.OrderItem{{ProductId: p.ID + 1, Quantity: 1}},
+				}
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "out of stock",
+			req: func(u *storer.User, p *storer.Product) *pb.OrderReq {
+				return &pb.OrderReq{
+					UserId: u.ID,
+					Items:  []*pb.OrderItem{{ProductId: p.ID, Quantity: 11}},
+				}
+			},
+			code: codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, st := newTestServer(t)
+			u, p := seedCatalogue(t, st)
+
+			res, err := srv.CreateOrder(context.Background(), tc.req(u, p))
+			if tc.code != codes.OK {
+				require.Equal(t, tc.code, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, usd(4000), toMoney(res.GetSubtotalPrice()))
+			require.Equal(t, usd(400), toMoney(res.GetTaxPrice()))
+			require.Equal(t, usd(500), toMoney(res.GetShippingPrice()))
+			require.Equal(t, usd(4900), toMoney(res.GetTotalPrice()))
+			require.Len(t, res.GetPriceLines(), 1)
+			require.Equal(t, usd(4000), toMoney(res.GetPriceLines()[0].GetLineTotal()))
+			require.Equal(t, p.Name, res.GetItems()[0].GetName())
+			require.Equal(t, p.Image, res.GetItems()[0].GetImage())
+			require.Equal(t, usd(2000), toMoney(res.GetItems()[0].GetPrice()))
+		})
+	}
+}
+
+func TestGetOrderOwnership(t *testing.T) {
+	srv, st := newTestServer(t)
+	u, p := seedCatalogue(t, st)
+
+	created, err := srv.CreateOrder(context.Background(), &pb.OrderReq{
+		UserId: u.ID,
+		Items:  []*pb.OrderItem{{ProductId: p.ID, Quantity: 1}},
+	})
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name string
+		req  *pb.GetOrderReq
+		code codes.Code
+	}{
+		{
+			name: "owner",
+			req:  &pb.GetOrderReq{Id: created.GetId(), UserId: u.ID},
+			code: codes.OK,
+		},
+		{
+			name: "admin",
+			req:  &pb.GetOrderReq{Id: created.GetId(), UserId: u.ID + 1, IsAdmin: true},
+			code: codes.OK,
+		},
+		{
+			name: "other customer",
+			req:  &pb.GetOrderReq{Id: created.GetId(), UserId: u.ID + 1},
+			code: codes.NotFound,
+		},
+		{
+			name: "missing order",
+			req:  &pb.GetOrderReq{Id: created.GetId() + 1, UserId: u.ID},
+			code: codes.NotFound,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := srv.GetOrder(context.Background(), tc.req)
+			require.Equal(t, tc.code, status.Code(err))
+			if tc.code == codes.OK {
+				require.Equal(t, created.GetId(), res.GetId())
+				require.Equal(t, u.ID, res.GetUserId())
+				require.Len(t, res.GetItems(), 1)
+			}
+		})
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	tcs := []struct {
+		name    string
+		from    []pb.OrderStatus
+		to      pb.OrderStatus
+		isAdmin bool
+		other   bool
+		code    codes.Code
+	}{
+		{name: "customer cancels pending order", to: pb.OrderStatus_CANCELLED, code: codes.OK},
+		{name: "customer cannot ship", to: pb.OrderStatus_SHIPPED, code: codes.PermissionDenied},
+		{name: "customer cannot cancel paid order", from: []pb.OrderStatus{pb.OrderStatus_PAID}, to: pb.OrderStatus_CANCELLED, code: codes.PermissionDenied},
+		{name: "customer cannot touch other orders", to: pb.OrderStatus_CANCELLED, other: true, code: codes.NotFound},
+		{name: "admin ships", from: []pb.OrderStatus{pb.OrderStatus_PAID, pb.OrderStatus_PROCESSING}, to: pb.OrderStatus_SHIPPED, isAdmin: true, code: codes.OK},
+		{name: "admin cannot skip payment", to: pb.OrderStatus_SHIPPED, isAdmin: true, code: codes.FailedPrecondition},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			srv, st := newTestServer(t)
+			u, p := seedCatalogue(t, st)
+
+			created, err := srv.CreateOrder(ctx, &pb.OrderReq{
+				UserId:    u.ID,
+				UserEmail: u.Email,
+				Items:     []*pb.OrderItem{{ProductId: p.ID, Quantity: 1}},
+			})
+			require.NoError(t, err)
+
+			for _, from := range tc.from {
+				_, err = srv.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusReq{Id: created.GetId(), Status: from, UserId: u.ID, IsAdmin: true})
+				require.NoError(t, err)
+			}
+
+			userID := u.ID
+			if tc.other {
+				userID++
+			}
+			res, err := srv.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusReq{
+				Id:      created.GetId(),
+				Status:  tc.to,
+				UserId:  userID,
+				IsAdmin: tc.isAdmin,
+				Reason:  "test",
+			})
+			require.Equal(t, tc.code, status.Code(err))
+			if tc.code != codes.OK {
+				return
+			}
+			require.Equal(t, tc.to, res.GetStatus())
+
+			history, err := srv.ListOrderStatusHistory(ctx, &pb.GetOrderReq{Id: created.GetId(), UserId: u.ID})
+			require.NoError(t, err)
+			require.Len(t, history.GetChanges(), len(tc.from)+1)
+			require.Equal(t, "test", history.GetChanges()[len(tc.from)].GetReason())
+
+			publishOutbox(t, st)
+			events, err := st.ListNotificationEvents(ctx)
+			require.NoError(t, err)
+			require.Len(t, events, len(tc.from)+2)
+			for _, ev := range events {
+				require.Equal(t, u.Email, ev.UserEmail)
+			}
+		})
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	ctx := context.Background()
+	srv, st := newTestServer(t)
+	u, p := seedCatalogue(t, st)
+
+	_, err := srv.Checkout(ctx, &pb.CheckoutReq{UserId: u.ID, UserEmail: u.Email})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = srv.AddToCart(ctx, &pb.CartReq{UserId: u.ID, ProductId: p.ID, Quantity: 0})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.AddToCart(ctx, &pb.CartReq{UserId: u.ID, ProductId: p.ID + 1, Quantity: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	cart, err := srv.AddToCart(ctx, &pb.CartReq{UserId: u.ID, ProductId: p.ID, Quantity: 2})
+	require.NoError(t, err)
+	require.Len(t, cart.GetItems(), 1)
+	require.Equal(t, usd(4000), toMoney(cart.GetSubtotalPrice()))
+
+	// the order is priced from the catalogue at checkout, not when the product
+	// was added to the cart
+	_, err = st.UpdateProduct(ctx, p.ID, func(p *storer.Product) { p.Price = usd(2500) })
+	require.NoError(t, err)
+
+	res, err := srv.Checkout(ctx, &pb.CheckoutReq{UserId: u.ID, UserEmail: u.Email, PaymentMethod: "card"})
+	require.NoError(t, err)
+	require.Equal(t, usd(5000), toMoney(res.GetSubtotalPrice()))
+	require.Equal(t, usd(500), toMoney(res.GetTaxPrice()))
+	require.Equal(t, usd(500), toMoney(res.GetShippingPrice()))
+	require.Equal(t, usd(6000), toMoney(res.GetTotalPrice()))
+	require.Equal(t, p.Name, res.GetItems()[0].GetName())
+	require.Equal(t, p.Image, res.GetItems()[0].GetImage())
+
+	cart, err = srv.GetCart(ctx, &pb.CartReq{UserId: u.ID})
+	require.NoError(t, err)
+	require.Empty(t, cart.GetItems())
+
+	publishOutbox(t, st)
+	events, err := st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, storer.OptionalID(res.GetId()), events[0].OrderID)
+
+	_, err = srv.RemoveCartItem(ctx, &pb.CartReq{UserId: u.ID, ProductId: p.ID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestReviews(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, pricing.NewPricer(pricing.FlatRateTax{}, pricing.FlatShipping{}), WithVerifiedReviews())
+	u, p := seedCatalogue(t, st)
+	other, err := st.CreateUser(ctx, &storer.User{Name: "other user", Email: "other@example.com", Password: "password"})
+	require.NoError(t, err)
+
+	req := &pb.ReviewReq{ProductId: p.ID, UserId: u.ID, Stars: 4, Title: "great", Body: "works as advertised"}
+	_, err = srv.CreateReview(ctx, &pb.ReviewReq{ProductId: p.ID, UserId: u.ID, Stars: 6})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.CreateReview(ctx, req)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	order, err := srv.CreateOrder(ctx, &pb.OrderReq{
+		UserId: u.ID,
+		Items:  []*pb.OrderItem{{ProductId: p.ID, Quantity: 1}},
+	})
+	require.NoError(t, err)
+	_, err = srv.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusReq{Id: order.GetId(), Status: pb.OrderStatus_PAID, UserId: other.ID, IsAdmin: true})
+	require.NoError(t, err)
+
+	rv, err := srv.CreateReview(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), rv.GetStars())
+	_, err = srv.CreateReview(ctx, req)
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// only the author may edit a review
+	_, err = srv.UpdateReview(ctx, &pb.ReviewReq{Id: rv.GetId(), ProductId: p.ID, UserId: other.ID, Stars: 1})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = srv.UpdateReview(ctx, &pb.ReviewReq{Id: rv.GetId(), ProductId: p.ID + 1, UserId: u.ID, Stars: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	updated, err := srv.UpdateReview(ctx, &pb.ReviewReq{Id: rv.GetId(), ProductId: p.ID, UserId: u.ID, Stars: 2})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), updated.GetStars())
+	require.Equal(t, "great", updated.GetTitle())
+	require.NotNil(t, updated.GetUpdatedAt())
+
+	pr, err := srv.GetProduct(ctx, &pb.ProductReq{Id: p.ID})
+	require.NoError(t, err)
+	require.Equal(t, 2.0, pr.GetRating())
+	require.Equal(t, int64(1), pr.GetNumReviews())
+
+	list, err := srv.ListReviews(ctx, &pb.ListReviewsReq{ProductId: p.ID})
+	require.NoError(t, err)
+	require.Len(t, list.GetReviews(), 1)
+
+	// admins may remove any review
+	_, err = srv.DeleteReview(ctx, &pb.ReviewReq{Id: rv.GetId(), ProductId: p.ID, UserId: other.ID})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = srv.DeleteReview(ctx, &pb.ReviewReq{Id: rv.GetId(), ProductId: p.ID, UserId: other.ID, IsAdmin: true})
+	require.NoError(t, err)
+
+	pr, err = srv.GetProduct(ctx, &pb.ProductReq{Id: p.ID})
+	require.NoError(t, err)
+	require.Zero(t, pr.GetRating())
+	require.Zero(t, pr.GetNumReviews())
+}
+
+func TestClaimNotificationEvents(t *testing.T) {
+	ctx := context.Background()
+	srv, st := newTestServer(t)
+	u, p := seedCatalogue(t, st)
+
+	_, err := srv.AddToCart(ctx, &pb.CartReq{UserId: u.ID, ProductId: p.ID, Quantity: 1})
+	require.NoError(t, err)
+	_, err = srv.Checkout(ctx, &pb.CheckoutReq{UserId: u.ID, UserEmail: u.Email, PaymentMethod: "card"})
+	require.NoError(t, err)
+	publishOutbox(t, st)
+
+	lease := durationpb.New(time.Minute)
+	_, err = srv.ClaimNotificationEvents(ctx, &pb.ClaimNotificationEventsReq{BatchSize: 10, LeaseDuration: lease})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.ClaimNotificationEvents(ctx, &pb.ClaimNotificationEventsReq{WorkerId: "worker-1", BatchSize: 0, LeaseDuration: lease})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.ClaimNotificationEvents(ctx, &pb.ClaimNotificationEventsReq{WorkerId: "worker-1", BatchSize: 10})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err := srv.ClaimNotificationEvents(ctx, &pb.ClaimNotificationEventsReq{WorkerId: "worker-1", BatchSize: 10, LeaseDuration: lease})
+	require.NoError(t, err)
+	require.Len(t, res.GetEvents(), 1)
+	ev := res.GetEvents()[0]
+	require.Equal(t, "worker-1", ev.GetLeasedBy())
+	require.NotNil(t, ev.GetLeasedUntil())
+
+	res, err = srv.ClaimNotificationEvents(ctx, &pb.ClaimNotificationEventsReq{WorkerId: "worker-2", BatchSize: 10, LeaseDuration: lease})
+	require.NoError(t, err)
+	require.Empty(t, res.GetEvents())
+
+	_, err = srv.UpdateNotificationEvent(ctx, &pb.UpdateNotificationEventReq{
+		Id:           ev.GetId(),
+		StateId:      ev.GetStateId(),
+		ResponseType: pb.NotificationResponseType_SUCCESS,
+		WorkerId:     "worker-2",
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	ures, err := srv.UpdateNotificationEvent(ctx, &pb.UpdateNotificationEventReq{
+		Id:           ev.GetId(),
+		StateId:      ev.GetStateId(),
+		ResponseType: pb.NotificationResponseType_SUCCESS,
+		WorkerId:     "worker-1",
+	})
+	require.NoError(t, err)
+	require.True(t, ures.GetSucceeded())
+}
+
+// subscribeStream hands the responses of SubscribeNotificationEvents to the
+// test.
+type subscribeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	res chan *pb.SubscribeNotificationEventsRes
+}
+
+func (s *subscribeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *subscribeStream) Send(res *pb.SubscribeNotificationEventsRes) error {
+	s.res <- res
+	return nil
+}
+
+// subscribe runs SubscribeNotificationEvents until the returned function is
+// called, which returns the error the subscription ended with.
+func subscribe(srv *Server, req *pb.SubscribeNotificationEventsReq) (*subscribeStream, func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &subscribeStream{ctx: ctx, res: make(chan *pb.SubscribeNotificationEventsRes, 10)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.SubscribeNotificationEvents(req, stream)
+	}()
+
+	return stream, func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func TestSubscribeNotificationEvents(t *testing.T) {
+	ctx := context.Background()
+	b := broker.New()
+	st := storer.NewMemoryStorer(storer.WithOnEnqueue(b.Publish))
+	srv := NewServer(st, pricing.NewPricer(pricing.FlatRateTax{}, pricing.FlatShipping{}), WithBroker(b))
+	u, p := seedCatalogue(t, st)
+
+	req := &pb.SubscribeNotificationEventsReq{WorkerId: "worker-1", BatchSize: 10, LeaseDuration: durationpb.New(time.Minute)}
+
+	_, stop := subscribe(NewServer(st, nil), req)
+	require.Equal(t, codes.Unimplemented, status.Code(stop()))
+	_, stop = subscribe(srv, &pb.SubscribeNotificationEventsReq{WorkerId: "worker-1", LeaseDuration: req.LeaseDuration})
+	require.Equal(t, codes.InvalidArgument, status.Code(stop()))
+
+	// without a cursor, due events are claimed straight away
+	stream, stop := subscribe(srv, req)
+	res := <-stream.res
+	require.Empty(t, res.GetEvents())
+	require.NotEmpty(t, res.GetCursor())
+
+	_, err := srv.AddToCart(ctx, &pb.CartReq{UserId: u.ID, ProductId: p.ID, Quantity: 1})
+	require.NoError(t, err)
+	order, err := srv.Checkout(ctx, &pb.CheckoutReq{UserId: u.ID, UserEmail: u.Email, PaymentMethod: "card"})
+	require.NoError(t, err)
+	publishOutbox(t, st)
+
+	res = <-stream.res
+	require.Len(t, res.GetEvents(), 1)
+	require.Equal(t, pb.OrderStatus_PENDING, res.GetEvents()[0].GetOrderStatus())
+	require.Equal(t, "worker-1", res.GetEvents()[0].GetLeasedBy())
+	require.Equal(t, codes.Canceled, status.Code(stop()))
+
+	// events enqueued while disconnected are pushed when the subscription
+	// resumes from its cursor
+	_, err = srv.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusReq{Id: order.GetId(), Status: pb.OrderStatus_PAID, UserId: u.ID, IsAdmin: true})
+	require.NoError(t, err)
+	publishOutbox(t, st)
+
+	req.Cursor = res.GetCursor()
+	stream, stop = subscribe(srv, req)
+	res = <-stream.res
+	require.Len(t, res.GetEvents(), 1)
+	require.Equal(t, pb.OrderStatus_PAID, res.GetEvents()[0].GetOrderStatus())
+	require.Equal(t, codes.Canceled, status.Code(stop()))
+}
+
+func TestReplayNotifications(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer(storer.WithRetryPolicy(storer.RetryPolicy{MaxAttempts: 1, Multiplier: 1}))
+	srv := NewServer(st, pricing.NewPricer(pricing.FlatRateTax{}, pricing.FlatShipping{}))
+	u, p := seedCatalogue(t, st)
+
+	_, err := srv.AddToCart(ctx, &pb.CartReq{UserId: u.ID, ProductId: p.ID, Quantity: 1})
+	require.NoError(t, err)
+	_, err = srv.Checkout(ctx, &pb.CheckoutReq{UserId: u.ID, UserEmail: u.Email, PaymentMethod: "card"})
+	require.NoError(t, err)
+	publishOutbox(t, st)
+
+	claimed, err := srv.ClaimNotificationEvents(ctx, &pb.ClaimNotificationEventsReq{WorkerId: "worker-1", BatchSize: 10, LeaseDuration: durationpb.New(time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, claimed.GetEvents(), 1)
+	ev := claimed.GetEvents()[0]
+
+	_, err = srv.UpdateNotificationEvent(ctx, &pb.UpdateNotificationEventReq{
+		Id:           ev.GetId(),
+		StateId:      ev.GetStateId(),
+		ResponseType: pb.NotificationResponseType_FAILURE,
+		Message:      "failed: mailbox full",
+		WorkerId:     "worker-1",
+	})
+	require.NoError(t, err)
+
+	failed := pb.NotificationDeliveryState_FAILED
+	list, err := srv.ListNotificationStates(ctx, &pb.ListNotificationStatesReq{State: &failed})
+	require.NoError(t, err)
+	require.Len(t, list.GetStates(), 1)
+	require.Equal(t, ev.GetStateId(), list.GetStates()[0].GetId())
+	require.Equal(t, u.Email, list.GetStates()[0].GetUserEmail())
+
+	_, err = srv.ListNotificationStates(ctx, &pb.ListNotificationStatesReq{Sort: "message"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	ns, err := srv.GetNotificationState(ctx, &pb.NotificationStateReq{Id: ev.GetStateId()})
+	require.NoError(t, err)
+	require.Equal(t, pb.NotificationDeliveryState_FAILED, ns.GetState())
+	require.Len(t, ns.GetAttempts(), 1)
+	require.Equal(t, "failed: mailbox full", ns.GetAttempts()[0].GetMessage())
+
+	_, err = srv.GetNotificationState(ctx, &pb.NotificationStateReq{Id: ev.GetStateId() + 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = srv.ReplayNotifications(ctx, &pb.ReplayNotificationsReq{Ids: []int64{ev.GetStateId()}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.ReplayNotifications(ctx, &pb.ReplayNotificationsReq{ActorId: u.ID})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.ReplayNotifications(ctx, &pb.ReplayNotificationsReq{Ids: []int64{ev.GetStateId() + 1}, ActorId: u.ID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	replayed, err := srv.ReplayNotifications(ctx, &pb.ReplayNotificationsReq{Ids: []int64{ev.GetStateId()}, ActorId: u.ID})
+	require.NoError(t, err)
+	require.Len(t, replayed.GetEvents(), 1)
+	require.Zero(t, replayed.GetEvents()[0].GetAttempts())
+
+	_, err = srv.ReplayNotifications(ctx, &pb.ReplayNotificationsReq{Ids: []int64{ev.GetStateId()}, ActorId: u.ID})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	ns, err = srv.GetNotificationState(ctx, &pb.NotificationStateReq{Id: ev.GetStateId()})
+	require.NoError(t, err)
+	require.Equal(t, pb.NotificationDeliveryState_NOT_SENT, ns.GetState())
+	require.Len(t, ns.GetReplays(), 1)
+	require.Equal(t, u.ID, ns.GetReplays()[0].GetActorId())
+	require.Equal(t, "failed: mailbox full", ns.GetReplays()[0].GetPreviousMessage())
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	ctx := context.Background()
+	st := storer.NewMemoryStorer()
+	srv := NewServer(st, pricing.NewPricer(pricing.FlatRateTax{}, pricing.FlatShipping{}))
+	u, p := seedCatalogue(t, st)
+
+	prefs, err := srv.GetNotificationPreferences(ctx, &pb.NotificationPreferencesReq{UserId: u.ID})
+	require.NoError(t, err)
+	require.Len(t, prefs.GetPreferences(), len(storer.NotificationTypes))
+	for _, pref := range prefs.GetPreferences() {
+		require.True(t, pref.GetEnabled())
+	}
+
+	_, err = srv.GetNotificationPreferences(ctx, &pb.NotificationPreferencesReq{UserId: u.ID + 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.UpdateNotificationPreferences(ctx, &pb.UpdateNotificationPreferencesReq{UserId: u.ID})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.UpdateNotificationPreferences(ctx, &pb.UpdateNotificationPreferencesReq{
+		UserId:      u.ID,
+		Preferences: []*pb.NotificationPreference{{Type: "order.lost", Channel: "email"}},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.UpdateNotificationPreferences(ctx, &pb.UpdateNotificationPreferencesReq{
+		UserId:      u.ID,
+		Preferences: []*pb.NotificationPreference{{Type: "order.pending", Channel: "sms"}},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	prefs, err = srv.UpdateNotificationPreferences(ctx, &pb.UpdateNotificationPreferencesReq{
+		UserId:      u.ID,
+		Preferences: []*pb.NotificationPreference{{Type: "order.pending", Channel: "email", Enabled: false}},
+	})
+	require.NoError(t, err)
+	for _, pref := range prefs.GetPreferences() {
+		require.Equal(t, pref.GetType() != "order.pending", pref.GetEnabled())
+	}
+
+	// the worker learns the type of the event to look up the preference
+	_, err = srv.AddToCart(ctx, &pb.CartReq{UserId: u.ID, ProductId: p.ID, Quantity: 1})
+	require.NoError(t, err)
+	_, err = srv.Checkout(ctx, &pb.CheckoutReq{UserId: u.ID, UserEmail: u.Email, PaymentMethod: "card"})
+	require.NoError(t, err)
+	publishOutbox(t, st)
+
+	claimed, err := srv.ClaimNotificationEvents(ctx, &pb.ClaimNotificationEventsReq{WorkerId: "worker-1", BatchSize: 10, LeaseDuration: durationpb.New(time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, claimed.GetEvents(), 1)
+	ev := claimed.GetEvents()[0]
+	require.Equal(t, "order.pending", ev.GetNotificationType())
+
+	res, err := srv.UpdateNotificationEvent(ctx, &pb.UpdateNotificationEventReq{
+		Id:           ev.GetId(),
+		StateId:      ev.GetStateId(),
+		ResponseType: pb.NotificationResponseType_OPTED_OUT,
+		Message:      "suppressed: user opted out",
+		WorkerId:     "worker-1",
+	})
+	require.NoError(t, err)
+	require.False(t, res.GetSucceeded())
+
+	ns, err := srv.GetNotificationState(ctx, &pb.NotificationStateReq{Id: ev.GetStateId()})
+	require.NoError(t, err)
+	require.Equal(t, pb.NotificationDeliveryState_SUPPRESSED, ns.GetState())
+	require.Empty(t, ns.GetAttempts())
+	require.NotNil(t, ns.GetCompletedAt())
+
+	// suppressed notifications are not retried
+	claimed, err = srv.ClaimNotificationEvents(ctx, &pb.ClaimNotificationEventsReq{WorkerId: "worker-1", BatchSize: 10, LeaseDuration: durationpb.New(time.Minute)})
+	require.NoError(t, err)
+	require.Empty(t, claimed.GetEvents())
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	srv, st := newTestServer(t)
+	u, p := seedCatalogue(t, st)
+
+	_, err := srv.CreateWebhook(ctx, &pb.WebhookReq{Url: "ftp://example.com/hooks", EventTypes: []string{"order.created"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.CreateWebhook(ctx, &pb.WebhookReq{Url: "https://example.com/hooks", EventTypes: []string{"order.lost"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.CreateWebhook(ctx, &pb.WebhookReq{Url: "https://example.com/hooks"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	created, err := srv.CreateWebhook(ctx, &pb.WebhookReq{Url: "https://example.com/hooks", EventTypes: []string{"order.created", "order.created"}})
+	require.NoError(t, err)
+	require.True(t, created.GetActive())
+	require.Equal(t, []string{"order.created"}, created.GetEventTypes())
+	require.NotEmpty(t, created.GetSecret())
+
+	inactive, err := srv.CreateWebhook(ctx, &pb.WebhookReq{Url: "https://example.org/hooks", EventTypes: []string{"order.created"}, Secret: "whsec_partner"})
+	require.NoError(t, err)
+	require.Equal(t, "whsec_partner", inactive.GetSecret())
+	inactive, err = srv.UpdateWebhook(ctx, &pb.WebhookReq{Id: inactive.GetId(), Active: proto.Bool(false)})
+	require.NoError(t, err)
+	require.False(t, inactive.GetActive())
+	require.Equal(t, "https://example.org/hooks", inactive.GetUrl())
+	require.NotNil(t, inactive.GetUpdatedAt())
+
+	_, err = srv.UpdateWebhook(ctx, &pb.WebhookReq{Id: created.GetId(), Url: "example.com"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.UpdateWebhook(ctx, &pb.WebhookReq{Id: 99, Url: "https://example.com"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	webhooks, err := srv.ListWebhooks(ctx, &pb.ListWebhooksReq{})
+	require.NoError(t, err)
+	require.Len(t, webhooks.GetWebhooks(), 2)
+
+	// only the active webhook gets the order event
+	_, err = srv.AddToCart(ctx, &pb.CartReq{UserId: u.ID, ProductId: p.ID, Quantity: 1})
+	require.NoError(t, err)
+	order, err := srv.Checkout(ctx, &pb.CheckoutReq{UserId: u.ID, UserEmail: u.Email, PaymentMethod: "card"})
+	require.NoError(t, err)
+	_, err = outbox.NewRelay(st, outbox.NewWebhookConsumer(st)).Publish(ctx)
+	require.NoError(t, err)
+
+	deliveries, err := srv.ListWebhookDeliveries(ctx, &pb.ListWebhookDeliveriesReq{WebhookId: created.GetId()})
+	require.NoError(t, err)
+	require.Len(t, deliveries.GetDeliveries(), 1)
+	d := deliveries.GetDeliveries()[0]
+	require.Equal(t, "order.created", d.GetEventType())
+	require.Equal(t, pb.WebhookDeliveryState_DELIVERY_PENDING, d.GetState())
+	require.Contains(t, string(d.GetPayload()), fmt.Sprintf(`"order_id":%d`, order.GetId()))
+
+	deliveries, err = srv.ListWebhookDeliveries(ctx, &pb.ListWebhookDeliveriesReq{WebhookId: inactive.GetId()})
+	require.NoError(t, err)
+	require.Empty(t, deliveries.GetDeliveries())
+
+	claimed, err := st.ClaimWebhookDeliveries(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	_, err = st.RecordWebhookDeliveryAttempt(ctx, claimed[0], &storer.WebhookDeliveryAttempt{StatusCode: 200, Succeeded: true, Message: "200 OK"})
+	require.NoError(t, err)
+
+	got, err := srv.GetWebhookDelivery(ctx, &pb.WebhookDeliveryReq{Id: d.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, pb.WebhookDeliveryState_DELIVERY_SUCCEEDED, got.GetState())
+	require.Len(t, got.GetAttemptLog(), 1)
+	require.Equal(t, int32(200), got.GetAttemptLog()[0].GetStatusCode())
+
+	_, err = srv.DeleteWebhook(ctx, &pb.WebhookReq{Id: created.GetId()})
+	require.NoError(t, err)
+	_, err = srv.DeleteWebhook(ctx, &pb.WebhookReq{Id: created.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.GetWebhookDelivery(ctx, &pb.WebhookDeliveryReq{Id: d.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.ListWebhookDeliveries(ctx, &pb.ListWebhookDeliveriesReq{WebhookId: created.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	srv, _ := newTestServer(t)
+
+	expiresAt := timestamppb.New(time.Now().Add(time.Hour))
+	laptop, err := srv.CreateSession(ctx, &pb.SessionReq{Id: "laptop", UserEmail: "test@example.com", RefreshToken: "token-1", UserAgent: "firefox", ClientIp: "192.0.2.1", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.NotNil(t, laptop.GetLastUsedAt())
+	_, err = srv.CreateSession(ctx, &pb.SessionReq{Id: "phone", UserEmail: "test@example.com", RefreshToken: "token-2", UserAgent: "safari", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	_, err = srv.CreateSession(ctx, &pb.SessionReq{Id: "other", UserEmail: "other@example.com", RefreshToken: "token-3", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+
+	renewed, err := srv.RotateSession(ctx, &pb.RotateSessionReq{Id: laptop.GetId(), NextId: "laptop-renewed", RefreshToken: "token-4", UserAgent: "firefox", ClientIp: "192.0.2.2", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.2", renewed.GetClientIp())
+
+	sessions, err := srv.ListSessionsForUser(ctx, &pb.ListSessionsReq{UserEmail: "test@example.com"})
+	require.NoError(t, err)
+	require.Len(t, sessions.GetSessions(), 2)
+	require.Equal(t, renewed.GetId(), sessions.GetSessions()[0].GetId())
+	require.Equal(t, "firefox", sessions.GetSessions()[0].GetUserAgent())
+
+	// users can only revoke their own sessions
+	_, err = srv.RevokeSession(ctx, &pb.SessionReq{Id: "other", UserEmail: "test@example.com"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.RevokeSession(ctx, &pb.SessionReq{Id: "unknown", UserEmail: "test@example.com"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.RevokeSession(ctx, &pb.SessionReq{Id: "phone", UserEmail: "test@example.com"})
+	require.NoError(t, err)
+
+	sessions, err = srv.ListSessionsForUser(ctx, &pb.ListSessionsReq{UserEmail: "test@example.com"})
+	require.NoError(t, err)
+	require.Len(t, sessions.GetSessions(), 1)
+
+	_, err = srv.RevokeAllSessions(ctx, &pb.RevokeAllSessionsReq{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.RevokeAllSessions(ctx, &pb.RevokeAllSessionsReq{UserEmail: "test@example.com"})
+	require.NoError(t, err)
+
+	sessions, err = srv.ListSessionsForUser(ctx, &pb.ListSessionsReq{UserEmail: "test@example.com"})
+	require.NoError(t, err)
+	require.Empty(t, sessions.GetSessions())
+	sessions, err = srv.ListSessionsForUser(ctx, &pb.ListSessionsReq{UserEmail: "other@example.com"})
+	require.NoError(t, err)
+	require.Len(t, sessions.GetSessions(), 1)
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	srv, st := newTestServer(t)
+	u, _ := seedCatalogue(t, st)
+	_, err := srv.CreateSession(ctx, &pb.SessionReq{Id: "laptop", UserEmail: u.Email, RefreshToken: "token", ExpiresAt: timestamppb.New(time.Now().Add(time.Hour))})
+	require.NoError(t, err)
+
+	// unknown emails look the same to the caller but send nothing
+	_, err = srv.RequestPasswordReset(ctx, &pb.RequestPasswordResetReq{Email: "unknown@example.com"})
+	require.NoError(t, err)
+	_, err = srv.RequestPasswordReset(ctx, &pb.RequestPasswordResetReq{Email: u.Email})
+	require.NoError(t, err)
+
+	events, err := srv.ListNotificationEvents(ctx, &pb.ListNotificationEventsReq{})
+	require.NoError(t, err)
+	require.Len(t, events.GetEvents(), 1)
+	ev := events.GetEvents()[0]
+	require.Equal(t, u.Email, ev.GetUserEmail())
+	require.Equal(t, string(storer.PasswordResetNotification), ev.GetNotificationType())
+	require.Zero(t, ev.GetOrderId())
+	tok := ev.GetPasswordReset().GetToken()
+	require.NotEmpty(t, tok)
+	require.WithinDuration(t, time.Now().Add(passwordResetTokenTTL), ev.GetPasswordReset().GetExpiresAt().AsTime(), time.Minute)
+
+	_, err = srv.ResetPassword(ctx, &pb.ResetPasswordReq{Token: "unknown", Password: "new password"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = srv.ResetPassword(ctx, &pb.ResetPasswordReq{Token: tok})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err := srv.ResetPassword(ctx, &pb.ResetPasswordReq{Token: tok, Password: "new password"})
+	require.NoError(t, err)
+	require.Equal(t, u.Email, res.GetUserEmail())
+
+	user, err := st.GetUser(ctx, u.Email)
+	require.NoError(t, err)
+	require.NoError(t, util.CheckPassword("new password", user.Password))
+	sess, err := srv.GetSession(ctx, &pb.SessionReq{Id: "laptop"})
+	require.NoError(t, err)
+	require.True(t, sess.GetIsRevoked())
+
+	_, err = srv.ResetPassword(ctx, &pb.ResetPasswordReq{Token: tok, Password: "another password"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,112 @@
+package storer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOrderStatusChanged is returned by UpdateOrderStatus when the order is no
+// longer in the status the change was made from, e.g. because a concurrent
+// request moved it first.
+var ErrOrderStatusChanged = errors.New("order status has changed")
+
+// InsufficientStockError is returned by CreateOrder when one or more order
+// items ask for more units than the product has in stock. No stock is
+// reserved and no order is written when it is returned.
+type InsufficientStockError struct {
+	ProductIDs []int64
+}
+
+func (e *InsufficientStockError) Error() string {
+	ids := make([]string, 0, len(e.ProductIDs))
+	for _, id := range e.ProductIDs {
+		ids = append(ids, fmt.Sprint(id))
+	}
+
+	return fmt.Sprintf("insufficient stock for products: %s", strings.Join(ids, ", "))
+}
+
+// ErrCartChanged is returned by Checkout when the cart no longer holds the
+// items the order was built from.
+var ErrCartChanged = errors.New("cart has changed")
+
+// ErrReviewExists is returned by CreateReview when the user has already
+// reviewed the product.
+var ErrReviewExists = errors.New("product already reviewed")
+
+// ErrNotLeaseHolder is returned by UpdateNotificationEvent when the event is
+// not leased by the worker acking it, either because the lease expired or
+// because the event was never claimed by that worker.
+var ErrNotLeaseHolder = errors.New("notification event not leased by worker")
+
+// ErrAlreadyEnqueued is returned by EnqueueNotificationEvent when a
+// notification was already enqueued for the outbox event.
+var ErrAlreadyEnqueued = errors.New("notification already enqueued for outbox event")
+
+// ErrNotReplayable is returned by ReplayNotifications when a notification has
+// not failed or can't be sent again.
+var ErrNotReplayable = errors.New("notification has not failed")
+
+// ErrPasswordResetTokenInvalid is returned by ResetPassword when the token
+// doesn't exist, has expired or was already used.
+var ErrPasswordResetTokenInvalid = errors.New("password reset token is invalid")
+
+// ErrSessionRevoked is returned by RotateSession when the session was revoked.
+var ErrSessionRevoked = errors.New("session revoked")
+
+// ErrRefreshTokenReused is returned by RotateSession when the session was
+// already rotated, i.e. its refresh token was used twice. Its whole family is
+// revoked, as one of the uses was likely by someone who stole the token.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// InvalidTransitionError is returned by UpdateOrderStatus when the order
+// lifecycle does not allow moving an order from one status to another.
+type InvalidTransitionError struct {
+	OrderID int64
+	From    OrderStatus
+	To      OrderStatus
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("order %d cannot move from %s to %s", e.OrderID, e.From, e.To)
+}
+
+// checkTransition validates c against the current status of o and fills in
+// c.FromStatus when the caller left it empty.
+func checkTransition(o *Order, c *OrderStatusChange) error {
+	if c.FromStatus != "" && c.FromStatus != o.Status {
+		return fmt.Errorf("%w: order %d is %s, not %s", ErrOrderStatusChanged, o.ID, o.Status, c.FromStatus)
+	}
+	if !o.Status.CanTransitionTo(c.ToStatus) {
+		return &InvalidTransitionError{OrderID: o.ID, From: o.Status, To: c.ToStatus}
+	}
+	c.FromStatus = o.Status
+
+	return nil
+}
+
+// checkReplayable checks that every id in ids is one of states and that all
+// of them have failed.
+func checkReplayable(states []*NotificationState, ids []int64) error {
+	found := make(map[int64]*NotificationState, len(states))
+	for _, ns := range states {
+		found[ns.ID] = ns
+	}
+	for _, id := range ids {
+		ns, ok := found[id]
+		if !ok {
+			return fmt.Errorf("notification state %d: %w", id, sql.ErrNoRows)
+		}
+		if ns.State != Failed {
+			return fmt.Errorf("%w: notification %d is %s", ErrNotReplayable, id, ns.State)
+		}
+		// the reset token is gone along with the event that carried it
+		if ns.NotificationType == PasswordResetNotification {
+			return fmt.Errorf("%w: notification %d is a password reset", ErrNotReplayable, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package storer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type OutboxEventType string
+
+const (
+	OrderCreated       OutboxEventType = "order.created"
+	OrderStatusChanged OutboxEventType = "order.status_changed"
+)
+
+// OutboxEventTypes are the event types webhooks can subscribe to.
+var OutboxEventTypes = []OutboxEventType{OrderCreated, OrderStatusChanged}
+
+const orderAggregate = "order"
+
+// OutboxEvent is a domain event. It is written in the same transaction as the
+// change it describes and delivered to consumers afterwards, so a change is
+// never committed without its event or the other way round.
+type OutboxEvent struct {
+	ID            int64           `db:"id"`
+	AggregateType string          `db:"aggregate_type"`
+	AggregateID   int64           `db:"aggregate_id"`
+	EventType     OutboxEventType `db:"event_type"`
+	Payload       []byte          `db:"payload"`
+	Attempts      int64           `db:"attempts"`
+	LastError     string          `db:"last_error"`
+	CreatedAt     time.Time       `db:"created_at"`
+	PublishedAt   *time.Time      `db:"published_at"`
+	// ParkedAt is set once the event has failed too often to be delivered
+	// again.
+	ParkedAt *time.Time `db:"parked_at"`
+}
+
+// OrderEvent is the payload of the order events. FromStatus is empty for
+// OrderCreated.
+type OrderEvent struct {
+	OrderID    int64       `json:"order_id"`
+	UserID     int64       `json:"user_id"`
+	FromStatus OrderStatus `json:"from_status,omitempty"`
+	Status     OrderStatus `json:"status"`
+}
+
+func newOrderEvent(typ OutboxEventType, e OrderEvent) (*OutboxEvent, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding order event: %w", err)
+	}
+
+	return &OutboxEvent{
+		AggregateType: orderAggregate,
+		AggregateID:   e.OrderID,
+		EventType:     typ,
+		Payload:       payload,
+	}, nil
+}
+
+// OrderEvent decodes the payload of an order event.
+func (ev *OutboxEvent) OrderEvent() (*OrderEvent, error) {
+	if ev.AggregateType != orderAggregate {
+		return nil, fmt.Errorf("outbox event %d is not an order event", ev.ID)
+	}
+
+	var e OrderEvent
+	if err := json.Unmarshal(ev.Payload, &e); err != nil {
+		return nil, fmt.Errorf("error decoding order event: %w", err)
+	}
+
+	return &e, nil
+}
+
+// failOutboxEvent records a failed delivery of ev at now.
+func failOutboxEvent(ev *OutboxEvent, message string, maxAttempts int64, now time.Time) {
+	ev.Attempts++
+	ev.LastError = message
+	if ev.Attempts >= maxAttempts {
+		ev.ParkedAt = &now
+	}
+}
@@ -0,0 +1,233 @@
+package storer
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhij/ecomm/money"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// ErrInvalidListParams is returned by the List methods for an unknown sort
+// key or a malformed page cursor.
+var ErrInvalidListParams = errors.New("invalid list parameters")
+
+// Page selects a window of a list. Rows are returned in Sort order, which is
+// a sort key optionally prefixed with "-" for descending order, with ties
+// broken by ID. Cursor is the NextCursor returned with the previous page.
+type Page struct {
+	Limit  int
+	Cursor string
+	Sort   string
+}
+
+type sortKind int
+
+const (
+	sortInt sortKind = iota
+	sortMoney
+	sortDecimal
+	sortString
+	sortTime
+)
+
+type sortField struct {
+	column string
+	kind   sortKind
+}
+
+const cursorTimeLayout = "2006-01-02 15:04:05"
+
+// cursor is the position of the last row of a page: its sort key value in a
+// form MySQL can compare against the column, and its ID.
+type cursor struct {
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListParams)
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListParams)
+	}
+
+	return &c, nil
+}
+
+// pageQuery is a Page resolved against the sort keys of one table.
+type pageQuery struct {
+	key    string
+	field  sortField
+	desc   bool
+	limit  int
+	cursor *cursor
+}
+
+func resolvePage(p Page, fields map[string]sortField) (*pageQuery, error) {
+	key := strings.TrimPrefix(p.Sort, "-")
+	if key == "" {
+		key = "id"
+	}
+
+	field, ok := fields[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort key %q", ErrInvalidListParams, key)
+	}
+
+	c, err := decodeCursor(p.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := p.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	return &pageQuery{
+		key:    key,
+		field:  field,
+		desc:   strings.HasPrefix(p.Sort, "-"),
+		limit:  limit,
+		cursor: c,
+	}, nil
+}
+
+// where appends the keyset condition that selects rows after the cursor.
+func (pq *pageQuery) where(conds []string, args []interface{}) ([]string, []interface{}) {
+	if pq.cursor == nil {
+		return conds, args
+	}
+
+	op := ">"
+	if pq.desc {
+		op = "<"
+	}
+	col := pq.field.column
+	if col == "id" {
+		return append(conds, fmt.Sprintf("id %s ?", op)), append(args, pq.cursor.ID)
+	}
+
+	return append(conds, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", col, op, col, op)),
+		append(args, pq.cursor.Value, pq.cursor.Value, pq.cursor.ID)
+}
+
+func (pq *pageQuery) orderBy() string {
+	dir := "ASC"
+	if pq.desc {
+		dir = "DESC"
+	}
+
+	if pq.field.column == "id" {
+		return fmt.Sprintf(" ORDER BY id %s LIMIT %d", dir, pq.limit+1)
+	}
+
+	return fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %d", pq.field.column, dir, dir, pq.limit+1)
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// compare orders two cursor values of the page's sort key.
+func (pq *pageQuery) compare(a, b string) int {
+	switch pq.field.kind {
+	case sortInt:
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+		return cmpInt64(x, y)
+	case sortMoney:
+		x, _ := money.Parse(a, money.DefaultCurrency)
+		y, _ := money.Parse(b, money.DefaultCurrency)
+		return cmpInt64(x.Amount, y.Amount)
+	case sortDecimal:
+		x, _ := strconv.ParseFloat(a, 64)
+		y, _ := strconv.ParseFloat(b, 64)
+		return cmp.Compare(x, y)
+	case sortTime:
+		x, _ := time.Parse(cursorTimeLayout, a)
+		y, _ := time.Parse(cursorTimeLayout, b)
+		return x.Compare(y)
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// less orders two rows by the page's sort key and ID, honouring direction.
+func (pq *pageQuery) less(av string, aID int64, bv string, bID int64) bool {
+	c := pq.compare(av, bv)
+	if c == 0 {
+		c = cmpInt64(aID, bID)
+	}
+	if pq.desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+// after reports whether a row comes after the cursor.
+func (pq *pageQuery) after(v string, id int64) bool {
+	if pq.cursor == nil {
+		return true
+	}
+	return pq.less(pq.cursor.Value, pq.cursor.ID, v, id)
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func formatCursorTime(t time.Time) string {
+	return t.UTC().Format(cursorTimeLayout)
+}
+
+// paginate trims the extra row fetched to detect a following page and returns
+// the cursor of the last row kept.
+func paginate[T any](pq *pageQuery, rows []T, position func(T) (string, int64)) ([]T, string) {
+	if len(rows) <= pq.limit {
+		return rows, ""
+	}
+
+	rows = rows[:pq.limit]
+	v, id := position(rows[len(rows)-1])
+
+	return rows, encodeCursor(cursor{Value: v, ID: id})
+}
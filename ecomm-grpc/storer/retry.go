@@ -0,0 +1,62 @@
+package storer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides when a failed notification is attempted again and when
+// it is given up on. The delay after the nth failed attempt is
+// BaseDelay * Multiplier^(n-1), capped at MaxDelay, of which up to a Jitter
+// fraction is taken off at random so that events failing together are not
+// retried together.
+type RetryPolicy struct {
+	MaxAttempts int64
+	BaseDelay   time.Duration
+	Multiplier  float64
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Minute,
+	Multiplier:  4,
+	MaxDelay:    time.Hour,
+	Jitter:      0.2,
+}
+
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return fmt.Errorf("max attempts must be at least 1")
+	case p.BaseDelay < 0:
+		return fmt.Errorf("base delay must not be negative")
+	case p.Multiplier < 1:
+		return fmt.Errorf("multiplier must be at least 1")
+	case p.MaxDelay < 0:
+		return fmt.Errorf("max delay must not be negative")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// Delay returns how long to wait after the given number of failed attempts.
+func (p RetryPolicy) Delay(attempts int64) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+
+	d := float64(p.BaseDelay) * math.Pow(p.Multiplier, float64(attempts-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+
+	return time.Duration(d)
+}
@@ -0,0 +1,32 @@
+package storer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute, Multiplier: 2, MaxDelay: 5 * time.Minute}
+	require.NoError(t, p.Validate())
+
+	require.Equal(t, time.Duration(0), p.Delay(0))
+	require.Equal(t, time.Minute, p.Delay(1))
+	require.Equal(t, 2*time.Minute, p.Delay(2))
+	require.Equal(t, 4*time.Minute, p.Delay(3))
+	require.Equal(t, 5*time.Minute, p.Delay(4))
+	require.Equal(t, 5*time.Minute, p.Delay(50))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.Delay(2)
+		require.GreaterOrEqual(t, d, time.Minute)
+		require.LessOrEqual(t, d, 2*time.Minute)
+	}
+
+	require.Error(t, RetryPolicy{MaxAttempts: 0, Multiplier: 1}.Validate())
+	require.Error(t, RetryPolicy{MaxAttempts: 1, Multiplier: 0.5}.Validate())
+	require.Error(t, RetryPolicy{MaxAttempts: 1, Multiplier: 1, Jitter: 2}.Validate())
+	require.NoError(t, DefaultRetryPolicy.Validate())
+}
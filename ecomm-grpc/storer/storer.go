@@ -0,0 +1,129 @@
+package storer
+
+import (
+	"context"
+	"time"
+)
+
+// Storer is the persistence layer used by the ecomm-grpc server. MySQLStorer
+// is the production implementation; MemoryStorer is a drop-in replacement for
+// tests and local development.
+type Storer interface {
+	CreateProduct(ctx context.Context, p *Product) (*Product, error)
+	GetProduct(ctx context.Context, id int64) (*Product, error)
+	ListProducts(ctx context.Context, f ProductFilter) ([]*Product, string, error)
+	UpdateProduct(ctx context.Context, id int64, patch func(*Product)) (*Product, error)
+	DeleteProduct(ctx context.Context, id int64) error
+
+	CreateOrder(ctx context.Context, o *Order) (*Order, error)
+	GetOrder(ctx context.Context, id int64) (*Order, error)
+	GetOrderStatusByID(ctx context.Context, id int64) (*Order, error)
+	ListOrders(ctx context.Context, f OrderFilter) ([]*Order, string, error)
+	ListOrdersForUser(ctx context.Context, userID int64, p Page) ([]*Order, string, error)
+	UpdateOrderStatus(ctx context.Context, c *OrderStatusChange) (*Order, error)
+	ListOrderStatusHistory(ctx context.Context, orderID int64) ([]*OrderStatusChange, error)
+	DeleteOrder(ctx context.Context, id int64) error
+
+	GetCart(ctx context.Context, userID int64) (*Cart, error)
+	AddToCart(ctx context.Context, userID, productID, quantity int64) (*Cart, error)
+	UpdateCartItem(ctx context.Context, userID, productID, quantity int64) (*Cart, error)
+	RemoveCartItem(ctx context.Context, userID, productID int64) (*Cart, error)
+	Checkout(ctx context.Context, cart *Cart, o *Order) (*Order, error)
+
+	CreateReview(ctx context.Context, r *Review) (*Review, error)
+	GetReview(ctx context.Context, id int64) (*Review, error)
+	ListReviews(ctx context.Context, productID int64, p Page) ([]*Review, string, error)
+	UpdateReview(ctx context.Context, r *Review) (*Review, error)
+	DeleteReview(ctx context.Context, id int64) error
+	HasPurchased(ctx context.Context, userID, productID int64) (bool, error)
+
+	CreateUser(ctx context.Context, u *User) (*User, error)
+	GetUser(ctx context.Context, email string) (*User, error)
+	GetUserByID(ctx context.Context, id int64) (*User, error)
+	ListUsers(ctx context.Context, f UserFilter) ([]*User, string, error)
+	UpdateUser(ctx context.Context, u *User) (*User, error)
+	DeleteUser(ctx context.Context, id int64) error
+
+	CreateSession(ctx context.Context, s *Session) (*Session, error)
+	GetSession(ctx context.Context, id string) (*Session, error)
+	RotateSession(ctx context.Context, id string, next *Session) (*Session, error)
+	ListSessionsForUser(ctx context.Context, email string) ([]*Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	RevokeAllSessions(ctx context.Context, email string) error
+	DeleteSession(ctx context.Context, id string) error
+
+	CreatePasswordResetToken(ctx context.Context, t *PasswordResetToken, ne *NotificationEvent) (*PasswordResetToken, error)
+	ResetPassword(ctx context.Context, tokenHash, password string) (*User, error)
+
+	ListOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error)
+	MarkOutboxEventPublished(ctx context.Context, id int64) error
+	FailOutboxEvent(ctx context.Context, id int64, message string, maxAttempts int64) (*OutboxEvent, error)
+
+	EnqueueNotificationEvent(ctx context.Context, ne *NotificationEvent) (*NotificationEvent, error)
+	ListNotificationEvents(ctx context.Context) ([]*NotificationEvent, error)
+	ClaimNotificationEvents(ctx context.Context, workerID string, batch int, lease time.Duration) ([]*NotificationEvent, error)
+	UpdateNotificationEvent(ctx context.Context, ev *NotificationEvent, es *NotificationState, responseType NotificationResponseType) (bool, error)
+	UpdateNotificationEvents(ctx context.Context, updates []*NotificationUpdate) ([]bool, error)
+	ListNotificationAttempts(ctx context.Context, stateID int64) ([]*NotificationAttempt, error)
+	GetNotificationState(ctx context.Context, id int64) (*NotificationState, error)
+	ListNotificationStates(ctx context.Context, f NotificationStateFilter) ([]*NotificationState, string, error)
+	ListNotificationReplays(ctx context.Context, stateID int64) ([]*NotificationReplay, error)
+	ReplayNotifications(ctx context.Context, stateIDs []int64, actorID int64) ([]*NotificationEvent, error)
+
+	ListNotificationPreferences(ctx context.Context, userID int64) ([]*NotificationPreference, error)
+	SetNotificationPreferences(ctx context.Context, userID int64, prefs []*NotificationPreference) error
+
+	CreateWebhook(ctx context.Context, w *Webhook) (*Webhook, error)
+	GetWebhook(ctx context.Context, id int64) (*Webhook, error)
+	ListWebhooks(ctx context.Context) ([]*Webhook, error)
+	UpdateWebhook(ctx context.Context, w *Webhook) (*Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	EnqueueWebhookDeliveries(ctx context.Context, ev *OutboxEvent) ([]*WebhookDelivery, error)
+	ClaimWebhookDeliveries(ctx context.Context, workerID string, batch int, lease time.Duration) ([]*WebhookDelivery, error)
+	RecordWebhookDeliveryAttempt(ctx context.Context, d *WebhookDelivery, a *WebhookDeliveryAttempt) (*WebhookDelivery, error)
+	GetWebhookDelivery(ctx context.Context, id int64) (*WebhookDelivery, error)
+	ListWebhookDeliveries(ctx context.Context, webhookID int64, p Page) ([]*WebhookDelivery, string, error)
+	ListWebhookDeliveryAttempts(ctx context.Context, deliveryID int64) ([]*WebhookDeliveryAttempt, error)
+}
+
+var (
+	_ Storer = (*MySQLStorer)(nil)
+	_ Storer = (*MemoryStorer)(nil)
+)
+
+type options struct {
+	retry     RetryPolicy
+	onEnqueue func(...*NotificationEvent)
+}
+
+// Option configures a MySQLStorer or a MemoryStorer.
+type Option func(*options)
+
+// WithRetryPolicy sets when failed notifications are retried. It defaults to
+// DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = p
+	}
+}
+
+// WithOnEnqueue sets a function that is called with the events enqueued by
+// EnqueueNotificationEvent and ReplayNotifications once they are stored. It
+// must not block or call back into the storer.
+func WithOnEnqueue(fn func(...*NotificationEvent)) Option {
+	return func(o *options) {
+		o.onEnqueue = fn
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		retry:     DefaultRetryPolicy,
+		onEnqueue: func(...*NotificationEvent) {},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
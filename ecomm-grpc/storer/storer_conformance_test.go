@@ -0,0 +1,1794 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhij/ecomm/money"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorerConformance runs the behaviour every Storer implementation must
+// share. newStorer must return an empty store configured with opts for each
+// call.
+func testStorerConformance(t *testing.T, newStorer func(*testing.T, ...Option) Storer) {
+	enqueued := &enqueueRecorder{}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, Storer)
+		opts []Option
+	}{
+		{name: "products", test: conformProducts},
+		{name: "orders", test: conformOrders},
+		{name: "order lifecycle", test: conformOrderLifecycle},
+		{name: "create order rolls back", test: conformCreateOrderRollback},
+		{name: "stock reservation", test: conformStockReservation},
+		{name: "delete order restock", test: conformDeleteOrderRestock},
+		{name: "refund restock", test: conformRefundRestock},
+		{name: "concurrent checkouts", test: conformConcurrentCheckouts},
+		{name: "concurrent product updates", test: conformConcurrentProductUpdates},
+		{name: "list products", test: conformListProducts},
+		{name: "list orders", test: conformListOrders},
+		{name: "cart", test: conformCart},
+		{name: "checkout", test: conformCheckout},
+		{name: "reviews", test: conformReviews},
+		{name: "verified purchase", test: conformHasPurchased},
+		{name: "users", test: conformUsers},
+		{name: "sessions", test: conformSessions},
+		{name: "session rotation", test: conformSessionRotation},
+		{name: "sessions for user", test: conformSessionsForUser},
+		{name: "password reset", test: conformPasswordReset, opts: []Option{WithRetryPolicy(RetryPolicy{MaxAttempts: 1, Multiplier: 1})}},
+		{name: "outbox", test: conformOutbox},
+		{name: "outbox failures", test: conformOutboxFailures},
+		{name: "notification success", test: conformNotificationSuccess},
+		{name: "notification retries", test: conformNotificationRetries, opts: []Option{WithRetryPolicy(immediateRetries)}},
+		{name: "notification leases", test: conformNotificationLeases, opts: []Option{WithRetryPolicy(immediateRetries)}},
+		{name: "notification stale state", test: conformNotificationStaleState, opts: []Option{WithRetryPolicy(RetryPolicy{MaxAttempts: 1, Multiplier: 1})}},
+		{name: "notification backoff", test: conformNotificationBackoff, opts: []Option{WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, Multiplier: 2})}},
+		{name: "notification replay", test: conformNotificationReplay, opts: []Option{WithRetryPolicy(RetryPolicy{MaxAttempts: 1, Multiplier: 1})}},
+		{name: "notification preferences", test: conformNotificationPreferences},
+		{name: "notification batch update", test: conformNotificationBatchUpdate, opts: []Option{WithRetryPolicy(immediateRetries)}},
+		{name: "webhooks", test: conformWebhooks},
+		{name: "webhook deliveries", test: conformWebhookDeliveries, opts: []Option{WithRetryPolicy(RetryPolicy{MaxAttempts: 2, Multiplier: 1})}},
+		{name: "enqueue hook", test: enqueued.conform, opts: []Option{WithOnEnqueue(enqueued.record), WithRetryPolicy(RetryPolicy{MaxAttempts: 1, Multiplier: 1})}},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, newStorer(t, tc.opts...))
+		})
+	}
+}
+
+// immediateRetries makes failed notifications due again straight away.
+var immediateRetries = RetryPolicy{MaxAttempts: 3, Multiplier: 1}
+
+func seedUser(t *testing.T, st Storer, email string) *User {
+	u, err := st.CreateUser(context.Background(), &User{
+		Name:     "test user",
+		Email:    email,
+		Password: "password",
+		Locale:   "en",
+	})
+	require.NoError(t, err)
+
+	return u
+}
+
+func seedProduct(t *testing.T, st Storer, name string) *Product {
+	p, err := st.CreateProduct(context.Background(), &Product{
+		Name:         name,
+		Image:        "test.jpg",
+		Category:     "test category",
+		Description:  "test description",
+		Price:        money.New(9999, "USD"),
+		CountInStock: 10,
+	})
+	require.NoError(t, err)
+
+	return p
+}
+
+func seedOrder(t *testing.T, st Storer, userID int64, products ...*Product) *Order {
+	o := &Order{
+		PaymentMethod: "test payment method",
+		TaxPrice:      money.New(1000, "USD"),
+		ShippingPrice: money.New(2000, "USD"),
+		TotalPrice:    money.New(12999, "USD"),
+		UserID:        userID,
+	}
+	for _, p := range products {
+		o.Items = append(o.Items, OrderItem{
+			Name:      p.Name,
+			Quantity:  1,
+			Image:     p.Image,
+			Price:     money.New(9900, "USD"),
+			ProductID: p.ID,
+		})
+	}
+
+	co, err := st.CreateOrder(context.Background(), o)
+	require.NoError(t, err)
+
+	return co
+}
+
+func conformProducts(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	p := seedProduct(t, st, "test product")
+	require.NotZero(t, p.ID)
+
+	gp, err := st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, p.Name, gp.Name)
+	require.Equal(t, p.CountInStock, gp.CountInStock)
+
+	up, err := st.UpdateProduct(ctx, p.ID, func(p *Product) {
+		p.Name = "new test product"
+		p.UpdatedAt = toTimePtr(time.Now())
+	})
+	require.NoError(t, err)
+	require.Equal(t, "new test product", up.Name)
+	require.Equal(t, p.CountInStock, up.CountInStock)
+
+	_, err = st.UpdateProduct(ctx, p.ID+1000, func(p *Product) {})
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+
+	seedProduct(t, st, "test product 2")
+	products, _, err := st.ListProducts(ctx, ProductFilter{})
+	require.NoError(t, err)
+	require.Len(t, products, 2)
+	require.Equal(t, "new test product", products[0].Name)
+
+	require.NoError(t, st.DeleteProduct(ctx, p.ID))
+	_, err = st.GetProduct(ctx, p.ID)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+}
+
+func conformOrders(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p1 := seedProduct(t, st, "test product")
+	p2 := seedProduct(t, st, "test product 2")
+	o := seedOrder(t, st, u.ID, p1, p2)
+	require.NotZero(t, o.ID)
+
+	go1, err := st.GetOrder(ctx, o.ID)
+	require.NoError(t, err)
+	require.Equal(t, o.ID, go1.ID)
+	require.Equal(t, Pending, go1.Status)
+	require.Len(t, go1.Items, 2)
+	require.Equal(t, p1.ID, go1.Items[0].ProductID)
+	require.Equal(t, o.ID, go1.Items[0].OrderID)
+	require.Equal(t, o.Items[0].Price, go1.Items[0].Price)
+	require.Equal(t, o.TotalPrice, go1.TotalPrice)
+
+	_, err = st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: Paid, ActorID: u.ID, ActorRole: AdminActor})
+	require.NoError(t, err)
+
+	so, err := st.GetOrderStatusByID(ctx, o.ID)
+	require.NoError(t, err)
+	require.Equal(t, Paid, so.Status)
+	require.Equal(t, u.ID, so.UserID)
+
+	orders, _, err := st.ListOrders(ctx, OrderFilter{})
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Len(t, orders[0].Items, 2)
+
+	require.NoError(t, st.DeleteOrder(ctx, o.ID))
+	_, err = st.GetOrder(ctx, o.ID)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+	_, err = st.GetOrderStatusByID(ctx, o.ID)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+}
+
+func conformOrderLifecycle(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p := seedProduct(t, st, "test product")
+	o := seedOrder(t, st, u.ID, p)
+
+	for _, next := range []OrderStatus{Paid, Processing, Shipped, Delivered} {
+		uo, err := st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: next, ActorID: u.ID, ActorRole: AdminActor})
+		require.NoError(t, err)
+		require.Equal(t, next, uo.Status)
+		require.NotNil(t, uo.UpdatedAt)
+		require.Len(t, uo.Items, 1)
+	}
+
+	_, err := st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: Pending, ActorID: u.ID, ActorRole: AdminActor})
+	var transitionErr *InvalidTransitionError
+	require.True(t, errors.As(err, &transitionErr))
+	require.Equal(t, Delivered, transitionErr.From)
+	require.Equal(t, Pending, transitionErr.To)
+
+	_, err = st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, FromStatus: Shipped, ToStatus: RefundRequested, ActorID: u.ID, ActorRole: CustomerActor})
+	require.True(t, errors.Is(err, ErrOrderStatusChanged))
+
+	_, err = st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, FromStatus: Delivered, ToStatus: RefundRequested, ActorID: u.ID, ActorRole: CustomerActor, Reason: "damaged"})
+	require.NoError(t, err)
+
+	history, err := st.ListOrderStatusHistory(ctx, o.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 5)
+	require.Equal(t, Pending, history[0].FromStatus)
+	require.Equal(t, Paid, history[0].ToStatus)
+	require.Equal(t, Delivered, history[4].FromStatus)
+	require.Equal(t, RefundRequested, history[4].ToStatus)
+	require.Equal(t, CustomerActor, history[4].ActorRole)
+	require.Equal(t, u.ID, history[4].ActorID)
+	require.Equal(t, "damaged", history[4].Reason)
+
+	// cancelling puts the items back in stock
+	o2 := seedOrder(t, st, u.ID, p)
+	gp, err := st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, p.CountInStock-2, gp.CountInStock)
+
+	_, err = st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o2.ID, FromStatus: Pending, ToStatus: Cancelled, ActorID: u.ID, ActorRole: CustomerActor})
+	require.NoError(t, err)
+	gp, err = st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, p.CountInStock-1, gp.CountInStock)
+
+	_, err = st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o2.ID, ToStatus: Paid, ActorID: u.ID, ActorRole: AdminActor})
+	require.True(t, errors.As(err, &transitionErr))
+
+	_, err = st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o2.ID + 1000, ToStatus: Paid, ActorID: u.ID, ActorRole: AdminActor})
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+}
+
+func conformCreateOrderRollback(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p := seedProduct(t, st, "test product")
+
+	_, err := st.CreateOrder(ctx, &Order{
+		PaymentMethod: "test payment method",
+		UserID:        u.ID,
+		Items: []OrderItem{
+			{Name: p.Name, Quantity: 1, Image: p.Image, ProductID: p.ID},
+			{Name: "missing", Quantity: 1, Image: "missing.jpg", ProductID: p.ID + 1000},
+		},
+	})
+	require.Error(t, err)
+
+	orders, _, err := st.ListOrders(ctx, OrderFilter{})
+	require.NoError(t, err)
+	require.Empty(t, orders)
+
+	events, err := st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func conformStockReservation(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p1 := seedProduct(t, st, "test product")
+	p2 := seedProduct(t, st, "test product 2")
+
+	o, err := st.CreateOrder(ctx, &Order{
+		PaymentMethod: "test payment method",
+		UserID:        u.ID,
+		Items: []OrderItem{
+			{Name: p1.Name, Quantity: 4, Image: p1.Image, ProductID: p1.ID},
+			{Name: p2.Name, Quantity: 10, Image: p2.Image, ProductID: p2.ID},
+		},
+	})
+	require.NoError(t, err)
+
+	gp1, err := st.GetProduct(ctx, p1.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), gp1.CountInStock)
+
+	_, err = st.CreateOrder(ctx, &Order{
+		PaymentMethod: "test payment method",
+		UserID:        u.ID,
+		Items: []OrderItem{
+			{Name: p1.Name, Quantity: 1, Image: p1.Image, ProductID: p1.ID},
+			{Name: p2.Name, Quantity: 1, Image: p2.Image, ProductID: p2.ID},
+		},
+	})
+	var stockErr *InsufficientStockError
+	require.ErrorAs(t, err, &stockErr)
+	require.Equal(t, []int64{p2.ID}, stockErr.ProductIDs)
+
+	// the rejected order must not have reserved anything
+	gp1, err = st.GetProduct(ctx, p1.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), gp1.CountInStock)
+
+	require.NoError(t, st.DeleteOrder(ctx, o.ID))
+
+	gp1, err = st.GetProduct(ctx, p1.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), gp1.CountInStock)
+
+	gp2, err := st.GetProduct(ctx, p2.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), gp2.CountInStock)
+}
+
+func conformDeleteOrderRestock(t *testing.T, st Storer) {
+	ctx := context.Background()
+	u := seedUser(t, st, "test@example.com")
+
+	tcs := []struct {
+		name     string
+		statuses []OrderStatus
+		restock  bool
+	}{
+		{name: "pending", restock: true},
+		{name: "processing", statuses: []OrderStatus{Paid, Processing}, restock: true},
+		// cancelling restocked it already
+		{name: "cancelled", statuses: []OrderStatus{Cancelled}},
+		{name: "shipped", statuses: []OrderStatus{Paid, Processing, Shipped}},
+		{name: "delivered", statuses: []OrderStatus{Paid, Processing, Shipped, Delivered}},
+		{name: "refund requested before shipping", statuses: []OrderStatus{Paid, RefundRequested}, restock: true},
+		// refunding restocked it already
+		{name: "refunded before shipping", statuses: []OrderStatus{Paid, RefundRequested, Refunded}},
+		{name: "refunded after shipping", statuses: []OrderStatus{Paid, Processing, Shipped, Delivered, RefundRequested, Refunded}},
+	}
+
+	for _, tc := range tcs {
+		p := seedProduct(t, st, tc.name)
+		o := seedOrder(t, st, u.ID, p)
+		for _, status := range tc.statuses {
+			_, err := st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: status, ActorID: u.ID, ActorRole: AdminActor})
+			require.NoError(t, err, tc.name)
+		}
+
+		before, err := st.GetProduct(ctx, p.ID)
+		require.NoError(t, err, tc.name)
+
+		require.NoError(t, st.DeleteOrder(ctx, o.ID), tc.name)
+
+		after, err := st.GetProduct(ctx, p.ID)
+		require.NoError(t, err, tc.name)
+		want := before.CountInStock
+		if tc.restock {
+			want++
+		}
+		require.Equal(t, want, after.CountInStock, tc.name)
+	}
+}
+
+func conformRefundRestock(t *testing.T, st Storer) {
+	ctx := context.Background()
+	u := seedUser(t, st, "test@example.com")
+
+	tcs := []struct {
+		name     string
+		statuses []OrderStatus
+		stock    int64
+	}{
+		{name: "before shipping", statuses: []OrderStatus{Paid, RefundRequested, Refunded}, stock: 10},
+		{name: "after delivery", statuses: []OrderStatus{Paid, Processing, Shipped, Delivered, RefundRequested, Refunded}, stock: 9},
+	}
+
+	for _, tc := range tcs {
+		p := seedProduct(t, st, tc.name)
+		o := seedOrder(t, st, u.ID, p)
+		for _, status := range tc.statuses {
+			_, err := st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: status, ActorID: u.ID, ActorRole: AdminActor})
+			require.NoError(t, err, tc.name)
+		}
+
+		gp, err := st.GetProduct(ctx, p.ID)
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.stock, gp.CountInStock, tc.name)
+	}
+}
+
+func conformConcurrentCheckouts(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p := seedProduct(t, st, "test product")
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int64
+	)
+	for i := 0; i < 25; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := st.CreateOrder(ctx, &Order{
+				PaymentMethod: "test payment method",
+				UserID:        u.ID,
+				Items:         []OrderItem{{Name: p.Name, Quantity: 1, Image: p.Image, ProductID: p.ID}},
+			})
+			if err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	gp, err := st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, p.CountInStock, created)
+	require.Zero(t, gp.CountInStock)
+}
+
+// conformConcurrentProductUpdates checks that editing a product doesn't write
+// back stock that orders placed meanwhile have taken.
+func conformConcurrentProductUpdates(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p := seedProduct(t, st, "test product")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := st.CreateOrder(ctx, &Order{
+				PaymentMethod: "test payment method",
+				UserID:        u.ID,
+				Items:         []OrderItem{{Name: p.Name, Quantity: 1, Image: p.Image, ProductID: p.ID}},
+			})
+			errs <- err
+		}()
+		go func(i int) {
+			defer wg.Done()
+			_, err := st.UpdateProduct(ctx, p.ID, func(p *Product) {
+				p.Name = fmt.Sprintf("test product %d", i)
+			})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	gp, err := st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, p.CountInStock-5, gp.CountInStock)
+}
+
+func conformListProducts(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	prices := []int64{500, 1500, 1000, 1500, 2500}
+	for i, price := range prices {
+		p := seedProduct(t, st, fmt.Sprintf("product %d", i))
+		_, err := st.UpdateProduct(ctx, p.ID, func(p *Product) {
+			p.Price = money.New(price, "USD")
+			if i == 4 {
+				p.Category = "other"
+				p.CountInStock = 0
+			}
+		})
+		require.NoError(t, err)
+	}
+
+	var (
+		names  []string
+		cursor string
+	)
+	for {
+		page, next, err := st.ListProducts(ctx, ProductFilter{Page: Page{Limit: 2, Cursor: cursor, Sort: "-price"}})
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), 2)
+		for _, p := range page {
+			names = append(names, p.Name)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	require.Equal(t, []string{"product 4", "product 3", "product 1", "product 2", "product 0"}, names)
+
+	minPrice, maxPrice := money.New(1000, "USD"), money.New(2000, "USD")
+	products, next, err := st.ListProducts(ctx, ProductFilter{MinPrice: &minPrice, MaxPrice: &maxPrice})
+	require.NoError(t, err)
+	require.Empty(t, next)
+	require.Len(t, products, 3)
+
+	products, _, err = st.ListProducts(ctx, ProductFilter{Category: "test category", InStock: true})
+	require.NoError(t, err)
+	require.Len(t, products, 4)
+
+	_, _, err = st.ListProducts(ctx, ProductFilter{Page: Page{Sort: "description"}})
+	require.True(t, errors.Is(err, ErrInvalidListParams))
+
+	_, _, err = st.ListProducts(ctx, ProductFilter{Page: Page{Cursor: "not a cursor"}})
+	require.True(t, errors.Is(err, ErrInvalidListParams))
+}
+
+func conformListOrders(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u1 := seedUser(t, st, "test1@example.com")
+	u2 := seedUser(t, st, "test2@example.com")
+	p := seedProduct(t, st, "test product")
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		ids = append(ids, seedOrder(t, st, u1.ID, p).ID)
+	}
+	o := seedOrder(t, st, u2.ID, p)
+	_, err := st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: Cancelled, ActorID: u2.ID, ActorRole: CustomerActor})
+	require.NoError(t, err)
+
+	orders, next, err := st.ListOrders(ctx, OrderFilter{Page: Page{Limit: 2}, UserID: u1.ID})
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	require.NotEmpty(t, next)
+	require.Equal(t, ids[0], orders[0].ID)
+	require.Len(t, orders[0].Items, 1)
+
+	orders, next, err = st.ListOrders(ctx, OrderFilter{Page: Page{Limit: 2, Cursor: next}, UserID: u1.ID})
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Empty(t, next)
+	require.Equal(t, ids[2], orders[0].ID)
+
+	history, next, err := st.ListOrdersForUser(ctx, u1.ID, Page{Sort: "-id"})
+	require.NoError(t, err)
+	require.Empty(t, next)
+	require.Len(t, history, 3)
+	require.Equal(t, ids[2], history[0].ID)
+	require.Len(t, history[0].Items, 1)
+
+	history, _, err = st.ListOrdersForUser(ctx, u2.ID, Page{})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, o.ID, history[0].ID)
+
+	orders, _, err = st.ListOrders(ctx, OrderFilter{Status: Cancelled})
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Equal(t, o.ID, orders[0].ID)
+
+	orders, _, err = st.ListOrders(ctx, OrderFilter{Page: Page{Sort: "-id"}, CreatedAfter: toTimePtr(time.Now().Add(-time.Hour))})
+	require.NoError(t, err)
+	require.Len(t, orders, 4)
+	require.Equal(t, o.ID, orders[0].ID)
+
+	orders, _, err = st.ListOrders(ctx, OrderFilter{CreatedBefore: toTimePtr(time.Now().Add(-time.Hour))})
+	require.NoError(t, err)
+	require.Empty(t, orders)
+}
+
+func conformCart(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p1 := seedProduct(t, st, "test product")
+	p2 := seedProduct(t, st, "test product 2")
+
+	c, err := st.GetCart(ctx, u.ID)
+	require.NoError(t, err)
+	require.Zero(t, c.ID)
+	require.Empty(t, c.Items)
+
+	_, err = st.AddToCart(ctx, u.ID, p1.ID, 1)
+	require.NoError(t, err)
+	_, err = st.AddToCart(ctx, u.ID, p2.ID, 1)
+	require.NoError(t, err)
+	c, err = st.AddToCart(ctx, u.ID, p1.ID, 2)
+	require.NoError(t, err)
+	require.NotZero(t, c.ID)
+	require.Len(t, c.Items, 2)
+	require.Equal(t, p1.ID, c.Items[0].ProductID)
+	require.Equal(t, int64(3), c.Items[0].Quantity)
+	require.Equal(t, p1.Name, c.Items[0].Name)
+	require.Equal(t, p1.Price, c.Items[0].Price)
+
+	c, err = st.UpdateCartItem(ctx, u.ID, p2.ID, 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), c.Items[1].Quantity)
+
+	c, err = st.RemoveCartItem(ctx, u.ID, p1.ID)
+	require.NoError(t, err)
+	require.Len(t, c.Items, 1)
+	require.Equal(t, p2.ID, c.Items[0].ProductID)
+
+	_, err = st.UpdateCartItem(ctx, u.ID, p1.ID, 1)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+	_, err = st.RemoveCartItem(ctx, u.ID, p1.ID)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+
+	_, err = st.AddToCart(ctx, u.ID, p2.ID+1000, 1)
+	require.Error(t, err)
+
+	// removing a product from the catalogue removes it from carts
+	require.NoError(t, st.DeleteProduct(ctx, p2.ID))
+	c, err = st.GetCart(ctx, u.ID)
+	require.NoError(t, err)
+	require.Empty(t, c.Items)
+}
+
+func conformCheckout(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p := seedProduct(t, st, "test product")
+
+	c, err := st.AddToCart(ctx, u.ID, p.ID, 2)
+	require.NoError(t, err)
+
+	order := func() *Order {
+		return &Order{
+			PaymentMethod: "test payment method",
+			TotalPrice:    money.New(19998, "USD"),
+			UserID:        u.ID,
+			Items:         []OrderItem{{Name: p.Name, Quantity: 2, Image: p.Image, Price: p.Price, ProductID: p.ID}},
+		}
+	}
+
+	// the cart changed after the order was priced
+	_, err = st.AddToCart(ctx, u.ID, p.ID, 1)
+	require.NoError(t, err)
+	_, err = st.Checkout(ctx, c, order())
+	require.True(t, errors.Is(err, ErrCartChanged))
+
+	c, err = st.UpdateCartItem(ctx, u.ID, p.ID, 2)
+	require.NoError(t, err)
+	o, err := st.Checkout(ctx, c, order())
+	require.NoError(t, err)
+	require.NotZero(t, o.ID)
+
+	go1, err := st.GetOrder(ctx, o.ID)
+	require.NoError(t, err)
+	require.Len(t, go1.Items, 1)
+	require.Equal(t, int64(2), go1.Items[0].Quantity)
+
+	gp, err := st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, p.CountInStock-2, gp.CountInStock)
+
+	c, err = st.GetCart(ctx, u.ID)
+	require.NoError(t, err)
+	require.Empty(t, c.Items)
+
+	// a failed order leaves the cart untouched
+	c, err = st.AddToCart(ctx, u.ID, p.ID, 100)
+	require.NoError(t, err)
+	o = order()
+	o.Items[0].Quantity = 100
+	_, err = st.Checkout(ctx, c, o)
+	var stockErr *InsufficientStockError
+	require.True(t, errors.As(err, &stockErr))
+
+	c, err = st.GetCart(ctx, u.ID)
+	require.NoError(t, err)
+	require.Len(t, c.Items, 1)
+}
+
+func conformReviews(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u1 := seedUser(t, st, "test@example.com")
+	u2 := seedUser(t, st, "test2@example.com")
+	p := seedProduct(t, st, "test product")
+
+	r1, err := st.CreateReview(ctx, &Review{UserID: u1.ID, ProductID: p.ID, Stars: 5, Title: "great", Body: "works as advertised"})
+	require.NoError(t, err)
+	require.NotZero(t, r1.ID)
+	require.Equal(t, "great", r1.Title)
+	require.False(t, r1.CreatedAt.IsZero())
+
+	r2, err := st.CreateReview(ctx, &Review{UserID: u2.ID, ProductID: p.ID, Stars: 2, Title: "meh", Body: "broke after a week"})
+	require.NoError(t, err)
+
+	gp, err := st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3.5, gp.Rating)
+	require.Equal(t, int64(2), gp.NumReviews)
+
+	_, err = st.CreateReview(ctx, &Review{UserID: u1.ID, ProductID: p.ID, Stars: 1, Title: "again", Body: "second review"})
+	require.True(t, errors.Is(err, ErrReviewExists))
+	_, err = st.CreateReview(ctx, &Review{UserID: u1.ID, ProductID: p.ID + 1000, Stars: 1, Title: "missing", Body: "no such product"})
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+
+	// updating a product does not overwrite its rating
+	_, err = st.UpdateProduct(ctx, p.ID, func(p *Product) { p.Name = "new test product" })
+	require.NoError(t, err)
+
+	r2.Stars = 3
+	r2.Body = "support replaced it"
+	r2.UpdatedAt = toTimePtr(time.Now())
+	ur, err := st.UpdateReview(ctx, r2)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), ur.Stars)
+	require.Equal(t, "support replaced it", ur.Body)
+
+	gp, err = st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 4.0, gp.Rating)
+	require.Equal(t, int64(2), gp.NumReviews)
+
+	reviews, next, err := st.ListReviews(ctx, p.ID, Page{Limit: 1, Sort: "-stars"})
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	require.Equal(t, r1.ID, reviews[0].ID)
+	reviews, next, err = st.ListReviews(ctx, p.ID, Page{Limit: 1, Cursor: next, Sort: "-stars"})
+	require.NoError(t, err)
+	require.Empty(t, next)
+	require.Len(t, reviews, 1)
+	require.Equal(t, r2.ID, reviews[0].ID)
+
+	// a user with reviews cannot be deleted
+	require.Error(t, st.DeleteUser(ctx, u2.ID))
+
+	require.NoError(t, st.DeleteReview(ctx, r1.ID))
+	_, err = st.GetReview(ctx, r1.ID)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+	require.True(t, errors.Is(st.DeleteReview(ctx, r1.ID), sql.ErrNoRows))
+
+	gp, err = st.GetProduct(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, 3.0, gp.Rating)
+	require.Equal(t, int64(1), gp.NumReviews)
+
+	// removing a product from the catalogue removes its reviews
+	require.NoError(t, st.DeleteProduct(ctx, p.ID))
+	_, err = st.GetReview(ctx, r2.ID)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+}
+
+func conformHasPurchased(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p1 := seedProduct(t, st, "test product")
+	p2 := seedProduct(t, st, "test product 2")
+	o := seedOrder(t, st, u.ID, p1)
+
+	// an unpaid order is not a purchase
+	purchased, err := st.HasPurchased(ctx, u.ID, p1.ID)
+	require.NoError(t, err)
+	require.False(t, purchased)
+
+	_, err = st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: Paid, ActorID: u.ID, ActorRole: AdminActor})
+	require.NoError(t, err)
+
+	purchased, err = st.HasPurchased(ctx, u.ID, p1.ID)
+	require.NoError(t, err)
+	require.True(t, purchased)
+
+	purchased, err = st.HasPurchased(ctx, u.ID, p2.ID)
+	require.NoError(t, err)
+	require.False(t, purchased)
+}
+
+func conformUsers(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	require.NotZero(t, u.ID)
+
+	_, err := st.CreateUser(ctx, &User{Name: "dup", Email: u.Email, Password: "password"})
+	require.Error(t, err)
+
+	gu, err := st.GetUser(ctx, u.Email)
+	require.NoError(t, err)
+	require.Equal(t, u.ID, gu.ID)
+
+	bu, err := st.GetUserByID(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, u.Email, bu.Email)
+
+	gu.Name = "new test user"
+	gu.IsAdmin = true
+	gu.Locale = "fr-CA"
+	gu.UpdatedAt = toTimePtr(time.Now())
+	_, err = st.UpdateUser(ctx, gu)
+	require.NoError(t, err)
+
+	users, _, err := st.ListUsers(ctx, UserFilter{})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, "new test user", users[0].Name)
+	require.True(t, users[0].IsAdmin)
+	require.Equal(t, "fr-CA", users[0].Locale)
+
+	require.NoError(t, st.DeleteUser(ctx, u.ID))
+	_, err = st.GetUser(ctx, u.Email)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+}
+
+func conformSessions(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	s, err := st.CreateSession(ctx, &Session{
+		ID:           "test-session",
+		UserEmail:    "test@example.com",
+		RefreshToken: "refresh-token",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	_, err = st.CreateSession(ctx, s)
+	require.Error(t, err)
+
+	require.NoError(t, st.RevokeSession(ctx, s.ID))
+	gs, err := st.GetSession(ctx, s.ID)
+	require.NoError(t, err)
+	require.True(t, gs.IsRevoked)
+	require.Equal(t, s.RefreshToken, gs.RefreshToken)
+
+	require.NoError(t, st.DeleteSession(ctx, s.ID))
+	_, err = st.GetSession(ctx, s.ID)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+}
+
+func conformSessionRotation(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	login, err := st.CreateSession(ctx, &Session{
+		ID:           "login",
+		UserEmail:    "test@example.com",
+		RefreshToken: "refresh-token-0",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.Equal(t, login.ID, login.FamilyID)
+
+	first, err := st.RotateSession(ctx, login.ID, &Session{ID: "first", RefreshToken: "refresh-token-1", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, login.ID, first.ParentID)
+	require.Equal(t, login.ID, first.FamilyID)
+	require.Equal(t, login.UserEmail, first.UserEmail)
+
+	gs, err := st.GetSession(ctx, login.ID)
+	require.NoError(t, err)
+	require.NotNil(t, gs.RotatedAt)
+	require.False(t, gs.IsRevoked)
+
+	second, err := st.RotateSession(ctx, first.ID, &Session{ID: "second", RefreshToken: "refresh-token-2", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, first.ID, second.ParentID)
+
+	// another family is left alone
+	other, err := st.CreateSession(ctx, &Session{ID: "other", UserEmail: "test@example.com", RefreshToken: "refresh-token-3", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	// presenting a rotated token revokes the family, including its latest
+	// session
+	_, err = st.RotateSession(ctx, first.ID, &Session{ID: "stolen", RefreshToken: "refresh-token-4", ExpiresAt: time.Now().Add(time.Hour)})
+	require.ErrorIs(t, err, ErrRefreshTokenReused)
+	for _, id := range []string{login.ID, first.ID, second.ID} {
+		gs, err := st.GetSession(ctx, id)
+		require.NoError(t, err)
+		require.True(t, gs.IsRevoked)
+	}
+	_, err = st.GetSession(ctx, "stolen")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = st.RotateSession(ctx, second.ID, &Session{ID: "third", RefreshToken: "refresh-token-5", ExpiresAt: time.Now().Add(time.Hour)})
+	require.ErrorIs(t, err, ErrSessionRevoked)
+
+	gs, err = st.GetSession(ctx, other.ID)
+	require.NoError(t, err)
+	require.False(t, gs.IsRevoked)
+
+	_, err = st.RotateSession(ctx, "unknown", &Session{ID: "fourth"})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func conformSessionsForUser(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	newSession := func(id, email string, expiresAt time.Time) *Session {
+		s, err := st.CreateSession(ctx, &Session{ID: id, UserEmail: email, RefreshToken: "refresh-" + id, UserAgent: "agent-" + id, ClientIP: "192.0.2.1", ExpiresAt: expiresAt})
+		require.NoError(t, err)
+		return s
+	}
+
+	laptop := newSession("laptop", "test@example.com", time.Now().Add(time.Hour))
+	phone := newSession("phone", "test@example.com", time.Now().Add(time.Hour))
+	newSession("expired", "test@example.com", time.Now().Add(-time.Hour))
+	newSession("other", "other@example.com", time.Now().Add(time.Hour))
+	revoked := newSession("revoked", "test@example.com", time.Now().Add(time.Hour))
+	require.NoError(t, st.RevokeSession(ctx, revoked.ID))
+
+	// a rotated device is listed by its latest session
+	renewed, err := st.RotateSession(ctx, laptop.ID, &Session{ID: "laptop-renewed", RefreshToken: "refresh-laptop-renewed", UserAgent: "agent-laptop", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	sessions, err := st.ListSessionsForUser(ctx, "test@example.com")
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	require.Equal(t, renewed.ID, sessions[0].ID)
+	require.Equal(t, "agent-laptop", sessions[0].UserAgent)
+	require.Equal(t, phone.ID, sessions[1].ID)
+	require.Equal(t, "192.0.2.1", sessions[1].ClientIP)
+
+	require.NoError(t, st.RevokeAllSessions(ctx, "test@example.com"))
+	sessions, err = st.ListSessionsForUser(ctx, "test@example.com")
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	sessions, err = st.ListSessionsForUser(ctx, "other@example.com")
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+}
+
+func conformPasswordReset(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	other := seedUser(t, st, "other@example.com")
+	for _, email := range []string{u.Email, other.Email} {
+		_, err := st.CreateSession(ctx, &Session{ID: "session-" + email, UserEmail: email, RefreshToken: "refresh", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+	}
+
+	newToken := func(userID int64, hash string, expiresAt time.Time) error {
+		ne, err := NewPasswordResetNotification(u.Email, PasswordReset{Token: "token-" + hash, ExpiresAt: expiresAt})
+		require.NoError(t, err)
+		_, err = st.CreatePasswordResetToken(ctx, &PasswordResetToken{UserID: userID, TokenHash: hash, ExpiresAt: expiresAt}, ne)
+		return err
+	}
+	require.NoError(t, newToken(u.ID, "first", time.Now().Add(time.Hour)))
+	require.NoError(t, newToken(u.ID, "second", time.Now().Add(time.Hour)))
+	require.NoError(t, newToken(u.ID, "expired", time.Now().Add(-time.Minute)))
+	require.NoError(t, newToken(other.ID, "other", time.Now().Add(time.Hour)))
+	require.Error(t, newToken(u.ID, "first", time.Now().Add(time.Hour)))
+	require.Error(t, newToken(-1, "unknown user", time.Now().Add(time.Hour)))
+
+	// the email goes out like any other notification, without an order
+	events, err := st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	require.Equal(t, PasswordResetNotification, events[0].NotificationType)
+	require.Zero(t, events[0].OrderID)
+	require.Equal(t, u.Email, events[0].UserEmail)
+	reset, err := events[0].PasswordReset()
+	require.NoError(t, err)
+	require.Equal(t, "token-first", reset.Token)
+
+	for _, hash := range []string{"unknown", "expired"} {
+		_, err = st.ResetPassword(ctx, hash, "new password")
+		require.ErrorIs(t, err, ErrPasswordResetTokenInvalid, hash)
+	}
+
+	ru, err := st.ResetPassword(ctx, "first", "new password")
+	require.NoError(t, err)
+	require.Equal(t, u.ID, ru.ID)
+	require.Equal(t, "new password", ru.Password)
+
+	got, err := st.GetUser(ctx, u.Email)
+	require.NoError(t, err)
+	require.Equal(t, "new password", got.Password)
+	sess, err := st.GetSession(ctx, "session-"+u.Email)
+	require.NoError(t, err)
+	require.True(t, sess.IsRevoked)
+	sess, err = st.GetSession(ctx, "session-"+other.Email)
+	require.NoError(t, err)
+	require.False(t, sess.IsRevoked)
+
+	// the token is single use and the user's other tokens are used up with it
+	for _, hash := range []string{"first", "second"} {
+		_, err = st.ResetPassword(ctx, hash, "another password")
+		require.ErrorIs(t, err, ErrPasswordResetTokenInvalid, hash)
+	}
+	_, err = st.ResetPassword(ctx, "other", "new password")
+	require.NoError(t, err)
+
+	// a failed reset email can't be replayed, its token is gone
+	claimed, err := st.ClaimNotificationEvents(ctx, "worker-1", 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	_, err = st.UpdateNotificationEvent(ctx, claimed[0], &NotificationState{Message: "mailbox full"}, NotificationFailure)
+	require.NoError(t, err)
+	_, err = st.ReplayNotifications(ctx, []int64{claimed[0].StateID}, u.ID)
+	require.ErrorIs(t, err, ErrNotReplayable)
+}
+
+func conformOutbox(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+
+	_, err := st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: Paid, ActorID: u.ID, ActorRole: AdminActor})
+	require.NoError(t, err)
+	_, err = st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: Pending, ActorID: u.ID, ActorRole: AdminActor})
+	var transitionErr *InvalidTransitionError
+	require.ErrorAs(t, err, &transitionErr)
+
+	events, err := st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	require.Equal(t, OrderCreated, events[0].EventType)
+	require.Equal(t, o.ID, events[0].AggregateID)
+	created, err := events[0].OrderEvent()
+	require.NoError(t, err)
+	require.Equal(t, &OrderEvent{OrderID: o.ID, UserID: u.ID, Status: Pending}, created)
+
+	require.Equal(t, OrderStatusChanged, events[1].EventType)
+	changed, err := events[1].OrderEvent()
+	require.NoError(t, err)
+	require.Equal(t, &OrderEvent{OrderID: o.ID, UserID: u.ID, FromStatus: Pending, Status: Paid}, changed)
+
+	limited, err := st.ListOutboxEvents(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, limited, 1)
+	require.Equal(t, events[0].ID, limited[0].ID)
+
+	err = st.MarkOutboxEventPublished(ctx, events[0].ID)
+	require.NoError(t, err)
+	err = st.MarkOutboxEventPublished(ctx, events[1].ID+100)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	pending, err := st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, events[1].ID, pending[0].ID)
+
+	// a notification is enqueued at most once per outbox event
+	ev, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{UserEmail: u.Email, OrderStatus: Paid, OrderID: OptionalID(o.ID), OutboxEventID: events[1].ID})
+	require.NoError(t, err)
+	_, err = st.EnqueueNotificationEvent(ctx, &NotificationEvent{UserEmail: u.Email, OrderStatus: Paid, OrderID: OptionalID(o.ID), OutboxEventID: events[1].ID})
+	require.ErrorIs(t, err, ErrAlreadyEnqueued)
+
+	ns, err := st.GetNotificationState(ctx, ev.StateID)
+	require.NoError(t, err)
+	require.NotNil(t, ns.OutboxEventID)
+	require.Equal(t, events[1].ID, *ns.OutboxEventID)
+
+	states, _, err := st.ListNotificationStates(ctx, NotificationStateFilter{})
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+}
+
+func conformOutboxFailures(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+	_, err := st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: Paid, ActorID: u.ID, ActorRole: AdminActor})
+	require.NoError(t, err)
+
+	events, err := st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	ev, err := st.FailOutboxEvent(ctx, events[0].ID, "consumer failed", 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), ev.Attempts)
+	require.Equal(t, "consumer failed", ev.LastError)
+	require.Nil(t, ev.ParkedAt)
+
+	pending, err := st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+
+	// a parked event is no longer listed
+	ev, err = st.FailOutboxEvent(ctx, events[0].ID, "consumer failed again", 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), ev.Attempts)
+	require.Equal(t, "consumer failed again", ev.LastError)
+	require.NotNil(t, ev.ParkedAt)
+
+	pending, err = st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, events[1].ID, pending[0].ID)
+
+	_, err = st.FailOutboxEvent(ctx, events[1].ID+100, "consumer failed", 2)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// deleting an order drops its pending events
+	require.NoError(t, st.MarkOutboxEventPublished(ctx, events[1].ID))
+	o2 := seedOrder(t, st, u.ID, seedProduct(t, st, "other product"))
+	pending, err = st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, o2.ID, pending[0].AggregateID)
+
+	require.NoError(t, st.DeleteOrder(ctx, o2.ID))
+	pending, err = st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func conformNotificationSuccess(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+
+	ev, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{
+		UserEmail:   u.Email,
+		OrderStatus: Pending,
+		OrderID:     OptionalID(o.ID),
+	})
+	require.NoError(t, err)
+	require.NotZero(t, ev.ID)
+	require.NotZero(t, ev.StateID)
+
+	events, err := st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, ev.ID, events[0].ID)
+	require.Equal(t, Pending, events[0].OrderStatus)
+	require.Equal(t, "worker-1", events[0].LeasedBy)
+	require.NotNil(t, events[0].LeasedUntil)
+
+	succeeded, err := st.UpdateNotificationEvent(ctx, events[0], &NotificationState{Message: "sent"}, NotificationSucess)
+	require.NoError(t, err)
+	require.True(t, succeeded)
+
+	events, err = st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	_, err = st.UpdateNotificationEvent(ctx, ev, &NotificationState{}, NotificationResponseType("unknown"))
+	require.Error(t, err)
+}
+
+func conformNotificationBatchUpdate(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	p := seedProduct(t, st, "test product")
+	var enqueued []*NotificationEvent
+	for _, status := range []OrderStatus{Paid, Shipped, Delivered} {
+		ev, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{
+			UserEmail:   u.Email,
+			OrderStatus: status,
+			OrderID:     OptionalID(seedOrder(t, st, u.ID, p).ID),
+		})
+		require.NoError(t, err)
+		enqueued = append(enqueued, ev)
+	}
+
+	events, err := st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	// an update that can't be made leaves the others undone
+	stolen := *events[2]
+	stolen.LeasedBy = "worker-2"
+	_, err = st.UpdateNotificationEvents(ctx, []*NotificationUpdate{
+		{Event: events[0], State: &NotificationState{Message: "sent"}, ResponseType: NotificationSucess},
+		{Event: &stolen, State: &NotificationState{Message: "sent"}, ResponseType: NotificationSucess},
+	})
+	require.ErrorIs(t, err, ErrNotLeaseHolder)
+
+	_, err = st.UpdateNotificationEvents(ctx, []*NotificationUpdate{
+		{Event: events[0], State: &NotificationState{Message: "sent"}, ResponseType: NotificationSucess},
+		{Event: events[0], State: &NotificationState{Message: "sent"}, ResponseType: NotificationSucess},
+	})
+	require.Error(t, err)
+
+	for _, ev := range enqueued {
+		ns, err := st.GetNotificationState(ctx, ev.StateID)
+		require.NoError(t, err)
+		require.Equal(t, NotSent, ns.State)
+	}
+	attempts, err := st.ListNotificationAttempts(ctx, enqueued[0].StateID)
+	require.NoError(t, err)
+	require.Empty(t, attempts)
+
+	succeeded, err := st.UpdateNotificationEvents(ctx, []*NotificationUpdate{
+		{Event: events[0], State: &NotificationState{Message: "sent in digest"}, ResponseType: NotificationSucess},
+		{Event: events[1], State: &NotificationState{Message: "sent in digest"}, ResponseType: NotificationSucess},
+		{Event: events[2], State: &NotificationState{Message: "suppressed"}, ResponseType: NotificationSuppressed},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, true, false}, succeeded)
+
+	pending, err := st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	for i, state := range []NotificationEventState{Sent, Sent, Suppressed} {
+		ns, err := st.GetNotificationState(ctx, enqueued[i].StateID)
+		require.NoError(t, err)
+		require.Equal(t, state, ns.State)
+	}
+}
+
+func conformNotificationRetries(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+
+	ev, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{
+		UserEmail:   u.Email,
+		OrderStatus: Pending,
+		OrderID:     OptionalID(o.ID),
+	})
+	require.NoError(t, err)
+
+	for i := int64(1); i < immediateRetries.MaxAttempts; i++ {
+		events, err := st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, i-1, events[0].Attempts)
+
+		succeeded, err := st.UpdateNotificationEvent(ctx, events[0], &NotificationState{Message: "failed"}, NotificationFailure)
+		require.NoError(t, err)
+		require.False(t, succeeded)
+	}
+
+	events, err := st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	succeeded, err := st.UpdateNotificationEvent(ctx, events[0], &NotificationState{Message: "failed"}, NotificationFailure)
+	require.NoError(t, err)
+	require.False(t, succeeded)
+
+	events, err = st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	ev.LeasedBy = "worker-1"
+	_, err = st.UpdateNotificationEvent(ctx, ev, &NotificationState{Message: "failed"}, NotificationFailure)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func conformNotificationLeases(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+
+	for i := 0; i < 3; i++ {
+		_, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{
+			UserEmail:   u.Email,
+			OrderStatus: Pending,
+			OrderID:     OptionalID(o.ID),
+		})
+		require.NoError(t, err)
+	}
+
+	// leased events are not handed out again
+	first, err := st.ClaimNotificationEvents(ctx, "worker-1", 2, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+
+	second, err := st.ClaimNotificationEvents(ctx, "worker-2", 2, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+	require.NotContains(t, []int64{first[0].ID, first[1].ID}, second[0].ID)
+
+	none, err := st.ClaimNotificationEvents(ctx, "worker-3", 2, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, none)
+
+	// nor listed for callers that ack without claiming
+	listed, err := st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Empty(t, listed)
+
+	// only the lease holder may ack or nack
+	stolen := *first[0]
+	stolen.LeasedBy = "worker-2"
+	_, err = st.UpdateNotificationEvent(ctx, &stolen, &NotificationState{Message: "sent"}, NotificationSucess)
+	require.ErrorIs(t, err, ErrNotLeaseHolder)
+
+	stolen.LeasedBy = ""
+	_, err = st.UpdateNotificationEvent(ctx, &stolen, &NotificationState{Message: "failed"}, NotificationFailure)
+	require.ErrorIs(t, err, ErrNotLeaseHolder)
+
+	succeeded, err := st.UpdateNotificationEvent(ctx, first[0], &NotificationState{Message: "sent"}, NotificationSucess)
+	require.NoError(t, err)
+	require.True(t, succeeded)
+
+	// a nack releases the lease
+	_, err = st.UpdateNotificationEvent(ctx, first[1], &NotificationState{Message: "failed"}, NotificationFailure)
+	require.NoError(t, err)
+
+	retried, err := st.ClaimNotificationEvents(ctx, "worker-3", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, retried, 1)
+	require.Equal(t, first[1].ID, retried[0].ID)
+	require.Equal(t, int64(1), retried[0].Attempts)
+
+	// an expired lease returns the event to the queue and the previous holder
+	// can no longer ack it
+	expired, err := st.ClaimNotificationEvents(ctx, "worker-4", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, expired, 0)
+
+	_, err = st.UpdateNotificationEvent(ctx, second[0], &NotificationState{Message: "failed"}, NotificationFailure)
+	require.NoError(t, err)
+
+	expired, err = st.ClaimNotificationEvents(ctx, "worker-4", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+
+	listed, err = st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, expired[0].ID, listed[0].ID)
+
+	reclaimed, err := st.ClaimNotificationEvents(ctx, "worker-5", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, reclaimed, 1)
+	require.Equal(t, expired[0].ID, reclaimed[0].ID)
+
+	_, err = st.UpdateNotificationEvent(ctx, expired[0], &NotificationState{Message: "sent"}, NotificationSucess)
+	require.ErrorIs(t, err, ErrNotLeaseHolder)
+
+	succeeded, err = st.UpdateNotificationEvent(ctx, reclaimed[0], &NotificationState{Message: "sent"}, NotificationSucess)
+	require.NoError(t, err)
+	require.True(t, succeeded)
+}
+
+// conformNotificationStaleState checks that acks update the state of the
+// queued event rather than whichever state the worker names.
+func conformNotificationStaleState(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+
+	for i := 0; i < 3; i++ {
+		_, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{
+			UserEmail:   u.Email,
+			OrderStatus: Pending,
+			OrderID:     OptionalID(o.ID),
+		})
+		require.NoError(t, err)
+	}
+
+	events, err := st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	other := events[2].StateID
+
+	updates := []struct {
+		responseType NotificationResponseType
+		state        NotificationEventState
+	}{
+		{NotificationSucess, Sent},
+		{NotificationFailure, Failed},
+	}
+	for i, up := range updates {
+		stale := *events[i]
+		stale.StateID = other
+		_, err = st.UpdateNotificationEvent(ctx, &stale, &NotificationState{Message: "done"}, up.responseType)
+		require.NoError(t, err)
+
+		ns, err := st.GetNotificationState(ctx, events[i].StateID)
+		require.NoError(t, err)
+		require.Equal(t, up.state, ns.State)
+	}
+
+	stale := *events[2]
+	stale.StateID = events[0].StateID
+	_, err = st.UpdateNotificationEvent(ctx, &stale, &NotificationState{Message: "suppressed"}, NotificationSuppressed)
+	require.NoError(t, err)
+
+	for i, state := range []NotificationEventState{Sent, Failed, Suppressed} {
+		ns, err := st.GetNotificationState(ctx, events[i].StateID)
+		require.NoError(t, err)
+		require.Equal(t, state, ns.State)
+	}
+}
+
+func toTimePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func conformNotificationBackoff(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+
+	ev, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{
+		UserEmail:   u.Email,
+		OrderStatus: Pending,
+		OrderID:     OptionalID(o.ID),
+	})
+	require.NoError(t, err)
+
+	events, err := st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	failedAt := time.Now()
+	_, err = st.UpdateNotificationEvent(ctx, events[0], &NotificationState{Message: "connection refused"}, NotificationFailure)
+	require.NoError(t, err)
+
+	// the event is not due again until the backoff has passed
+	events, err = st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	events, err = st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	attempts, err := st.ListNotificationAttempts(ctx, ev.StateID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	require.Equal(t, int64(1), attempts[0].Attempt)
+	require.False(t, attempts[0].Succeeded)
+	require.Equal(t, "connection refused", attempts[0].Message)
+	require.WithinDuration(t, failedAt, attempts[0].AttemptedAt, 2*time.Second)
+
+	empty, err := st.ListNotificationAttempts(ctx, ev.StateID+1)
+	require.NoError(t, err)
+	require.Empty(t, empty)
+}
+
+func conformNotificationReplay(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o1 := seedOrder(t, st, u.ID, seedProduct(t, st, "first product"))
+	o2 := seedOrder(t, st, u.ID, seedProduct(t, st, "second product"))
+
+	for _, o := range []*Order{o1, o1, o2} {
+		_, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{
+			UserEmail:   u.Email,
+			OrderStatus: Shipped,
+			OrderID:     OptionalID(o.ID),
+		})
+		require.NoError(t, err)
+	}
+
+	events, err := st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	_, err = st.UpdateNotificationEvent(ctx, events[0], &NotificationState{Message: "sent"}, NotificationSucess)
+	require.NoError(t, err)
+	for _, ev := range events[1:] {
+		_, err = st.UpdateNotificationEvent(ctx, ev, &NotificationState{Message: "mailbox full"}, NotificationFailure)
+		require.NoError(t, err)
+	}
+	sentID, failedIDs := events[0].StateID, []int64{events[1].StateID, events[2].StateID}
+
+	failed, next, err := st.ListNotificationStates(ctx, NotificationStateFilter{State: Failed})
+	require.NoError(t, err)
+	require.Empty(t, next)
+	require.Len(t, failed, 2)
+	require.Equal(t, failedIDs, []int64{failed[0].ID, failed[1].ID})
+	require.Equal(t, "mailbox full", failed[0].Message)
+	require.Equal(t, u.Email, failed[0].UserEmail)
+	require.Equal(t, Shipped, failed[0].OrderStatus)
+
+	sent, _, err := st.ListNotificationStates(ctx, NotificationStateFilter{State: Sent})
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	require.Equal(t, sentID, sent[0].ID)
+	require.NotNil(t, sent[0].CompletedAt)
+
+	byOrder, next, err := st.ListNotificationStates(ctx, NotificationStateFilter{OrderID: o1.ID, Page: Page{Limit: 1, Sort: "-id"}})
+	require.NoError(t, err)
+	require.Len(t, byOrder, 1)
+	require.Equal(t, failedIDs[0], byOrder[0].ID)
+	byOrder, next, err = st.ListNotificationStates(ctx, NotificationStateFilter{OrderID: o1.ID, Page: Page{Limit: 1, Sort: "-id", Cursor: next}})
+	require.NoError(t, err)
+	require.Empty(t, next)
+	require.Equal(t, sentID, byOrder[0].ID)
+
+	future := time.Now().Add(time.Hour)
+	none, _, err := st.ListNotificationStates(ctx, NotificationStateFilter{RequestedAfter: &future})
+	require.NoError(t, err)
+	require.Empty(t, none)
+
+	// replays are all or nothing
+	_, err = st.ReplayNotifications(ctx, []int64{failedIDs[0], sentID}, u.ID)
+	require.ErrorIs(t, err, ErrNotReplayable)
+	_, err = st.ReplayNotifications(ctx, []int64{failedIDs[0], failedIDs[1] + 100}, u.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	ns, err := st.GetNotificationState(ctx, failedIDs[0])
+	require.NoError(t, err)
+	require.Equal(t, Failed, ns.State)
+
+	replayed, err := st.ReplayNotifications(ctx, failedIDs, u.ID)
+	require.NoError(t, err)
+	require.Len(t, replayed, 2)
+	for i, ev := range replayed {
+		require.Equal(t, failedIDs[i], ev.StateID)
+		require.Equal(t, u.Email, ev.UserEmail)
+		require.Equal(t, Shipped, ev.OrderStatus)
+		require.Zero(t, ev.Attempts)
+	}
+
+	ns, err = st.GetNotificationState(ctx, failedIDs[0])
+	require.NoError(t, err)
+	require.Equal(t, NotSent, ns.State)
+	require.Empty(t, ns.Message)
+
+	replays, err := st.ListNotificationReplays(ctx, failedIDs[0])
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	require.Equal(t, u.ID, replays[0].ActorID)
+	require.Equal(t, "mailbox full", replays[0].PreviousMessage)
+
+	_, err = st.ReplayNotifications(ctx, failedIDs, u.ID)
+	require.ErrorIs(t, err, ErrNotReplayable)
+
+	events, err = st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	_, err = st.GetNotificationState(ctx, failedIDs[1]+100)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func conformNotificationPreferences(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	other := seedUser(t, st, "other@example.com")
+
+	prefs, err := st.ListNotificationPreferences(ctx, u.ID)
+	require.NoError(t, err)
+	require.Empty(t, prefs)
+
+	err = st.SetNotificationPreferences(ctx, u.ID, []*NotificationPreference{
+		{Type: OrderNotification(Shipped), Channel: EmailChannel, Enabled: false},
+		{Type: OrderNotification(Delivered), Channel: EmailChannel, Enabled: false},
+	})
+	require.NoError(t, err)
+	err = st.SetNotificationPreferences(ctx, u.ID, []*NotificationPreference{
+		{Type: OrderNotification(Delivered), Channel: EmailChannel, Enabled: true},
+	})
+	require.NoError(t, err)
+	err = st.SetNotificationPreferences(ctx, other.ID, []*NotificationPreference{
+		{Type: OrderNotification(Pending), Channel: EmailChannel, Enabled: false},
+	})
+	require.NoError(t, err)
+
+	prefs, err = st.ListNotificationPreferences(ctx, u.ID)
+	require.NoError(t, err)
+	require.Len(t, prefs, 2)
+	require.Equal(t, OrderNotification(Delivered), prefs[0].Type)
+	require.True(t, prefs[0].Enabled)
+	require.Equal(t, OrderNotification(Shipped), prefs[1].Type)
+	require.False(t, prefs[1].Enabled)
+	require.Equal(t, EmailChannel, prefs[1].Channel)
+
+	err = st.SetNotificationPreferences(ctx, other.ID+100, []*NotificationPreference{
+		{Type: OrderNotification(Pending), Channel: EmailChannel, Enabled: false},
+	})
+	require.Error(t, err)
+
+	// a suppressed notification is done with, without an attempt
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+	ev, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{UserEmail: u.Email, OrderStatus: Shipped, OrderID: OptionalID(o.ID)})
+	require.NoError(t, err)
+	events, err := st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	succeeded, err := st.UpdateNotificationEvent(ctx, events[0], &NotificationState{Message: "suppressed"}, NotificationSuppressed)
+	require.NoError(t, err)
+	require.False(t, succeeded)
+
+	ns, err := st.GetNotificationState(ctx, ev.StateID)
+	require.NoError(t, err)
+	require.Equal(t, Suppressed, ns.State)
+	require.NotNil(t, ns.CompletedAt)
+
+	attempts, err := st.ListNotificationAttempts(ctx, ev.StateID)
+	require.NoError(t, err)
+	require.Empty(t, attempts)
+
+	events, err = st.ListNotificationEvents(ctx)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	_, err = st.ReplayNotifications(ctx, []int64{ev.StateID}, u.ID)
+	require.ErrorIs(t, err, ErrNotReplayable)
+}
+
+// enqueueRecorder records the events passed to the WithOnEnqueue hook.
+type enqueueRecorder struct {
+	mu  sync.Mutex
+	ids []int64
+}
+
+func (r *enqueueRecorder) record(evs ...*NotificationEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ev := range evs {
+		r.ids = append(r.ids, ev.ID)
+	}
+}
+
+func (r *enqueueRecorder) recorded() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]int64(nil), r.ids...)
+}
+
+func (r *enqueueRecorder) conform(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+
+	ev, err := st.EnqueueNotificationEvent(ctx, &NotificationEvent{UserEmail: u.Email, OrderStatus: Pending, OrderID: OptionalID(o.ID), OutboxEventID: 1})
+	require.NoError(t, err)
+	require.Equal(t, []int64{ev.ID}, r.recorded())
+
+	// nothing is enqueued, so the hook is not called
+	_, err = st.EnqueueNotificationEvent(ctx, &NotificationEvent{UserEmail: u.Email, OrderStatus: Pending, OrderID: OptionalID(o.ID), OutboxEventID: 1})
+	require.ErrorIs(t, err, ErrAlreadyEnqueued)
+	require.Len(t, r.recorded(), 1)
+
+	events, err := st.ClaimNotificationEvents(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	_, err = st.UpdateNotificationEvent(ctx, events[0], &NotificationState{Message: "mailbox full"}, NotificationFailure)
+	require.NoError(t, err)
+
+	replayed, err := st.ReplayNotifications(ctx, []int64{ev.StateID}, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, []int64{ev.ID, replayed[0].ID}, r.recorded())
+}
+
+func conformWebhooks(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	w, err := st.CreateWebhook(ctx, &Webhook{URL: "https://partner.example.com/hooks", EventTypes: WebhookEventTypes{OrderCreated}, Secret: "secret", Active: true})
+	require.NoError(t, err)
+	require.NotZero(t, w.ID)
+
+	got, err := st.GetWebhook(ctx, w.ID)
+	require.NoError(t, err)
+	require.Equal(t, w.URL, got.URL)
+	require.Equal(t, WebhookEventTypes{OrderCreated}, got.EventTypes)
+	require.Equal(t, "secret", got.Secret)
+	require.True(t, got.Active)
+
+	now := time.Now()
+	got.EventTypes = WebhookEventTypes{OrderCreated, OrderStatusChanged}
+	got.Active = false
+	got.UpdatedAt = &now
+	_, err = st.UpdateWebhook(ctx, got)
+	require.NoError(t, err)
+
+	_, err = st.CreateWebhook(ctx, &Webhook{URL: "https://other.example.com/hooks", EventTypes: WebhookEventTypes{OrderStatusChanged}, Secret: "other", Active: true})
+	require.NoError(t, err)
+
+	webhooks, err := st.ListWebhooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 2)
+	require.Equal(t, w.ID, webhooks[0].ID)
+	require.Equal(t, WebhookEventTypes{OrderCreated, OrderStatusChanged}, webhooks[0].EventTypes)
+	require.False(t, webhooks[0].Active)
+	require.NotNil(t, webhooks[0].UpdatedAt)
+
+	err = st.DeleteWebhook(ctx, w.ID)
+	require.NoError(t, err)
+	_, err = st.GetWebhook(ctx, w.ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func conformWebhookDeliveries(t *testing.T, st Storer) {
+	ctx := context.Background()
+
+	u := seedUser(t, st, "test@example.com")
+	o := seedOrder(t, st, u.ID, seedProduct(t, st, "test product"))
+	_, err := st.UpdateOrderStatus(ctx, &OrderStatusChange{OrderID: o.ID, ToStatus: Paid, ActorID: u.ID, ActorRole: AdminActor})
+	require.NoError(t, err)
+	events, err := st.ListOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	all, err := st.CreateWebhook(ctx, &Webhook{URL: "https://all.example.com", EventTypes: WebhookEventTypes{OrderCreated, OrderStatusChanged}, Secret: "all", Active: true})
+	require.NoError(t, err)
+	changes, err := st.CreateWebhook(ctx, &Webhook{URL: "https://changes.example.com", EventTypes: WebhookEventTypes{OrderStatusChanged}, Secret: "changes", Active: true})
+	require.NoError(t, err)
+	_, err = st.CreateWebhook(ctx, &Webhook{URL: "https://inactive.example.com", EventTypes: WebhookEventTypes{OrderCreated, OrderStatusChanged}, Secret: "inactive"})
+	require.NoError(t, err)
+
+	// events go to the active webhooks subscribed to their type, once
+	created, err := st.EnqueueWebhookDeliveries(ctx, events[0])
+	require.NoError(t, err)
+	require.Len(t, created, 1)
+	require.Equal(t, all.ID, created[0].WebhookID)
+	changed, err := st.EnqueueWebhookDeliveries(ctx, events[1])
+	require.NoError(t, err)
+	require.Len(t, changed, 2)
+	again, err := st.EnqueueWebhookDeliveries(ctx, events[1])
+	require.NoError(t, err)
+	require.Empty(t, again)
+
+	claimed, err := st.ClaimWebhookDeliveries(ctx, "worker-1", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 3)
+	require.Equal(t, created[0].ID, claimed[0].ID)
+	require.Equal(t, OrderCreated, claimed[0].EventType)
+	require.JSONEq(t, string(events[0].Payload), string(claimed[0].Payload))
+	require.Equal(t, "worker-1", claimed[0].LeasedBy)
+
+	leased, err := st.ClaimWebhookDeliveries(ctx, "worker-2", 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, leased)
+
+	// only the lease holder records attempts
+	_, err = st.RecordWebhookDeliveryAttempt(ctx, &WebhookDelivery{ID: claimed[0].ID, LeasedBy: "worker-2"}, &WebhookDeliveryAttempt{Succeeded: true})
+	require.ErrorIs(t, err, ErrNotLeaseHolder)
+
+	d, err := st.RecordWebhookDeliveryAttempt(ctx, claimed[0], &WebhookDeliveryAttempt{StatusCode: 200, Succeeded: true, Message: "200 OK"})
+	require.NoError(t, err)
+	require.Equal(t, DeliverySucceeded, d.State)
+	require.NotNil(t, d.CompletedAt)
+
+	// a failed delivery is retried until the retry policy gives up
+	d, err = st.RecordWebhookDeliveryAttempt(ctx, claimed[1], &WebhookDeliveryAttempt{StatusCode: 500, Message: "500 Internal Server Error"})
+	require.NoError(t, err)
+	require.Equal(t, DeliveryPending, d.State)
+	require.Equal(t, int64(1), d.Attempts)
+
+	retried, err := st.ClaimWebhookDeliveries(ctx, "worker-2", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, retried, 1)
+	require.Equal(t, claimed[1].ID, retried[0].ID)
+
+	d, err = st.RecordWebhookDeliveryAttempt(ctx, retried[0], &WebhookDeliveryAttempt{Message: "connection refused"})
+	require.NoError(t, err)
+	require.Equal(t, DeliveryFailed, d.State)
+	require.Equal(t, int64(2), d.Attempts)
+	require.NotNil(t, d.CompletedAt)
+
+	_, err = st.RecordWebhookDeliveryAttempt(ctx, retried[0], &WebhookDeliveryAttempt{Succeeded: true})
+	require.ErrorIs(t, err, ErrNotLeaseHolder)
+
+	attempts, err := st.ListWebhookDeliveryAttempts(ctx, claimed[1].ID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	require.Equal(t, int64(1), attempts[0].Attempt)
+	require.Equal(t, 500, attempts[0].StatusCode)
+	require.False(t, attempts[0].Succeeded)
+	require.Equal(t, "connection refused", attempts[1].Message)
+
+	got, err := st.GetWebhookDelivery(ctx, claimed[1].ID)
+	require.NoError(t, err)
+	require.Equal(t, DeliveryFailed, got.State)
+	_, err = st.GetWebhookDelivery(ctx, claimed[2].ID+100)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	deliveries, next, err := st.ListWebhookDeliveries(ctx, all.ID, Page{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.NotEmpty(t, next)
+	deliveries, next, err = st.ListWebhookDeliveries(ctx, all.ID, Page{Limit: 1, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Empty(t, next)
+
+	// deliveries go with their webhook
+	err = st.DeleteWebhook(ctx, changes.ID)
+	require.NoError(t, err)
+	deliveries, _, err = st.ListWebhookDeliveries(ctx, changes.ID, Page{})
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+	_, err = st.GetWebhookDelivery(ctx, changed[1].ID)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
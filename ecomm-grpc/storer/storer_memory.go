@@ -0,0 +1,1757 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dhij/ecomm/money"
+)
+
+// MemoryStorer is a thread-safe, in-memory Storer. It mirrors the behaviour
+// of MySQLStorer, including missing rows surfacing as sql.ErrNoRows, foreign
+// key and unique constraints, and all-or-nothing multi-row writes.
+type MemoryStorer struct {
+	mu sync.Mutex
+
+	options
+
+	products             map[int64]Product
+	orders               map[int64]Order
+	orderItems           map[int64]OrderItem
+	users                map[int64]User
+	sessions             map[string]Session
+	passwordResets       map[int64]PasswordResetToken
+	notificationStates   map[int64]NotificationState
+	notificationEvents   map[int64]NotificationEvent
+	orderHistory         map[int64]OrderStatusChange
+	carts                map[int64]Cart
+	cartItems            map[int64]CartItem
+	reviews              map[int64]Review
+	notificationAttempts []NotificationAttempt
+	notificationReplays  []NotificationReplay
+	notificationPrefs    map[notificationPreferenceKey]NotificationPreference
+	outboxEvents         []OutboxEvent
+	webhooks             map[int64]Webhook
+	webhookDeliveries    map[int64]WebhookDelivery
+	webhookAttempts      []WebhookDeliveryAttempt
+
+	lastProductID             int64
+	lastOrderID               int64
+	lastOrderItemID           int64
+	lastUserID                int64
+	lastPasswordResetID       int64
+	lastNotificationStateID   int64
+	lastNotificationEventID   int64
+	lastOrderHistoryID        int64
+	lastCartID                int64
+	lastCartItemID            int64
+	lastReviewID              int64
+	lastNotificationAttemptID int64
+	lastNotificationReplayID  int64
+	lastOutboxEventID         int64
+	lastWebhookID             int64
+	lastWebhookDeliveryID     int64
+	lastWebhookAttemptID      int64
+}
+
+func NewMemoryStorer(opts ...Option) *MemoryStorer {
+	return &MemoryStorer{
+		options:            newOptions(opts),
+		products:           make(map[int64]Product),
+		orders:             make(map[int64]Order),
+		orderItems:         make(map[int64]OrderItem),
+		users:              make(map[int64]User),
+		sessions:           make(map[string]Session),
+		passwordResets:     make(map[int64]PasswordResetToken),
+		notificationStates: make(map[int64]NotificationState),
+		notificationEvents: make(map[int64]NotificationEvent),
+		orderHistory:       make(map[int64]OrderStatusChange),
+		carts:              make(map[int64]Cart),
+		cartItems:          make(map[int64]CartItem),
+		reviews:            make(map[int64]Review),
+		notificationPrefs:  make(map[notificationPreferenceKey]NotificationPreference),
+		webhooks:           make(map[int64]Webhook),
+		webhookDeliveries:  make(map[int64]WebhookDelivery),
+	}
+}
+
+func errForeignKey(table, ref string) error {
+	return fmt.Errorf("foreign key constraint fails: %s references %s", table, ref)
+}
+
+// storeMoney mirrors a round trip through a decimal column: amounts in other
+// currencies are rejected and amounts read back are in the default currency.
+func storeMoney(ms ...*money.Money) error {
+	for _, m := range ms {
+		if _, err := m.Value(); err != nil {
+			return err
+		}
+		m.Currency = money.DefaultCurrency
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorer) CreateProduct(ctx context.Context, p *Product) (*Product, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	np := *p
+	if err := storeMoney(&np.Price); err != nil {
+		return nil, fmt.Errorf("error inserting product: %w", err)
+	}
+
+	ms.lastProductID++
+	p.ID = ms.lastProductID
+	np.ID = p.ID
+	np.Rating = 0
+	np.NumReviews = 0
+	np.CreatedAt = time.Now()
+	np.UpdatedAt = nil
+	ms.products[np.ID] = np
+
+	return p, nil
+}
+
+func (ms *MemoryStorer) GetProduct(ctx context.Context, id int64) (*Product, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	p, ok := ms.products[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting product: %w", sql.ErrNoRows)
+	}
+
+	return &p, nil
+}
+
+func (ms *MemoryStorer) ListProducts(ctx context.Context, f ProductFilter) ([]*Product, string, error) {
+	pq, err := resolvePage(f.Page, productSortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	products := make([]*Product, 0, len(ms.products))
+	for _, p := range ms.products {
+		p := p
+		if f.Category != "" && p.Category != f.Category {
+			continue
+		}
+		if f.MinPrice != nil && p.Price.Amount < f.MinPrice.Amount {
+			continue
+		}
+		if f.MaxPrice != nil && p.Price.Amount > f.MaxPrice.Amount {
+			continue
+		}
+		if f.InStock && p.CountInStock <= 0 {
+			continue
+		}
+		products = append(products, &p)
+	}
+
+	products, next := pageOf(pq, products, productPosition(pq.key))
+	return products, next, nil
+}
+
+func (ms *MemoryStorer) UpdateProduct(ctx context.Context, id int64, patch func(*Product)) (*Product, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	existing, ok := ms.products[id]
+	if !ok {
+		return nil, fmt.Errorf("error updating product: %w", sql.ErrNoRows)
+	}
+
+	np := existing
+	patch(&np)
+	if err := storeMoney(&np.Price); err != nil {
+		return nil, fmt.Errorf("error updating product: %w", err)
+	}
+	np.ID = id
+	np.Rating = existing.Rating
+	np.NumReviews = existing.NumReviews
+	np.CreatedAt = existing.CreatedAt
+	ms.products[id] = np
+
+	return &np, nil
+}
+
+func (ms *MemoryStorer) DeleteProduct(ctx context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, oi := range ms.orderItems {
+		if oi.ProductID == id {
+			return fmt.Errorf("error deleting product: %w", errForeignKey("order_items", "products"))
+		}
+	}
+	// cart_items and reviews rows are removed by ON DELETE CASCADE
+	for ciID, ci := range ms.cartItems {
+		if ci.ProductID == id {
+			delete(ms.cartItems, ciID)
+		}
+	}
+	for rID, r := range ms.reviews {
+		if r.ProductID == id {
+			delete(ms.reviews, rID)
+		}
+	}
+	delete(ms.products, id)
+
+	return nil
+}
+
+func (ms *MemoryStorer) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	err := ms.insertOrder(o)
+	if err != nil {
+		return nil, fmt.Errorf("error creating order: %w", err)
+	}
+
+	return o, nil
+}
+
+func (ms *MemoryStorer) insertOrder(o *Order) error {
+	// validate every constraint up front so that a failure leaves no partial
+	// writes behind, the same as a rolled back transaction
+	if _, ok := ms.users[o.UserID]; !ok {
+		return errForeignKey("orders", "users")
+	}
+	no := *o
+	if err := storeMoney(&no.TaxPrice, &no.ShippingPrice, &no.TotalPrice); err != nil {
+		return err
+	}
+	items := make([]OrderItem, len(o.Items))
+	copy(items, o.Items)
+	for i := range items {
+		if err := storeMoney(&items[i].Price); err != nil {
+			return fmt.Errorf("error creating order item: %w", err)
+		}
+	}
+	ids, quantities := stockQuantities(o.Items)
+	var short []int64
+	for _, id := range ids {
+		if ms.products[id].CountInStock < quantities[id] {
+			short = append(short, id)
+		}
+	}
+	if len(short) > 0 {
+		return &InsufficientStockError{ProductIDs: short}
+	}
+	ev, err := newOrderEvent(OrderCreated, OrderEvent{OrderID: ms.lastOrderID + 1, UserID: o.UserID, Status: Pending})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		p := ms.products[id]
+		p.CountInStock -= quantities[id]
+		ms.products[id] = p
+	}
+
+	ms.lastOrderID++
+	o.ID = ms.lastOrderID
+
+	no.ID = o.ID
+	no.Status = Pending
+	no.CreatedAt = time.Now()
+	no.UpdatedAt = nil
+	no.Items = nil
+	ms.orders[no.ID] = no
+
+	for i := range items {
+		ms.lastOrderItemID++
+		items[i].ID = ms.lastOrderItemID
+		items[i].OrderID = o.ID
+		ms.orderItems[items[i].ID] = items[i]
+	}
+	ms.insertOutboxEvent(ev)
+
+	return nil
+}
+
+func (ms *MemoryStorer) orderItemsFor(orderID int64) []OrderItem {
+	var items []OrderItem
+	for _, oi := range ms.orderItems {
+		if oi.OrderID == orderID {
+			items = append(items, oi)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	return items
+}
+
+func (ms *MemoryStorer) GetOrder(ctx context.Context, id int64) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, ok := ms.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting order: %w", sql.ErrNoRows)
+	}
+	o.Items = ms.orderItemsFor(o.ID)
+
+	return &o, nil
+}
+
+func (ms *MemoryStorer) GetOrderStatusByID(ctx context.Context, id int64) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, ok := ms.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting order: %w", sql.ErrNoRows)
+	}
+
+	return &Order{
+		ID:     o.ID,
+		UserID: o.UserID,
+		Status: o.Status,
+	}, nil
+}
+
+func (ms *MemoryStorer) ListOrders(ctx context.Context, f OrderFilter) ([]*Order, string, error) {
+	pq, err := resolvePage(f.Page, orderSortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	orders := make([]*Order, 0, len(ms.orders))
+	for _, o := range ms.orders {
+		o := o
+		if f.Status != "" && o.Status != f.Status {
+			continue
+		}
+		if f.UserID != 0 && o.UserID != f.UserID {
+			continue
+		}
+		if f.CreatedAfter != nil && o.CreatedAt.Before(*f.CreatedAfter) {
+			continue
+		}
+		if f.CreatedBefore != nil && !o.CreatedAt.Before(*f.CreatedBefore) {
+			continue
+		}
+		orders = append(orders, &o)
+	}
+
+	orders, next := pageOf(pq, orders, orderPosition(pq.key))
+	for _, o := range orders {
+		o.Items = ms.orderItemsFor(o.ID)
+	}
+
+	return orders, next, nil
+}
+
+func (ms *MemoryStorer) ListOrdersForUser(ctx context.Context, userID int64, p Page) ([]*Order, string, error) {
+	return ms.ListOrders(ctx, OrderFilter{Page: p, UserID: userID})
+}
+
+func (ms *MemoryStorer) UpdateOrderStatus(ctx context.Context, c *OrderStatusChange) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, ok := ms.orders[c.OrderID]
+	if !ok {
+		return nil, fmt.Errorf("error updating order status: %w", sql.ErrNoRows)
+	}
+
+	err := checkTransition(&o, c)
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %w", err)
+	}
+	ev, err := newOrderEvent(OrderStatusChanged, OrderEvent{OrderID: o.ID, UserID: o.UserID, FromStatus: o.Status, Status: c.ToStatus})
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %w", err)
+	}
+
+	if restocksOnTransition(c.ToStatus, ms.orderShipped(o.ID)) {
+		ms.restockOrderItems(o.ID)
+	}
+
+	now := time.Now()
+	c.CreatedAt = now
+	o.Status = c.ToStatus
+	o.UpdatedAt = &now
+	ms.orders[o.ID] = o
+
+	ms.lastOrderHistoryID++
+	c.ID = ms.lastOrderHistoryID
+	ms.orderHistory[c.ID] = *c
+	ms.insertOutboxEvent(ev)
+
+	o.Items = ms.orderItemsFor(o.ID)
+	return &o, nil
+}
+
+func (ms *MemoryStorer) ListOrderStatusHistory(ctx context.Context, orderID int64) ([]*OrderStatusChange, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var changes []*OrderStatusChange
+	for _, c := range ms.orderHistory {
+		if c.OrderID == orderID {
+			c := c
+			changes = append(changes, &c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+
+	return changes, nil
+}
+
+func (ms *MemoryStorer) DeleteOrder(ctx context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, ns := range ms.notificationStates {
+		if int64(ns.OrderID) == id {
+			return fmt.Errorf("error deleting order: %w", errForeignKey("notification_states", "orders"))
+		}
+	}
+	for _, ne := range ms.notificationEvents {
+		if int64(ne.OrderID) == id {
+			return fmt.Errorf("error deleting order: %w", errForeignKey("notification_events_queue", "orders"))
+		}
+	}
+
+	if o, ok := ms.orders[id]; ok && restocksOnDelete(o.Status, ms.orderShipped(id)) {
+		ms.restockOrderItems(id)
+	}
+	for oiID, oi := range ms.orderItems {
+		if oi.OrderID == id {
+			delete(ms.orderItems, oiID)
+		}
+	}
+	// order_status_history rows are removed by ON DELETE CASCADE
+	for cID, c := range ms.orderHistory {
+		if c.OrderID == id {
+			delete(ms.orderHistory, cID)
+		}
+	}
+	delete(ms.orders, id)
+
+	events := ms.outboxEvents[:0]
+	for _, ev := range ms.outboxEvents {
+		if ev.AggregateType != orderAggregate || ev.AggregateID != id || ev.PublishedAt != nil {
+			events = append(events, ev)
+		}
+	}
+	ms.outboxEvents = events
+
+	return nil
+}
+
+// orderShipped reports whether the order ever moved to Shipped.
+func (ms *MemoryStorer) orderShipped(orderID int64) bool {
+	for _, c := range ms.orderHistory {
+		if c.OrderID == orderID && c.ToStatus == Shipped {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms *MemoryStorer) restockOrderItems(orderID int64) {
+	ids, quantities := stockQuantities(ms.orderItemsFor(orderID))
+	for _, id := range ids {
+		if p, ok := ms.products[id]; ok {
+			p.CountInStock += quantities[id]
+			ms.products[id] = p
+		}
+	}
+}
+
+func (ms *MemoryStorer) GetCart(ctx context.Context, userID int64) (*Cart, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.getCart(userID), nil
+}
+
+func (ms *MemoryStorer) AddToCart(ctx context.Context, userID, productID, quantity int64) (*Cart, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[userID]; !ok {
+		return nil, fmt.Errorf("error adding to cart: %w", errForeignKey("carts", "users"))
+	}
+	if _, ok := ms.products[productID]; !ok {
+		return nil, fmt.Errorf("error adding to cart: %w", errForeignKey("cart_items", "products"))
+	}
+
+	c := ms.touchCart(userID)
+	for id, ci := range ms.cartItems {
+		if ci.CartID == c.ID && ci.ProductID == productID {
+			ci.Quantity += quantity
+			ms.cartItems[id] = ci
+			return ms.getCart(userID), nil
+		}
+	}
+
+	ms.lastCartItemID++
+	ms.cartItems[ms.lastCartItemID] = CartItem{
+		ID:        ms.lastCartItemID,
+		CartID:    c.ID,
+		ProductID: productID,
+		Quantity:  quantity,
+		CreatedAt: time.Now(),
+	}
+
+	return ms.getCart(userID), nil
+}
+
+func (ms *MemoryStorer) UpdateCartItem(ctx context.Context, userID, productID, quantity int64) (*Cart, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ci, ok := ms.cartItemFor(userID, productID)
+	if !ok {
+		return nil, fmt.Errorf("error updating cart item: %w", sql.ErrNoRows)
+	}
+	ci.Quantity = quantity
+	ms.cartItems[ci.ID] = ci
+	ms.touchCart(userID)
+
+	return ms.getCart(userID), nil
+}
+
+func (ms *MemoryStorer) RemoveCartItem(ctx context.Context, userID, productID int64) (*Cart, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ci, ok := ms.cartItemFor(userID, productID)
+	if !ok {
+		return nil, fmt.Errorf("error removing cart item: %w", sql.ErrNoRows)
+	}
+	delete(ms.cartItems, ci.ID)
+	ms.touchCart(userID)
+
+	return ms.getCart(userID), nil
+}
+
+func (ms *MemoryStorer) Checkout(ctx context.Context, cart *Cart, o *Order) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	c, ok := ms.cartFor(cart.UserID)
+	if !ok || !sameCartItems(ms.getCart(cart.UserID).Items, cart.Items) {
+		return nil, fmt.Errorf("error checking out cart: %w", ErrCartChanged)
+	}
+
+	err := ms.insertOrder(o)
+	if err != nil {
+		return nil, fmt.Errorf("error checking out cart: %w", err)
+	}
+	ms.clearCart(c.ID)
+
+	return o, nil
+}
+
+func (ms *MemoryStorer) cartFor(userID int64) (Cart, bool) {
+	for _, c := range ms.carts {
+		if c.UserID == userID {
+			return c, true
+		}
+	}
+	return Cart{}, false
+}
+
+func (ms *MemoryStorer) touchCart(userID int64) Cart {
+	c, ok := ms.cartFor(userID)
+	if !ok {
+		ms.lastCartID++
+		c = Cart{ID: ms.lastCartID, UserID: userID, CreatedAt: time.Now()}
+	} else {
+		now := time.Now()
+		c.UpdatedAt = &now
+	}
+	ms.carts[c.ID] = c
+
+	return c
+}
+
+func (ms *MemoryStorer) getCart(userID int64) *Cart {
+	c, ok := ms.cartFor(userID)
+	if !ok {
+		return &Cart{UserID: userID}
+	}
+
+	for _, ci := range ms.cartItems {
+		if ci.CartID != c.ID {
+			continue
+		}
+		p := ms.products[ci.ProductID]
+		ci.Name, ci.Image, ci.Price = p.Name, p.Image, p.Price
+		c.Items = append(c.Items, ci)
+	}
+	sort.Slice(c.Items, func(i, j int) bool { return c.Items[i].ID < c.Items[j].ID })
+
+	return &c
+}
+
+func (ms *MemoryStorer) cartItemFor(userID, productID int64) (CartItem, bool) {
+	c, ok := ms.cartFor(userID)
+	if !ok {
+		return CartItem{}, false
+	}
+	for _, ci := range ms.cartItems {
+		if ci.CartID == c.ID && ci.ProductID == productID {
+			return ci, true
+		}
+	}
+	return CartItem{}, false
+}
+
+func (ms *MemoryStorer) clearCart(cartID int64) {
+	for id, ci := range ms.cartItems {
+		if ci.CartID == cartID {
+			delete(ms.cartItems, id)
+		}
+	}
+}
+
+func (ms *MemoryStorer) CreateReview(ctx context.Context, r *Review) (*Review, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.products[r.ProductID]; !ok {
+		return nil, fmt.Errorf("error creating review: %w", sql.ErrNoRows)
+	}
+	if _, ok := ms.users[r.UserID]; !ok {
+		return nil, fmt.Errorf("error creating review: %w", errForeignKey("reviews", "users"))
+	}
+	for _, er := range ms.reviews {
+		if er.UserID == r.UserID && er.ProductID == r.ProductID {
+			return nil, fmt.Errorf("error creating review: %w", ErrReviewExists)
+		}
+	}
+
+	ms.lastReviewID++
+	nr := *r
+	nr.ID = ms.lastReviewID
+	nr.CreatedAt = time.Now()
+	nr.UpdatedAt = nil
+	ms.reviews[nr.ID] = nr
+	ms.updateProductRating(nr.ProductID)
+
+	return &nr, nil
+}
+
+func (ms *MemoryStorer) GetReview(ctx context.Context, id int64) (*Review, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	r, ok := ms.reviews[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting review: %w", sql.ErrNoRows)
+	}
+
+	return &r, nil
+}
+
+func (ms *MemoryStorer) ListReviews(ctx context.Context, productID int64, p Page) ([]*Review, string, error) {
+	pq, err := resolvePage(p, reviewSortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var reviews []*Review
+	for _, r := range ms.reviews {
+		r := r
+		if r.ProductID == productID {
+			reviews = append(reviews, &r)
+		}
+	}
+
+	reviews, next := pageOf(pq, reviews, reviewPosition(pq.key))
+	return reviews, next, nil
+}
+
+func (ms *MemoryStorer) UpdateReview(ctx context.Context, r *Review) (*Review, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	existing, ok := ms.reviews[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("error updating review: %w", sql.ErrNoRows)
+	}
+	existing.Stars = r.Stars
+	existing.Title = r.Title
+	existing.Body = r.Body
+	existing.UpdatedAt = r.UpdatedAt
+	ms.reviews[r.ID] = existing
+	ms.updateProductRating(existing.ProductID)
+
+	return &existing, nil
+}
+
+func (ms *MemoryStorer) DeleteReview(ctx context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	r, ok := ms.reviews[id]
+	if !ok {
+		return fmt.Errorf("error deleting review: %w", sql.ErrNoRows)
+	}
+	delete(ms.reviews, id)
+	ms.updateProductRating(r.ProductID)
+
+	return nil
+}
+
+func (ms *MemoryStorer) HasPurchased(ctx context.Context, userID, productID int64) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, oi := range ms.orderItems {
+		if oi.ProductID != productID {
+			continue
+		}
+		o := ms.orders[oi.OrderID]
+		if o.UserID != userID {
+			continue
+		}
+		for _, s := range purchasedStatuses {
+			if o.Status == s {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// updateProductRating mirrors the rating recomputation of MySQLStorer,
+// including the rounding of the average to the two decimals of the column.
+func (ms *MemoryStorer) updateProductRating(productID int64) {
+	p, ok := ms.products[productID]
+	if !ok {
+		return
+	}
+
+	var stars, count int64
+	for _, r := range ms.reviews {
+		if r.ProductID == productID {
+			stars += r.Stars
+			count++
+		}
+	}
+	p.Rating = 0
+	if count > 0 {
+		p.Rating = math.Round(float64(stars)/float64(count)*100) / 100
+	}
+	p.NumReviews = count
+	ms.products[productID] = p
+}
+
+func (ms *MemoryStorer) CreateUser(ctx context.Context, u *User) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, eu := range ms.users {
+		if eu.Email == u.Email {
+			return nil, fmt.Errorf("error inserting user: duplicate email %q", u.Email)
+		}
+	}
+
+	ms.lastUserID++
+	u.ID = ms.lastUserID
+
+	nu := *u
+	nu.CreatedAt = time.Now()
+	nu.UpdatedAt = nil
+	ms.users[nu.ID] = nu
+
+	return u, nil
+}
+
+func (ms *MemoryStorer) GetUser(ctx context.Context, email string) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, u := range ms.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+
+	return nil, fmt.Errorf("error getting user: %w", sql.ErrNoRows)
+}
+
+func (ms *MemoryStorer) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	u, ok := ms.users[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting user: %w", sql.ErrNoRows)
+	}
+
+	return &u, nil
+}
+
+func (ms *MemoryStorer) ListUsers(ctx context.Context, f UserFilter) ([]*User, string, error) {
+	pq, err := resolvePage(f.Page, userSortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	users := make([]*User, 0, len(ms.users))
+	for _, u := range ms.users {
+		u := u
+		if f.IsAdmin != nil && u.IsAdmin != *f.IsAdmin {
+			continue
+		}
+		users = append(users, &u)
+	}
+
+	users, next := pageOf(pq, users, userPosition(pq.key))
+	return users, next, nil
+}
+
+func (ms *MemoryStorer) UpdateUser(ctx context.Context, u *User) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	existing, ok := ms.users[u.ID]
+	if !ok {
+		return u, nil
+	}
+	for _, eu := range ms.users {
+		if eu.ID != u.ID && eu.Email == u.Email {
+			return nil, fmt.Errorf("error updating user: duplicate email %q", u.Email)
+		}
+	}
+
+	nu := *u
+	nu.CreatedAt = existing.CreatedAt
+	ms.users[u.ID] = nu
+
+	return u, nil
+}
+
+func (ms *MemoryStorer) DeleteUser(ctx context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, o := range ms.orders {
+		if o.UserID == id {
+			return fmt.Errorf("error deleting user: %w", errForeignKey("orders", "users"))
+		}
+	}
+	for _, r := range ms.reviews {
+		if r.UserID == id {
+			return fmt.Errorf("error deleting user: %w", errForeignKey("reviews", "users"))
+		}
+	}
+	// carts and their items, notification preferences and password reset
+	// tokens are removed by ON DELETE CASCADE
+	if c, ok := ms.cartFor(id); ok {
+		ms.clearCart(c.ID)
+		delete(ms.carts, c.ID)
+	}
+	for k := range ms.notificationPrefs {
+		if k.userID == id {
+			delete(ms.notificationPrefs, k)
+		}
+	}
+	for tid, t := range ms.passwordResets {
+		if t.UserID == id {
+			delete(ms.passwordResets, tid)
+		}
+	}
+	delete(ms.users, id)
+
+	return nil
+}
+
+func (ms *MemoryStorer) CreateSession(ctx context.Context, s *Session) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.sessions[s.ID]; ok {
+		return nil, fmt.Errorf("error inserting session: duplicate id %q", s.ID)
+	}
+	if s.FamilyID == "" {
+		s.FamilyID = s.ID
+	}
+
+	ns := *s
+	ns.CreatedAt = time.Now()
+	ms.sessions[ns.ID] = ns
+
+	return s, nil
+}
+
+func (ms *MemoryStorer) GetSession(ctx context.Context, id string) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting session: %w", sql.ErrNoRows)
+	}
+
+	return &s, nil
+}
+
+func (ms *MemoryStorer) RotateSession(ctx context.Context, id string, next *Session) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	parent, ok := ms.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting session: %w", sql.ErrNoRows)
+	}
+	if parent.IsRevoked {
+		return nil, ErrSessionRevoked
+	}
+	if parent.RotatedAt != nil {
+		for sid, s := range ms.sessions {
+			if s.FamilyID == parent.FamilyID {
+				s.IsRevoked = true
+				ms.sessions[sid] = s
+			}
+		}
+		return nil, fmt.Errorf("%w: session %s", ErrRefreshTokenReused, id)
+	}
+	if _, ok := ms.sessions[next.ID]; ok {
+		return nil, fmt.Errorf("error inserting session: duplicate id %q", next.ID)
+	}
+
+	now := time.Now()
+	parent.RotatedAt = &now
+	ms.sessions[id] = parent
+
+	next.ParentID = parent.ID
+	next.FamilyID = parent.FamilyID
+	next.UserEmail = parent.UserEmail
+	ns := *next
+	ns.CreatedAt = now
+	ms.sessions[ns.ID] = ns
+
+	return next, nil
+}
+
+func (ms *MemoryStorer) ListSessionsForUser(ctx context.Context, email string) ([]*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	var sessions []*Session
+	for _, s := range ms.sessions {
+		if s.UserEmail != email || s.IsRevoked || s.RotatedAt != nil || !s.ExpiresAt.After(now) {
+			continue
+		}
+		s := s
+		sessions = append(sessions, &s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.After(sessions[j].CreatedAt) })
+
+	return sessions, nil
+}
+
+func (ms *MemoryStorer) RevokeSession(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if s, ok := ms.sessions[id]; ok {
+		s.IsRevoked = true
+		ms.sessions[id] = s
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorer) RevokeAllSessions(ctx context.Context, email string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for id, s := range ms.sessions {
+		if s.UserEmail == email {
+			s.IsRevoked = true
+			ms.sessions[id] = s
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorer) DeleteSession(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.sessions, id)
+
+	return nil
+}
+
+func (ms *MemoryStorer) CreatePasswordResetToken(ctx context.Context, t *PasswordResetToken, ne *NotificationEvent) (*PasswordResetToken, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[t.UserID]; !ok {
+		return nil, fmt.Errorf("error creating password reset token: %w", errForeignKey("password_reset_tokens", "users"))
+	}
+	for _, et := range ms.passwordResets {
+		if et.TokenHash == t.TokenHash {
+			return nil, fmt.Errorf("error creating password reset token: duplicate token hash")
+		}
+	}
+
+	ev, err := ms.enqueueNotificationEvent(ne)
+	if err != nil {
+		return nil, fmt.Errorf("error creating password reset token: %w", err)
+	}
+
+	ms.lastPasswordResetID++
+	t.ID = ms.lastPasswordResetID
+
+	nt := *t
+	nt.UsedAt = nil
+	nt.CreatedAt = time.Now()
+	ms.passwordResets[nt.ID] = nt
+	ms.onEnqueue(ev)
+
+	return t, nil
+}
+
+func (ms *MemoryStorer) ResetPassword(ctx context.Context, tokenHash, password string) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	var (
+		t     PasswordResetToken
+		found bool
+	)
+	for _, et := range ms.passwordResets {
+		if et.TokenHash == tokenHash {
+			t, found = et, true
+			break
+		}
+	}
+	if !found || t.UsedAt != nil || !now.Before(t.ExpiresAt) {
+		return nil, fmt.Errorf("error resetting password: %w", ErrPasswordResetTokenInvalid)
+	}
+
+	u, ok := ms.users[t.UserID]
+	if !ok {
+		return nil, fmt.Errorf("error resetting password: %w", sql.ErrNoRows)
+	}
+	u.Password = password
+	u.UpdatedAt = &now
+	ms.users[u.ID] = u
+
+	for id, et := range ms.passwordResets {
+		if et.UserID == u.ID && et.UsedAt == nil {
+			et.UsedAt = &now
+			ms.passwordResets[id] = et
+		}
+	}
+	for id, s := range ms.sessions {
+		if s.UserEmail == u.Email {
+			s.IsRevoked = true
+			ms.sessions[id] = s
+		}
+	}
+
+	return &u, nil
+}
+
+func (ms *MemoryStorer) insertOutboxEvent(ev *OutboxEvent) {
+	ms.lastOutboxEventID++
+	ev.ID = ms.lastOutboxEventID
+	ev.CreatedAt = time.Now()
+	ms.outboxEvents = append(ms.outboxEvents, *ev)
+}
+
+func (ms *MemoryStorer) ListOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var events []*OutboxEvent
+	for _, ev := range ms.outboxEvents {
+		if len(events) == limit {
+			break
+		}
+		if ev.PublishedAt == nil && ev.ParkedAt == nil {
+			ev := ev
+			events = append(events, &ev)
+		}
+	}
+
+	return events, nil
+}
+
+func (ms *MemoryStorer) MarkOutboxEventPublished(ctx context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.outboxEvents {
+		if ms.outboxEvents[i].ID == id {
+			now := time.Now()
+			ms.outboxEvents[i].PublishedAt = &now
+			return nil
+		}
+	}
+
+	return fmt.Errorf("error marking outbox event published: %w", sql.ErrNoRows)
+}
+
+func (ms *MemoryStorer) FailOutboxEvent(ctx context.Context, id int64, message string, maxAttempts int64) (*OutboxEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.outboxEvents {
+		if ms.outboxEvents[i].ID == id {
+			failOutboxEvent(&ms.outboxEvents[i], message, maxAttempts, time.Now())
+			ev := ms.outboxEvents[i]
+			return &ev, nil
+		}
+	}
+
+	return nil, fmt.Errorf("error failing outbox event: %w", sql.ErrNoRows)
+}
+
+func (ms *MemoryStorer) EnqueueNotificationEvent(ctx context.Context, ne *NotificationEvent) (*NotificationEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ev, err := ms.enqueueNotificationEvent(ne)
+	if err != nil {
+		return nil, fmt.Errorf("error enqueuing notification event: %w", err)
+	}
+	ms.onEnqueue(ev)
+
+	return ev, nil
+}
+
+// enqueueNotificationEvent is EnqueueNotificationEvent with ms.mu held.
+func (ms *MemoryStorer) enqueueNotificationEvent(ne *NotificationEvent) (*NotificationEvent, error) {
+	if ne.NotificationType == "" {
+		ne.NotificationType = OrderNotification(ne.OrderStatus)
+	}
+	if _, ok := ms.orders[int64(ne.OrderID)]; ne.OrderID != 0 && !ok {
+		return nil, errForeignKey("notification_states", "orders")
+	}
+
+	var outboxEventID *int64
+	if ne.OutboxEventID != 0 {
+		for _, ns := range ms.notificationStates {
+			if ns.OutboxEventID != nil && *ns.OutboxEventID == ne.OutboxEventID {
+				return nil, ErrAlreadyEnqueued
+			}
+		}
+		id := ne.OutboxEventID
+		outboxEventID = &id
+	}
+
+	now := time.Now()
+	ms.lastNotificationStateID++
+	ms.notificationStates[ms.lastNotificationStateID] = NotificationState{
+		ID:               ms.lastNotificationStateID,
+		OrderID:          ne.OrderID,
+		UserEmail:        ne.UserEmail,
+		OrderStatus:      ne.OrderStatus,
+		NotificationType: ne.NotificationType,
+		OutboxEventID:    outboxEventID,
+		State:            NotSent,
+		RequestedAt:      now,
+	}
+	ne.StateID = ms.lastNotificationStateID
+
+	ms.lastNotificationEventID++
+	ne.ID = ms.lastNotificationEventID
+
+	ne.NextAttemptAt = now
+
+	nev := *ne
+	nev.CreatedAt = now
+	nev.UpdatedAt = nil
+	ms.notificationEvents[nev.ID] = nev
+
+	return ne, nil
+}
+
+func (ms *MemoryStorer) ListNotificationEvents(ctx context.Context) ([]*NotificationEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	return ms.dueNotificationEvents(now, unleased(now)), nil
+}
+
+// unleased keeps the events whose lease, if any, has expired at now.
+func unleased(now time.Time) func(*NotificationEvent) bool {
+	return func(ne *NotificationEvent) bool {
+		return ne.LeasedUntil == nil || !ne.LeasedUntil.After(now)
+	}
+}
+
+// dueNotificationEvents returns copies of the events due at now that match
+// keep, oldest first.
+func (ms *MemoryStorer) dueNotificationEvents(now time.Time, keep func(*NotificationEvent) bool) []*NotificationEvent {
+	var events []*NotificationEvent
+	for _, ne := range ms.notificationEvents {
+		ne := ne
+		if ne.Attempts < ms.retry.MaxAttempts && !ne.NextAttemptAt.After(now) && keep(&ne) {
+			events = append(events, &ne)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.Before(events[j].CreatedAt)
+		}
+		return events[i].ID < events[j].ID
+	})
+
+	return events
+}
+
+func (ms *MemoryStorer) ClaimNotificationEvents(ctx context.Context, workerID string, batch int, lease time.Duration) ([]*NotificationEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	events := ms.dueNotificationEvents(now, unleased(now))
+	if len(events) > batch {
+		events = events[:batch]
+	}
+
+	until := now.Add(lease)
+	for _, ev := range events {
+		ev.LeasedBy = workerID
+		ev.LeasedUntil = &until
+		ms.notificationEvents[ev.ID] = *ev
+	}
+
+	return events, nil
+}
+
+func (ms *MemoryStorer) UpdateNotificationEvent(ctx context.Context, ev *NotificationEvent, es *NotificationState, responseType NotificationResponseType) (bool, error) {
+	succeeded, err := ms.UpdateNotificationEvents(ctx, []*NotificationUpdate{{Event: ev, State: es, ResponseType: responseType}})
+	if err != nil {
+		return false, err
+	}
+
+	return succeeded[0], nil
+}
+
+func (ms *MemoryStorer) UpdateNotificationEvents(ctx context.Context, updates []*NotificationUpdate) ([]bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// check every update before applying any, as the transaction would
+	now := time.Now()
+	seen := make(map[int64]bool, len(updates))
+	for _, u := range updates {
+		if !u.ResponseType.valid() {
+			return nil, fmt.Errorf("invalid notification response type: %v", u.ResponseType)
+		}
+
+		ev, ok := ms.notificationEvents[u.Event.ID]
+		if !ok || seen[u.Event.ID] {
+			return nil, fmt.Errorf("error getting notification event: %w", sql.ErrNoRows)
+		}
+		seen[u.Event.ID] = true
+		if !holdsLease(&ev, u.Event.LeasedBy, now) {
+			return nil, fmt.Errorf("%w: event %d", ErrNotLeaseHolder, u.Event.ID)
+		}
+	}
+
+	succeeded := make([]bool, len(updates))
+	for i, u := range updates {
+		succeeded[i] = ms.updateNotificationEvent(u.Event, u.State, u.ResponseType, now)
+	}
+
+	return succeeded, nil
+}
+
+func (ms *MemoryStorer) updateNotificationEvent(ev *NotificationEvent, es *NotificationState, responseType NotificationResponseType, now time.Time) bool {
+	u := ms.notificationEvents[ev.ID]
+	if responseType == NotificationSuppressed {
+		ms.setNotificationState(u.StateID, Suppressed, es.Message)
+		delete(ms.notificationEvents, ev.ID)
+		return false
+	}
+
+	ms.lastNotificationAttemptID++
+	ms.notificationAttempts = append(ms.notificationAttempts, NotificationAttempt{
+		ID:          ms.lastNotificationAttemptID,
+		StateID:     u.StateID,
+		Attempt:     u.Attempts + 1,
+		Succeeded:   responseType == NotificationSucess,
+		Message:     es.Message,
+		AttemptedAt: now,
+	})
+
+	switch responseType {
+	case NotificationSucess:
+		ms.setNotificationState(u.StateID, Sent, es.Message)
+		delete(ms.notificationEvents, ev.ID)
+		return true
+	default:
+		if u.Attempts+1 < ms.retry.MaxAttempts {
+			u.UpdatedAt = &now
+			u.Attempts += 1
+			u.NextAttemptAt = now.Add(ms.retry.Delay(u.Attempts))
+			u.LeasedBy = ""
+			u.LeasedUntil = nil
+			ms.notificationEvents[u.ID] = u
+		} else {
+			ms.setNotificationState(u.StateID, Failed, es.Message)
+			delete(ms.notificationEvents, u.ID)
+		}
+
+		return false
+	}
+}
+
+func (ms *MemoryStorer) ListNotificationAttempts(ctx context.Context, stateID int64) ([]*NotificationAttempt, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var attempts []*NotificationAttempt
+	for _, a := range ms.notificationAttempts {
+		if a.StateID == stateID {
+			a := a
+			attempts = append(attempts, &a)
+		}
+	}
+
+	return attempts, nil
+}
+
+func (ms *MemoryStorer) GetNotificationState(ctx context.Context, id int64) (*NotificationState, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ns, ok := ms.notificationStates[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting notification state: %w", sql.ErrNoRows)
+	}
+
+	return &ns, nil
+}
+
+func (ms *MemoryStorer) ListNotificationStates(ctx context.Context, f NotificationStateFilter) ([]*NotificationState, string, error) {
+	pq, err := resolvePage(f.Page, notificationStateSortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	states := make([]*NotificationState, 0, len(ms.notificationStates))
+	for _, ns := range ms.notificationStates {
+		ns := ns
+		if f.OrderID != 0 && int64(ns.OrderID) != f.OrderID {
+			continue
+		}
+		if f.State != "" && ns.State != f.State {
+			continue
+		}
+		if f.RequestedAfter != nil && ns.RequestedAt.Before(*f.RequestedAfter) {
+			continue
+		}
+		if f.RequestedBefore != nil && !ns.RequestedAt.Before(*f.RequestedBefore) {
+			continue
+		}
+		states = append(states, &ns)
+	}
+
+	states, next := pageOf(pq, states, notificationStatePosition(pq.key))
+	return states, next, nil
+}
+
+func (ms *MemoryStorer) ListNotificationReplays(ctx context.Context, stateID int64) ([]*NotificationReplay, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var replays []*NotificationReplay
+	for _, r := range ms.notificationReplays {
+		if r.StateID == stateID {
+			r := r
+			replays = append(replays, &r)
+		}
+	}
+
+	return replays, nil
+}
+
+func (ms *MemoryStorer) ReplayNotifications(ctx context.Context, stateIDs []int64, actorID int64) ([]*NotificationEvent, error) {
+	if len(stateIDs) == 0 {
+		return nil, nil
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var states []*NotificationState
+	seen := make(map[int64]bool, len(stateIDs))
+	for _, id := range stateIDs {
+		if ns, ok := ms.notificationStates[id]; ok && !seen[id] {
+			seen[id] = true
+			states = append(states, &ns)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+
+	err := checkReplayable(states, stateIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error replaying notifications: %w", err)
+	}
+
+	now := time.Now()
+	var events []*NotificationEvent
+	for _, ns := range states {
+		ms.lastNotificationReplayID++
+		ms.notificationReplays = append(ms.notificationReplays, NotificationReplay{
+			ID:              ms.lastNotificationReplayID,
+			StateID:         ns.ID,
+			ActorID:         actorID,
+			PreviousMessage: ns.Message,
+			CreatedAt:       now,
+		})
+
+		ns.State = NotSent
+		ns.Message = ""
+		ns.CompletedAt = nil
+		ms.notificationStates[ns.ID] = *ns
+
+		ms.lastNotificationEventID++
+		ev := NotificationEvent{
+			ID:               ms.lastNotificationEventID,
+			UserEmail:        ns.UserEmail,
+			OrderStatus:      ns.OrderStatus,
+			OrderID:          ns.OrderID,
+			NotificationType: ns.NotificationType,
+			StateID:          ns.ID,
+			NextAttemptAt:    now,
+			CreatedAt:        now,
+		}
+		ms.notificationEvents[ev.ID] = ev
+		events = append(events, &ev)
+	}
+	ms.onEnqueue(events...)
+
+	return events, nil
+}
+
+func (ms *MemoryStorer) setNotificationState(id int64, state NotificationEventState, message string) {
+	ns, ok := ms.notificationStates[id]
+	if !ok {
+		return
+	}
+
+	ns.State = state
+	ns.Message = message
+	if state == Sent || state == Suppressed {
+		t := time.Now()
+		ns.CompletedAt = &t
+	}
+	ms.notificationStates[id] = ns
+}
+
+type notificationPreferenceKey struct {
+	userID  int64
+	typ     NotificationType
+	channel NotificationChannel
+}
+
+func (ms *MemoryStorer) ListNotificationPreferences(ctx context.Context, userID int64) ([]*NotificationPreference, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var prefs []*NotificationPreference
+	for _, p := range ms.notificationPrefs {
+		if p.UserID == userID {
+			p := p
+			prefs = append(prefs, &p)
+		}
+	}
+	sort.Slice(prefs, func(i, j int) bool {
+		if prefs[i].Type != prefs[j].Type {
+			return prefs[i].Type < prefs[j].Type
+		}
+		return prefs[i].Channel < prefs[j].Channel
+	})
+
+	return prefs, nil
+}
+
+func (ms *MemoryStorer) SetNotificationPreferences(ctx context.Context, userID int64, prefs []*NotificationPreference) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[userID]; !ok {
+		return fmt.Errorf("error setting notification preferences: %w", errForeignKey("notification_preferences", "users"))
+	}
+
+	now := time.Now()
+	for _, p := range prefs {
+		p.UserID = userID
+		p.UpdatedAt = now
+		ms.notificationPrefs[notificationPreferenceKey{userID, p.Type, p.Channel}] = *p
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorer) CreateWebhook(ctx context.Context, w *Webhook) (*Webhook, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.lastWebhookID++
+	w.ID = ms.lastWebhookID
+	w.CreatedAt = time.Now()
+	w.UpdatedAt = nil
+
+	nw := *w
+	nw.EventTypes = slices.Clone(w.EventTypes)
+	ms.webhooks[nw.ID] = nw
+
+	return w, nil
+}
+
+func (ms *MemoryStorer) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	w, ok := ms.webhooks[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting webhook: %w", sql.ErrNoRows)
+	}
+	w.EventTypes = slices.Clone(w.EventTypes)
+
+	return &w, nil
+}
+
+func (ms *MemoryStorer) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var webhooks []*Webhook
+	for _, w := range ms.webhooks {
+		w := w
+		w.EventTypes = slices.Clone(w.EventTypes)
+		webhooks = append(webhooks, &w)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	return webhooks, nil
+}
+
+func (ms *MemoryStorer) UpdateWebhook(ctx context.Context, w *Webhook) (*Webhook, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	existing, ok := ms.webhooks[w.ID]
+	if !ok {
+		return w, nil
+	}
+	existing.URL = w.URL
+	existing.EventTypes = slices.Clone(w.EventTypes)
+	existing.Secret = w.Secret
+	existing.Active = w.Active
+	existing.UpdatedAt = w.UpdatedAt
+	ms.webhooks[w.ID] = existing
+
+	return w, nil
+}
+
+func (ms *MemoryStorer) DeleteWebhook(ctx context.Context, id int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.webhooks, id)
+	for _, d := range ms.webhookDeliveries {
+		if d.WebhookID == id {
+			delete(ms.webhookDeliveries, d.ID)
+			ms.webhookAttempts = slices.DeleteFunc(ms.webhookAttempts, func(a WebhookDeliveryAttempt) bool {
+				return a.DeliveryID == d.ID
+			})
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorer) EnqueueWebhookDeliveries(ctx context.Context, ev *OutboxEvent) ([]*WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	enqueued := make(map[int64]bool)
+	for _, d := range ms.webhookDeliveries {
+		if d.OutboxEventID == ev.ID {
+			enqueued[d.WebhookID] = true
+		}
+	}
+
+	var webhookIDs []int64
+	for _, w := range ms.webhooks {
+		if w.Active && w.EventTypes.Has(ev.EventType) && !enqueued[w.ID] {
+			webhookIDs = append(webhookIDs, w.ID)
+		}
+	}
+	slices.Sort(webhookIDs)
+
+	now := time.Now()
+	var deliveries []*WebhookDelivery
+	for _, id := range webhookIDs {
+		d := newWebhookDelivery(id, ev, now)
+		ms.lastWebhookDeliveryID++
+		d.ID = ms.lastWebhookDeliveryID
+		ms.webhookDeliveries[d.ID] = *d
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
+
+func (ms *MemoryStorer) ClaimWebhookDeliveries(ctx context.Context, workerID string, batch int, lease time.Duration) ([]*WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	var deliveries []*WebhookDelivery
+	for _, d := range ms.webhookDeliveries {
+		d := d
+		if d.State != DeliveryPending || d.NextAttemptAt.After(now) || (d.LeasedUntil != nil && d.LeasedUntil.After(now)) || !ms.webhooks[d.WebhookID].Active {
+			continue
+		}
+		deliveries = append(deliveries, &d)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID < deliveries[j].ID
+	})
+	if len(deliveries) > batch {
+		deliveries = deliveries[:batch]
+	}
+
+	until := now.Add(lease)
+	for _, d := range deliveries {
+		d.LeasedBy = workerID
+		d.LeasedUntil = &until
+		ms.webhookDeliveries[d.ID] = *d
+	}
+
+	return deliveries, nil
+}
+
+func (ms *MemoryStorer) RecordWebhookDeliveryAttempt(ctx context.Context, d *WebhookDelivery, a *WebhookDeliveryAttempt) (*WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	u, ok := ms.webhookDeliveries[d.ID]
+	if !ok {
+		return nil, fmt.Errorf("error getting webhook delivery: %w", sql.ErrNoRows)
+	}
+	now := time.Now()
+	if u.State != DeliveryPending || !leaseHeldBy(u.LeasedBy, u.LeasedUntil, d.LeasedBy, now) {
+		return nil, fmt.Errorf("%w: webhook delivery %d", ErrNotLeaseHolder, d.ID)
+	}
+
+	ms.lastWebhookAttemptID++
+	a.ID = ms.lastWebhookAttemptID
+	a.DeliveryID = u.ID
+	a.Attempt = u.Attempts + 1
+	a.AttemptedAt = now
+	ms.webhookAttempts = append(ms.webhookAttempts, *a)
+
+	ms.completeWebhookDeliveryAttempt(&u, a, now)
+	ms.webhookDeliveries[u.ID] = u
+
+	return &u, nil
+}
+
+func (ms *MemoryStorer) GetWebhookDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	d, ok := ms.webhookDeliveries[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting webhook delivery: %w", sql.ErrNoRows)
+	}
+
+	return &d, nil
+}
+
+func (ms *MemoryStorer) ListWebhookDeliveries(ctx context.Context, webhookID int64, p Page) ([]*WebhookDelivery, string, error) {
+	pq, err := resolvePage(p, webhookDeliverySortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var deliveries []*WebhookDelivery
+	for _, d := range ms.webhookDeliveries {
+		d := d
+		if d.WebhookID == webhookID {
+			deliveries = append(deliveries, &d)
+		}
+	}
+
+	deliveries, next := pageOf(pq, deliveries, webhookDeliveryPosition(pq.key))
+	return deliveries, next, nil
+}
+
+func (ms *MemoryStorer) ListWebhookDeliveryAttempts(ctx context.Context, deliveryID int64) ([]*WebhookDeliveryAttempt, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var attempts []*WebhookDeliveryAttempt
+	for _, a := range ms.webhookAttempts {
+		if a.DeliveryID == deliveryID {
+			a := a
+			attempts = append(attempts, &a)
+		}
+	}
+
+	return attempts, nil
+}
+
+// pageOf applies the sort order and cursor of pq to rows the way the keyset
+// query in MySQLStorer does.
+func pageOf[T any](pq *pageQuery, rows []T, position func(T) (string, int64)) ([]T, string) {
+	sort.Slice(rows, func(i, j int) bool {
+		av, aID := position(rows[i])
+		bv, bID := position(rows[j])
+		return pq.less(av, aID, bv, bID)
+	})
+
+	kept := rows[:0]
+	for _, r := range rows {
+		if pq.after(position(r)) {
+			kept = append(kept, r)
+		}
+	}
+
+	return paginate(pq, kept, position)
+}
@@ -0,0 +1,9 @@
+package storer
+
+import "testing"
+
+func TestMemoryStorerConformance(t *testing.T) {
+	testStorerConformance(t, func(t *testing.T, opts ...Option) Storer {
+		return NewMemoryStorer(opts...)
+	})
+}
@@ -2,28 +2,30 @@ package storer
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
-const (
-	maxAttempts = 3
-)
-
 type MySQLStorer struct {
 	db *sqlx.DB
+	options
 }
 
-func NewMySQLStorer(db *sqlx.DB) *MySQLStorer {
+func NewMySQLStorer(db *sqlx.DB, opts ...Option) *MySQLStorer {
 	return &MySQLStorer{
-		db: db,
+		db:      db,
+		options: newOptions(opts),
 	}
 }
 
 func (ms *MySQLStorer) CreateProduct(ctx context.Context, p *Product) (*Product, error) {
-	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (:name, :image, :category, :description, :rating, :num_reviews, :price, :count_in_stock)", p)
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO products (name, image, category, description, price, count_in_stock) VALUES (:name, :image, :category, :description, :price, :count_in_stock)", p)
 	if err != nil {
 		return nil, fmt.Errorf("error inserting product: %w", err)
 	}
@@ -47,23 +49,67 @@ func (ms *MySQLStorer) GetProduct(ctx context.Context, id int64) (*Product, erro
 	return &p, nil
 }
 
-func (ms *MySQLStorer) ListProducts(ctx context.Context) ([]*Product, error) {
+func (ms *MySQLStorer) ListProducts(ctx context.Context, f ProductFilter) ([]*Product, string, error) {
+	pq, err := resolvePage(f.Page, productSortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if f.Category != "" {
+		conds = append(conds, "category=?")
+		args = append(args, f.Category)
+	}
+	if f.MinPrice != nil {
+		conds = append(conds, "price>=?")
+		args = append(args, *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		conds = append(conds, "price<=?")
+		args = append(args, *f.MaxPrice)
+	}
+	if f.InStock {
+		conds = append(conds, "count_in_stock>0")
+	}
+	conds, args = pq.where(conds, args)
+
 	var products []*Product
-	err := ms.db.SelectContext(ctx, &products, "SELECT * FROM products")
+	err = ms.db.SelectContext(ctx, &products, "SELECT * FROM products"+whereClause(conds)+pq.orderBy(), args...)
 	if err != nil {
-		return nil, fmt.Errorf("error listing products: %w", err)
+		return nil, "", fmt.Errorf("error listing products: %w", err)
 	}
 
-	return products, nil
+	products, next := paginate(pq, products, productPosition(pq.key))
+	return products, next, nil
 }
 
-func (ms *MySQLStorer) UpdateProduct(ctx context.Context, p *Product) (*Product, error) {
-	_, err := ms.db.NamedExecContext(ctx, "UPDATE products SET name=:name, image=:image, category=:category, description=:description, rating=:rating, num_reviews=:num_reviews, price=:price, count_in_stock=:count_in_stock, updated_at=:updated_at WHERE id=:id", p)
+// UpdateProduct applies patch to the product with its row locked, so that
+// stock taken by orders placed meanwhile isn't written back.
+func (ms *MySQLStorer) UpdateProduct(ctx context.Context, id int64, patch func(*Product)) (*Product, error) {
+	var p Product
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(ctx, &p, "SELECT * FROM products WHERE id=? FOR UPDATE", id)
+		if err != nil {
+			return fmt.Errorf("error getting product: %w", err)
+		}
+
+		patch(&p)
+		p.ID = id
+		_, err = tx.NamedExecContext(ctx, "UPDATE products SET name=:name, image=:image, category=:category, description=:description, price=:price, count_in_stock=:count_in_stock, updated_at=:updated_at WHERE id=:id", &p)
+		if err != nil {
+			return fmt.Errorf("error updating product: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error updating product: %w", err)
 	}
 
-	return p, nil
+	return &p, nil
 }
 
 func (ms *MySQLStorer) DeleteProduct(ctx context.Context, id int64) error {
@@ -77,27 +123,137 @@ func (ms *MySQLStorer) DeleteProduct(ctx context.Context, id int64) error {
 
 func (ms *MySQLStorer) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
 	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
-		// insert into orders
-		order, err := createOrder(ctx, tx, o)
+		return insertOrder(ctx, tx, o)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating order: %w", err)
+	}
+
+	return o, nil
+}
+
+// insertOrder takes the items of o out of stock and writes the order, its
+// items and an OrderCreated event in tx.
+func insertOrder(ctx context.Context, tx *sqlx.Tx, o *Order) error {
+	// lock the product rows and take the items out of stock
+	err := reserveStock(ctx, tx, o.Items)
+	if err != nil {
+		return err
+	}
+
+	// insert into orders
+	order, err := createOrder(ctx, tx, o)
+	if err != nil {
+		return fmt.Errorf("error creating order: %w", err)
+	}
+
+	for _, oi := range o.Items {
+		oi.OrderID = order.ID
+		// insert into order_items
+		err = createOrderItem(ctx, tx, oi)
 		if err != nil {
-			return fmt.Errorf("error creating order: %w", err)
+			return fmt.Errorf("error creating order item: %w", err)
 		}
+	}
 
-		for _, oi := range o.Items {
-			oi.OrderID = order.ID
-			// insert into order_items
-			err = createOrderItem(ctx, tx, oi)
-			if err != nil {
-				return fmt.Errorf("error creating order item: %w", err)
-			}
-		}
+	ev, err := newOrderEvent(OrderCreated, OrderEvent{OrderID: order.ID, UserID: o.UserID, Status: Pending})
+	if err != nil {
+		return err
+	}
+
+	return insertOutboxEvent(ctx, tx, ev)
+}
+
+// stockQuantities sums item quantities per product and returns the product
+// IDs in ascending order, so that concurrent transactions always lock product
+// rows in the same order and cannot deadlock each other.
+func stockQuantities(items []OrderItem) ([]int64, map[int64]int64) {
+	quantities := make(map[int64]int64)
+	for _, oi := range items {
+		quantities[oi.ProductID] += oi.Quantity
+	}
+
+	ids := make([]int64, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, quantities
+}
+
+func reserveStock(ctx context.Context, tx *sqlx.Tx, items []OrderItem) error {
+	ids, quantities := stockQuantities(items)
+	if len(ids) == 0 {
 		return nil
-	})
+	}
+
+	q, args, err := sqlx.In("SELECT id, count_in_stock FROM products WHERE id IN (?) ORDER BY id FOR UPDATE", ids)
 	if err != nil {
-		return nil, fmt.Errorf("error creating order: %w", err)
+		return fmt.Errorf("error building stock query: %w", err)
 	}
 
-	return o, nil
+	var stock []Product
+	err = tx.SelectContext(ctx, &stock, tx.Rebind(q), args...)
+	if err != nil {
+		return fmt.Errorf("error locking products: %w", err)
+	}
+
+	inStock := make(map[int64]int64, len(stock))
+	for _, p := range stock {
+		inStock[p.ID] = p.CountInStock
+	}
+
+	var short []int64
+	for _, id := range ids {
+		if inStock[id] < quantities[id] {
+			short = append(short, id)
+		}
+	}
+	if len(short) > 0 {
+		return &InsufficientStockError{ProductIDs: short}
+	}
+
+	for _, id := range ids {
+		_, err = tx.ExecContext(ctx, "UPDATE products SET count_in_stock=count_in_stock-? WHERE id=?", quantities[id], id)
+		if err != nil {
+			return fmt.Errorf("error decrementing stock: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// orderShipped reports whether the order ever moved to Shipped, which the
+// status of a refunded order doesn't tell.
+func orderShipped(ctx context.Context, tx *sqlx.Tx, orderID int64) (bool, error) {
+	var shipped bool
+	err := tx.GetContext(ctx, &shipped, "SELECT EXISTS (SELECT 1 FROM order_status_history WHERE order_id=? AND to_status=?)", orderID, Shipped)
+	if err != nil {
+		return false, fmt.Errorf("error getting order status history: %w", err)
+	}
+
+	return shipped, nil
+}
+
+// restockOrderItems puts the items of an order back into stock. It must run
+// in the same transaction that deletes, cancels or refunds the order.
+func restockOrderItems(ctx context.Context, tx *sqlx.Tx, orderID int64) error {
+	var items []OrderItem
+	err := tx.SelectContext(ctx, &items, "SELECT product_id, quantity FROM order_items WHERE order_id=?", orderID)
+	if err != nil {
+		return fmt.Errorf("error getting order items: %w", err)
+	}
+
+	ids, quantities := stockQuantities(items)
+	for _, id := range ids {
+		_, err = tx.ExecContext(ctx, "UPDATE products SET count_in_stock=count_in_stock+? WHERE id=?", quantities[id], id)
+		if err != nil {
+			return fmt.Errorf("error restocking product: %w", err)
+		}
+	}
+
+	return nil
 }
 
 func createOrder(ctx context.Context, tx *sqlx.Tx, o *Order) (*Order, error) {
@@ -130,9 +286,9 @@ func createOrderItem(ctx context.Context, tx *sqlx.Tx, oi OrderItem) error {
 	return nil
 }
 
-func (ms *MySQLStorer) GetOrder(ctx context.Context, userID int64) (*Order, error) {
+func (ms *MySQLStorer) GetOrder(ctx context.Context, id int64) (*Order, error) {
 	var o Order
-	err := ms.db.GetContext(ctx, &o, "SELECT * FROM orders WHERE user_id=?", userID)
+	err := ms.db.GetContext(ctx, &o, "SELECT * FROM orders WHERE id=?", id)
 	if err != nil {
 		return nil, fmt.Errorf("error getting order: %w", err)
 	}
@@ -157,238 +313,1055 @@ func (ms *MySQLStorer) GetOrderStatusByID(ctx context.Context, id int64) (*Order
 	return &o, nil
 }
 
-func (ms *MySQLStorer) ListOrders(ctx context.Context) ([]*Order, error) {
-	var orders []*Order
-	err := ms.db.SelectContext(ctx, &orders, "SELECT * FROM orders")
+func (ms *MySQLStorer) ListOrders(ctx context.Context, f OrderFilter) ([]*Order, string, error) {
+	pq, err := resolvePage(f.Page, orderSortFields)
 	if err != nil {
-		return nil, fmt.Errorf("error listing orders: %w", err)
+		return nil, "", err
 	}
 
-	for i := range orders {
-		var items []OrderItem
-		err = ms.db.SelectContext(ctx, &items, "SELECT * FROM order_items WHERE order_id=?", orders[i].ID)
-		if err != nil {
-			return nil, fmt.Errorf("error getting order items: %w", err)
-		}
-		orders[i].Items = items
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if f.Status != "" {
+		conds = append(conds, "status=?")
+		args = append(args, f.Status)
+	}
+	if f.UserID != 0 {
+		conds = append(conds, "user_id=?")
+		args = append(args, f.UserID)
+	}
+	if f.CreatedAfter != nil {
+		conds = append(conds, "created_at>=?")
+		args = append(args, *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		conds = append(conds, "created_at<?")
+		args = append(args, *f.CreatedBefore)
 	}
+	conds, args = pq.where(conds, args)
 
-	return orders, nil
-}
+	var orders []*Order
+	err = ms.db.SelectContext(ctx, &orders, "SELECT * FROM orders"+whereClause(conds)+pq.orderBy(), args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing orders: %w", err)
+	}
 
-func (ms *MySQLStorer) UpdateOrderStatus(ctx context.Context, o *Order) (*Order, error) {
-	_, err := ms.db.NamedExecContext(ctx, "UPDATE orders SET status=:status, updated_at=:updated_at WHERE id=:id", o)
+	orders, next := paginate(pq, orders, orderPosition(pq.key))
+	err = ms.attachOrderItems(ctx, orders)
 	if err != nil {
-		return nil, fmt.Errorf("error updating order status: %w", err)
+		return nil, "", err
 	}
 
-	return o, nil
+	return orders, next, nil
 }
 
-func (ms *MySQLStorer) DeleteOrder(ctx context.Context, id int64) error {
-	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
-		_, err := tx.ExecContext(ctx, "DELETE FROM order_items WHERE order_id=?", id)
-		if err != nil {
-			return fmt.Errorf("error deleting order items: %w", err)
-		}
-
-		_, err = tx.ExecContext(ctx, "DELETE FROM orders WHERE id=?", id)
-		if err != nil {
-			return fmt.Errorf("error deleting order: %w", err)
-		}
+// ListOrdersForUser returns the order history of a user, oldest first unless
+// the page asks for another order.
+func (ms *MySQLStorer) ListOrdersForUser(ctx context.Context, userID int64, p Page) ([]*Order, string, error) {
+	return ms.ListOrders(ctx, OrderFilter{Page: p, UserID: userID})
+}
 
+// attachOrderItems loads the items of all orders with a single query.
+func (ms *MySQLStorer) attachOrderItems(ctx context.Context, orders []*Order) error {
+	if len(orders) == 0 {
 		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("error deleting order: %w", err)
 	}
 
-	return nil
-}
+	ids := make([]int64, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.ID)
+	}
 
-func (ms *MySQLStorer) execTx(ctx context.Context, fn func(*sqlx.Tx) error) error {
-	tx, err := ms.db.BeginTxx(ctx, nil)
+	q, args, err := sqlx.In("SELECT * FROM order_items WHERE order_id IN (?) ORDER BY id", ids)
 	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
+		return fmt.Errorf("error building order items query: %w", err)
 	}
 
-	err = fn(tx)
+	var items []OrderItem
+	err = ms.db.SelectContext(ctx, &items, ms.db.Rebind(q), args...)
 	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			return fmt.Errorf("error rolling back transaction: %w", rbErr)
-		}
-		return fmt.Errorf("error in transaction: %w", err)
+		return fmt.Errorf("error getting order items: %w", err)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %w", err)
+	byOrder := make(map[int64][]OrderItem, len(orders))
+	for _, oi := range items {
+		byOrder[oi.OrderID] = append(byOrder[oi.OrderID], oi)
+	}
+	for _, o := range orders {
+		o.Items = byOrder[o.ID]
 	}
 
 	return nil
 }
 
-func (ms *MySQLStorer) CreateUser(ctx context.Context, u *User) (*User, error) {
-	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO users (name, email, password, is_admin) VALUES (:name, :email, :password, :is_admin)", u)
-	if err != nil {
-		return nil, fmt.Errorf("error inserting user: %w", err)
-	}
+// UpdateOrderStatus moves an order along its lifecycle and records the change
+// in the status history. The order row is locked so that c.FromStatus, when
+// set, is checked against the status the change is applied to. Cancelled
+// orders and orders refunded before they shipped are put back in stock.
+func (ms *MySQLStorer) UpdateOrderStatus(ctx context.Context, c *OrderStatusChange) (*Order, error) {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var o Order
+		err := tx.GetContext(ctx, &o, "SELECT id, user_id, status FROM orders WHERE id=? FOR UPDATE", c.OrderID)
+		if err != nil {
+			return fmt.Errorf("error getting order: %w", err)
+		}
 
-	id, err := res.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("error getting last insert ID: %w", err)
-	}
-	u.ID = id
+		err = checkTransition(&o, c)
+		if err != nil {
+			return err
+		}
 
-	return u, nil
-}
+		var shipped bool
+		if c.ToStatus == Refunded {
+			shipped, err = orderShipped(ctx, tx, o.ID)
+			if err != nil {
+				return err
+			}
+		}
+		if restocksOnTransition(c.ToStatus, shipped) {
+			err = restockOrderItems(ctx, tx, o.ID)
+			if err != nil {
+				return fmt.Errorf("error restocking order items: %w", err)
+			}
+		}
 
-func (ms *MySQLStorer) GetUser(ctx context.Context, email string) (*User, error) {
-	var u User
-	err := ms.db.GetContext(ctx, &u, "SELECT * FROM users WHERE email=?", email)
-	if err != nil {
-		return nil, fmt.Errorf("error getting user: %w", err)
-	}
+		c.CreatedAt = time.Now()
+		_, err = tx.ExecContext(ctx, "UPDATE orders SET status=?, updated_at=? WHERE id=?", c.ToStatus, c.CreatedAt, o.ID)
+		if err != nil {
+			return fmt.Errorf("error updating order: %w", err)
+		}
 
-	return &u, nil
-}
+		res, err := tx.NamedExecContext(ctx, "INSERT INTO order_status_history (order_id, from_status, to_status, actor_id, actor_role, reason, created_at) VALUES (:order_id, :from_status, :to_status, :actor_id, :actor_role, :reason, :created_at)", c)
+		if err != nil {
+			return fmt.Errorf("error inserting order status history: %w", err)
+		}
 
-func (ms *MySQLStorer) ListUsers(ctx context.Context) ([]*User, error) {
-	var users []*User
-	err := ms.db.SelectContext(ctx, &users, "SELECT * FROM users")
-	if err != nil {
-		return nil, fmt.Errorf("error listing users: %w", err)
-	}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("error getting last insert ID: %w", err)
+		}
+		c.ID = id
 
-	return users, nil
-}
+		ev, err := newOrderEvent(OrderStatusChanged, OrderEvent{OrderID: o.ID, UserID: o.UserID, FromStatus: o.Status, Status: c.ToStatus})
+		if err != nil {
+			return err
+		}
 
-func (ms *MySQLStorer) UpdateUser(ctx context.Context, u *User) (*User, error) {
-	_, err := ms.db.NamedExecContext(ctx, "UPDATE users SET name=:name, email=:email, password=:password, is_admin=:is_admin, updated_at=:updated_at WHERE id=:id", u)
+		return insertOutboxEvent(ctx, tx, ev)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error updating user: %w", err)
+		return nil, fmt.Errorf("error updating order status: %w", err)
 	}
 
-	return u, nil
+	return ms.GetOrder(ctx, c.OrderID)
 }
 
-func (ms *MySQLStorer) DeleteUser(ctx context.Context, id int64) error {
-	_, err := ms.db.ExecContext(ctx, "DELETE FROM users WHERE id=?", id)
+func (ms *MySQLStorer) ListOrderStatusHistory(ctx context.Context, orderID int64) ([]*OrderStatusChange, error) {
+	var changes []*OrderStatusChange
+	err := ms.db.SelectContext(ctx, &changes, "SELECT * FROM order_status_history WHERE order_id=? ORDER BY id", orderID)
 	if err != nil {
-		return fmt.Errorf("error deleting user: %w", err)
+		return nil, fmt.Errorf("error listing order status history: %w", err)
 	}
 
-	return nil
+	return changes, nil
 }
 
-func (ms *MySQLStorer) CreateSession(ctx context.Context, s *Session) (*Session, error) {
-	_, err := ms.db.NamedExecContext(ctx, "INSERT INTO sessions (id, user_email, refresh_token, is_revoked, expires_at) VALUES (:id, :user_email, :refresh_token, :is_revoked, :expires_at)", s)
-	if err != nil {
-		return nil, fmt.Errorf("error inserting session: %w", err)
-	}
+// DeleteOrder deletes an order and puts its items back in stock unless it was
+// cancelled or has shipped. The order row is locked so that a concurrent
+// cancellation can't restock it a second time.
+func (ms *MySQLStorer) DeleteOrder(ctx context.Context, id int64) error {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var o Order
+		err := tx.GetContext(ctx, &o, "SELECT id, user_id, status FROM orders WHERE id=? FOR UPDATE", id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error getting order: %w", err)
+		}
 
-	return s, nil
-}
+		shipped, err := orderShipped(ctx, tx, id)
+		if err != nil {
+			return err
+		}
 
-func (ms *MySQLStorer) GetSession(ctx context.Context, id string) (*Session, error) {
-	var s Session
-	err := ms.db.GetContext(ctx, &s, "SELECT * FROM sessions WHERE id=?", id)
-	if err != nil {
-		return nil, fmt.Errorf("error getting session: %w", err)
-	}
+		if restocksOnDelete(o.Status, shipped) {
+			err = restockOrderItems(ctx, tx, id)
+			if err != nil {
+				return fmt.Errorf("error restocking order items: %w", err)
+			}
+		}
 
-	return &s, nil
-}
+		_, err = tx.ExecContext(ctx, "DELETE FROM order_items WHERE order_id=?", id)
+		if err != nil {
+			return fmt.Errorf("error deleting order items: %w", err)
+		}
 
-func (ms *MySQLStorer) RevokeSession(ctx context.Context, id string) error {
-	_, err := ms.db.NamedExecContext(ctx, "UPDATE sessions SET is_revoked=1 WHERE id=:id", map[string]interface{}{"id": id})
-	if err != nil {
-		return fmt.Errorf("error revoking session: %w", err)
-	}
+		_, err = tx.ExecContext(ctx, "DELETE FROM orders WHERE id=?", id)
+		if err != nil {
+			return fmt.Errorf("error deleting order: %w", err)
+		}
 
-	return nil
-}
+		// events of the order that are still pending would fail to be
+		// delivered without it
+		_, err = tx.ExecContext(ctx, "DELETE FROM outbox_events WHERE aggregate_type=? AND aggregate_id=? AND published_at IS NULL", orderAggregate, id)
+		if err != nil {
+			return fmt.Errorf("error deleting outbox events: %w", err)
+		}
 
-func (ms *MySQLStorer) DeleteSession(ctx context.Context, id string) error {
-	_, err := ms.db.ExecContext(ctx, "DELETE FROM sessions WHERE id=?", id)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error deleting session: %w", err)
+		return fmt.Errorf("error deleting order: %w", err)
 	}
 
 	return nil
 }
 
-func insertNotificationState(ctx context.Context, tx *sqlx.Tx, es *NotificationState) (*NotificationState, error) {
-	res, err := tx.NamedExecContext(ctx, "INSERT INTO notification_states (order_id, state, message) VALUES (:order_id, :state, :message)", es)
+func (ms *MySQLStorer) GetCart(ctx context.Context, userID int64) (*Cart, error) {
+	var c Cart
+	err := ms.db.GetContext(ctx, &c, "SELECT * FROM carts WHERE user_id=?", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Cart{UserID: userID}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error inserting notification state: %w", err)
+		return nil, fmt.Errorf("error getting cart: %w", err)
 	}
 
-	id, err := res.LastInsertId()
+	err = ms.db.SelectContext(ctx, &c.Items, "SELECT cart_items.id, cart_items.cart_id, cart_items.product_id, cart_items.quantity, cart_items.created_at, products.name, products.image, products.price FROM cart_items JOIN products ON products.id = cart_items.product_id WHERE cart_items.cart_id=? ORDER BY cart_items.id", c.ID)
 	if err != nil {
-		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+		return nil, fmt.Errorf("error getting cart items: %w", err)
 	}
-	es.ID = id
 
-	return es, nil
+	return &c, nil
 }
 
-func insertNotificationEvent(ctx context.Context, tx *sqlx.Tx, u *NotificationEvent) (*NotificationEvent, error) {
-	res, err := tx.NamedExecContext(ctx, "INSERT INTO notification_events_queue (user_email, order_status, order_id, state_id, attempts) VALUES (:user_email, :order_status, :order_id, :state_id, :attempts)", u)
-	if err != nil {
-		return nil, fmt.Errorf("error inserting notification event: %w", err)
-	}
+func (ms *MySQLStorer) AddToCart(ctx context.Context, userID, productID, quantity int64) (*Cart, error) {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		cartID, err := touchCart(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
 
-	id, err := res.LastInsertId()
+		_, err = tx.ExecContext(ctx, "INSERT INTO cart_items (cart_id, product_id, quantity) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE quantity=quantity+VALUES(quantity)", cartID, productID, quantity)
+		if err != nil {
+			return fmt.Errorf("error inserting cart item: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+		return nil, fmt.Errorf("error adding to cart: %w", err)
 	}
-	u.ID = id
 
-	return u, nil
+	return ms.GetCart(ctx, userID)
 }
 
-func (ms *MySQLStorer) EnqueueNotificationEvent(ctx context.Context, ne *NotificationEvent) (*NotificationEvent, error) {
-	var ev *NotificationEvent
+func (ms *MySQLStorer) UpdateCartItem(ctx context.Context, userID, productID, quantity int64) (*Cart, error) {
 	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
-		ns, err := insertNotificationState(ctx, tx, &NotificationState{
-			OrderID: ne.OrderID,
-			State:   NotSent,
-			Message: "",
-		})
+		id, err := cartItemID(ctx, tx, userID, productID)
 		if err != nil {
-			return fmt.Errorf("error inserting notification state: %w", err)
+			return err
 		}
-		ne.StateID = ns.ID
 
-		ev, err = insertNotificationEvent(ctx, tx, ne)
+		_, err = tx.ExecContext(ctx, "UPDATE cart_items SET quantity=? WHERE id=?", quantity, id)
 		if err != nil {
-			return fmt.Errorf("error inserting notification event: %w", err)
+			return fmt.Errorf("error updating cart item: %w", err)
 		}
 
-		return nil
+		_, err = touchCart(ctx, tx, userID)
+		return err
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error enqueuing notification event: %w", err)
+		return nil, fmt.Errorf("error updating cart item: %w", err)
 	}
 
-	return ev, nil
+	return ms.GetCart(ctx, userID)
 }
 
-func (ms *MySQLStorer) ListNotificationEvents(ctx context.Context) ([]*NotificationEvent, error) {
-	var events []*NotificationEvent
-
-	q := fmt.Sprintf("SELECT * FROM notification_events_queue WHERE attempts < %d ORDER BY created_at", maxAttempts)
-	err := ms.db.SelectContext(ctx, &events, q)
+func (ms *MySQLStorer) RemoveCartItem(ctx context.Context, userID, productID int64) (*Cart, error) {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		id, err := cartItemID(ctx, tx, userID, productID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM cart_items WHERE id=?", id)
+		if err != nil {
+			return fmt.Errorf("error deleting cart item: %w", err)
+		}
+
+		_, err = touchCart(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error removing cart item: %w", err)
+	}
+
+	return ms.GetCart(ctx, userID)
+}
+
+// Checkout creates o from cart and empties the cart in the same transaction
+// as CreateOrder. The cart row is locked while its items are compared with
+// cart.Items, so that an order is never built from a stale cart.
+func (ms *MySQLStorer) Checkout(ctx context.Context, cart *Cart, o *Order) (*Order, error) {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var cartID int64
+		err := tx.GetContext(ctx, &cartID, "SELECT id FROM carts WHERE user_id=? FOR UPDATE", cart.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrCartChanged
+			}
+			return fmt.Errorf("error locking cart: %w", err)
+		}
+
+		var items []CartItem
+		err = tx.SelectContext(ctx, &items, "SELECT product_id, quantity FROM cart_items WHERE cart_id=?", cartID)
+		if err != nil {
+			return fmt.Errorf("error getting cart items: %w", err)
+		}
+		if !sameCartItems(items, cart.Items) {
+			return ErrCartChanged
+		}
+
+		err = insertOrder(ctx, tx, o)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id=?", cartID)
+		if err != nil {
+			return fmt.Errorf("error clearing cart: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error checking out cart: %w", err)
+	}
+
+	return o, nil
+}
+
+// touchCart creates the cart of a user if it does not exist yet, marks it as
+// updated and returns its ID.
+func touchCart(ctx context.Context, tx *sqlx.Tx, userID int64) (int64, error) {
+	res, err := tx.ExecContext(ctx, "INSERT INTO carts (user_id) VALUES (?) ON DUPLICATE KEY UPDATE id=LAST_INSERT_ID(id), updated_at=CURRENT_TIMESTAMP", userID)
+	if err != nil {
+		return 0, fmt.Errorf("error upserting cart: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+
+	return id, nil
+}
+
+func cartItemID(ctx context.Context, tx *sqlx.Tx, userID, productID int64) (int64, error) {
+	var id int64
+	err := tx.GetContext(ctx, &id, "SELECT cart_items.id FROM cart_items JOIN carts ON carts.id = cart_items.cart_id WHERE carts.user_id=? AND cart_items.product_id=? FOR UPDATE", userID, productID)
+	if err != nil {
+		return 0, fmt.Errorf("error getting cart item: %w", err)
+	}
+
+	return id, nil
+}
+
+// sameCartItems reports whether two carts hold the same quantity of every
+// product.
+func sameCartItems(a, b []CartItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	quantities := make(map[int64]int64, len(a))
+	for _, ci := range a {
+		quantities[ci.ProductID] = ci.Quantity
+	}
+	for _, ci := range b {
+		if q, ok := quantities[ci.ProductID]; !ok || q != ci.Quantity {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CreateReview adds the review of a user for a product and recomputes the
+// rating of the product in the same transaction. Writes to the reviews of a
+// product are serialized by locking the product row.
+func (ms *MySQLStorer) CreateReview(ctx context.Context, r *Review) (*Review, error) {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		err := lockProduct(ctx, tx, r.ProductID)
+		if err != nil {
+			return err
+		}
+
+		var id int64
+		err = tx.GetContext(ctx, &id, "SELECT id FROM reviews WHERE user_id=? AND product_id=?", r.UserID, r.ProductID)
+		if err == nil {
+			return ErrReviewExists
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("error getting review: %w", err)
+		}
+
+		res, err := tx.NamedExecContext(ctx, "INSERT INTO reviews (user_id, product_id, stars, title, body) VALUES (:user_id, :product_id, :stars, :title, :body)", r)
+		if err != nil {
+			return fmt.Errorf("error inserting review: %w", err)
+		}
+
+		id, err = res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("error getting last insert ID: %w", err)
+		}
+		r.ID = id
+
+		return updateProductRating(ctx, tx, r.ProductID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating review: %w", err)
+	}
+
+	return ms.GetReview(ctx, r.ID)
+}
+
+func (ms *MySQLStorer) GetReview(ctx context.Context, id int64) (*Review, error) {
+	var r Review
+	err := ms.db.GetContext(ctx, &r, "SELECT * FROM reviews WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting review: %w", err)
+	}
+
+	return &r, nil
+}
+
+func (ms *MySQLStorer) ListReviews(ctx context.Context, productID int64, p Page) ([]*Review, string, error) {
+	pq, err := resolvePage(p, reviewSortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	conds, args := pq.where([]string{"product_id=?"}, []interface{}{productID})
+
+	var reviews []*Review
+	err = ms.db.SelectContext(ctx, &reviews, "SELECT * FROM reviews"+whereClause(conds)+pq.orderBy(), args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing reviews: %w", err)
+	}
+
+	reviews, next := paginate(pq, reviews, reviewPosition(pq.key))
+	return reviews, next, nil
+}
+
+func (ms *MySQLStorer) UpdateReview(ctx context.Context, r *Review) (*Review, error) {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		err := lockProduct(ctx, tx, r.ProductID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NamedExecContext(ctx, "UPDATE reviews SET stars=:stars, title=:title, body=:body, updated_at=:updated_at WHERE id=:id", r)
+		if err != nil {
+			return fmt.Errorf("error updating review: %w", err)
+		}
+
+		return updateProductRating(ctx, tx, r.ProductID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating review: %w", err)
+	}
+
+	return ms.GetReview(ctx, r.ID)
+}
+
+func (ms *MySQLStorer) DeleteReview(ctx context.Context, id int64) error {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var productID int64
+		err := tx.GetContext(ctx, &productID, "SELECT product_id FROM reviews WHERE id=?", id)
+		if err != nil {
+			return fmt.Errorf("error getting review: %w", err)
+		}
+
+		err = lockProduct(ctx, tx, productID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM reviews WHERE id=?", id)
+		if err != nil {
+			return fmt.Errorf("error deleting review: %w", err)
+		}
+
+		return updateProductRating(ctx, tx, productID)
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting review: %w", err)
+	}
+
+	return nil
+}
+
+// HasPurchased reports whether the user has an order for the product that was
+// paid for and not refunded or cancelled.
+func (ms *MySQLStorer) HasPurchased(ctx context.Context, userID, productID int64) (bool, error) {
+	q, args, err := sqlx.In("SELECT EXISTS (SELECT 1 FROM order_items JOIN orders ON orders.id = order_items.order_id WHERE orders.user_id=? AND order_items.product_id=? AND orders.status IN (?))", userID, productID, purchasedStatuses)
+	if err != nil {
+		return false, fmt.Errorf("error building purchase query: %w", err)
+	}
+
+	var purchased bool
+	err = ms.db.GetContext(ctx, &purchased, ms.db.Rebind(q), args...)
+	if err != nil {
+		return false, fmt.Errorf("error checking purchase: %w", err)
+	}
+
+	return purchased, nil
+}
+
+func lockProduct(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	var pid int64
+	err := tx.GetContext(ctx, &pid, "SELECT id FROM products WHERE id=? FOR UPDATE", id)
+	if err != nil {
+		return fmt.Errorf("error locking product: %w", err)
+	}
+
+	return nil
+}
+
+// updateProductRating recomputes the rating and review count of a product
+// from its reviews.
+func updateProductRating(ctx context.Context, tx *sqlx.Tx, productID int64) error {
+	_, err := tx.ExecContext(ctx, "UPDATE products SET rating=(SELECT COALESCE(AVG(stars), 0) FROM reviews WHERE product_id=?), num_reviews=(SELECT COUNT(*) FROM reviews WHERE product_id=?) WHERE id=?", productID, productID, productID)
+	if err != nil {
+		return fmt.Errorf("error updating product rating: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) execTx(ctx context.Context, fn func(*sqlx.Tx) error) error {
+	tx, err := ms.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	err = fn(tx)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("error rolling back transaction: %w", rbErr)
+		}
+		return fmt.Errorf("error in transaction: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) CreateUser(ctx context.Context, u *User) (*User, error) {
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO users (name, email, password, is_admin, locale) VALUES (:name, :email, :password, :is_admin, :locale)", u)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting user: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	u.ID = id
+
+	return u, nil
+}
+
+func (ms *MySQLStorer) GetUser(ctx context.Context, email string) (*User, error) {
+	var u User
+	err := ms.db.GetContext(ctx, &u, "SELECT * FROM users WHERE email=?", email)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+
+	return &u, nil
+}
+
+func (ms *MySQLStorer) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	var u User
+	err := ms.db.GetContext(ctx, &u, "SELECT * FROM users WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+
+	return &u, nil
+}
+
+func (ms *MySQLStorer) ListUsers(ctx context.Context, f UserFilter) ([]*User, string, error) {
+	pq, err := resolvePage(f.Page, userSortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if f.IsAdmin != nil {
+		conds = append(conds, "is_admin=?")
+		args = append(args, *f.IsAdmin)
+	}
+	conds, args = pq.where(conds, args)
+
+	var users []*User
+	err = ms.db.SelectContext(ctx, &users, "SELECT * FROM users"+whereClause(conds)+pq.orderBy(), args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing users: %w", err)
+	}
+
+	users, next := paginate(pq, users, userPosition(pq.key))
+	return users, next, nil
+}
+
+func (ms *MySQLStorer) UpdateUser(ctx context.Context, u *User) (*User, error) {
+	_, err := ms.db.NamedExecContext(ctx, "UPDATE users SET name=:name, email=:email, password=:password, is_admin=:is_admin, locale=:locale, updated_at=:updated_at WHERE id=:id", u)
+	if err != nil {
+		return nil, fmt.Errorf("error updating user: %w", err)
+	}
+
+	return u, nil
+}
+
+func (ms *MySQLStorer) DeleteUser(ctx context.Context, id int64) error {
+	_, err := ms.db.ExecContext(ctx, "DELETE FROM users WHERE id=?", id)
+	if err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) CreateSession(ctx context.Context, s *Session) (*Session, error) {
+	if s.FamilyID == "" {
+		s.FamilyID = s.ID
+	}
+
+	_, err := ms.db.NamedExecContext(ctx, insertSessionQuery, s)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting session: %w", err)
+	}
+
+	return s, nil
+}
+
+const insertSessionQuery = "INSERT INTO sessions (id, parent_id, family_id, user_email, refresh_token, user_agent, client_ip, is_revoked, last_used_at, expires_at) VALUES (:id, :parent_id, :family_id, :user_email, :refresh_token, :user_agent, :client_ip, :is_revoked, :last_used_at, :expires_at)"
+
+// RotateSession marks the session id rotated and creates next in its family.
+// Rotating a session twice revokes its family and returns
+// ErrRefreshTokenReused.
+func (ms *MySQLStorer) RotateSession(ctx context.Context, id string, next *Session) (*Session, error) {
+	reused := false
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var parent Session
+		err := tx.GetContext(ctx, &parent, "SELECT * FROM sessions WHERE id=? FOR UPDATE", id)
+		if err != nil {
+			return fmt.Errorf("error getting session: %w", err)
+		}
+		if parent.IsRevoked {
+			return ErrSessionRevoked
+		}
+		if parent.RotatedAt != nil {
+			// committed, unlike the error it leads to
+			_, err = tx.ExecContext(ctx, "UPDATE sessions SET is_revoked=1 WHERE family_id=?", parent.FamilyID)
+			if err != nil {
+				return fmt.Errorf("error revoking session family: %w", err)
+			}
+			reused = true
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE sessions SET rotated_at=? WHERE id=?", time.Now(), parent.ID)
+		if err != nil {
+			return fmt.Errorf("error rotating session: %w", err)
+		}
+
+		next.ParentID = parent.ID
+		next.FamilyID = parent.FamilyID
+		next.UserEmail = parent.UserEmail
+		_, err = tx.NamedExecContext(ctx, insertSessionQuery, next)
+		if err != nil {
+			return fmt.Errorf("error inserting session: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, fmt.Errorf("%w: session %s", ErrRefreshTokenReused, id)
+	}
+
+	return next, nil
+}
+
+func (ms *MySQLStorer) GetSession(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	err := ms.db.GetContext(ctx, &s, "SELECT * FROM sessions WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting session: %w", err)
+	}
+
+	return &s, nil
+}
+
+// ListSessionsForUser lists the user's active sessions, one per device, most
+// recently renewed first.
+func (ms *MySQLStorer) ListSessionsForUser(ctx context.Context, email string) ([]*Session, error) {
+	var sessions []*Session
+	err := ms.db.SelectContext(ctx, &sessions, "SELECT * FROM sessions WHERE user_email=? AND is_revoked=0 AND rotated_at IS NULL AND expires_at>? ORDER BY created_at DESC", email, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (ms *MySQLStorer) RevokeSession(ctx context.Context, id string) error {
+	_, err := ms.db.NamedExecContext(ctx, "UPDATE sessions SET is_revoked=1 WHERE id=:id", map[string]interface{}{"id": id})
+	if err != nil {
+		return fmt.Errorf("error revoking session: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) RevokeAllSessions(ctx context.Context, email string) error {
+	_, err := ms.db.ExecContext(ctx, "UPDATE sessions SET is_revoked=1 WHERE user_email=?", email)
+	if err != nil {
+		return fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) DeleteSession(ctx context.Context, id string) error {
+	_, err := ms.db.ExecContext(ctx, "DELETE FROM sessions WHERE id=?", id)
+	if err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+
+	return nil
+}
+
+// CreatePasswordResetToken stores t and enqueues ne, the email that sends the
+// token to the user, in one transaction.
+func (ms *MySQLStorer) CreatePasswordResetToken(ctx context.Context, t *PasswordResetToken, ne *NotificationEvent) (*PasswordResetToken, error) {
+	var ev *NotificationEvent
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.NamedExecContext(ctx, "INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (:user_id, :token_hash, :expires_at)", t)
+		if err != nil {
+			return fmt.Errorf("error inserting password reset token: %w", err)
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("error getting last insert ID: %w", err)
+		}
+		t.ID = id
+
+		ev, err = enqueueNotificationEvent(ctx, tx, ne)
+		if err != nil {
+			return fmt.Errorf("error enqueuing notification event: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating password reset token: %w", err)
+	}
+	ms.onEnqueue(ev)
+
+	return t, nil
+}
+
+// ResetPassword sets the password of the user the token with tokenHash was
+// issued to. The token and any other outstanding tokens of the user are used
+// up and the user's sessions are revoked. It returns
+// ErrPasswordResetTokenInvalid when the token doesn't exist, has expired or
+// was already used.
+func (ms *MySQLStorer) ResetPassword(ctx context.Context, tokenHash, password string) (*User, error) {
+	var u User
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var t PasswordResetToken
+		err := tx.GetContext(ctx, &t, "SELECT * FROM password_reset_tokens WHERE token_hash=? FOR UPDATE", tokenHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPasswordResetTokenInvalid
+		}
+		if err != nil {
+			return fmt.Errorf("error getting password reset token: %w", err)
+		}
+
+		now := time.Now()
+		if t.UsedAt != nil || !now.Before(t.ExpiresAt) {
+			return ErrPasswordResetTokenInvalid
+		}
+
+		err = tx.GetContext(ctx, &u, "SELECT * FROM users WHERE id=? FOR UPDATE", t.UserID)
+		if err != nil {
+			return fmt.Errorf("error getting user: %w", err)
+		}
+
+		u.Password = password
+		u.UpdatedAt = &now
+		_, err = tx.ExecContext(ctx, "UPDATE users SET password=?, updated_at=? WHERE id=?", u.Password, u.UpdatedAt, u.ID)
+		if err != nil {
+			return fmt.Errorf("error updating user: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE password_reset_tokens SET used_at=? WHERE user_id=? AND used_at IS NULL", now, u.ID)
+		if err != nil {
+			return fmt.Errorf("error using password reset tokens: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE sessions SET is_revoked=1 WHERE user_email=?", u.Email)
+		if err != nil {
+			return fmt.Errorf("error revoking sessions: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error resetting password: %w", err)
+	}
+
+	return &u, nil
+}
+
+func insertOutboxEvent(ctx context.Context, tx *sqlx.Tx, ev *OutboxEvent) error {
+	ev.CreatedAt = time.Now()
+	res, err := tx.NamedExecContext(ctx, "INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, payload, created_at) VALUES (:aggregate_type, :aggregate_id, :event_type, :payload, :created_at)", ev)
+	if err != nil {
+		return fmt.Errorf("error inserting outbox event: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	ev.ID = id
+
+	return nil
+}
+
+// ListOutboxEvents lists up to limit events that have been neither published
+// nor parked yet, oldest first.
+func (ms *MySQLStorer) ListOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	var events []*OutboxEvent
+	err := ms.db.SelectContext(ctx, &events, "SELECT * FROM outbox_events WHERE published_at IS NULL AND parked_at IS NULL ORDER BY id LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing outbox events: %w", err)
+	}
+
+	return events, nil
+}
+
+func (ms *MySQLStorer) MarkOutboxEventPublished(ctx context.Context, id int64) error {
+	res, err := ms.db.ExecContext(ctx, "UPDATE outbox_events SET published_at=? WHERE id=?", time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("error marking outbox event published: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("error marking outbox event published: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// FailOutboxEvent records that delivering the event failed with message and
+// parks it once it has failed maxAttempts times.
+func (ms *MySQLStorer) FailOutboxEvent(ctx context.Context, id int64, message string, maxAttempts int64) (*OutboxEvent, error) {
+	var ev OutboxEvent
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(ctx, &ev, "SELECT * FROM outbox_events WHERE id=? FOR UPDATE", id)
+		if err != nil {
+			return fmt.Errorf("error getting outbox event: %w", err)
+		}
+
+		failOutboxEvent(&ev, message, maxAttempts, time.Now())
+		_, err = tx.NamedExecContext(ctx, "UPDATE outbox_events SET attempts=:attempts, last_error=:last_error, parked_at=:parked_at WHERE id=:id", &ev)
+		if err != nil {
+			return fmt.Errorf("error updating outbox event: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error failing outbox event: %w", err)
+	}
+
+	return &ev, nil
+}
+
+func insertNotificationState(ctx context.Context, tx *sqlx.Tx, es *NotificationState) (*NotificationState, error) {
+	res, err := tx.NamedExecContext(ctx, "INSERT INTO notification_states (order_id, user_email, order_status, notification_type, outbox_event_id, state, message) VALUES (:order_id, :user_email, :order_status, :notification_type, :outbox_event_id, :state, :message)", es)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting notification state: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	es.ID = id
+
+	return es, nil
+}
+
+func insertNotificationEvent(ctx context.Context, tx *sqlx.Tx, u *NotificationEvent) (*NotificationEvent, error) {
+	res, err := tx.NamedExecContext(ctx, "INSERT INTO notification_events_queue (user_email, order_status, order_id, notification_type, payload, state_id, attempts, next_attempt_at) VALUES (:user_email, :order_status, :order_id, :notification_type, :payload, :state_id, :attempts, :next_attempt_at)", u)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting notification event: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	u.ID = id
+
+	return u, nil
+}
+
+// EnqueueNotificationEvent enqueues a notification. A notification for an
+// outbox event is enqueued at most once; enqueuing it again returns
+// ErrAlreadyEnqueued. The unique key on outbox_event_id fails the loser of two
+// concurrent enqueues, which then sees ErrAlreadyEnqueued when it retries.
+func (ms *MySQLStorer) EnqueueNotificationEvent(ctx context.Context, ne *NotificationEvent) (*NotificationEvent, error) {
+	var ev *NotificationEvent
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		ev, err = enqueueNotificationEvent(ctx, tx, ne)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error enqueuing notification event: %w", err)
+	}
+	ms.onEnqueue(ev)
+
+	return ev, nil
+}
+
+func enqueueNotificationEvent(ctx context.Context, tx *sqlx.Tx, ne *NotificationEvent) (*NotificationEvent, error) {
+	if ne.NotificationType == "" {
+		ne.NotificationType = OrderNotification(ne.OrderStatus)
+	}
+
+	var outboxEventID *int64
+	if ne.OutboxEventID != 0 {
+		outboxEventID = &ne.OutboxEventID
+
+		var id int64
+		err := tx.GetContext(ctx, &id, "SELECT id FROM notification_states WHERE outbox_event_id=?", ne.OutboxEventID)
+		if err == nil {
+			return nil, ErrAlreadyEnqueued
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("error getting notification state: %w", err)
+		}
+	}
+
+	ns, err := insertNotificationState(ctx, tx, &NotificationState{
+		OrderID:          ne.OrderID,
+		UserEmail:        ne.UserEmail,
+		OrderStatus:      ne.OrderStatus,
+		NotificationType: ne.NotificationType,
+		OutboxEventID:    outboxEventID,
+		State:            NotSent,
+		Message:          "",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error inserting notification state: %w", err)
+	}
+	ne.StateID = ns.ID
+	ne.NextAttemptAt = time.Now()
+
+	ev, err := insertNotificationEvent(ctx, tx, ne)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting notification event: %w", err)
+	}
+
+	return ev, nil
+}
+
+// ListNotificationEvents lists the events that are due to be attempted and not
+// leased to a worker. The list is read-only: an event must be claimed with
+// ClaimNotificationEvents before it can be acknowledged or nacked.
+func (ms *MySQLStorer) ListNotificationEvents(ctx context.Context) ([]*NotificationEvent, error) {
+	var events []*NotificationEvent
+
+	now := time.Now()
+	err := ms.db.SelectContext(ctx, &events, "SELECT * FROM notification_events_queue WHERE attempts < ? AND next_attempt_at <= ? AND (leased_until IS NULL OR leased_until <= ?) ORDER BY created_at", ms.retry.MaxAttempts, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("error listing notification events: %w", err)
+	}
+
+	return events, nil
+}
+
+// ClaimNotificationEvents leases up to batch events to workerID for the lease
+// duration. Only events that are due are claimed. Rows locked by a concurrent
+// claim are skipped rather than waited on, so replicas never claim the same
+// event. Events whose lease has expired are claimable again.
+func (ms *MySQLStorer) ClaimNotificationEvents(ctx context.Context, workerID string, batch int, lease time.Duration) ([]*NotificationEvent, error) {
+	var events []*NotificationEvent
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now().Truncate(time.Second)
+		err := tx.SelectContext(ctx, &events, "SELECT * FROM notification_events_queue WHERE attempts < ? AND next_attempt_at <= ? AND (leased_until IS NULL OR leased_until <= ?) ORDER BY created_at, id LIMIT ? FOR UPDATE SKIP LOCKED", ms.retry.MaxAttempts, now, now, batch)
+		if err != nil {
+			return fmt.Errorf("error selecting notification events: %w", err)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		until := now.Add(lease)
+		ids := make([]int64, 0, len(events))
+		for _, ev := range events {
+			ids = append(ids, ev.ID)
+			ev.LeasedBy = workerID
+			ev.LeasedUntil = &until
+		}
+
+		q, args, err := sqlx.In("UPDATE notification_events_queue SET leased_by=?, leased_until=? WHERE id IN (?)", workerID, until, ids)
+		if err != nil {
+			return fmt.Errorf("error building lease query: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, tx.Rebind(q), args...)
+		if err != nil {
+			return fmt.Errorf("error leasing notification events: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error listing notification events: %w", err)
+		return nil, fmt.Errorf("error claiming notification events: %w", err)
 	}
 
 	return events, nil
 }
 
-func getNotificationEventAttempts(ctx context.Context, tx *sqlx.Tx, id int64) (*NotificationEvent, error) {
+func getNotificationEventLease(ctx context.Context, tx *sqlx.Tx, id int64) (*NotificationEvent, error) {
 	var u NotificationEvent
-	err := tx.GetContext(ctx, &u, "SELECT id, attempts FROM notification_events_queue WHERE id=?", id)
+	err := tx.GetContext(ctx, &u, "SELECT id, state_id, attempts, leased_by, leased_until FROM notification_events_queue WHERE id=? FOR UPDATE", id)
 	if err != nil {
 		return nil, fmt.Errorf("error getting notification event: %w", err)
 	}
@@ -396,8 +1369,17 @@ func getNotificationEventAttempts(ctx context.Context, tx *sqlx.Tx, id int64) (*
 	return &u, nil
 }
 
+// holdsLease reports whether workerID holds an unexpired lease on u.
+func holdsLease(u *NotificationEvent, workerID string, now time.Time) bool {
+	return leaseHeldBy(u.LeasedBy, u.LeasedUntil, workerID, now)
+}
+
+func leaseHeldBy(leasedBy string, leasedUntil *time.Time, workerID string, now time.Time) bool {
+	return workerID != "" && leasedBy == workerID && leasedUntil != nil && leasedUntil.After(now)
+}
+
 func updateNotificationEventAttempts(ctx context.Context, tx *sqlx.Tx, u *NotificationEvent) (*NotificationEvent, error) {
-	_, err := tx.NamedExecContext(ctx, "UPDATE notification_events_queue SET attempts=:attempts, updated_at=:updated_at WHERE id=:id", u)
+	_, err := tx.NamedExecContext(ctx, "UPDATE notification_events_queue SET attempts=:attempts, next_attempt_at=:next_attempt_at, leased_by=:leased_by, leased_until=:leased_until, updated_at=:updated_at WHERE id=:id", u)
 	if err != nil {
 		return nil, fmt.Errorf("error updating notification event: %w", err)
 	}
@@ -416,7 +1398,7 @@ func deleteNotificationEvent(ctx context.Context, tx *sqlx.Tx, id int64) error {
 
 func updateNotificationState(ctx context.Context, tx *sqlx.Tx, es *NotificationState) error {
 	q := "UPDATE notification_states SET state=:state, message=:message WHERE id=:id"
-	if es.State == Sent {
+	if es.State == Sent || es.State == Suppressed {
 		t := time.Now()
 		es.CompletedAt = &t
 		q = "UPDATE notification_states SET state=:state, message=:message, completed_at=:completed_at WHERE id=:id"
@@ -431,61 +1413,564 @@ func updateNotificationState(ctx context.Context, tx *sqlx.Tx, es *NotificationS
 }
 
 func (ms *MySQLStorer) UpdateNotificationEvent(ctx context.Context, ev *NotificationEvent, es *NotificationState, responseType NotificationResponseType) (bool, error) {
+	if !responseType.valid() {
+		return false, fmt.Errorf("invalid notification response type: %v", responseType)
+	}
+
 	succeeded := false
 	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
-		switch responseType {
-		case NotificationSucess:
-			err := updateNotificationState(ctx, tx, &NotificationState{
-				ID:      ev.StateID,
-				State:   Sent,
+		var err error
+		succeeded, err = ms.updateNotificationEvent(ctx, tx, ev, es, responseType, time.Now())
+		return err
+	})
+
+	return succeeded, err
+}
+
+// UpdateNotificationEvents records the outcome of several events in one
+// transaction, e.g. of the events sent together in a digest. If any of them
+// can't be updated, none are.
+func (ms *MySQLStorer) UpdateNotificationEvents(ctx context.Context, updates []*NotificationUpdate) ([]bool, error) {
+	for _, u := range updates {
+		if !u.ResponseType.valid() {
+			return nil, fmt.Errorf("invalid notification response type: %v", u.ResponseType)
+		}
+	}
+
+	succeeded := make([]bool, len(updates))
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now()
+		for i, u := range updates {
+			var err error
+			succeeded[i], err = ms.updateNotificationEvent(ctx, tx, u.Event, u.State, u.ResponseType, now)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return succeeded, nil
+}
+
+func (ms *MySQLStorer) updateNotificationEvent(ctx context.Context, tx *sqlx.Tx, ev *NotificationEvent, es *NotificationState, responseType NotificationResponseType, now time.Time) (bool, error) {
+	u, err := getNotificationEventLease(ctx, tx, ev.ID)
+	if err != nil {
+		return false, fmt.Errorf("error getting notification event: %w", err)
+	}
+	if !holdsLease(u, ev.LeasedBy, now) {
+		return false, fmt.Errorf("%w: event %d", ErrNotLeaseHolder, ev.ID)
+	}
+
+	if responseType == NotificationSuppressed {
+		// nothing was sent, so there is no attempt to record
+		err = updateNotificationState(ctx, tx, &NotificationState{
+			ID:      u.StateID,
+			State:   Suppressed,
+			Message: es.Message,
+		})
+		if err != nil {
+			return false, fmt.Errorf("error updating notification state: %w", err)
+		}
+
+		return false, deleteNotificationEvent(ctx, tx, ev.ID)
+	}
+
+	err = insertNotificationAttempt(ctx, tx, &NotificationAttempt{
+		StateID:     u.StateID,
+		Attempt:     u.Attempts + 1,
+		Succeeded:   responseType == NotificationSucess,
+		Message:     es.Message,
+		AttemptedAt: now,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error inserting notification attempt: %w", err)
+	}
+
+	switch responseType {
+	case NotificationSucess:
+		err := updateNotificationState(ctx, tx, &NotificationState{
+			ID:      u.StateID,
+			State:   Sent,
+			Message: es.Message,
+		})
+		if err != nil {
+			return false, fmt.Errorf("error updating notification state: %w", err)
+		}
+
+		err = deleteNotificationEvent(ctx, tx, ev.ID)
+		if err != nil {
+			return false, fmt.Errorf("error deleting notification event: %w", err)
+		}
+
+		return true, nil
+	case NotificationFailure:
+		if u.Attempts+1 < ms.retry.MaxAttempts {
+			// release the lease so the event is claimed again once it is
+			// due
+			u.UpdatedAt = &now
+			u.Attempts += 1
+			u.NextAttemptAt = now.Add(ms.retry.Delay(u.Attempts))
+			u.LeasedBy = ""
+			u.LeasedUntil = nil
+
+			_, err = updateNotificationEventAttempts(ctx, tx, u)
+			if err != nil {
+				return false, fmt.Errorf("error updating notification event: %w", err)
+			}
+		} else {
+			err = updateNotificationState(ctx, tx, &NotificationState{
+				ID:      u.StateID,
+				State:   Failed,
 				Message: es.Message,
 			})
+			if err != nil {
+				return false, fmt.Errorf("error updating notification state: %w", err)
+			}
+
+			err = deleteNotificationEvent(ctx, tx, u.ID)
+			if err != nil {
+				return false, fmt.Errorf("error deleting notification event: %w", err)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func insertNotificationAttempt(ctx context.Context, tx *sqlx.Tx, a *NotificationAttempt) error {
+	_, err := tx.NamedExecContext(ctx, "INSERT INTO notification_attempts (state_id, attempt, succeeded, message, attempted_at) VALUES (:state_id, :attempt, :succeeded, :message, :attempted_at)", a)
+	if err != nil {
+		return fmt.Errorf("error inserting notification attempt: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) ListNotificationAttempts(ctx context.Context, stateID int64) ([]*NotificationAttempt, error) {
+	var attempts []*NotificationAttempt
+	err := ms.db.SelectContext(ctx, &attempts, "SELECT * FROM notification_attempts WHERE state_id=? ORDER BY id", stateID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing notification attempts: %w", err)
+	}
+
+	return attempts, nil
+}
+
+func (ms *MySQLStorer) GetNotificationState(ctx context.Context, id int64) (*NotificationState, error) {
+	var ns NotificationState
+	err := ms.db.GetContext(ctx, &ns, "SELECT * FROM notification_states WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting notification state: %w", err)
+	}
+
+	return &ns, nil
+}
+
+func (ms *MySQLStorer) ListNotificationStates(ctx context.Context, f NotificationStateFilter) ([]*NotificationState, string, error) {
+	pq, err := resolvePage(f.Page, notificationStateSortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if f.OrderID != 0 {
+		conds = append(conds, "order_id=?")
+		args = append(args, f.OrderID)
+	}
+	if f.State != "" {
+		conds = append(conds, "state=?")
+		args = append(args, f.State)
+	}
+	if f.RequestedAfter != nil {
+		conds = append(conds, "requested_at>=?")
+		args = append(args, *f.RequestedAfter)
+	}
+	if f.RequestedBefore != nil {
+		conds = append(conds, "requested_at<?")
+		args = append(args, *f.RequestedBefore)
+	}
+	conds, args = pq.where(conds, args)
+
+	var states []*NotificationState
+	err = ms.db.SelectContext(ctx, &states, "SELECT * FROM notification_states"+whereClause(conds)+pq.orderBy(), args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing notification states: %w", err)
+	}
+
+	states, next := paginate(pq, states, notificationStatePosition(pq.key))
+	return states, next, nil
+}
+
+func (ms *MySQLStorer) ListNotificationReplays(ctx context.Context, stateID int64) ([]*NotificationReplay, error) {
+	var replays []*NotificationReplay
+	err := ms.db.SelectContext(ctx, &replays, "SELECT * FROM notification_replays WHERE state_id=? ORDER BY id", stateID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing notification replays: %w", err)
+	}
+
+	return replays, nil
+}
+
+// ReplayNotifications puts failed notifications back on the queue with their
+// attempts reset and records who did so. Either all of them are replayed or,
+// if any is missing or has not failed, none are.
+func (ms *MySQLStorer) ReplayNotifications(ctx context.Context, stateIDs []int64, actorID int64) ([]*NotificationEvent, error) {
+	if len(stateIDs) == 0 {
+		return nil, nil
+	}
+
+	var events []*NotificationEvent
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		q, args, err := sqlx.In("SELECT * FROM notification_states WHERE id IN (?) ORDER BY id FOR UPDATE", stateIDs)
+		if err != nil {
+			return fmt.Errorf("error building notification states query: %w", err)
+		}
+
+		var states []*NotificationState
+		err = tx.SelectContext(ctx, &states, tx.Rebind(q), args...)
+		if err != nil {
+			return fmt.Errorf("error locking notification states: %w", err)
+		}
+
+		err = checkReplayable(states, stateIDs)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, ns := range states {
+			_, err = tx.ExecContext(ctx, "UPDATE notification_states SET state=?, message=?, completed_at=NULL WHERE id=?", NotSent, "", ns.ID)
 			if err != nil {
 				return fmt.Errorf("error updating notification state: %w", err)
 			}
 
-			err = deleteNotificationEvent(ctx, tx, ev.ID)
+			_, err = tx.ExecContext(ctx, "INSERT INTO notification_replays (state_id, actor_id, previous_message) VALUES (?, ?, ?)", ns.ID, actorID, ns.Message)
+			if err != nil {
+				return fmt.Errorf("error inserting notification replay: %w", err)
+			}
+
+			ev, err := insertNotificationEvent(ctx, tx, &NotificationEvent{
+				UserEmail:        ns.UserEmail,
+				OrderStatus:      ns.OrderStatus,
+				OrderID:          ns.OrderID,
+				NotificationType: ns.NotificationType,
+				StateID:          ns.ID,
+				NextAttemptAt:    now,
+			})
 			if err != nil {
-				return fmt.Errorf("error deleting notification event: %w", err)
+				return fmt.Errorf("error inserting notification event: %w", err)
 			}
-			succeeded = true
-		case NotificationFailure:
-			u, err := getNotificationEventAttempts(ctx, tx, ev.ID)
+			events = append(events, ev)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error replaying notifications: %w", err)
+	}
+	ms.onEnqueue(events...)
+
+	return events, nil
+}
+
+// ListNotificationPreferences lists the preferences a user has set, by type
+// and channel.
+func (ms *MySQLStorer) ListNotificationPreferences(ctx context.Context, userID int64) ([]*NotificationPreference, error) {
+	var prefs []*NotificationPreference
+	err := ms.db.SelectContext(ctx, &prefs, "SELECT * FROM notification_preferences WHERE user_id=? ORDER BY notification_type, channel", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing notification preferences: %w", err)
+	}
+
+	return prefs, nil
+}
+
+// SetNotificationPreferences creates or updates the preferences of a user.
+// Preferences that are not given are left as they are.
+func (ms *MySQLStorer) SetNotificationPreferences(ctx context.Context, userID int64, prefs []*NotificationPreference) error {
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now()
+		for _, p := range prefs {
+			p.UserID = userID
+			p.UpdatedAt = now
+			_, err := tx.NamedExecContext(ctx, "INSERT INTO notification_preferences (user_id, notification_type, channel, enabled, updated_at) VALUES (:user_id, :notification_type, :channel, :enabled, :updated_at) ON DUPLICATE KEY UPDATE enabled=VALUES(enabled), updated_at=VALUES(updated_at)", p)
 			if err != nil {
-				return fmt.Errorf("error getting notification event: %w", err)
+				return fmt.Errorf("error upserting notification preference: %w", err)
 			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error setting notification preferences: %w", err)
+	}
+
+	return nil
+}
+
+func (ms *MySQLStorer) CreateWebhook(ctx context.Context, w *Webhook) (*Webhook, error) {
+	w.CreatedAt = time.Now()
+	res, err := ms.db.NamedExecContext(ctx, "INSERT INTO webhooks (url, event_types, secret, active, created_at) VALUES (:url, :event_types, :secret, :active, :created_at)", w)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting webhook: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	w.ID = id
+
+	return w, nil
+}
+
+func (ms *MySQLStorer) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	var w Webhook
+	err := ms.db.GetContext(ctx, &w, "SELECT * FROM webhooks WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting webhook: %w", err)
+	}
+
+	return &w, nil
+}
+
+func (ms *MySQLStorer) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	var webhooks []*Webhook
+	err := ms.db.SelectContext(ctx, &webhooks, "SELECT * FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+func (ms *MySQLStorer) UpdateWebhook(ctx context.Context, w *Webhook) (*Webhook, error) {
+	_, err := ms.db.NamedExecContext(ctx, "UPDATE webhooks SET url=:url, event_types=:event_types, secret=:secret, active=:active, updated_at=:updated_at WHERE id=:id", w)
+	if err != nil {
+		return nil, fmt.Errorf("error updating webhook: %w", err)
+	}
+
+	return w, nil
+}
+
+// DeleteWebhook deletes a webhook together with its deliveries.
+func (ms *MySQLStorer) DeleteWebhook(ctx context.Context, id int64) error {
+	_, err := ms.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id=?", id)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+
+	return nil
+}
+
+func insertWebhookDelivery(ctx context.Context, tx *sqlx.Tx, d *WebhookDelivery) error {
+	res, err := tx.NamedExecContext(ctx, "INSERT INTO webhook_deliveries (webhook_id, outbox_event_id, event_type, payload, occurred_at, state, attempts, next_attempt_at, created_at) VALUES (:webhook_id, :outbox_event_id, :event_type, :payload, :occurred_at, :state, :attempts, :next_attempt_at, :created_at)", d)
+	if err != nil {
+		return fmt.Errorf("error inserting webhook delivery: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting last insert ID: %w", err)
+	}
+	d.ID = id
+
+	return nil
+}
+
+// EnqueueWebhookDeliveries queues a delivery of an outbox event to every
+// active webhook subscribed to its type. An event is queued for a webhook at
+// most once, so enqueuing it again only queues it for webhooks created since.
+func (ms *MySQLStorer) EnqueueWebhookDeliveries(ctx context.Context, ev *OutboxEvent) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		var webhooks []*Webhook
+		err := tx.SelectContext(ctx, &webhooks, "SELECT * FROM webhooks WHERE active=true ORDER BY id")
+		if err != nil {
+			return fmt.Errorf("error listing webhooks: %w", err)
+		}
 
-			if u.Attempts+1 < maxAttempts {
-				t := time.Now()
-				u.UpdatedAt = &t
-				u.Attempts += 1
-
-				_, err = updateNotificationEventAttempts(ctx, tx, u)
-				if err != nil {
-					return fmt.Errorf("error updating notification event: %w", err)
-				}
-			} else {
-				err = updateNotificationState(ctx, tx, &NotificationState{
-					ID:      ev.StateID,
-					State:   Failed,
-					Message: es.Message,
-				})
-				if err != nil {
-					return fmt.Errorf("error updating notification state: %w", err)
-				}
-
-				err = deleteNotificationEvent(ctx, tx, u.ID)
-				if err != nil {
-					return fmt.Errorf("error deleting notification event: %w", err)
-				}
+		var enqueued []int64
+		err = tx.SelectContext(ctx, &enqueued, "SELECT webhook_id FROM webhook_deliveries WHERE outbox_event_id=?", ev.ID)
+		if err != nil {
+			return fmt.Errorf("error listing webhook deliveries: %w", err)
+		}
+
+		now := time.Now()
+		for _, w := range webhooks {
+			if !w.EventTypes.Has(ev.EventType) || slices.Contains(enqueued, w.ID) {
+				continue
 			}
 
-		default:
-			return fmt.Errorf("invalid notification response type: %v", responseType)
+			d := newWebhookDelivery(w.ID, ev, now)
+			err := insertWebhookDelivery(ctx, tx, d)
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, d)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("error enqueuing webhook deliveries: %w", err)
+	}
 
-	return succeeded, err
+	return deliveries, nil
+}
+
+func newWebhookDelivery(webhookID int64, ev *OutboxEvent, now time.Time) *WebhookDelivery {
+	return &WebhookDelivery{
+		WebhookID:     webhookID,
+		OutboxEventID: ev.ID,
+		EventType:     ev.EventType,
+		Payload:       ev.Payload,
+		OccurredAt:    ev.CreatedAt,
+		State:         DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// ClaimWebhookDeliveries leases up to batch pending deliveries that are due to
+// workerID, skipping the ones of inactive webhooks.
+func (ms *MySQLStorer) ClaimWebhookDeliveries(ctx context.Context, workerID string, batch int, lease time.Duration) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now().Truncate(time.Second)
+		err := tx.SelectContext(ctx, &deliveries, "SELECT * FROM webhook_deliveries WHERE state=? AND next_attempt_at <= ? AND (leased_until IS NULL OR leased_until <= ?) AND webhook_id IN (SELECT id FROM webhooks WHERE active=true) ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED", DeliveryPending, now, now, batch)
+		if err != nil {
+			return fmt.Errorf("error selecting webhook deliveries: %w", err)
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		until := now.Add(lease)
+		ids := make([]int64, 0, len(deliveries))
+		for _, d := range deliveries {
+			ids = append(ids, d.ID)
+			d.LeasedBy = workerID
+			d.LeasedUntil = &until
+		}
+
+		q, args, err := sqlx.In("UPDATE webhook_deliveries SET leased_by=?, leased_until=? WHERE id IN (?)", workerID, until, ids)
+		if err != nil {
+			return fmt.Errorf("error building lease query: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, tx.Rebind(q), args...)
+		if err != nil {
+			return fmt.Errorf("error leasing webhook deliveries: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// RecordWebhookDeliveryAttempt records an attempt at a delivery leased by
+// d.LeasedBy. A successful attempt completes the delivery; a failed one
+// schedules the next attempt or, once the retry policy gives up, fails it.
+func (ms *MySQLStorer) RecordWebhookDeliveryAttempt(ctx context.Context, d *WebhookDelivery, a *WebhookDeliveryAttempt) (*WebhookDelivery, error) {
+	var u WebhookDelivery
+	err := ms.execTx(ctx, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(ctx, &u, "SELECT * FROM webhook_deliveries WHERE id=? FOR UPDATE", d.ID)
+		if err != nil {
+			return fmt.Errorf("error getting webhook delivery: %w", err)
+		}
+		now := time.Now()
+		if u.State != DeliveryPending || !leaseHeldBy(u.LeasedBy, u.LeasedUntil, d.LeasedBy, now) {
+			return fmt.Errorf("%w: webhook delivery %d", ErrNotLeaseHolder, d.ID)
+		}
+
+		a.DeliveryID = u.ID
+		a.Attempt = u.Attempts + 1
+		a.AttemptedAt = now
+		_, err = tx.NamedExecContext(ctx, "INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, succeeded, message, attempted_at) VALUES (:delivery_id, :attempt, :status_code, :succeeded, :message, :attempted_at)", a)
+		if err != nil {
+			return fmt.Errorf("error inserting webhook delivery attempt: %w", err)
+		}
+
+		ms.completeWebhookDeliveryAttempt(&u, a, now)
+		_, err = tx.NamedExecContext(ctx, "UPDATE webhook_deliveries SET state=:state, attempts=:attempts, next_attempt_at=:next_attempt_at, leased_by=:leased_by, leased_until=:leased_until, completed_at=:completed_at WHERE id=:id", &u)
+		if err != nil {
+			return fmt.Errorf("error updating webhook delivery: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error recording webhook delivery attempt: %w", err)
+	}
+
+	return &u, nil
+}
+
+// completeWebhookDeliveryAttempt moves d on after attempt a and releases its
+// lease.
+func (o *options) completeWebhookDeliveryAttempt(d *WebhookDelivery, a *WebhookDeliveryAttempt, now time.Time) {
+	d.Attempts = a.Attempt
+	d.LeasedBy = ""
+	d.LeasedUntil = nil
+
+	switch {
+	case a.Succeeded:
+		d.State = DeliverySucceeded
+		d.CompletedAt = &now
+	case d.Attempts >= o.retry.MaxAttempts:
+		d.State = DeliveryFailed
+		d.CompletedAt = &now
+	default:
+		d.NextAttemptAt = now.Add(o.retry.Delay(d.Attempts))
+	}
+}
+
+func (ms *MySQLStorer) GetWebhookDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := ms.db.GetContext(ctx, &d, "SELECT * FROM webhook_deliveries WHERE id=?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting webhook delivery: %w", err)
+	}
+
+	return &d, nil
+}
+
+func (ms *MySQLStorer) ListWebhookDeliveries(ctx context.Context, webhookID int64, p Page) ([]*WebhookDelivery, string, error) {
+	pq, err := resolvePage(p, webhookDeliverySortFields)
+	if err != nil {
+		return nil, "", err
+	}
+
+	conds, args := pq.where([]string{"webhook_id=?"}, []interface{}{webhookID})
+
+	var deliveries []*WebhookDelivery
+	err = ms.db.SelectContext(ctx, &deliveries, "SELECT * FROM webhook_deliveries"+whereClause(conds)+pq.orderBy(), args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing webhook deliveries: %w", err)
+	}
+
+	deliveries, next := paginate(pq, deliveries, webhookDeliveryPosition(pq.key))
+	return deliveries, next, nil
+}
+
+func (ms *MySQLStorer) ListWebhookDeliveryAttempts(ctx context.Context, deliveryID int64) ([]*WebhookDeliveryAttempt, error) {
+	var attempts []*WebhookDeliveryAttempt
+	err := ms.db.SelectContext(ctx, &attempts, "SELECT * FROM webhook_delivery_attempts WHERE delivery_id=? ORDER BY id", deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhook delivery attempts: %w", err)
+	}
+
+	return attempts, nil
 }
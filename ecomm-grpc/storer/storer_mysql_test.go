@@ -2,10 +2,15 @@ package storer
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/dhij/ecomm/money"
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 )
@@ -29,7 +34,7 @@ func TestCreateProduct(t *testing.T) {
 		Description:  "test description",
 		Rating:       5,
 		NumReviews:   10,
-		Price:        100.0,
+		Price:        money.New(10000, "USD"),
 		CountInStock: 100,
 	}
 
@@ -40,7 +45,7 @@ func TestCreateProduct(t *testing.T) {
 		{
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO products (name, image, category, description, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
 				cp, err := st.CreateProduct(context.Background(), p)
 				require.NoError(t, err)
 				require.Equal(t, int64(1), cp.ID)
@@ -51,7 +56,7 @@ func TestCreateProduct(t *testing.T) {
 		{
 			name: "failed inserting product",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error inserting product"))
+				mock.ExpectExec("INSERT INTO products (name, image, category, description, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error inserting product"))
 				_, err := st.CreateProduct(context.Background(), p)
 				require.Error(t, err)
 				err = mock.ExpectationsWereMet()
@@ -61,7 +66,7 @@ func TestCreateProduct(t *testing.T) {
 		{
 			name: "failed getting last insert ID",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("error getting last insert ID")))
+				mock.ExpectExec("INSERT INTO products (name, image, category, description, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("error getting last insert ID")))
 				_, err := st.CreateProduct(context.Background(), p)
 				require.Error(t, err)
 				err = mock.ExpectationsWereMet()
@@ -88,7 +93,7 @@ func TestGetProduct(t *testing.T) {
 		Description:  "test description",
 		Rating:       5,
 		NumReviews:   10,
-		Price:        100.0,
+		Price:        money.New(10000, "USD"),
 		CountInStock: 100,
 	}
 
@@ -100,7 +105,7 @@ func TestGetProduct(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
-					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price, p.CountInStock, p.CreatedAt, p.UpdatedAt)
+					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price.Decimal(), p.CountInStock, p.CreatedAt, p.UpdatedAt)
 
 				mock.ExpectQuery("SELECT * FROM products WHERE id=?").WithArgs(1).WillReturnRows(rows)
 
@@ -144,7 +149,7 @@ func TestListProducts(t *testing.T) {
 		Description:  "test description",
 		Rating:       5,
 		NumReviews:   100,
-		Price:        99.99,
+		Price:        money.New(9999, "USD"),
 		CountInStock: 10,
 	}
 
@@ -156,12 +161,49 @@ func TestListProducts(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
-					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price, p.CountInStock, p.CreatedAt, p.UpdatedAt)
-				mock.ExpectQuery("SELECT * FROM products").WillReturnRows(rows)
+					AddRow(1, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, p.Price.Decimal(), p.CountInStock, p.CreatedAt, p.UpdatedAt)
+				mock.ExpectQuery("SELECT * FROM products ORDER BY id ASC LIMIT 51").WillReturnRows(rows)
 
-				products, err := st.ListProducts(context.Background())
+				products, next, err := st.ListProducts(context.Background(), ProductFilter{})
 				require.NoError(t, err)
 				require.Len(t, products, 1)
+				require.Empty(t, next)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "filtered page",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}).
+					AddRow(3, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, "80.00", p.CountInStock, p.CreatedAt, p.UpdatedAt).
+					AddRow(2, p.Name, p.Image, p.Category, p.Description, p.Rating, p.NumReviews, "70.00", p.CountInStock, p.CreatedAt, p.UpdatedAt)
+				mock.ExpectQuery("SELECT * FROM products WHERE category=? AND price<=? AND count_in_stock>0 AND (price < ? OR (price = ? AND id < ?)) ORDER BY price DESC, id DESC LIMIT 2").
+					WithArgs(p.Category, "100.00", "90.00", "90.00", 4).
+					WillReturnRows(rows)
+
+				maxPrice := money.New(10000, "USD")
+				products, next, err := st.ListProducts(context.Background(), ProductFilter{
+					Page:     Page{Limit: 1, Sort: "-price", Cursor: encodeCursor(cursor{Value: "90.00", ID: 4})},
+					Category: p.Category,
+					MaxPrice: &maxPrice,
+					InStock:  true,
+				})
+				require.NoError(t, err)
+				require.Len(t, products, 1)
+				require.Equal(t, int64(3), products[0].ID)
+				require.Equal(t, encodeCursor(cursor{Value: "80.00", ID: 3}), next)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "invalid sort key",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				_, _, err := st.ListProducts(context.Background(), ProductFilter{Page: Page{Sort: "password"}})
+				require.ErrorIs(t, err, ErrInvalidListParams)
 
 				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
@@ -170,9 +212,9 @@ func TestListProducts(t *testing.T) {
 		{
 			name: "failed querying products",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT * FROM products").WillReturnError(fmt.Errorf("error querying products"))
+				mock.ExpectQuery("SELECT * FROM products ORDER BY id ASC LIMIT 51").WillReturnError(fmt.Errorf("error querying products"))
 
-				_, err := st.ListProducts(context.Background())
+				_, _, err := st.ListProducts(context.Background(), ProductFilter{})
 				require.Error(t, err)
 
 				err = mock.ExpectationsWereMet()
@@ -190,29 +232,8 @@ func TestListProducts(t *testing.T) {
 }
 
 func TestUpdateProduct(t *testing.T) {
-	p := &Product{
-		ID:           1,
-		Name:         "test product",
-		Image:        "test.jpg",
-		Category:     "test category",
-		Description:  "test description",
-		Rating:       5,
-		NumReviews:   100,
-		Price:        99.99,
-		CountInStock: 10,
-	}
-
-	np := &Product{
-		ID:           1,
-		Name:         "new test product",
-		Image:        "test.jpg",
-		Category:     "test category",
-		Description:  "test description",
-		Rating:       5,
-		NumReviews:   100,
-		Price:        99.99,
-		CountInStock: 10,
-	}
+	productCols := []string{"id", "name", "image", "category", "description", "rating", "num_reviews", "price", "count_in_stock", "created_at", "updated_at"}
+	rename := func(p *Product) { p.Name = "new test product" }
 
 	tcs := []struct {
 		name string
@@ -221,18 +242,33 @@ func TestUpdateProduct(t *testing.T) {
 		{
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM products WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(productCols).AddRow(1, "test product", "test.jpg", "test category", "test description", 5, 100, "99.99", 7, time.Now(), nil))
+				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, price=?, count_in_stock=?, updated_at=? WHERE id=?").
+					WithArgs("new test product", "test.jpg", "test category", "test description", "99.99", 7, nil, 1).
 					WillReturnResult(sqlmock.NewResult(1, 1))
-				cp, err := st.CreateProduct(context.Background(), p)
-				require.NoError(t, err)
-				require.Equal(t, int64(1), cp.ID)
+				mock.ExpectCommit()
 
-				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=?, updated_at=? WHERE id=?").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				up, err := st.UpdateProduct(context.Background(), np)
+				up, err := st.UpdateProduct(context.Background(), 1, rename)
 				require.NoError(t, err)
 				require.Equal(t, int64(1), up.ID)
-				require.Equal(t, np.Name, up.Name)
+				require.Equal(t, "new test product", up.Name)
+				require.Equal(t, int64(7), up.CountInStock)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed getting product",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM products WHERE id=? FOR UPDATE").WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+
+				_, err := st.UpdateProduct(context.Background(), 1, rename)
+				require.ErrorIs(t, err, sql.ErrNoRows)
 
 				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
@@ -241,9 +277,14 @@ func TestUpdateProduct(t *testing.T) {
 		{
 			name: "failed updating product",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, rating=?, num_reviews=?, price=?, count_in_stock=?, updated_at=? WHERE id=?").
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM products WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(productCols).AddRow(1, "test product", "test.jpg", "test category", "test description", 5, 100, "99.99", 7, time.Now(), nil))
+				mock.ExpectExec("UPDATE products SET name=?, image=?, category=?, description=?, price=?, count_in_stock=?, updated_at=? WHERE id=?").
 					WillReturnError(fmt.Errorf("error updating product"))
-				_, err := st.UpdateProduct(context.Background(), p)
+				mock.ExpectRollback()
+
+				_, err := st.UpdateProduct(context.Background(), 1, rename)
 				require.Error(t, err)
 
 				err = mock.ExpectationsWereMet()
@@ -303,23 +344,23 @@ func TestCreateOrder(t *testing.T) {
 			Name:      "test product",
 			Quantity:  1,
 			Image:     "test.jpg",
-			Price:     99.99,
+			Price:     money.New(9999, "USD"),
 			ProductID: 1,
 		},
 		{
 			Name:      "test product 2",
 			Quantity:  2,
 			Image:     "test2.jpg",
-			Price:     199.99,
+			Price:     money.New(19999, "USD"),
 			ProductID: 2,
 		},
 	}
 
 	o := &Order{
 		PaymentMethod: "test payment method",
-		TaxPrice:      10.0,
-		ShippingPrice: 20.0,
-		TotalPrice:    129.99,
+		TaxPrice:      money.New(1000, "USD"),
+		ShippingPrice: money.New(2000, "USD"),
+		TotalPrice:    money.New(12999, "USD"),
 		Items:         ois,
 	}
 
@@ -331,9 +372,12 @@ func TestCreateOrder(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				expectReserveStock(mock)
+				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price, user_id) VALUES (?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectExec("INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, payload, created_at) VALUES (?, ?, ?, ?, ?)").
+					WithArgs("order", 1, OrderCreated, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 
 				co, err := st.CreateOrder(context.Background(), o)
@@ -348,7 +392,26 @@ func TestCreateOrder(t *testing.T) {
 			name: "failed creating order",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?)").WillReturnError(fmt.Errorf("error creating order"))
+				expectReserveStock(mock)
+				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price, user_id) VALUES (?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error creating order"))
+				mock.ExpectRollback()
+
+				_, err := st.CreateOrder(context.Background(), o)
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed writing outbox event",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReserveStock(mock)
+				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price, user_id) VALUES (?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectExec("INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, payload, created_at) VALUES (?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error writing outbox event"))
 				mock.ExpectRollback()
 
 				_, err := st.CreateOrder(context.Background(), o)
@@ -362,13 +425,33 @@ func TestCreateOrder(t *testing.T) {
 			name: "failed creating order item",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				expectReserveStock(mock)
+				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price, user_id) VALUES (?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnError(fmt.Errorf("error creating order item"))
 				mock.ExpectRollback()
 
 				_, err := st.CreateOrder(context.Background(), o)
 				require.Error(t, err)
 
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "insufficient stock",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				rows := sqlmock.NewRows([]string{"id", "count_in_stock"}).AddRow(1, 10).AddRow(2, 1)
+				mock.ExpectQuery("SELECT id, count_in_stock FROM products WHERE id IN (?, ?) ORDER BY id FOR UPDATE").WithArgs(1, 2).WillReturnRows(rows)
+				mock.ExpectRollback()
+
+				_, err := st.CreateOrder(context.Background(), o)
+				require.Error(t, err)
+
+				var stockErr *InsufficientStockError
+				require.ErrorAs(t, err, &stockErr)
+				require.Equal(t, []int64{2}, stockErr.ProductIDs)
+
 				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
 			},
@@ -389,23 +472,23 @@ func TestGetOrder(t *testing.T) {
 			Name:      "test product",
 			Quantity:  1,
 			Image:     "test.jpg",
-			Price:     99.99,
+			Price:     money.New(9999, "USD"),
 			ProductID: 1,
 		},
 		{
 			Name:      "test product 2",
 			Quantity:  2,
 			Image:     "test2.jpg",
-			Price:     199.99,
+			Price:     money.New(19999, "USD"),
 			ProductID: 2,
 		},
 	}
 
 	o := &Order{
 		PaymentMethod: "test payment method",
-		TaxPrice:      10.0,
-		ShippingPrice: 20.0,
-		TotalPrice:    129.99,
+		TaxPrice:      money.New(1000, "USD"),
+		ShippingPrice: money.New(2000, "USD"),
+		TotalPrice:    money.New(12999, "USD"),
 		Items:         ois,
 	}
 
@@ -417,13 +500,13 @@ func TestGetOrder(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
-					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
+					AddRow(1, o.PaymentMethod, o.TaxPrice.Decimal(), o.ShippingPrice.Decimal(), o.TotalPrice.Decimal(), o.CreatedAt, o.UpdatedAt)
 
 				mock.ExpectQuery("SELECT * FROM orders WHERE id=?").WithArgs(1).WillReturnRows(orows)
 
 				oirows := sqlmock.NewRows([]string{"id", "name", "quantity", "image", "price", "product_id", "order_id"}).
-					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price, ois[0].ProductID, 1).
-					AddRow(2, ois[1].Name, ois[1].Quantity, ois[1].Image, ois[1].Price, ois[1].ProductID, 1)
+					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price.Decimal(), ois[0].ProductID, 1).
+					AddRow(2, ois[1].Name, ois[1].Quantity, ois[1].Image, ois[1].Price.Decimal(), ois[1].ProductID, 1)
 
 				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).WillReturnRows(oirows)
 
@@ -459,7 +542,7 @@ func TestGetOrder(t *testing.T) {
 			name: "failed getting order items",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
-					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
+					AddRow(1, o.PaymentMethod, o.TaxPrice.Decimal(), o.ShippingPrice.Decimal(), o.TotalPrice.Decimal(), o.CreatedAt, o.UpdatedAt)
 
 				mock.ExpectQuery("SELECT * FROM orders WHERE id=?").WithArgs(1).WillReturnRows(orows)
 
@@ -476,9 +559,12 @@ func TestGetOrder(t *testing.T) {
 			name: "failed committing transaction",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price) VALUES (?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				expectReserveStock(mock)
+				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price, user_id) VALUES (?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectExec("INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, payload, created_at) VALUES (?, ?, ?, ?, ?)").
+					WithArgs("order", 1, OrderCreated, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit().WillReturnError(fmt.Errorf("error committing transaction"))
 
 				_, err := st.CreateOrder(context.Background(), o)
@@ -504,23 +590,23 @@ func TestListOrders(t *testing.T) {
 			Name:      "test product",
 			Quantity:  1,
 			Image:     "test.jpg",
-			Price:     99.99,
+			Price:     money.New(9999, "USD"),
 			ProductID: 1,
 		},
 		{
 			Name:      "test product 2",
 			Quantity:  2,
 			Image:     "test2.jpg",
-			Price:     199.99,
+			Price:     money.New(19999, "USD"),
 			ProductID: 2,
 		},
 	}
 
 	o := &Order{
 		PaymentMethod: "test payment method",
-		TaxPrice:      10.0,
-		ShippingPrice: 20.0,
-		TotalPrice:    129.99,
+		TaxPrice:      money.New(1000, "USD"),
+		ShippingPrice: money.New(2000, "USD"),
+		TotalPrice:    money.New(12999, "USD"),
 		Items:         ois,
 	}
 
@@ -532,19 +618,24 @@ func TestListOrders(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
-					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
+					AddRow(1, o.PaymentMethod, o.TaxPrice.Decimal(), o.ShippingPrice.Decimal(), o.TotalPrice.Decimal(), o.CreatedAt, o.UpdatedAt)
+
+				orows.AddRow(2, o.PaymentMethod, o.TaxPrice.Decimal(), o.ShippingPrice.Decimal(), o.TotalPrice.Decimal(), o.CreatedAt, o.UpdatedAt)
 
-				mock.ExpectQuery("SELECT * FROM orders").WillReturnRows(orows)
+				mock.ExpectQuery("SELECT * FROM orders WHERE user_id=? ORDER BY id ASC LIMIT 51").WithArgs(1).WillReturnRows(orows)
 
 				oirows := sqlmock.NewRows([]string{"id", "name", "quantity", "image", "price", "product_id", "order_id"}).
-					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price, ois[0].ProductID, 1).
-					AddRow(2, ois[1].Name, ois[1].Quantity, ois[1].Image, ois[1].Price, ois[1].ProductID, 1)
+					AddRow(1, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price.Decimal(), ois[0].ProductID, 1).
+					AddRow(2, ois[1].Name, ois[1].Quantity, ois[1].Image, ois[1].Price.Decimal(), ois[1].ProductID, 1).
+					AddRow(3, ois[0].Name, ois[0].Quantity, ois[0].Image, ois[0].Price.Decimal(), ois[0].ProductID, 2)
 
-				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).WillReturnRows(oirows)
+				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id IN (?, ?) ORDER BY id").WithArgs(1, 2).WillReturnRows(oirows)
 
-				mo, err := st.ListOrders(context.Background())
+				mo, _, err := st.ListOrders(context.Background(), OrderFilter{UserID: 1})
 				require.NoError(t, err)
-				require.Len(t, mo, 1)
+				require.Len(t, mo, 2)
+				require.Len(t, mo[0].Items, 2)
+				require.Len(t, mo[1].Items, 1)
 
 				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
@@ -553,9 +644,9 @@ func TestListOrders(t *testing.T) {
 		{
 			name: "failed querying orders",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT * FROM orders").WillReturnError(fmt.Errorf("error querying orders"))
+				mock.ExpectQuery("SELECT * FROM orders ORDER BY id ASC LIMIT 51").WillReturnError(fmt.Errorf("error querying orders"))
 
-				_, err := st.ListOrders(context.Background())
+				_, _, err := st.ListOrders(context.Background(), OrderFilter{})
 				require.Error(t, err)
 
 				err = mock.ExpectationsWereMet()
@@ -566,13 +657,13 @@ func TestListOrders(t *testing.T) {
 			name: "failed querying order items",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				orows := sqlmock.NewRows([]string{"id", "payment_method", "tax_price", "shipping_price", "total_price", "created_at", "updated_at"}).
-					AddRow(1, o.PaymentMethod, o.TaxPrice, o.ShippingPrice, o.TotalPrice, o.CreatedAt, o.UpdatedAt)
+					AddRow(1, o.PaymentMethod, o.TaxPrice.Decimal(), o.ShippingPrice.Decimal(), o.TotalPrice.Decimal(), o.CreatedAt, o.UpdatedAt)
 
-				mock.ExpectQuery("SELECT * FROM orders").WillReturnRows(orows)
+				mock.ExpectQuery("SELECT * FROM orders ORDER BY id ASC LIMIT 51").WillReturnRows(orows)
 
-				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).WillReturnError(fmt.Errorf("error querying order items"))
+				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id IN (?) ORDER BY id").WithArgs(1).WillReturnError(fmt.Errorf("error querying order items"))
 
-				_, err := st.ListOrders(context.Background())
+				_, _, err := st.ListOrders(context.Background(), OrderFilter{})
 				require.Error(t, err)
 
 				err = mock.ExpectationsWereMet()
@@ -589,6 +680,69 @@ func TestListOrders(t *testing.T) {
 	}
 }
 
+func TestUpdateOrderStatus(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, user_id, status FROM orders WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "status"}).AddRow(1, 3, "pending"))
+				expectRestock(mock)
+				mock.ExpectExec("UPDATE orders SET status=?, updated_at=? WHERE id=?").WithArgs(Cancelled, sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO order_status_history (order_id, from_status, to_status, actor_id, actor_role, reason, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)").
+					WithArgs(1, Pending, Cancelled, 2, CustomerActor, "changed my mind", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, payload, created_at) VALUES (?, ?, ?, ?, ?)").
+					WithArgs("order", 1, OrderStatusChanged, []byte(`{"order_id":1,"user_id":3,"from_status":"pending","status":"cancelled"}`), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery("SELECT * FROM orders WHERE id=?").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "cancelled"))
+				mock.ExpectQuery("SELECT * FROM order_items WHERE order_id=?").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "order_id"}))
+
+				c := &OrderStatusChange{OrderID: 1, FromStatus: Pending, ToStatus: Cancelled, ActorID: 2, ActorRole: CustomerActor, Reason: "changed my mind"}
+				o, err := st.UpdateOrderStatus(context.Background(), c)
+				require.NoError(t, err)
+				require.Equal(t, Cancelled, o.Status)
+				require.Equal(t, int64(1), c.ID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "invalid transition",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, user_id, status FROM orders WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "status"}).AddRow(1, 3, "delivered"))
+				mock.ExpectRollback()
+
+				_, err := st.UpdateOrderStatus(context.Background(), &OrderStatusChange{OrderID: 1, ToStatus: Pending})
+				var transitionErr *InvalidTransitionError
+				require.ErrorAs(t, err, &transitionErr)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
 func TestDeleteOrder(t *testing.T) {
 	tcs := []struct {
 		name string
@@ -598,8 +752,11 @@ func TestDeleteOrder(t *testing.T) {
 			name: "success",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				expectLockOrder(mock, "pending", false)
+				expectRestock(mock)
 				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM outbox_events WHERE aggregate_type=? AND aggregate_id=? AND published_at IS NULL").WithArgs("order", 1).WillReturnResult(sqlmock.NewResult(0, 2))
 				mock.ExpectCommit()
 
 				err := st.DeleteOrder(context.Background(), 1)
@@ -613,6 +770,8 @@ func TestDeleteOrder(t *testing.T) {
 			name: "failed deleting order item",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				expectLockOrder(mock, "pending", false)
+				expectRestock(mock)
 				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnError(fmt.Errorf("error deleting order item"))
 				mock.ExpectRollback()
 
@@ -627,6 +786,8 @@ func TestDeleteOrder(t *testing.T) {
 			name: "failed deleting order",
 			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				expectLockOrder(mock, "pending", false)
+				expectRestock(mock)
 				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnError(fmt.Errorf("error deleting order"))
 				mock.ExpectRollback()
@@ -634,6 +795,73 @@ func TestDeleteOrder(t *testing.T) {
 				err := st.DeleteOrder(context.Background(), 1)
 				require.Error(t, err)
 
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed deleting outbox events",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockOrder(mock, "pending", false)
+				expectRestock(mock)
+				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM outbox_events WHERE aggregate_type=? AND aggregate_id=? AND published_at IS NULL").WithArgs("order", 1).WillReturnError(fmt.Errorf("error deleting outbox events"))
+				mock.ExpectRollback()
+
+				err := st.DeleteOrder(context.Background(), 1)
+				require.Error(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "cancelled order",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				// cancelling restocked it already
+				mock.ExpectBegin()
+				expectLockOrder(mock, "cancelled", false)
+				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM outbox_events WHERE aggregate_type=? AND aggregate_id=? AND published_at IS NULL").WithArgs("order", 1).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+
+				err := st.DeleteOrder(context.Background(), 1)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "shipped order",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectLockOrder(mock, "refunded", true)
+				mock.ExpectExec("DELETE FROM order_items WHERE order_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM orders WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM outbox_events WHERE aggregate_type=? AND aggregate_id=? AND published_at IS NULL").WithArgs("order", 1).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+
+				err := st.DeleteOrder(context.Background(), 1)
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "missing order",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, user_id, status FROM orders WHERE id=? FOR UPDATE").WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectCommit()
+
+				err := st.DeleteOrder(context.Background(), 1)
+				require.NoError(t, err)
+
 				err = mock.ExpectationsWereMet()
 				require.NoError(t, err)
 			},
@@ -647,3 +875,786 @@ func TestDeleteOrder(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckout(t *testing.T) {
+	o := &Order{
+		PaymentMethod: "test payment method",
+		TotalPrice:    money.New(12999, "USD"),
+		UserID:        1,
+		Items: []OrderItem{
+			{Name: "test product", Quantity: 1, Image: "test.jpg", Price: money.New(9999, "USD"), ProductID: 1},
+			{Name: "test product 2", Quantity: 2, Image: "test2.jpg", Price: money.New(1500, "USD"), ProductID: 2},
+		},
+	}
+	cart := &Cart{
+		ID:     1,
+		UserID: 1,
+		Items:  []CartItem{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: 2}},
+	}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id FROM carts WHERE user_id=? FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery("SELECT product_id, quantity FROM cart_items WHERE cart_id=?").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"product_id", "quantity"}).AddRow(2, 2).AddRow(1, 1))
+				expectReserveStock(mock)
+				mock.ExpectExec("INSERT INTO orders (payment_method, tax_price, shipping_price, total_price, user_id) VALUES (?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (?, ?, ?, ?, ?, ?)").WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectExec("INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, payload, created_at) VALUES (?, ?, ?, ?, ?)").
+					WithArgs("order", 1, OrderCreated, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("DELETE FROM cart_items WHERE cart_id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+
+				co, err := st.Checkout(context.Background(), cart, o)
+				require.NoError(t, err)
+				require.Equal(t, int64(1), co.ID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "cart changed",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id FROM carts WHERE user_id=? FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery("SELECT product_id, quantity FROM cart_items WHERE cart_id=?").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"product_id", "quantity"}).AddRow(1, 3))
+				mock.ExpectRollback()
+
+				_, err := st.Checkout(context.Background(), cart, o)
+				require.ErrorIs(t, err, ErrCartChanged)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+func TestCreateReview(t *testing.T) {
+	r := &Review{UserID: 1, ProductID: 1, Stars: 4, Title: "great", Body: "works as advertised"}
+	reviewCols := []string{"id", "user_id", "product_id", "stars", "title", "body", "created_at", "updated_at"}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id FROM products WHERE id=? FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery("SELECT id FROM reviews WHERE user_id=? AND product_id=?").WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectExec("INSERT INTO reviews (user_id, product_id, stars, title, body) VALUES (?, ?, ?, ?, ?)").WithArgs(1, 1, 4, "great", "works as advertised").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE products SET rating=(SELECT COALESCE(AVG(stars), 0) FROM reviews WHERE product_id=?), num_reviews=(SELECT COUNT(*) FROM reviews WHERE product_id=?) WHERE id=?").WithArgs(1, 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery("SELECT * FROM reviews WHERE id=?").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(reviewCols).AddRow(1, 1, 1, 4, "great", "works as advertised", time.Now(), nil))
+
+				cr, err := st.CreateReview(context.Background(), r)
+				require.NoError(t, err)
+				require.Equal(t, int64(1), cr.ID)
+				require.Equal(t, int64(4), cr.Stars)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "already reviewed",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id FROM products WHERE id=? FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery("SELECT id FROM reviews WHERE user_id=? AND product_id=?").WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				mock.ExpectRollback()
+
+				_, err := st.CreateReview(context.Background(), r)
+				require.ErrorIs(t, err, ErrReviewExists)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+func expectReserveStock(mock sqlmock.Sqlmock) {
+	rows := sqlmock.NewRows([]string{"id", "count_in_stock"}).AddRow(1, 10).AddRow(2, 10)
+	mock.ExpectQuery("SELECT id, count_in_stock FROM products WHERE id IN (?, ?) ORDER BY id FOR UPDATE").WithArgs(1, 2).WillReturnRows(rows)
+	mock.ExpectExec("UPDATE products SET count_in_stock=count_in_stock-? WHERE id=?").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE products SET count_in_stock=count_in_stock-? WHERE id=?").WithArgs(2, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectLockOrder expects order 1 in status to be locked for deletion.
+func expectLockOrder(mock sqlmock.Sqlmock, status string, shipped bool) {
+	mock.ExpectQuery("SELECT id, user_id, status FROM orders WHERE id=? FOR UPDATE").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "status"}).AddRow(1, 3, status))
+	mock.ExpectQuery("SELECT EXISTS (SELECT 1 FROM order_status_history WHERE order_id=? AND to_status=?)").WithArgs(1, "shipped").
+		WillReturnRows(sqlmock.NewRows([]string{"shipped"}).AddRow(shipped))
+}
+
+func expectRestock(mock sqlmock.Sqlmock) {
+	rows := sqlmock.NewRows([]string{"product_id", "quantity"}).AddRow(1, 1).AddRow(2, 2)
+	mock.ExpectQuery("SELECT product_id, quantity FROM order_items WHERE order_id=?").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectExec("UPDATE products SET count_in_stock=count_in_stock+? WHERE id=?").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE products SET count_in_stock=count_in_stock+? WHERE id=?").WithArgs(2, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestClaimNotificationEvents(t *testing.T) {
+	eventCols := []string{"id", "user_email", "order_status", "order_id", "state_id", "attempts", "next_attempt_at", "leased_by", "leased_until", "created_at", "updated_at"}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM notification_events_queue WHERE attempts < ? AND next_attempt_at <= ? AND (leased_until IS NULL OR leased_until <= ?) ORDER BY created_at, id LIMIT ? FOR UPDATE SKIP LOCKED").WithArgs(DefaultRetryPolicy.MaxAttempts, sqlmock.AnyArg(), sqlmock.AnyArg(), 2).
+					WillReturnRows(sqlmock.NewRows(eventCols).
+						AddRow(1, "test@example.com", "pending", 1, 1, 0, time.Now(), "", nil, time.Now(), nil).
+						AddRow(2, "test@example.com", "paid", 1, 2, 1, time.Now(), "worker-2", time.Now().Add(-time.Minute), time.Now(), nil))
+				mock.ExpectExec("UPDATE notification_events_queue SET leased_by=?, leased_until=? WHERE id IN (?, ?)").WithArgs("worker-1", sqlmock.AnyArg(), 1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+
+				events, err := st.ClaimNotificationEvents(context.Background(), "worker-1", 2, time.Minute)
+				require.NoError(t, err)
+				require.Len(t, events, 2)
+				for _, ev := range events {
+					require.Equal(t, "worker-1", ev.LeasedBy)
+					require.NotNil(t, ev.LeasedUntil)
+				}
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "empty queue",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM notification_events_queue WHERE attempts < ? AND next_attempt_at <= ? AND (leased_until IS NULL OR leased_until <= ?) ORDER BY created_at, id LIMIT ? FOR UPDATE SKIP LOCKED").WithArgs(DefaultRetryPolicy.MaxAttempts, sqlmock.AnyArg(), sqlmock.AnyArg(), 2).
+					WillReturnRows(sqlmock.NewRows(eventCols))
+				mock.ExpectCommit()
+
+				events, err := st.ClaimNotificationEvents(context.Background(), "worker-1", 2, time.Minute)
+				require.NoError(t, err)
+				require.Empty(t, events)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+func TestUpdateNotificationEvent(t *testing.T) {
+	leaseCols := []string{"id", "state_id", "attempts", "leased_by", "leased_until"}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "failure backs off",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, state_id, attempts, leased_by, leased_until FROM notification_events_queue WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(leaseCols).AddRow(1, 1, 0, "worker-1", time.Now().Add(time.Minute)))
+				mock.ExpectExec("INSERT INTO notification_attempts (state_id, attempt, succeeded, message, attempted_at) VALUES (?, ?, ?, ?, ?)").WithArgs(1, 1, false, "failed", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE notification_events_queue SET attempts=?, next_attempt_at=?, leased_by=?, leased_until=?, updated_at=? WHERE id=?").WithArgs(1, sqlmock.AnyArg(), "", nil, sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				succeeded, err := st.UpdateNotificationEvent(context.Background(), &NotificationEvent{ID: 1, StateID: 1, LeasedBy: "worker-1"}, &NotificationState{Message: "failed"}, NotificationFailure)
+				require.NoError(t, err)
+				require.False(t, succeeded)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "suppressed",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, state_id, attempts, leased_by, leased_until FROM notification_events_queue WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(leaseCols).AddRow(1, 1, 0, "worker-1", time.Now().Add(time.Minute)))
+				mock.ExpectExec("UPDATE notification_states SET state=?, message=?, completed_at=? WHERE id=?").WithArgs(Suppressed, "suppressed", sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM notification_events_queue WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				succeeded, err := st.UpdateNotificationEvent(context.Background(), &NotificationEvent{ID: 1, StateID: 1, LeasedBy: "worker-1"}, &NotificationState{Message: "suppressed"}, NotificationSuppressed)
+				require.NoError(t, err)
+				require.False(t, succeeded)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "state of the queued event",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, state_id, attempts, leased_by, leased_until FROM notification_events_queue WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(leaseCols).AddRow(1, 5, 0, "worker-1", time.Now().Add(time.Minute)))
+				mock.ExpectExec("INSERT INTO notification_attempts (state_id, attempt, succeeded, message, attempted_at) VALUES (?, ?, ?, ?, ?)").WithArgs(5, 1, true, "sent", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE notification_states SET state=?, message=?, completed_at=? WHERE id=?").WithArgs(Sent, "sent", sqlmock.AnyArg(), 5).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM notification_events_queue WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				// the worker names a stale state
+				succeeded, err := st.UpdateNotificationEvent(context.Background(), &NotificationEvent{ID: 1, StateID: 2, LeasedBy: "worker-1"}, &NotificationState{Message: "sent"}, NotificationSucess)
+				require.NoError(t, err)
+				require.True(t, succeeded)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "batch",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				for _, id := range []int64{1, 2} {
+					mock.ExpectQuery("SELECT id, state_id, attempts, leased_by, leased_until FROM notification_events_queue WHERE id=? FOR UPDATE").WithArgs(id).
+						WillReturnRows(sqlmock.NewRows(leaseCols).AddRow(id, id, 0, "worker-1", time.Now().Add(time.Minute)))
+					mock.ExpectExec("INSERT INTO notification_attempts (state_id, attempt, succeeded, message, attempted_at) VALUES (?, ?, ?, ?, ?)").WithArgs(id, 1, true, "sent in digest", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(id, 1))
+					mock.ExpectExec("UPDATE notification_states SET state=?, message=?, completed_at=? WHERE id=?").WithArgs(Sent, "sent in digest", sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectExec("DELETE FROM notification_events_queue WHERE id=?").WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectCommit()
+
+				succeeded, err := st.UpdateNotificationEvents(context.Background(), []*NotificationUpdate{
+					{Event: &NotificationEvent{ID: 1, StateID: 1, LeasedBy: "worker-1"}, State: &NotificationState{Message: "sent in digest"}, ResponseType: NotificationSucess},
+					{Event: &NotificationEvent{ID: 2, StateID: 2, LeasedBy: "worker-1"}, State: &NotificationState{Message: "sent in digest"}, ResponseType: NotificationSucess},
+				})
+				require.NoError(t, err)
+				require.Equal(t, []bool{true, true}, succeeded)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "batch rolled back",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, state_id, attempts, leased_by, leased_until FROM notification_events_queue WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(leaseCols).AddRow(1, 1, 0, "worker-1", time.Now().Add(time.Minute)))
+				mock.ExpectExec("INSERT INTO notification_attempts (state_id, attempt, succeeded, message, attempted_at) VALUES (?, ?, ?, ?, ?)").WithArgs(1, 1, true, "sent in digest", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE notification_states SET state=?, message=?, completed_at=? WHERE id=?").WithArgs(Sent, "sent in digest", sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM notification_events_queue WHERE id=?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT id, state_id, attempts, leased_by, leased_until FROM notification_events_queue WHERE id=? FOR UPDATE").WithArgs(2).
+					WillReturnRows(sqlmock.NewRows(leaseCols).AddRow(2, 2, 0, "worker-2", time.Now().Add(time.Minute)))
+				mock.ExpectRollback()
+
+				_, err := st.UpdateNotificationEvents(context.Background(), []*NotificationUpdate{
+					{Event: &NotificationEvent{ID: 1, StateID: 1, LeasedBy: "worker-1"}, State: &NotificationState{Message: "sent in digest"}, ResponseType: NotificationSucess},
+					{Event: &NotificationEvent{ID: 2, StateID: 2, LeasedBy: "worker-1"}, State: &NotificationState{Message: "sent in digest"}, ResponseType: NotificationSucess},
+				})
+				require.ErrorIs(t, err, ErrNotLeaseHolder)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "ack without lease",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT id, state_id, attempts, leased_by, leased_until FROM notification_events_queue WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(leaseCols).AddRow(1, 1, 0, "worker-2", time.Now().Add(time.Minute)))
+				mock.ExpectRollback()
+
+				_, err := st.UpdateNotificationEvent(context.Background(), &NotificationEvent{ID: 1, StateID: 1, LeasedBy: "worker-1"}, &NotificationState{Message: "sent"}, NotificationSucess)
+				require.ErrorIs(t, err, ErrNotLeaseHolder)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+func TestReplayNotifications(t *testing.T) {
+	stateCols := []string{"id", "order_id", "user_email", "order_status", "notification_type", "state", "message", "requested_at", "completed_at"}
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "success",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM notification_states WHERE id IN (?) ORDER BY id FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(stateCols).AddRow(1, 1, "test@example.com", "shipped", "order.shipped", "failed", "mailbox full", time.Now(), nil))
+				mock.ExpectExec("UPDATE notification_states SET state=?, message=?, completed_at=NULL WHERE id=?").WithArgs("not sent", "", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO notification_replays (state_id, actor_id, previous_message) VALUES (?, ?, ?)").WithArgs(1, 2, "mailbox full").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO notification_events_queue (user_email, order_status, order_id, notification_type, payload, state_id, attempts, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").WithArgs("test@example.com", "shipped", 1, "order.shipped", []byte(nil), 1, 0, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(5, 1))
+				mock.ExpectCommit()
+
+				events, err := st.ReplayNotifications(context.Background(), []int64{1}, 2)
+				require.NoError(t, err)
+				require.Len(t, events, 1)
+				require.Equal(t, int64(5), events[0].ID)
+				require.Equal(t, int64(1), events[0].StateID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "not failed",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM notification_states WHERE id IN (?, ?) ORDER BY id FOR UPDATE").WithArgs(1, 2).
+					WillReturnRows(sqlmock.NewRows(stateCols).
+						AddRow(1, 1, "test@example.com", "shipped", "order.shipped", "failed", "mailbox full", time.Now(), nil).
+						AddRow(2, 1, "test@example.com", "delivered", "order.delivered", "sent", "sent", time.Now(), time.Now()))
+				mock.ExpectRollback()
+
+				_, err := st.ReplayNotifications(context.Background(), []int64{1, 2}, 2)
+				require.ErrorIs(t, err, ErrNotReplayable)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+func TestEnqueueWebhookDeliveries(t *testing.T) {
+	webhookCols := []string{"id", "url", "event_types", "secret", "active", "created_at", "updated_at"}
+	ev := &OutboxEvent{ID: 7, AggregateType: "order", AggregateID: 1, EventType: OrderStatusChanged, Payload: []byte(`{"order_id":1}`), CreatedAt: time.Now()}
+
+	withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+		st := NewMySQLStorer(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT * FROM webhooks WHERE active=true ORDER BY id").
+			WillReturnRows(sqlmock.NewRows(webhookCols).
+				AddRow(1, "https://created.example.com", "order.created", "secret", true, time.Now(), nil).
+				AddRow(2, "https://all.example.com", "order.created,order.status_changed", "secret", true, time.Now(), nil).
+				AddRow(3, "https://changes.example.com", "order.status_changed", "secret", true, time.Now(), nil))
+		mock.ExpectQuery("SELECT webhook_id FROM webhook_deliveries WHERE outbox_event_id=?").WithArgs(ev.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"webhook_id"}).AddRow(2))
+		mock.ExpectExec("INSERT INTO webhook_deliveries (webhook_id, outbox_event_id, event_type, payload, occurred_at, state, attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)").
+			WithArgs(3, ev.ID, OrderStatusChanged, ev.Payload, ev.CreatedAt, DeliveryPending, 0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		deliveries, err := st.EnqueueWebhookDeliveries(context.Background(), ev)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		require.Equal(t, int64(1), deliveries[0].ID)
+		require.Equal(t, int64(3), deliveries[0].WebhookID)
+
+		err = mock.ExpectationsWereMet()
+		require.NoError(t, err)
+	})
+}
+
+func TestRecordWebhookDeliveryAttempt(t *testing.T) {
+	deliveryCols := []string{"id", "webhook_id", "outbox_event_id", "event_type", "payload", "occurred_at", "state", "attempts", "next_attempt_at", "leased_by", "leased_until", "created_at", "completed_at"}
+	leased := func(attempts int64, leasedBy string) *sqlmock.Rows {
+		return sqlmock.NewRows(deliveryCols).
+			AddRow(1, 1, 7, "order.created", []byte(`{}`), time.Now(), "pending", attempts, time.Now(), leasedBy, time.Now().Add(time.Minute), time.Now(), nil)
+	}
+	insertAttempt := "INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, succeeded, message, attempted_at) VALUES (?, ?, ?, ?, ?, ?)"
+	updateDelivery := "UPDATE webhook_deliveries SET state=?, attempts=?, next_attempt_at=?, leased_by=?, leased_until=?, completed_at=? WHERE id=?"
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "delivered",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM webhook_deliveries WHERE id=? FOR UPDATE").WithArgs(1).WillReturnRows(leased(0, "worker-1"))
+				mock.ExpectExec(insertAttempt).WithArgs(1, 1, 200, true, "200 OK", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(updateDelivery).WithArgs(DeliverySucceeded, 1, sqlmock.AnyArg(), "", nil, sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				d, err := st.RecordWebhookDeliveryAttempt(context.Background(), &WebhookDelivery{ID: 1, LeasedBy: "worker-1"}, &WebhookDeliveryAttempt{StatusCode: 200, Succeeded: true, Message: "200 OK"})
+				require.NoError(t, err)
+				require.Equal(t, DeliverySucceeded, d.State)
+				require.NotNil(t, d.CompletedAt)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "retried",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM webhook_deliveries WHERE id=? FOR UPDATE").WithArgs(1).WillReturnRows(leased(0, "worker-1"))
+				mock.ExpectExec(insertAttempt).WithArgs(1, 1, 503, false, "503 Service Unavailable", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(updateDelivery).WithArgs(DeliveryPending, 1, sqlmock.AnyArg(), "", nil, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				d, err := st.RecordWebhookDeliveryAttempt(context.Background(), &WebhookDelivery{ID: 1, LeasedBy: "worker-1"}, &WebhookDeliveryAttempt{StatusCode: 503, Message: "503 Service Unavailable"})
+				require.NoError(t, err)
+				require.Equal(t, DeliveryPending, d.State)
+				require.True(t, d.NextAttemptAt.After(time.Now()))
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "failed",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM webhook_deliveries WHERE id=? FOR UPDATE").WithArgs(1).WillReturnRows(leased(DefaultRetryPolicy.MaxAttempts-1, "worker-1"))
+				mock.ExpectExec(insertAttempt).WithArgs(1, DefaultRetryPolicy.MaxAttempts, 0, false, "connection refused", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(updateDelivery).WithArgs(DeliveryFailed, DefaultRetryPolicy.MaxAttempts, sqlmock.AnyArg(), "", nil, sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				d, err := st.RecordWebhookDeliveryAttempt(context.Background(), &WebhookDelivery{ID: 1, LeasedBy: "worker-1"}, &WebhookDeliveryAttempt{Message: "connection refused"})
+				require.NoError(t, err)
+				require.Equal(t, DeliveryFailed, d.State)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "not lease holder",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM webhook_deliveries WHERE id=? FOR UPDATE").WithArgs(1).WillReturnRows(leased(0, "worker-2"))
+				mock.ExpectRollback()
+
+				_, err := st.RecordWebhookDeliveryAttempt(context.Background(), &WebhookDelivery{ID: 1, LeasedBy: "worker-1"}, &WebhookDeliveryAttempt{Succeeded: true})
+				require.ErrorIs(t, err, ErrNotLeaseHolder)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+var sessionCols = []string{"id", "parent_id", "family_id", "user_email", "refresh_token", "user_agent", "client_ip", "is_revoked", "created_at", "rotated_at", "last_used_at", "expires_at"}
+
+func TestRotateSession(t *testing.T) {
+	expiresAt := time.Now().Add(24 * time.Hour)
+	lastUsedAt := time.Now()
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "rotated",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM sessions WHERE id=? FOR UPDATE").WithArgs("s1").
+					WillReturnRows(sqlmock.NewRows(sessionCols).AddRow("s1", "", "s1", "test@example.com", "token-1", "", "", false, time.Now(), nil, nil, expiresAt))
+				mock.ExpectExec("UPDATE sessions SET rotated_at=? WHERE id=?").WithArgs(sqlmock.AnyArg(), "s1").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO sessions (id, parent_id, family_id, user_email, refresh_token, user_agent, client_ip, is_revoked, last_used_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)").
+					WithArgs("s2", "s1", "s1", "test@example.com", "token-2", "curl/8.0", "192.0.2.1", false, &lastUsedAt, expiresAt).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+
+				s, err := st.RotateSession(context.Background(), "s1", &Session{ID: "s2", RefreshToken: "token-2", UserAgent: "curl/8.0", ClientIP: "192.0.2.1", LastUsedAt: &lastUsedAt, ExpiresAt: expiresAt})
+				require.NoError(t, err)
+				require.Equal(t, "s1", s.ParentID)
+				require.Equal(t, "s1", s.FamilyID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "reused",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				// the family is revoked even though rotating fails
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM sessions WHERE id=? FOR UPDATE").WithArgs("s2").
+					WillReturnRows(sqlmock.NewRows(sessionCols).AddRow("s2", "s1", "s1", "test@example.com", "token-2", "", "", false, time.Now(), time.Now(), nil, expiresAt))
+				mock.ExpectExec("UPDATE sessions SET is_revoked=1 WHERE family_id=?").WithArgs("s1").WillReturnResult(sqlmock.NewResult(0, 3))
+				mock.ExpectCommit()
+
+				_, err := st.RotateSession(context.Background(), "s2", &Session{ID: "s4", RefreshToken: "token-4", ExpiresAt: expiresAt})
+				require.ErrorIs(t, err, ErrRefreshTokenReused)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "revoked",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM sessions WHERE id=? FOR UPDATE").WithArgs("s3").
+					WillReturnRows(sqlmock.NewRows(sessionCols).AddRow("s3", "s2", "s1", "test@example.com", "token-3", "", "", true, time.Now(), nil, nil, expiresAt))
+				mock.ExpectRollback()
+
+				_, err := st.RotateSession(context.Background(), "s3", &Session{ID: "s4", RefreshToken: "token-4", ExpiresAt: expiresAt})
+				require.ErrorIs(t, err, ErrSessionRevoked)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+// TestMySQLStorerConformance runs the shared Storer suite against a real,
+// migrated MySQL database. Every table is truncated before each case, so
+// point ECOMM_TEST_MYSQL_DSN at a throwaway database, e.g.
+// "root:password@tcp(127.0.0.1:3306)/ecomm_test?parseTime=true".
+func TestMySQLStorerConformance(t *testing.T) {
+	dsn := os.Getenv("ECOMM_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("ECOMM_TEST_MYSQL_DSN is not set")
+	}
+
+	db, err := sqlx.Open("mysql", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	testStorerConformance(t, func(t *testing.T, opts ...Option) Storer {
+		truncateTables(t, db)
+		return NewMySQLStorer(db, opts...)
+	})
+}
+
+func truncateTables(t *testing.T, db *sqlx.DB) {
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var tables []string
+	err = conn.SelectContext(ctx, &tables, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name <> 'schema_migrations'")
+	require.NoError(t, err)
+
+	_, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS=0")
+	require.NoError(t, err)
+	for _, table := range tables {
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("TRUNCATE TABLE `%s`", table))
+		require.NoError(t, err)
+	}
+	_, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS=1")
+	require.NoError(t, err)
+}
+
+func TestSessionsForUser(t *testing.T) {
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "list",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT * FROM sessions WHERE user_email=? AND is_revoked=0 AND rotated_at IS NULL AND expires_at>? ORDER BY created_at DESC").
+					WithArgs("test@example.com", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows(sessionCols).
+						AddRow("s2", "s1", "s1", "test@example.com", "token-2", "curl/8.0", "192.0.2.1", false, time.Now(), nil, time.Now(), time.Now().Add(time.Hour)).
+						AddRow("s3", "", "s3", "test@example.com", "token-3", "", "", false, time.Now(), nil, nil, time.Now().Add(time.Hour)))
+
+				sessions, err := st.ListSessionsForUser(context.Background(), "test@example.com")
+				require.NoError(t, err)
+				require.Len(t, sessions, 2)
+				require.Equal(t, "curl/8.0", sessions[0].UserAgent)
+				require.NotNil(t, sessions[0].LastUsedAt)
+				require.Nil(t, sessions[1].LastUsedAt)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "revoke all",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE sessions SET is_revoked=1 WHERE user_email=?").WithArgs("test@example.com").WillReturnResult(sqlmock.NewResult(0, 3))
+
+				err := st.RevokeAllSessions(context.Background(), "test@example.com")
+				require.NoError(t, err)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	tokenCols := []string{"id", "user_id", "token_hash", "expires_at", "used_at", "created_at"}
+	userCols := []string{"id", "name", "email", "password", "is_admin", "locale", "created_at", "updated_at"}
+	expiresAt := time.Now().Add(time.Hour)
+
+	tcs := []struct {
+		name string
+		test func(*testing.T, *MySQLStorer, sqlmock.Sqlmock)
+	}{
+		{
+			name: "create token",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				ne, err := NewPasswordResetNotification("test@example.com", PasswordReset{Token: "token", ExpiresAt: expiresAt})
+				require.NoError(t, err)
+
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (?, ?, ?)").WithArgs(1, "hash", expiresAt).WillReturnResult(sqlmock.NewResult(3, 1))
+				mock.ExpectExec("INSERT INTO notification_states (order_id, user_email, order_status, notification_type, outbox_event_id, state, message) VALUES (?, ?, ?, ?, ?, ?, ?)").
+					WithArgs(nil, "test@example.com", "", "account.password_reset", nil, "not sent", "").WillReturnResult(sqlmock.NewResult(4, 1))
+				mock.ExpectExec("INSERT INTO notification_events_queue (user_email, order_status, order_id, notification_type, payload, state_id, attempts, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)").
+					WithArgs("test@example.com", "", nil, "account.password_reset", ne.Payload, 4, 0, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(5, 1))
+				mock.ExpectCommit()
+
+				tok, err := st.CreatePasswordResetToken(context.Background(), &PasswordResetToken{UserID: 1, TokenHash: "hash", ExpiresAt: expiresAt}, ne)
+				require.NoError(t, err)
+				require.Equal(t, int64(3), tok.ID)
+				require.Equal(t, int64(5), ne.ID)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "reset",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM password_reset_tokens WHERE token_hash=? FOR UPDATE").WithArgs("hash").
+					WillReturnRows(sqlmock.NewRows(tokenCols).AddRow(3, 1, "hash", expiresAt, nil, time.Now()))
+				mock.ExpectQuery("SELECT * FROM users WHERE id=? FOR UPDATE").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(userCols).AddRow(1, "test user", "test@example.com", "old password", false, "en", time.Now(), nil))
+				mock.ExpectExec("UPDATE users SET password=?, updated_at=? WHERE id=?").WithArgs("new password", sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE password_reset_tokens SET used_at=? WHERE user_id=? AND used_at IS NULL").WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec("UPDATE sessions SET is_revoked=1 WHERE user_email=?").WithArgs("test@example.com").WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+
+				u, err := st.ResetPassword(context.Background(), "hash", "new password")
+				require.NoError(t, err)
+				require.Equal(t, "new password", u.Password)
+				require.NotNil(t, u.UpdatedAt)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "used token",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM password_reset_tokens WHERE token_hash=? FOR UPDATE").WithArgs("hash").
+					WillReturnRows(sqlmock.NewRows(tokenCols).AddRow(3, 1, "hash", expiresAt, time.Now(), time.Now()))
+				mock.ExpectRollback()
+
+				_, err := st.ResetPassword(context.Background(), "hash", "new password")
+				require.ErrorIs(t, err, ErrPasswordResetTokenInvalid)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "unknown token",
+			test: func(t *testing.T, st *MySQLStorer, mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT * FROM password_reset_tokens WHERE token_hash=? FOR UPDATE").WithArgs("unknown").WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+
+				_, err := st.ResetPassword(context.Background(), "unknown", "new password")
+				require.ErrorIs(t, err, ErrPasswordResetTokenInvalid)
+
+				err = mock.ExpectationsWereMet()
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestDB(t, func(db *sqlx.DB, mock sqlmock.Sqlmock) {
+				st := NewMySQLStorer(db)
+				tc.test(t, st, mock)
+			})
+		})
+	}
+}
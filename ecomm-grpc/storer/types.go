@@ -1,35 +1,125 @@
 package storer
 
-import "time"
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
 
+	"github.com/dhij/ecomm/money"
+)
+
+// Product is a catalogue entry. Rating and NumReviews are derived from the
+// reviews of the product and are only written by the review methods.
 type Product struct {
-	ID           int64      `db:"id"`
-	Name         string     `db:"name"`
-	Image        string     `db:"image"`
-	Category     string     `db:"category"`
-	Description  string     `db:"description"`
-	Rating       int64      `db:"rating"`
-	NumReviews   int64      `db:"num_reviews"`
-	Price        float32    `db:"price"`
-	CountInStock int64      `db:"count_in_stock"`
-	CreatedAt    time.Time  `db:"created_at"`
-	UpdatedAt    *time.Time `db:"updated_at"`
+	ID           int64       `db:"id"`
+	Name         string      `db:"name"`
+	Image        string      `db:"image"`
+	Category     string      `db:"category"`
+	Description  string      `db:"description"`
+	Rating       float64     `db:"rating"`
+	NumReviews   int64       `db:"num_reviews"`
+	Price        money.Money `db:"price"`
+	CountInStock int64       `db:"count_in_stock"`
+	CreatedAt    time.Time   `db:"created_at"`
+	UpdatedAt    *time.Time  `db:"updated_at"`
+}
+
+type ProductFilter struct {
+	Page
+	Category string
+	MinPrice *money.Money
+	MaxPrice *money.Money
+	InStock  bool
+}
+
+var productSortFields = map[string]sortField{
+	"id":             {column: "id", kind: sortInt},
+	"name":           {column: "name", kind: sortString},
+	"price":          {column: "price", kind: sortMoney},
+	"rating":         {column: "rating", kind: sortDecimal},
+	"count_in_stock": {column: "count_in_stock", kind: sortInt},
+	"created_at":     {column: "created_at", kind: sortTime},
+}
+
+func productPosition(key string) func(*Product) (string, int64) {
+	return func(p *Product) (string, int64) {
+		switch key {
+		case "name":
+			return p.Name, p.ID
+		case "price":
+			return p.Price.Decimal(), p.ID
+		case "rating":
+			return strconv.FormatFloat(p.Rating, 'f', 2, 64), p.ID
+		case "count_in_stock":
+			return strconv.FormatInt(p.CountInStock, 10), p.ID
+		case "created_at":
+			return formatCursorTime(p.CreatedAt), p.ID
+		default:
+			return strconv.FormatInt(p.ID, 10), p.ID
+		}
+	}
 }
 
 type OrderStatus string
 
 const (
-	Pending   OrderStatus = "pending"
-	Shipped   OrderStatus = "shipped"
-	Delivered OrderStatus = "delivered"
+	Pending         OrderStatus = "pending"
+	Paid            OrderStatus = "paid"
+	Processing      OrderStatus = "processing"
+	Shipped         OrderStatus = "shipped"
+	Delivered       OrderStatus = "delivered"
+	Cancelled       OrderStatus = "cancelled"
+	RefundRequested OrderStatus = "refund_requested"
+	Refunded        OrderStatus = "refunded"
 )
 
+// orderTransitions is the order lifecycle: the statuses an order may move to
+// from each status. Cancelled and refunded orders are final.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	Pending:         {Paid, Cancelled},
+	Paid:            {Processing, RefundRequested},
+	Processing:      {Shipped, RefundRequested},
+	Shipped:         {Delivered},
+	Delivered:       {RefundRequested},
+	RefundRequested: {Refunded},
+}
+
+// purchasedStatuses are the statuses of orders that count as a purchase of
+// their products: paid for and neither cancelled nor refunded.
+var purchasedStatuses = []OrderStatus{Paid, Processing, Shipped, Delivered, RefundRequested}
+
+// restocksOnTransition reports whether moving an order to status s puts its
+// items back in stock: cancelled orders and orders refunded before their goods
+// shipped.
+func restocksOnTransition(s OrderStatus, shipped bool) bool {
+	return s == Cancelled || (s == Refunded && !shipped)
+}
+
+// restocksOnDelete reports whether deleting an order in status s puts its items
+// back in stock: cancelling or refunding an order restocked it already and
+// goods that have shipped are gone.
+func restocksOnDelete(s OrderStatus, shipped bool) bool {
+	return s != Cancelled && s != Refunded && s != Shipped && s != Delivered && !shipped
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, t := range orderTransitions[s] {
+		if t == next {
+			return true
+		}
+	}
+	return false
+}
+
 type Order struct {
 	ID            int64       `db:"id"`
 	PaymentMethod string      `db:"payment_method"`
-	TaxPrice      float32     `db:"tax_price"`
-	ShippingPrice float32     `db:"shipping_price"`
-	TotalPrice    float32     `db:"total_price"`
+	TaxPrice      money.Money `db:"tax_price"`
+	ShippingPrice money.Money `db:"shipping_price"`
+	TotalPrice    money.Money `db:"total_price"`
 	UserID        int64       `db:"user_id"`
 	Status        OrderStatus `db:"status"`
 	CreatedAt     time.Time   `db:"created_at"`
@@ -37,14 +127,124 @@ type Order struct {
 	Items         []OrderItem
 }
 
+type OrderFilter struct {
+	Page
+	Status        OrderStatus
+	UserID        int64
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+var orderSortFields = map[string]sortField{
+	"id":          {column: "id", kind: sortInt},
+	"status":      {column: "status", kind: sortString},
+	"total_price": {column: "total_price", kind: sortMoney},
+	"created_at":  {column: "created_at", kind: sortTime},
+}
+
+func orderPosition(key string) func(*Order) (string, int64) {
+	return func(o *Order) (string, int64) {
+		switch key {
+		case "status":
+			return string(o.Status), o.ID
+		case "total_price":
+			return o.TotalPrice.Decimal(), o.ID
+		case "created_at":
+			return formatCursorTime(o.CreatedAt), o.ID
+		default:
+			return strconv.FormatInt(o.ID, 10), o.ID
+		}
+	}
+}
+
+type ActorRole string
+
+const (
+	CustomerActor ActorRole = "customer"
+	AdminActor    ActorRole = "admin"
+)
+
+// OrderStatusChange is one transition in the life of an order, recorded in
+// order_status_history together with who made it and why.
+type OrderStatusChange struct {
+	ID         int64       `db:"id"`
+	OrderID    int64       `db:"order_id"`
+	FromStatus OrderStatus `db:"from_status"`
+	ToStatus   OrderStatus `db:"to_status"`
+	ActorID    int64       `db:"actor_id"`
+	ActorRole  ActorRole   `db:"actor_role"`
+	Reason     string      `db:"reason"`
+	CreatedAt  time.Time   `db:"created_at"`
+}
+
 type OrderItem struct {
-	ID        int64   `db:"id"`
-	Name      string  `db:"name"`
-	Quantity  int64   `db:"quantity"`
-	Image     string  `db:"image"`
-	Price     float32 `db:"price"`
-	ProductID int64   `db:"product_id"`
-	OrderID   int64   `db:"order_id"`
+	ID        int64       `db:"id"`
+	Name      string      `db:"name"`
+	Quantity  int64       `db:"quantity"`
+	Image     string      `db:"image"`
+	Price     money.Money `db:"price"`
+	ProductID int64       `db:"product_id"`
+	OrderID   int64       `db:"order_id"`
+}
+
+// Cart is the shopping cart of a user. A user without a cart has an empty
+// Cart with a zero ID.
+type Cart struct {
+	ID        int64      `db:"id"`
+	UserID    int64      `db:"user_id"`
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt *time.Time `db:"updated_at"`
+	Items     []CartItem
+}
+
+// CartItem is a product in a cart. Name, Image and Price are read from the
+// product, so they are always the current catalogue values.
+type CartItem struct {
+	ID        int64       `db:"id"`
+	CartID    int64       `db:"cart_id"`
+	ProductID int64       `db:"product_id"`
+	Quantity  int64       `db:"quantity"`
+	Name      string      `db:"name"`
+	Image     string      `db:"image"`
+	Price     money.Money `db:"price"`
+	CreatedAt time.Time   `db:"created_at"`
+}
+
+// Review is the review of a product by a user. A user reviews a product at
+// most once.
+type Review struct {
+	ID        int64      `db:"id"`
+	UserID    int64      `db:"user_id"`
+	ProductID int64      `db:"product_id"`
+	Stars     int64      `db:"stars"`
+	Title     string     `db:"title"`
+	Body      string     `db:"body"`
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt *time.Time `db:"updated_at"`
+}
+
+const (
+	MinReviewStars = 1
+	MaxReviewStars = 5
+)
+
+var reviewSortFields = map[string]sortField{
+	"id":         {column: "id", kind: sortInt},
+	"stars":      {column: "stars", kind: sortInt},
+	"created_at": {column: "created_at", kind: sortTime},
+}
+
+func reviewPosition(key string) func(*Review) (string, int64) {
+	return func(r *Review) (string, int64) {
+		switch key {
+		case "stars":
+			return strconv.FormatInt(r.Stars, 10), r.ID
+		case "created_at":
+			return formatCursorTime(r.CreatedAt), r.ID
+		default:
+			return strconv.FormatInt(r.ID, 10), r.ID
+		}
+	}
 }
 
 type User struct {
@@ -53,50 +253,272 @@ type User struct {
 	Email     string     `db:"email"`
 	Password  string     `db:"password"`
 	IsAdmin   bool       `db:"is_admin"`
+	Locale    string     `db:"locale"`
 	CreatedAt time.Time  `db:"created_at"`
 	UpdatedAt *time.Time `db:"updated_at"`
 }
 
+type UserFilter struct {
+	Page
+	IsAdmin *bool
+}
+
+var userSortFields = map[string]sortField{
+	"id":         {column: "id", kind: sortInt},
+	"name":       {column: "name", kind: sortString},
+	"email":      {column: "email", kind: sortString},
+	"created_at": {column: "created_at", kind: sortTime},
+}
+
+func userPosition(key string) func(*User) (string, int64) {
+	return func(u *User) (string, int64) {
+		switch key {
+		case "name":
+			return u.Name, u.ID
+		case "email":
+			return u.Email, u.ID
+		case "created_at":
+			return formatCursorTime(u.CreatedAt), u.ID
+		default:
+			return strconv.FormatInt(u.ID, 10), u.ID
+		}
+	}
+}
+
+// Session is a refresh token. Renewing it rotates it: the session is marked
+// rotated and a new one is created in its family, with it as parent. A login
+// starts a new family, so the family's unrotated session stands for a device.
 type Session struct {
-	ID           string    `db:"id"`
-	UserEmail    string    `db:"user_email"`
-	RefreshToken string    `db:"refresh_token"`
-	IsRevoked    bool      `db:"is_revoked"`
-	CreatedAt    time.Time `db:"created_at"`
-	ExpiresAt    time.Time `db:"expires_at"`
+	ID           string     `db:"id"`
+	ParentID     string     `db:"parent_id"`
+	FamilyID     string     `db:"family_id"`
+	UserEmail    string     `db:"user_email"`
+	RefreshToken string     `db:"refresh_token"`
+	UserAgent    string     `db:"user_agent"`
+	ClientIP     string     `db:"client_ip"`
+	IsRevoked    bool       `db:"is_revoked"`
+	CreatedAt    time.Time  `db:"created_at"`
+	RotatedAt    *time.Time `db:"rotated_at"`
+	LastUsedAt   *time.Time `db:"last_used_at"`
+	ExpiresAt    time.Time  `db:"expires_at"`
 }
 
 type NotificationEventState string
 
 const (
-	NotSent NotificationEventState = "not sent"
-	Sent    NotificationEventState = "sent"
-	Failed  NotificationEventState = "failed"
+	NotSent    NotificationEventState = "not sent"
+	Sent       NotificationEventState = "sent"
+	Failed     NotificationEventState = "failed"
+	Suppressed NotificationEventState = "suppressed"
 )
 
 type NotificationResponseType string
 
 const (
-	NotificationSucess  NotificationResponseType = "success"
-	NotificationFailure NotificationResponseType = "failure"
+	NotificationSucess     NotificationResponseType = "success"
+	NotificationFailure    NotificationResponseType = "failure"
+	NotificationSuppressed NotificationResponseType = "suppressed"
 )
 
+func (t NotificationResponseType) valid() bool {
+	return t == NotificationSucess || t == NotificationFailure || t == NotificationSuppressed
+}
+
+// NotificationUpdate is the outcome of sending the notification of an event,
+// as recorded by UpdateNotificationEvents.
+type NotificationUpdate struct {
+	Event        *NotificationEvent
+	State        *NotificationState
+	ResponseType NotificationResponseType
+}
+
 type NotificationState struct {
-	ID          int64                  `db:"id"`
-	OrderID     int64                  `db:"order_id"`
-	State       NotificationEventState `db:"state"`
-	Message     string                 `db:"message"`
-	RequestedAt time.Time              `db:"requested_at"`
-	CompletedAt *time.Time             `db:"completed_at"`
+	ID               int64                  `db:"id"`
+	OrderID          OptionalID             `db:"order_id"`
+	UserEmail        string                 `db:"user_email"`
+	OrderStatus      OrderStatus            `db:"order_status"`
+	NotificationType NotificationType       `db:"notification_type"`
+	OutboxEventID    *int64                 `db:"outbox_event_id"`
+	State            NotificationEventState `db:"state"`
+	Message          string                 `db:"message"`
+	RequestedAt      time.Time              `db:"requested_at"`
+	CompletedAt      *time.Time             `db:"completed_at"`
+}
+
+type NotificationStateFilter struct {
+	Page
+	OrderID         int64
+	State           NotificationEventState
+	RequestedAfter  *time.Time
+	RequestedBefore *time.Time
+}
+
+var notificationStateSortFields = map[string]sortField{
+	"id":           {column: "id", kind: sortInt},
+	"requested_at": {column: "requested_at", kind: sortTime},
 }
 
+func notificationStatePosition(key string) func(*NotificationState) (string, int64) {
+	return func(ns *NotificationState) (string, int64) {
+		switch key {
+		case "requested_at":
+			return formatCursorTime(ns.RequestedAt), ns.ID
+		default:
+			return strconv.FormatInt(ns.ID, 10), ns.ID
+		}
+	}
+}
+
+// NotificationReplay records an admin putting a failed notification back on
+// the queue.
+type NotificationReplay struct {
+	ID              int64     `db:"id"`
+	StateID         int64     `db:"state_id"`
+	ActorID         int64     `db:"actor_id"`
+	PreviousMessage string    `db:"previous_message"`
+	CreatedAt       time.Time `db:"created_at"`
+}
+
+// NotificationEvent is a notification waiting to be sent. Order notifications
+// are about OrderID moving to OrderStatus; other types carry what they need in
+// Payload.
 type NotificationEvent struct {
-	ID          int64       `db:"id"`
-	UserEmail   string      `db:"user_email"`
-	OrderStatus OrderStatus `db:"order_status"`
-	OrderID     int64       `db:"order_id"`
-	StateID     int64       `db:"state_id"`
-	Attempts    int64       `db:"attempts"`
-	CreatedAt   time.Time   `db:"created_at"`
-	UpdatedAt   *time.Time  `db:"updated_at"`
+	ID               int64            `db:"id"`
+	UserEmail        string           `db:"user_email"`
+	OrderStatus      OrderStatus      `db:"order_status"`
+	OrderID          OptionalID       `db:"order_id"`
+	NotificationType NotificationType `db:"notification_type"`
+	Payload          []byte           `db:"payload"`
+	StateID          int64            `db:"state_id"`
+	Attempts         int64            `db:"attempts"`
+	NextAttemptAt    time.Time        `db:"next_attempt_at"`
+	LeasedBy         string           `db:"leased_by"`
+	LeasedUntil      *time.Time       `db:"leased_until"`
+	CreatedAt        time.Time        `db:"created_at"`
+	UpdatedAt        *time.Time       `db:"updated_at"`
+
+	// OutboxEventID is the outbox event the notification is enqueued for, if
+	// any. It is kept on the notification state.
+	OutboxEventID int64 `db:"-"`
+}
+
+// NotificationAttempt records the outcome of one attempt at sending a
+// notification.
+type NotificationAttempt struct {
+	ID          int64     `db:"id"`
+	StateID     int64     `db:"state_id"`
+	Attempt     int64     `db:"attempt"`
+	Succeeded   bool      `db:"succeeded"`
+	Message     string    `db:"message"`
+	AttemptedAt time.Time `db:"attempted_at"`
+}
+
+// NotificationType identifies what a notification is about, e.g.
+// "order.shipped", so that users can turn kinds of notifications off.
+type NotificationType string
+
+// OrderNotification is the type of the notification sent when an order moves
+// to status.
+func OrderNotification(status OrderStatus) NotificationType {
+	return NotificationType("order." + string(status))
+}
+
+// PasswordResetNotification is the type of password reset emails. It isn't in
+// NotificationTypes: users can't turn it off.
+const PasswordResetNotification NotificationType = "account.password_reset"
+
+// NotificationTypes are the types users can set preferences for.
+var NotificationTypes = []NotificationType{
+	OrderNotification(Pending),
+	OrderNotification(Paid),
+	OrderNotification(Processing),
+	OrderNotification(Shipped),
+	OrderNotification(Delivered),
+	OrderNotification(Cancelled),
+	OrderNotification(RefundRequested),
+	OrderNotification(Refunded),
+}
+
+type NotificationChannel string
+
+const EmailChannel NotificationChannel = "email"
+
+// NotificationChannels are the channels users can set preferences for.
+var NotificationChannels = []NotificationChannel{EmailChannel}
+
+// NotificationPreference turns a type of notification on or off on a channel
+// for a user. Notifications without a preference are enabled.
+type NotificationPreference struct {
+	UserID    int64               `db:"user_id"`
+	Type      NotificationType    `db:"notification_type"`
+	Channel   NotificationChannel `db:"channel"`
+	Enabled   bool                `db:"enabled"`
+	UpdatedAt time.Time           `db:"updated_at"`
+}
+
+// OptionalID is a reference to another row that is NULL when zero.
+type OptionalID int64
+
+func (id *OptionalID) Scan(src any) error {
+	var n sql.NullInt64
+	if err := n.Scan(src); err != nil {
+		return err
+	}
+	*id = OptionalID(n.Int64)
+
+	return nil
+}
+
+func (id OptionalID) Value() (driver.Value, error) {
+	if id == 0 {
+		return nil, nil
+	}
+
+	return int64(id), nil
+}
+
+// PasswordResetToken lets a user who forgot their password set a new one. Only
+// the SHA-256 of the token is stored; the token itself is emailed to the user.
+type PasswordResetToken struct {
+	ID        int64      `db:"id"`
+	UserID    int64      `db:"user_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// PasswordReset is the payload of a password reset notification.
+type PasswordReset struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewPasswordResetNotification returns the notification that emails the token
+// of r to the user with email.
+func NewPasswordResetNotification(email string, r PasswordReset) (*NotificationEvent, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding password reset: %w", err)
+	}
+
+	return &NotificationEvent{
+		UserEmail:        email,
+		NotificationType: PasswordResetNotification,
+		Payload:          payload,
+	}, nil
+}
+
+// PasswordReset decodes the payload of a password reset notification.
+func (ne *NotificationEvent) PasswordReset() (*PasswordReset, error) {
+	if ne.NotificationType != PasswordResetNotification {
+		return nil, fmt.Errorf("notification event %d is not a password reset", ne.ID)
+	}
+
+	var r PasswordReset
+	if err := json.Unmarshal(ne.Payload, &r); err != nil {
+		return nil, fmt.Errorf("error decoding password reset: %w", err)
+	}
+
+	return &r, nil
 }
@@ -0,0 +1,117 @@
+package storer
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookEventTypes are the outbox event types a webhook is subscribed to.
+// They are stored as a comma separated list.
+type WebhookEventTypes []OutboxEventType
+
+func (t WebhookEventTypes) Has(typ OutboxEventType) bool {
+	return slices.Contains(t, typ)
+}
+
+func (t WebhookEventTypes) Value() (driver.Value, error) {
+	s := make([]string, 0, len(t))
+	for _, typ := range t {
+		s = append(s, string(typ))
+	}
+
+	return strings.Join(s, ","), nil
+}
+
+func (t *WebhookEventTypes) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into webhook event types", src)
+	}
+
+	*t = nil
+	for _, typ := range strings.Split(s, ",") {
+		if typ != "" {
+			*t = append(*t, OutboxEventType(typ))
+		}
+	}
+
+	return nil
+}
+
+// Webhook is a partner endpoint that outbox events of EventTypes are POSTed
+// to, signed with Secret.
+type Webhook struct {
+	ID         int64             `db:"id"`
+	URL        string            `db:"url"`
+	EventTypes WebhookEventTypes `db:"event_types"`
+	Secret     string            `db:"secret"`
+	Active     bool              `db:"active"`
+	CreatedAt  time.Time         `db:"created_at"`
+	UpdatedAt  *time.Time        `db:"updated_at"`
+}
+
+type WebhookDeliveryState string
+
+const (
+	DeliveryPending   WebhookDeliveryState = "pending"
+	DeliverySucceeded WebhookDeliveryState = "delivered"
+	DeliveryFailed    WebhookDeliveryState = "failed"
+)
+
+// WebhookDelivery is an outbox event on its way to a webhook. Deliveries are
+// leased, retried and given up on like notification events, under the same
+// RetryPolicy. Its ID stays the same across attempts, so receivers can use it
+// to drop duplicates.
+type WebhookDelivery struct {
+	ID            int64                `db:"id"`
+	WebhookID     int64                `db:"webhook_id"`
+	OutboxEventID int64                `db:"outbox_event_id"`
+	EventType     OutboxEventType      `db:"event_type"`
+	Payload       []byte               `db:"payload"`
+	OccurredAt    time.Time            `db:"occurred_at"`
+	State         WebhookDeliveryState `db:"state"`
+	Attempts      int64                `db:"attempts"`
+	NextAttemptAt time.Time            `db:"next_attempt_at"`
+	LeasedBy      string               `db:"leased_by"`
+	LeasedUntil   *time.Time           `db:"leased_until"`
+	CreatedAt     time.Time            `db:"created_at"`
+	CompletedAt   *time.Time           `db:"completed_at"`
+}
+
+var webhookDeliverySortFields = map[string]sortField{
+	"id":         {column: "id", kind: sortInt},
+	"created_at": {column: "created_at", kind: sortTime},
+}
+
+func webhookDeliveryPosition(key string) func(*WebhookDelivery) (string, int64) {
+	return func(d *WebhookDelivery) (string, int64) {
+		switch key {
+		case "created_at":
+			return formatCursorTime(d.CreatedAt), d.ID
+		default:
+			return strconv.FormatInt(d.ID, 10), d.ID
+		}
+	}
+}
+
+// WebhookDeliveryAttempt is the outcome of one attempt at a delivery.
+// StatusCode is 0 when no response was received.
+type WebhookDeliveryAttempt struct {
+	ID          int64     `db:"id"`
+	DeliveryID  int64     `db:"delivery_id"`
+	Attempt     int64     `db:"attempt"`
+	StatusCode  int       `db:"status_code"`
+	Succeeded   bool      `db:"succeeded"`
+	Message     string    `db:"message"`
+	AttemptedAt time.Time `db:"attempted_at"`
+}
@@ -0,0 +1,38 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// LogNotifier writes each message as a line of JSON, e.g. to stdout or a
+// file, instead of delivering it.
+type LogNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLogNotifier(w io.Writer) *LogNotifier {
+	return &LogNotifier{
+		w: w,
+	}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, m *Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	err := json.NewEncoder(n.w).Encode(struct {
+		Time time.Time `json:"time"`
+		*Message
+	}{time.Now().UTC(), m})
+	if err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryNotifier keeps the messages it is given. It is meant for tests and
+// local development.
+type MemoryNotifier struct {
+	mu   sync.Mutex
+	sent []Message
+	err  error
+}
+
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{}
+}
+
+func (n *MemoryNotifier) Notify(ctx context.Context, m *Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, *m)
+
+	return nil
+}
+
+// FailWith makes every following Notify return err, or succeed again when err
+// is nil.
+func (n *MemoryNotifier) FailWith(err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.err = err
+}
+
+// Sent returns the messages delivered so far.
+func (n *MemoryNotifier) Sent() []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]Message(nil), n.sent...)
+}
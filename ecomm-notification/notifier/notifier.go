@@ -0,0 +1,27 @@
+package notifier
+
+import "context"
+
+// Message is a notification to a customer. Body is plain text; HTML is an
+// optional alternative for channels that support it. Unsubscribe is an
+// optional one-click unsubscribe URL.
+type Message struct {
+	To          string `json:"to"`
+	Subject     string `json:"subject"`
+	Body        string `json:"body"`
+	HTML        string `json:"html,omitempty"`
+	Unsubscribe string `json:"unsubscribe,omitempty"`
+}
+
+// Notifier delivers messages over one channel. A nil error means the channel
+// accepted the message.
+type Notifier interface {
+	Notify(ctx context.Context, m *Message) error
+}
+
+var (
+	_ Notifier = (*SMTPNotifier)(nil)
+	_ Notifier = (*WebhookNotifier)(nil)
+	_ Notifier = (*LogNotifier)(nil)
+	_ Notifier = (*MemoryNotifier)(nil)
+)
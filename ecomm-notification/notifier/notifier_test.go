@@ -0,0 +1,66 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	m := &Message{To: "test@example.com", Subject: "email from ecomm", Body: "Order 1 is pending"}
+
+	tcs := []struct {
+		name   string
+		status int
+		ok     bool
+	}{
+		{name: "accepted", status: http.StatusAccepted, ok: true},
+		{name: "rejected", status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Message
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			err := NewWebhookNotifier(srv.URL, srv.Client()).Notify(context.Background(), m)
+			if tc.ok {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+			require.Equal(t, *m, got)
+		})
+	}
+}
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Message{To: "test@example.com", Subject: "email from ecomm", Body: "Order 1 is pending"}
+	require.NoError(t, NewLogNotifier(&buf).Notify(context.Background(), m))
+
+	var got Message
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	require.Equal(t, *m, got)
+}
+
+func TestNewSMTPNotifier(t *testing.T) {
+	for _, mode := range []TLSMode{StartTLS, ImplicitTLS, NoTLS} {
+		_, err := NewSMTPNotifier(SMTPConfig{Host: "localhost", Port: 25, TLS: mode})
+		require.NoError(t, err)
+	}
+
+	_, err := NewSMTPNotifier(SMTPConfig{Host: "localhost", Port: 25, TLS: "ssl"})
+	require.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+
+	gomail "gopkg.in/mail.v2"
+)
+
+// TLSMode is how the SMTP connection is secured.
+type TLSMode string
+
+const (
+	// StartTLS upgrades a plain connection and fails if the server does not
+	// support it.
+	StartTLS TLSMode = "starttls"
+	// ImplicitTLS connects over TLS from the start, usually on port 465.
+	ImplicitTLS TLSMode = "tls"
+	// NoTLS sends mail in the clear, e.g. to a relay on a private network.
+	NoTLS TLSMode = "none"
+)
+
+type SMTPConfig struct {
+	Host string
+	Port int
+	TLS  TLSMode
+	// Username and Password are used for PLAIN auth. Auth is skipped when
+	// Username is empty.
+	Username string
+	Password string
+	From     string
+}
+
+type SMTPNotifier struct {
+	from   string
+	dialer *gomail.Dialer
+}
+
+func NewSMTPNotifier(cfg SMTPConfig) (*SMTPNotifier, error) {
+	d := gomail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password)
+	switch cfg.TLS {
+	case StartTLS:
+		d.StartTLSPolicy = gomail.MandatoryStartTLS
+	case ImplicitTLS:
+		d.SSL = true
+	case NoTLS:
+		d.StartTLSPolicy = gomail.NoStartTLS
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", cfg.TLS)
+	}
+
+	return &SMTPNotifier{
+		from:   cfg.From,
+		dialer: d,
+	}, nil
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, m *Message) error {
+	gm := gomail.NewMessage()
+	gm.SetHeader("From", n.from)
+	gm.SetHeader("To", m.To)
+	gm.SetHeader("Subject", m.Subject)
+	if m.Unsubscribe != "" {
+		// RFC 8058 one-click unsubscribe
+		gm.SetHeader("List-Unsubscribe", "<"+m.Unsubscribe+">")
+		gm.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	gm.SetBody("text/plain", m.Body)
+	if m.HTML != "" {
+		gm.AddAlternative("text/html", m.HTML)
+	}
+
+	if err := n.dialer.DialAndSend(gm); err != nil {
+		return fmt.Errorf("error sending mail: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/ecomm-notification/notifier"
+	"github.com/dhij/ecomm/ecomm-notification/templates"
+)
+
+// digests hold the events of each user while their digest window is open.
+type digests struct {
+	mu      sync.Mutex
+	pending map[string]*digest
+}
+
+type digest struct {
+	events []*pb.NotificationEvent
+	timer  *time.Timer
+}
+
+// addToDigest holds an event until the digest window of its user closes.
+// The window opens with the first event of a user, so a user gets at most one
+// message per window however many of their orders change.
+func (s *Server) addToDigest(ev *pb.NotificationEvent) {
+	s.digests.mu.Lock()
+	defer s.digests.mu.Unlock()
+
+	d, ok := s.digests.pending[ev.GetUserEmail()]
+	if !ok {
+		// a pending digest counts as in flight so that it is sent on
+		// shutdown
+		s.inflight.Add(1)
+		d = &digest{}
+		d.timer = time.AfterFunc(s.digestWindow, func() {
+			s.flushDigest(ev.GetUserEmail())
+		})
+		s.digests.pending[ev.GetUserEmail()] = d
+	}
+
+	for _, held := range d.events {
+		if held.GetId() == ev.GetId() {
+			return
+		}
+	}
+	d.events = append(d.events, ev)
+}
+
+// flushDigests sends every pending digest without waiting for its window to
+// close.
+func (s *Server) flushDigests() {
+	s.digests.mu.Lock()
+	defer s.digests.mu.Unlock()
+
+	for email, d := range s.digests.pending {
+		// a timer that already fired is flushing its digest
+		if d.timer.Stop() {
+			go s.flushDigest(email)
+		}
+	}
+}
+
+func (s *Server) flushDigest(email string) {
+	defer s.inflight.Done()
+
+	s.digests.mu.Lock()
+	d := s.digests.pending[email]
+	delete(s.digests.pending, email)
+	s.digests.mu.Unlock()
+
+	// the events go back to the queue when their lease runs out
+	if err := s.sem.Acquire(s.sendCtx, 1); err != nil {
+		return
+	}
+	defer s.sem.Release(1)
+
+	if len(d.events) == 1 {
+		err := s.sendNotification(s.sendCtx, d.events[0])
+		err = s.updateNotificationEvent(s.sendCtx, d.events[0], err)
+		if err != nil {
+			fmt.Printf("processing event: %v\n", err)
+		}
+		return
+	}
+
+	errs := s.sendDigest(s.sendCtx, email, d.events)
+	err := s.updateNotificationEvents(s.sendCtx, d.events, errs)
+	if err != nil {
+		fmt.Printf("processing digest: %v\n", err)
+	}
+}
+
+// sendDigest sends one message listing the orders of events and returns the
+// outcome of each event: errSuppressed for the types of notification the user
+// turned off, else the outcome of the send.
+func (s *Server) sendDigest(ctx context.Context, email string, events []*pb.NotificationEvent) []error {
+	errs := make([]error, len(events))
+	fail := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+
+	user, err := s.client.GetUser(ctx, &pb.UserReq{Email: email})
+	if err != nil {
+		return fail(fmt.Errorf("error getting user: %w", err))
+	}
+
+	data := &templates.Data{
+		Customer: templates.Customer{
+			Name:  user.GetName(),
+			Email: user.GetEmail(),
+		},
+	}
+	for i, ev := range events {
+		status := strings.ToLower(ev.OrderStatus.String())
+		notificationType := ev.GetNotificationType()
+		if notificationType == "" {
+			notificationType = "order." + status
+		}
+
+		enabled, err := s.emailEnabled(ctx, user.GetId(), notificationType)
+		if err != nil {
+			return fail(err)
+		}
+		if !enabled {
+			errs[i] = errSuppressed
+			continue
+		}
+
+		order, err := s.client.GetOrder(ctx, &pb.GetOrderReq{Id: ev.OrderId, IsAdmin: true})
+		if err != nil {
+			return fail(fmt.Errorf("error getting order: %w", err))
+		}
+		data.Orders = append(data.Orders, toTemplateOrder(order, status))
+	}
+	if len(data.Orders) == 0 {
+		return errs
+	}
+
+	msg, err := s.templates.Render(user.GetLocale(), templates.Digest, data)
+	if err == nil {
+		err = s.notifier.Notify(ctx, &notifier.Message{
+			To:      email,
+			Subject: msg.Subject,
+			Body:    msg.Text,
+			HTML:    msg.HTML,
+		})
+	}
+
+	return fail(err)
+}
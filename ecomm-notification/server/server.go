@@ -2,126 +2,570 @@ package server
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/url"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/ecomm-notification/notifier"
+	"github.com/dhij/ecomm/ecomm-notification/templates"
+	"github.com/dhij/ecomm/money"
+	"github.com/dhij/ecomm/token"
 	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	defaultBatchSize       = 50
+	defaultLeaseDuration   = 5 * time.Minute
+	defaultPollInterval    = 30 * time.Second
+	defaultConcurrency     = 10
+	defaultShutdownTimeout = 30 * time.Second
+
+	// updateTimeout bounds recording the outcome of a send, which is done
+	// even after the send was cancelled
+	updateTimeout = 10 * time.Second
 
-	gomail "gopkg.in/mail.v2"
+	minResubscribeDelay = time.Second
+	maxResubscribeDelay = 30 * time.Second
+
+	unsubscribeTokenDuration = 90 * 24 * time.Hour
+
+	// passwordResetType is the type of password reset notifications, which
+	// are sent on their own and can't be turned off
+	passwordResetType = "account.password_reset"
 )
 
-type AdminInfo struct {
-	Email    string
-	Password string
-}
+// errSuppressed is returned by sendNotification when the user turned off the
+// type of notification of an event.
+var errSuppressed = errors.New("user opted out")
 
 type Server struct {
-	client    pb.EcommClient
-	adminInfo *AdminInfo
+	client        pb.EcommClient
+	notifier      notifier.Notifier
+	templates     *templates.Renderer
+	workerID      string
+	batchSize     int32
+	leaseDuration time.Duration
+	pollInterval  time.Duration
+
+	tokenMaker     *token.JWTMaker
+	unsubscribeURL string
+
+	resetURL string
+
+	concurrency     int64
+	shutdownTimeout time.Duration
+	sem             *semaphore.Weighted
+	inflight        sync.WaitGroup
+	// sendCtx is what sends run under. It is separate from the context given
+	// to Run so that in-flight sends can finish after Run is told to stop.
+	sendCtx     context.Context
+	cancelSends context.CancelFunc
+
+	digestWindow time.Duration
+	digests      digests
+}
+
+type Option func(*Server)
+
+// WithWorkerID sets the id events are leased under. It must be unique among
+// the replicas sharing a queue and defaults to the host name and process id.
+func WithWorkerID(id string) Option {
+	return func(s *Server) {
+		s.workerID = id
+	}
+}
+
+// WithBatchSize sets how many events are claimed per tick.
+func WithBatchSize(n int32) Option {
+	return func(s *Server) {
+		s.batchSize = n
+	}
+}
+
+// WithLeaseDuration sets how long claimed events are held before other
+// workers may claim them. It should comfortably exceed the time it takes to
+// send a batch.
+func WithLeaseDuration(d time.Duration) Option {
+	return func(s *Server) {
+		s.leaseDuration = d
+	}
+}
+
+// WithPollInterval sets how often events are claimed besides the ones pushed
+// by the subscription, which does not push retries and may be down.
+func WithPollInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.pollInterval = d
+	}
 }
 
-func NewServer(client pb.EcommClient, adminInfo *AdminInfo) *Server {
-	return &Server{
-		client:    client,
-		adminInfo: adminInfo,
+// WithConcurrency sets how many notifications are sent at once.
+func WithConcurrency(n int64) Option {
+	return func(s *Server) {
+		s.concurrency = n
 	}
 }
 
+// WithShutdownTimeout sets how long in-flight sends are given to finish once
+// Run is told to stop, after which they are cancelled.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownTimeout = d
+	}
+}
+
+// WithUnsubscribe adds a link to every notification that turns off its type
+// of notification for the user. The link is unsubscribeURL with a token signed
+// by tokenMaker in its "token" query parameter.
+func WithUnsubscribe(tokenMaker *token.JWTMaker, unsubscribeURL string) Option {
+	return func(s *Server) {
+		s.tokenMaker = tokenMaker
+		s.unsubscribeURL = unsubscribeURL
+	}
+}
+
+// WithPasswordReset sets the page password reset emails link to, with the
+// reset token in its "token" query parameter. Password reset notifications
+// fail without it.
+func WithPasswordReset(resetURL string) Option {
+	return func(s *Server) {
+		s.resetURL = resetURL
+	}
+}
+
+// WithDigest groups the events of each user claimed within window of the
+// first one into a single message listing every order and its new status.
+// The grouped events stay leased until the window closes, so it must be
+// comfortably shorter than the lease duration.
+func WithDigest(window time.Duration) Option {
+	return func(s *Server) {
+		s.digestWindow = window
+	}
+}
+
+func NewServer(client pb.EcommClient, notifier notifier.Notifier, templates *templates.Renderer, opts ...Option) *Server {
+	s := &Server{
+		client:          client,
+		notifier:        notifier,
+		templates:       templates,
+		workerID:        defaultWorkerID(),
+		batchSize:       defaultBatchSize,
+		leaseDuration:   defaultLeaseDuration,
+		pollInterval:    defaultPollInterval,
+		concurrency:     defaultConcurrency,
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.sem = semaphore.NewWeighted(s.concurrency)
+	s.sendCtx, s.cancelSends = context.WithCancel(context.Background())
+	s.digests.pending = make(map[string]*digest)
+
+	return s
+}
+
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "ecomm-notification"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Run claims and sends notifications until ctx is done. It then stops
+// claiming and returns once the sends in flight have finished and their
+// outcome is recorded. Events that were claimed but not started go back to
+// the queue when their lease runs out.
 func (s *Server) Run(ctx context.Context) {
-	// process notification event every 30 seconds
-	ticker := time.NewTicker(30 * time.Second)
+	subscribed := make(chan struct{})
+	go func() {
+		defer close(subscribed)
+		s.subscribe(ctx)
+	}()
+
+	// poll for notification events as a fallback to the subscription
+	ticker := time.NewTicker(s.pollInterval)
 	defer ticker.Stop()
 
-	for {
+	for ctx.Err() == nil {
 		// process notification event
 		err := s.processNotificationEvents(ctx)
-		if err != nil {
+		if err != nil && ctx.Err() == nil {
 			fmt.Printf("failed to process notification events: %v\n", err)
 		}
 
 		select {
 		case <-ticker.C:
 		case <-ctx.Done():
+		}
+	}
+
+	<-subscribed
+	s.drain()
+}
+
+// drain sends the pending digests and waits for the sends in flight,
+// cancelling them once the shutdown timeout has passed.
+func (s *Server) drain() {
+	s.flushDigests()
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(s.shutdownTimeout):
+		fmt.Printf("notifications still in flight after %v, cancelling them\n", s.shutdownTimeout)
+		s.cancelSends()
+	}
+	<-done
+}
+
+// subscribe processes the events pushed by the ecomm-grpc service as they are
+// enqueued. It resubscribes from the last cursor received whenever the
+// subscription ends, until ctx is done or the service does not support
+// subscriptions.
+func (s *Server) subscribe(ctx context.Context) {
+	var cursor string
+	delay := minResubscribeDelay
+	for {
+		received, err := s.receiveNotificationEvents(ctx, &cursor)
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			fmt.Printf("notification subscriptions are not supported, polling only: %v\n", err)
 			return
 		}
+		fmt.Printf("notification subscription ended: %v\n", err)
+
+		if received {
+			delay = minResubscribeDelay
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(2*delay, maxResubscribeDelay)
+	}
+}
+
+// receiveNotificationEvents subscribes from cursor and processes the events
+// received until the subscription ends, advancing cursor as it goes. It
+// reports whether anything was received.
+func (s *Server) receiveNotificationEvents(ctx context.Context, cursor *string) (bool, error) {
+	stream, err := s.client.SubscribeNotificationEvents(ctx, &pb.SubscribeNotificationEventsReq{
+		WorkerId:      s.workerID,
+		BatchSize:     s.batchSize,
+		LeaseDuration: durationpb.New(s.leaseDuration),
+		Cursor:        *cursor,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	received := false
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return received, err
+		}
+		received = true
+
+		err = s.processEvents(ctx, res.GetEvents())
+		if err != nil {
+			return received, err
+		}
+		*cursor = res.GetCursor()
 	}
 }
 
 func (s *Server) processNotificationEvents(ctx context.Context) error {
-	res, err := s.client.ListNotificationEvents(ctx, &pb.ListNotificationEventsReq{})
+	res, err := s.client.ClaimNotificationEvents(ctx, &pb.ClaimNotificationEventsReq{
+		WorkerId:      s.workerID,
+		BatchSize:     s.batchSize,
+		LeaseDuration: durationpb.New(s.leaseDuration),
+	})
 	if err != nil {
 		return err
 	}
 
-	var wg sync.WaitGroup
-	sem := semaphore.NewWeighted(10)
-	for _, ev := range res.Events {
-		wg.Add(1)
-		if err := sem.Acquire(ctx, 1); err != nil {
+	return s.processEvents(ctx, res.Events)
+}
+
+// processEvents starts sending the notifications of events, as many at a
+// time as the concurrency allows, and acks or nacks each event when its send
+// is done. It returns once every send has started, or without starting the
+// rest when ctx is done. In digest mode the events are held for their digest
+// instead, except for password resets which can't wait.
+func (s *Server) processEvents(ctx context.Context, events []*pb.NotificationEvent) error {
+	for _, ev := range events {
+		if s.digestWindow > 0 && ev.GetNotificationType() != passwordResetType {
+			s.addToDigest(ev)
+			continue
+		}
+
+		if err := s.sem.Acquire(ctx, 1); err != nil {
 			return err
 		}
+		s.inflight.Add(1)
 
 		go func(ev *pb.NotificationEvent) {
-			defer sem.Release(1)
-			defer wg.Done()
-			err := s.sendNotification(ctx, ev)
-			err = s.updateNotificationEvent(ctx, ev, err)
+			defer s.inflight.Done()
+			defer s.sem.Release(1)
+			err := s.sendNotification(s.sendCtx, ev)
+			err = s.updateNotificationEvent(s.sendCtx, ev, err)
 			if err != nil {
 				fmt.Printf("processing event: %v\n", err)
 			}
 		}(ev)
 	}
 
-	go func() {
-		wg.Wait()
-	}()
-
 	return nil
 }
 
 func (s *Server) sendNotification(ctx context.Context, ev *pb.NotificationEvent) error {
-	m := gomail.NewMessage()
-	m.SetHeader("From", s.adminInfo.Email)
-	m.SetHeader("To", ev.UserEmail)
-	m.SetHeader("Subject", "email from ecomm")
-	m.SetBody("text/plain", fmt.Sprintf("Order %d is %s", ev.OrderId, strings.ToLower(ev.OrderStatus.String())))
+	if ev.GetNotificationType() == passwordResetType {
+		return s.sendPasswordReset(ctx, ev)
+	}
 
-	d := gomail.NewDialer("smtp.gmail.com", 587, s.adminInfo.Email, s.adminInfo.Password)
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	order, err := s.client.GetOrder(ctx, &pb.GetOrderReq{Id: ev.OrderId, IsAdmin: true})
+	if err != nil {
+		return fmt.Errorf("error getting order: %w", err)
+	}
+
+	user, err := s.client.GetUser(ctx, &pb.UserReq{Email: ev.UserEmail})
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+
+	// render the status of the event rather than the current status of the
+	// order, which may have moved on since
+	status := strings.ToLower(ev.OrderStatus.String())
+	notificationType := ev.GetNotificationType()
+	if notificationType == "" {
+		notificationType = "order." + status
+	}
 
-	if err := d.DialAndSend(m); err != nil {
+	enabled, err := s.emailEnabled(ctx, user.GetId(), notificationType)
+	if err != nil {
 		return err
 	}
+	if !enabled {
+		return errSuppressed
+	}
 
-	return nil
+	data := toTemplateData(order, user, status)
+	if s.tokenMaker != nil {
+		data.UnsubscribeURL, err = s.unsubscribeLink(user.GetId(), notificationType)
+		if err != nil {
+			return err
+		}
+	}
+
+	email, err := s.templates.Render(user.GetLocale(), status, data)
+	if err != nil {
+		return err
+	}
+
+	return s.notifier.Notify(ctx, &notifier.Message{
+		To:          ev.UserEmail,
+		Subject:     email.Subject,
+		Body:        email.Text,
+		HTML:        email.HTML,
+		Unsubscribe: data.UnsubscribeURL,
+	})
+}
+
+// sendPasswordReset emails the reset link of a password reset event. It
+// skips the user's preferences: the email was asked for.
+func (s *Server) sendPasswordReset(ctx context.Context, ev *pb.NotificationEvent) error {
+	if s.resetURL == "" {
+		return errors.New("password reset url is not configured")
+	}
+	reset := ev.GetPasswordReset()
+	if reset.GetToken() == "" {
+		return fmt.Errorf("password reset event %d has no token", ev.GetId())
+	}
+
+	user, err := s.client.GetUser(ctx, &pb.UserReq{Email: ev.UserEmail})
+	if err != nil {
+		return fmt.Errorf("error getting user: %w", err)
+	}
+
+	link, err := linkWithToken(s.resetURL, reset.GetToken())
+	if err != nil {
+		return fmt.Errorf("error building password reset link: %w", err)
+	}
+
+	email, err := s.templates.Render(user.GetLocale(), templates.PasswordReset, &templates.Data{
+		Customer: templates.Customer{
+			Name:  user.GetName(),
+			Email: user.GetEmail(),
+		},
+		ResetURL:       link,
+		ResetExpiresAt: reset.GetExpiresAt().AsTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.notifier.Notify(ctx, &notifier.Message{
+		To:      ev.UserEmail,
+		Subject: email.Subject,
+		Body:    email.Text,
+		HTML:    email.HTML,
+	})
+}
+
+func (s *Server) emailEnabled(ctx context.Context, userID int64, notificationType string) (bool, error) {
+	res, err := s.client.GetNotificationPreferences(ctx, &pb.NotificationPreferencesReq{UserId: userID})
+	if err != nil {
+		return false, fmt.Errorf("error getting notification preferences: %w", err)
+	}
+
+	for _, p := range res.GetPreferences() {
+		if p.GetType() == notificationType && p.GetChannel() == "email" {
+			return p.GetEnabled(), nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *Server) unsubscribeLink(userID int64, notificationType string) (string, error) {
+	tokenStr, err := s.tokenMaker.CreateUnsubscribeToken(userID, notificationType, unsubscribeTokenDuration)
+	if err != nil {
+		return "", err
+	}
+
+	link, err := linkWithToken(s.unsubscribeURL, tokenStr)
+	if err != nil {
+		return "", fmt.Errorf("error building unsubscribe link: %w", err)
+	}
+
+	return link, nil
+}
+
+// linkWithToken adds tokenStr to rawURL as its "token" query parameter.
+func linkWithToken(rawURL, tokenStr string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing url: %w", err)
+	}
+	q := u.Query()
+	q.Set("token", tokenStr)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func toTemplateData(o *pb.OrderRes, u *pb.UserRes, status string) *templates.Data {
+	return &templates.Data{
+		Customer: templates.Customer{
+			Name:  u.GetName(),
+			Email: u.GetEmail(),
+		},
+		Order: toTemplateOrder(o, status),
+	}
+}
+
+func toTemplateOrder(o *pb.OrderRes, status string) templates.Order {
+	order := templates.Order{
+		ID:            o.GetId(),
+		Status:        status,
+		PaymentMethod: o.GetPaymentMethod(),
+		Subtotal:      toMoney(o.GetSubtotalPrice()),
+		Tax:           toMoney(o.GetTaxPrice()),
+		Shipping:      toMoney(o.GetShippingPrice()),
+		Total:         toMoney(o.GetTotalPrice()),
+		CreatedAt:     o.GetCreatedAt().AsTime(),
+	}
+	for _, oi := range o.GetItems() {
+		price := toMoney(oi.GetPrice())
+		order.Items = append(order.Items, templates.Item{
+			Name:      oi.GetName(),
+			Quantity:  oi.GetQuantity(),
+			UnitPrice: price,
+			LineTotal: price.Mul(oi.GetQuantity()),
+		})
+	}
+
+	return order
+}
+
+func toMoney(m *pb.Money) money.Money {
+	return money.New(m.GetAmount(), m.GetCurrency())
 }
 
 func (s *Server) updateNotificationEvent(ctx context.Context, ev *pb.NotificationEvent, err error) error {
+	req := s.toUpdateNotificationEventReq(ev, err)
+	fmt.Printf("updating event: %v\n", req)
+
+	// record the outcome even if the send was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), updateTimeout)
+	defer cancel()
+	_, err = s.client.UpdateNotificationEvent(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to update notification event: %s", err)
+	}
+
+	return nil
+}
+
+// updateNotificationEvents records the outcome of each of events, in one
+// transaction.
+func (s *Server) updateNotificationEvents(ctx context.Context, events []*pb.NotificationEvent, errs []error) error {
+	req := &pb.UpdateNotificationEventsReq{}
+	for i, ev := range events {
+		req.Events = append(req.Events, s.toUpdateNotificationEventReq(ev, errs[i]))
+	}
+	fmt.Printf("updating events: %v\n", req)
+
+	// record the outcome even if the send was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), updateTimeout)
+	defer cancel()
+	_, err := s.client.UpdateNotificationEvents(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to update notification events: %s", err)
+	}
+
+	return nil
+}
+
+func (s *Server) toUpdateNotificationEventReq(ev *pb.NotificationEvent, err error) *pb.UpdateNotificationEventReq {
 	req := &pb.UpdateNotificationEventReq{
-		Id:      ev.Id,
-		StateId: ev.StateId,
-		OrderId: ev.OrderId,
+		Id:       ev.Id,
+		StateId:  ev.StateId,
+		OrderId:  ev.OrderId,
+		WorkerId: s.workerID,
 	}
 
 	switch err {
 	case nil:
 		req.ResponseType = pb.NotificationResponseType_SUCCESS
 		req.Message = "notification sent successfully"
+	case errSuppressed:
+		req.ResponseType = pb.NotificationResponseType_OPTED_OUT
+		req.Message = fmt.Sprintf("suppressed: %s", err)
 	default:
 		req.ResponseType = pb.NotificationResponseType_FAILURE
 		req.Message = fmt.Sprintf("failed: %s", err)
 	}
 
-	fmt.Printf("updating event: %v\n", req)
-
-	_, err = s.client.UpdateNotificationEvent(ctx, req)
-	if err != nil {
-		return fmt.Errorf("failed to update notification event: %s", err)
-	}
-
-	return nil
+	return req
 }
@@ -0,0 +1,545 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhij/ecomm/ecomm-grpc/pb"
+	"github.com/dhij/ecomm/ecomm-notification/notifier"
+	"github.com/dhij/ecomm/ecomm-notification/templates"
+	"github.com/dhij/ecomm/token"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeClient serves a fixed list of notification events, with an order and a
+// user for each, and records the updates made to them. Calls to other methods
+// panic.
+type fakeClient struct {
+	pb.EcommClient
+
+	mu      sync.Mutex
+	events  []*pb.NotificationEvent
+	claim   *pb.ClaimNotificationEventsReq
+	updates map[int64]*pb.UpdateNotificationEventReq
+	// batches are the ids of the events updated together
+	batches [][]int64
+
+	// disabled are the types of notifications every user turned off email for
+	disabled []string
+
+	// streams are handed out to subscriptions in order; once they run out
+	// subscriptions are unimplemented
+	streams       []*fakeStream
+	subscriptions []*pb.SubscribeNotificationEventsReq
+}
+
+// fakeStream returns its responses and then fails with err.
+type fakeStream struct {
+	grpc.ClientStream
+
+	res []*pb.SubscribeNotificationEventsRes
+	err error
+}
+
+func (s *fakeStream) Recv() (*pb.SubscribeNotificationEventsRes, error) {
+	if len(s.res) == 0 {
+		return nil, s.err
+	}
+	res := s.res[0]
+	s.res = s.res[1:]
+	return res, nil
+}
+
+func (c *fakeClient) SubscribeNotificationEvents(ctx context.Context, in *pb.SubscribeNotificationEventsReq, opts ...grpc.CallOption) (pb.Ecomm_SubscribeNotificationEventsClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subscriptions = append(c.subscriptions, in)
+	if len(c.streams) == 0 {
+		return nil, status.Error(codes.Unimplemented, "unknown method SubscribeNotificationEvents")
+	}
+	stream := c.streams[0]
+	c.streams = c.streams[1:]
+	return stream, nil
+}
+
+func (c *fakeClient) ClaimNotificationEvents(ctx context.Context, in *pb.ClaimNotificationEventsReq, opts ...grpc.CallOption) (*pb.ClaimNotificationEventsRes, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.claim = in
+	return &pb.ClaimNotificationEventsRes{Events: c.events}, nil
+}
+
+func (c *fakeClient) GetOrder(ctx context.Context, in *pb.GetOrderReq, opts ...grpc.CallOption) (*pb.OrderRes, error) {
+	return &pb.OrderRes{
+		Id: in.GetId(),
+		Items: []*pb.OrderItem{
+			{Name: "test product", Quantity: 2, Price: &pb.Money{Amount: 1250, Currency: "USD"}},
+		},
+		SubtotalPrice: &pb.Money{Amount: 2500, Currency: "USD"},
+		TaxPrice:      &pb.Money{Amount: 250, Currency: "USD"},
+		ShippingPrice: &pb.Money{Amount: 500, Currency: "USD"},
+		TotalPrice:    &pb.Money{Amount: 3250, Currency: "USD"},
+	}, nil
+}
+
+func (c *fakeClient) GetUser(ctx context.Context, in *pb.UserReq, opts ...grpc.CallOption) (*pb.UserRes, error) {
+	locale := "en"
+	if strings.HasPrefix(in.GetEmail(), "fr") {
+		locale = "fr"
+	}
+	return &pb.UserRes{Id: 1, Name: "test user", Email: in.GetEmail(), Locale: locale}, nil
+}
+
+func (c *fakeClient) GetNotificationPreferences(ctx context.Context, in *pb.NotificationPreferencesReq, opts ...grpc.CallOption) (*pb.NotificationPreferencesRes, error) {
+	res := &pb.NotificationPreferencesRes{}
+	for _, typ := range c.disabled {
+		res.Preferences = append(res.Preferences, &pb.NotificationPreference{Type: typ, Channel: "email"})
+	}
+	return res, nil
+}
+
+func (c *fakeClient) UpdateNotificationEvent(ctx context.Context, in *pb.UpdateNotificationEventReq, opts ...grpc.CallOption) (*pb.UpdateNotificationEventRes, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.updates[in.GetId()] = in
+	return &pb.UpdateNotificationEventRes{Succeeded: in.GetResponseType() == pb.NotificationResponseType_SUCCESS}, nil
+}
+
+func (c *fakeClient) UpdateNotificationEvents(ctx context.Context, in *pb.UpdateNotificationEventsReq, opts ...grpc.CallOption) (*pb.UpdateNotificationEventsRes, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := &pb.UpdateNotificationEventsRes{}
+	var batch []int64
+	for _, u := range in.GetEvents() {
+		c.updates[u.GetId()] = u
+		batch = append(batch, u.GetId())
+		res.Results = append(res.Results, &pb.UpdateNotificationEventRes{Succeeded: u.GetResponseType() == pb.NotificationResponseType_SUCCESS})
+	}
+	c.batches = append(c.batches, batch)
+	return res, nil
+}
+
+func TestProcessNotificationEvents(t *testing.T) {
+	events := []*pb.NotificationEvent{
+		{Id: 1, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_PENDING, OrderId: 1, StateId: 1},
+		{Id: 2, UserEmail: "test2@example.com", OrderStatus: pb.OrderStatus_SHIPPED, OrderId: 2, StateId: 2},
+	}
+
+	tcs := []struct {
+		name     string
+		err      error
+		disabled []string
+		response pb.NotificationResponseType
+		sent     int
+	}{
+		{
+			name:     "sent",
+			response: pb.NotificationResponseType_SUCCESS,
+			sent:     2,
+		},
+		{
+			name:     "failed",
+			err:      errors.New("connection refused"),
+			response: pb.NotificationResponseType_FAILURE,
+		},
+		{
+			name:     "suppressed",
+			disabled: []string{"order.pending", "order.shipped"},
+			response: pb.NotificationResponseType_OPTED_OUT,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &fakeClient{events: events, disabled: tc.disabled, updates: make(map[int64]*pb.UpdateNotificationEventReq)}
+			n := notifier.NewMemoryNotifier()
+			n.FailWith(tc.err)
+
+			srv := NewServer(client, n, templates.NewRenderer(""), WithWorkerID("worker-1"), WithBatchSize(10), WithLeaseDuration(time.Minute))
+			err := srv.processNotificationEvents(context.Background())
+			require.NoError(t, err)
+			srv.inflight.Wait()
+
+			require.Equal(t, "worker-1", client.claim.GetWorkerId())
+			require.Equal(t, int32(10), client.claim.GetBatchSize())
+			require.Equal(t, time.Minute, client.claim.GetLeaseDuration().AsDuration())
+
+			require.Len(t, n.Sent(), tc.sent)
+			require.Len(t, client.updates, len(events))
+			for _, ev := range events {
+				u := client.updates[ev.GetId()]
+				require.Equal(t, tc.response, u.GetResponseType())
+				require.Equal(t, ev.GetStateId(), u.GetStateId())
+				require.Equal(t, ev.GetOrderId(), u.GetOrderId())
+				require.Equal(t, "worker-1", u.GetWorkerId())
+			}
+		})
+	}
+
+}
+
+func TestDigest(t *testing.T) {
+	events := []*pb.NotificationEvent{
+		{Id: 1, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_PAID, OrderId: 1, StateId: 1},
+		{Id: 2, UserEmail: "test2@example.com", OrderStatus: pb.OrderStatus_PAID, OrderId: 2, StateId: 2},
+		{Id: 3, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_SHIPPED, OrderId: 3, StateId: 3},
+		{Id: 4, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_DELIVERED, OrderId: 4, StateId: 4},
+	}
+
+	tcs := []struct {
+		name      string
+		err       error
+		disabled  []string
+		responses map[int64]pb.NotificationResponseType
+		sent      int
+		listed    []string
+	}{
+		{
+			name: "sent",
+			responses: map[int64]pb.NotificationResponseType{
+				1: pb.NotificationResponseType_SUCCESS,
+				2: pb.NotificationResponseType_SUCCESS,
+				3: pb.NotificationResponseType_SUCCESS,
+				4: pb.NotificationResponseType_SUCCESS,
+			},
+			sent:   2,
+			listed: []string{"- Order #1 (32.50 USD): payment received", "- Order #3 (32.50 USD): shipped", "- Order #4 (32.50 USD): delivered"},
+		},
+		{
+			name:     "partly suppressed",
+			disabled: []string{"order.shipped"},
+			responses: map[int64]pb.NotificationResponseType{
+				1: pb.NotificationResponseType_SUCCESS,
+				2: pb.NotificationResponseType_SUCCESS,
+				3: pb.NotificationResponseType_OPTED_OUT,
+				4: pb.NotificationResponseType_SUCCESS,
+			},
+			sent:   2,
+			listed: []string{"- Order #1 (32.50 USD): payment received", "- Order #4 (32.50 USD): delivered"},
+		},
+		{
+			name: "failed",
+			err:  errors.New("connection refused"),
+			responses: map[int64]pb.NotificationResponseType{
+				1: pb.NotificationResponseType_FAILURE,
+				2: pb.NotificationResponseType_FAILURE,
+				3: pb.NotificationResponseType_FAILURE,
+				4: pb.NotificationResponseType_FAILURE,
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &fakeClient{events: events, disabled: tc.disabled, updates: make(map[int64]*pb.UpdateNotificationEventReq)}
+			n := notifier.NewMemoryNotifier()
+			n.FailWith(tc.err)
+
+			srv := NewServer(client, n, templates.NewRenderer(""), WithWorkerID("worker-1"), WithDigest(20*time.Millisecond))
+			err := srv.processNotificationEvents(context.Background())
+			require.NoError(t, err)
+			// events claimed again within the window are not listed twice
+			err = srv.processEvents(context.Background(), events[:1])
+			require.NoError(t, err)
+			srv.inflight.Wait()
+
+			// the events of a user are acked together, a lone event on its own
+			require.Equal(t, [][]int64{{1, 3, 4}}, client.batches)
+			require.Len(t, client.updates, len(events))
+			for id, response := range tc.responses {
+				require.Equal(t, response, client.updates[id].GetResponseType())
+				require.Equal(t, "worker-1", client.updates[id].GetWorkerId())
+			}
+
+			sent := n.Sent()
+			require.Len(t, sent, tc.sent)
+			for _, m := range sent {
+				if m.To != "test@example.com" {
+					require.Equal(t, "Payment received for order #2", m.Subject)
+					continue
+				}
+				require.Equal(t, fmt.Sprintf("Updates on %d of your orders", len(tc.listed)), m.Subject)
+				for _, line := range tc.listed {
+					require.Contains(t, m.Body, line)
+				}
+				require.Equal(t, len(tc.listed), strings.Count(m.Body, "- Order #"))
+			}
+		})
+	}
+}
+
+func TestDigestShutdown(t *testing.T) {
+	events := []*pb.NotificationEvent{
+		{Id: 1, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_PAID, OrderId: 1, StateId: 1},
+		{Id: 2, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_SHIPPED, OrderId: 2, StateId: 2},
+	}
+	client := &fakeClient{events: events, updates: make(map[int64]*pb.UpdateNotificationEventReq)}
+	n := notifier.NewMemoryNotifier()
+
+	// pending digests are sent on shutdown rather than when their window
+	// closes
+	srv := NewServer(client, n, templates.NewRenderer(""), WithPollInterval(time.Hour), WithDigest(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(stopped)
+	}()
+	require.Eventually(t, func() bool {
+		srv.digests.mu.Lock()
+		defer srv.digests.mu.Unlock()
+		return len(srv.digests.pending) == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not send the pending digest")
+	}
+
+	require.Len(t, n.Sent(), 1)
+	require.Equal(t, [][]int64{{1, 2}}, client.batches)
+}
+
+// blockingNotifier holds every send until it is released or its context is
+// done.
+type blockingNotifier struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(ctx context.Context, m *notifier.Message) error {
+	n.started <- struct{}{}
+	select {
+	case <-n.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestRunShutdown(t *testing.T) {
+	events := []*pb.NotificationEvent{
+		{Id: 1, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_PENDING, OrderId: 1, StateId: 1},
+		{Id: 2, UserEmail: "test2@example.com", OrderStatus: pb.OrderStatus_SHIPPED, OrderId: 2, StateId: 2},
+	}
+
+	tcs := []struct {
+		name     string
+		release  bool
+		response pb.NotificationResponseType
+	}{
+		{
+			name:     "drained",
+			release:  true,
+			response: pb.NotificationResponseType_SUCCESS,
+		},
+		{
+			name:     "cancelled after timeout",
+			response: pb.NotificationResponseType_FAILURE,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &fakeClient{events: events, updates: make(map[int64]*pb.UpdateNotificationEventReq)}
+			n := &blockingNotifier{started: make(chan struct{}, len(events)), release: make(chan struct{})}
+			srv := NewServer(client, n, templates.NewRenderer(""), WithPollInterval(time.Hour), WithConcurrency(int64(len(events))), WithShutdownTimeout(50*time.Millisecond))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			go func() {
+				srv.Run(ctx)
+				close(stopped)
+			}()
+			for range events {
+				<-n.started
+			}
+
+			// Run waits for the sends in flight instead of cutting them off
+			cancel()
+			select {
+			case <-stopped:
+				t.Fatal("Run returned with sends in flight")
+			case <-time.After(10 * time.Millisecond):
+			}
+			if tc.release {
+				close(n.release)
+			}
+			<-stopped
+
+			// the outcome of every started send is recorded
+			require.Len(t, client.updates, len(events))
+			for _, ev := range events {
+				require.Equal(t, tc.response, client.updates[ev.GetId()].GetResponseType())
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	events := []*pb.NotificationEvent{
+		{Id: 1, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_PENDING, OrderId: 1, StateId: 1},
+		{Id: 2, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_PAID, OrderId: 1, StateId: 2},
+	}
+	client := &fakeClient{
+		updates: make(map[int64]*pb.UpdateNotificationEventReq),
+		streams: []*fakeStream{
+			{
+				res: []*pb.SubscribeNotificationEventsRes{{Events: events[:1], Cursor: "1"}},
+				err: status.Error(codes.Unavailable, "connection reset"),
+			},
+			{
+				res: []*pb.SubscribeNotificationEventsRes{{Cursor: "1"}, {Events: events[1:], Cursor: "2"}},
+				err: status.Error(codes.Unavailable, "connection reset"),
+			},
+		},
+	}
+	n := notifier.NewMemoryNotifier()
+
+	// the subscription resumes from its cursor after the first stream ends
+	// and gives up once the service no longer supports subscriptions
+	srv := NewServer(client, n, templates.NewRenderer(""), WithWorkerID("worker-1"))
+	srv.subscribe(context.Background())
+	srv.inflight.Wait()
+
+	require.Len(t, client.subscriptions, 3)
+	require.Empty(t, client.subscriptions[0].GetCursor())
+	require.Equal(t, "1", client.subscriptions[1].GetCursor())
+	require.Equal(t, "2", client.subscriptions[2].GetCursor())
+	require.Equal(t, "worker-1", client.subscriptions[0].GetWorkerId())
+
+	require.Len(t, n.Sent(), 2)
+	require.Len(t, client.updates, 2)
+	for _, ev := range events {
+		require.Equal(t, pb.NotificationResponseType_SUCCESS, client.updates[ev.GetId()].GetResponseType())
+	}
+}
+
+func TestSendNotification(t *testing.T) {
+	srv := NewServer(&fakeClient{}, notifier.NewMemoryNotifier(), templates.NewRenderer(""))
+
+	tcs := []struct {
+		name    string
+		ev      *pb.NotificationEvent
+		subject string
+		text    string
+	}{
+		{
+			name:    "english",
+			ev:      &pb.NotificationEvent{Id: 1, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_SHIPPED, OrderId: 7},
+			subject: "Your order #7 has shipped",
+			text:    "- 2 x test product at 12.50 USD: 25.00 USD",
+		},
+		{
+			name:    "localized",
+			ev:      &pb.NotificationEvent{Id: 2, UserEmail: "fr@example.com", OrderStatus: pb.OrderStatus_REFUNDED, OrderId: 8},
+			subject: "Votre commande n° 8 a été remboursée",
+			text:    "Votre remboursement de 32.50 USD a été effectué.",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			n := notifier.NewMemoryNotifier()
+			srv.notifier = n
+
+			require.NoError(t, srv.sendNotification(context.Background(), tc.ev))
+			sent := n.Sent()
+			require.Len(t, sent, 1)
+			require.Equal(t, tc.ev.GetUserEmail(), sent[0].To)
+			require.Equal(t, tc.subject, sent[0].Subject)
+			require.Contains(t, sent[0].Body, tc.text)
+			require.Contains(t, sent[0].HTML, "<td>test product</td>")
+		})
+	}
+}
+
+func TestUnsubscribeLink(t *testing.T) {
+	maker := token.NewJWTMaker("a-secret-key-that-is-long-enough!")
+	n := notifier.NewMemoryNotifier()
+	srv := NewServer(&fakeClient{}, n, templates.NewRenderer(""), WithUnsubscribe(maker, "https://shop.example.com/unsubscribe"))
+
+	ev := &pb.NotificationEvent{Id: 1, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_SHIPPED, OrderId: 7, NotificationType: "order.shipped"}
+	require.NoError(t, srv.sendNotification(context.Background(), ev))
+	sent := n.Sent()
+	require.Len(t, sent, 1)
+
+	u, err := url.Parse(sent[0].Unsubscribe)
+	require.NoError(t, err)
+	require.Equal(t, "shop.example.com", u.Host)
+	require.Contains(t, sent[0].Body, sent[0].Unsubscribe)
+	require.Contains(t, sent[0].HTML, "Unsubscribe")
+
+	claims, err := maker.VerifyUnsubscribeToken(u.Query().Get("token"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), claims.UserID)
+	require.Equal(t, "order.shipped", claims.Type)
+
+	// an unsubscribe token is not an access token
+	_, err = maker.VerifyToken(u.Query().Get("token"))
+	require.Error(t, err)
+}
+
+func TestPasswordReset(t *testing.T) {
+	reset := &pb.NotificationEvent{
+		Id:               2,
+		UserEmail:        "test@example.com",
+		StateId:          2,
+		NotificationType: passwordResetType,
+		PasswordReset:    &pb.PasswordResetNotification{Token: "reset-token", ExpiresAt: timestamppb.New(time.Now().Add(time.Hour))},
+	}
+	events := []*pb.NotificationEvent{
+		{Id: 1, UserEmail: "test@example.com", OrderStatus: pb.OrderStatus_PAID, OrderId: 1, StateId: 1},
+		reset,
+	}
+
+	// password resets skip the digest and can't be turned off
+	client := &fakeClient{events: events, disabled: []string{"order.paid", passwordResetType}, updates: make(map[int64]*pb.UpdateNotificationEventReq)}
+	n := notifier.NewMemoryNotifier()
+	srv := NewServer(client, n, templates.NewRenderer(""), WithDigest(time.Hour), WithPasswordReset("https://shop.example.com/reset-password"))
+	err := srv.processNotificationEvents(context.Background())
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.updates[reset.GetId()] != nil
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, pb.NotificationResponseType_SUCCESS, client.updates[reset.GetId()].GetResponseType())
+
+	sent := n.Sent()
+	require.Len(t, sent, 1)
+	require.Equal(t, "Reset your ecomm password", sent[0].Subject)
+	require.Contains(t, sent[0].Body, "https://shop.example.com/reset-password?token=reset-token")
+	require.Empty(t, sent[0].Unsubscribe)
+
+	srv.flushDigests()
+	srv.inflight.Wait()
+	require.Equal(t, pb.NotificationResponseType_OPTED_OUT, client.updates[1].GetResponseType())
+
+	// without a reset page there is nothing to link to
+	srv = NewServer(client, notifier.NewMemoryNotifier(), templates.NewRenderer(""))
+	require.Error(t, srv.sendNotification(context.Background(), reset))
+}
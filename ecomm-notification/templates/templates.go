@@ -0,0 +1,243 @@
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/dhij/ecomm/money"
+)
+
+// DefaultLocale is used when no template exists for the locale of a user.
+const DefaultLocale = "en"
+
+// Digest is the template of a message summing up the status changes of
+// several orders. It defines "text" and "html" itself instead of using the
+// layouts, and renders Data.Orders rather than Data.Order.
+const Digest = "digest"
+
+// PasswordReset is the template of password reset emails. Like Digest it
+// defines "text" and "html" itself, and it renders Data.ResetURL instead of
+// an order.
+const PasswordReset = "password_reset"
+
+//go:embed defaults
+var defaults embed.FS
+
+// ErrNoTemplate is returned by Render when there is no template for a status
+// in any locale.
+var ErrNoTemplate = errors.New("no template")
+
+// Data is what the templates are rendered against.
+type Data struct {
+	Customer Customer
+	Order    Order
+	// Orders are the orders of a Digest, each with its new status.
+	Orders []Order
+
+	// UnsubscribeURL turns off this type of notification for the customer.
+	// It is empty when unsubscribe links are not configured.
+	UnsubscribeURL string
+
+	// ResetURL is where the customer sets a new password with a
+	// PasswordReset, until ResetExpiresAt.
+	ResetURL       string
+	ResetExpiresAt time.Time
+}
+
+type Customer struct {
+	Name  string
+	Email string
+}
+
+type Order struct {
+	ID            int64
+	Status        string
+	PaymentMethod string
+	Items         []Item
+	Subtotal      money.Money
+	Tax           money.Money
+	Shipping      money.Money
+	Total         money.Money
+	CreatedAt     time.Time
+}
+
+type Item struct {
+	Name      string
+	Quantity  int64
+	UnitPrice money.Money
+	LineTotal money.Money
+}
+
+// Email is a rendered multipart email.
+type Email struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Renderer renders emails from templates laid out as
+//
+//	<locale>/<status>.tmpl     defines "subject" and "intro"
+//	<locale>/layout.txt.tmpl   defines "text"
+//	<locale>/layout.html.tmpl  defines "html"
+//
+// where status is the lower case order status, e.g. "refund_requested".
+// Templates in the directory given to NewRenderer take precedence over the
+// embedded defaults, file by file.
+type Renderer struct {
+	fsys []fs.FS
+}
+
+func NewRenderer(dir string) *Renderer {
+	sub, err := fs.Sub(defaults, "defaults")
+	if err != nil {
+		panic(err)
+	}
+
+	r := &Renderer{}
+	if dir != "" {
+		r.fsys = append(r.fsys, os.DirFS(dir))
+	}
+	r.fsys = append(r.fsys, sub)
+
+	return r
+}
+
+// Render renders the email for an order status in the given locale. A locale
+// without templates falls back to its base language, e.g. "fr-CA" to "fr",
+// and then to DefaultLocale.
+func (r *Renderer) Render(locale, status string, d *Data) (*Email, error) {
+	for _, loc := range localeCandidates(locale) {
+		src, err := r.readFile(path.Join(loc, status+".tmpl"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return r.render(loc, string(src), d)
+	}
+
+	return nil, fmt.Errorf("%w for status %q", ErrNoTemplate, status)
+}
+
+func (r *Renderer) render(locale, src string, d *Data) (*Email, error) {
+	textLayout, err := r.readFile(path.Join(locale, "layout.txt.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	htmlLayout, err := r.readFile(path.Join(locale, "layout.html.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	tt, err := texttemplate.New(locale).Parse(string(textLayout))
+	if err == nil {
+		_, err = tt.Parse(src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing text template: %w", err)
+	}
+
+	ht, err := htmltemplate.New(locale).Parse(string(htmlLayout))
+	if err == nil {
+		_, err = ht.Parse(src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing html template: %w", err)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tt.ExecuteTemplate(&subject, "subject", d); err != nil {
+		return nil, fmt.Errorf("error rendering subject: %w", err)
+	}
+	if err := tt.ExecuteTemplate(&text, "text", d); err != nil {
+		return nil, fmt.Errorf("error rendering text: %w", err)
+	}
+	if err := ht.ExecuteTemplate(&html, "html", d); err != nil {
+		return nil, fmt.Errorf("error rendering html: %w", err)
+	}
+
+	return &Email{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// readFile reads a template from the first file system that has it.
+func (r *Renderer) readFile(name string) ([]byte, error) {
+	for _, fsys := range r.fsys {
+		b, err := fs.ReadFile(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading template %s: %w", name, err)
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("error reading template %s: %w", name, fs.ErrNotExist)
+}
+
+func localeCandidates(locale string) []string {
+	locale = strings.ReplaceAll(locale, "_", "-")
+
+	var locales []string
+	if locale != "" {
+		locales = append(locales, locale)
+		if base, _, ok := strings.Cut(locale, "-"); ok {
+			locales = append(locales, base)
+		}
+	}
+
+	return append(locales, DefaultLocale)
+}
+
+// SampleData returns an order to preview templates with, three orders for
+// the Digest, or a reset link for the PasswordReset.
+func SampleData(status string) *Data {
+	if status == PasswordReset {
+		return &Data{
+			Customer:       Customer{Name: "Jane Doe", Email: "jane@example.com"},
+			ResetURL:       "https://shop.example.com/reset-password?token=sample",
+			ResetExpiresAt: time.Date(2026, 10, 18, 10, 30, 0, 0, time.UTC),
+		}
+	}
+	if status == Digest {
+		d := SampleData("shipped")
+		d.Orders = []Order{d.Order, d.Order, d.Order}
+		d.Orders[1].ID, d.Orders[1].Status = 1043, "delivered"
+		d.Orders[2].ID, d.Orders[2].Status = 1044, "refund_requested"
+		return d
+	}
+
+	return &Data{
+		Customer: Customer{Name: "Jane Doe", Email: "jane@example.com"},
+		Order: Order{
+			ID:            1042,
+			Status:        status,
+			PaymentMethod: "card",
+			Items: []Item{
+				{Name: "Mechanical keyboard", Quantity: 1, UnitPrice: money.New(8999, "USD"), LineTotal: money.New(8999, "USD")},
+				{Name: "USB-C cable", Quantity: 2, UnitPrice: money.New(1250, "USD"), LineTotal: money.New(2500, "USD")},
+			},
+			Subtotal:  money.New(11499, "USD"),
+			Tax:       money.New(1495, "USD"),
+			Shipping:  money.New(0, "USD"),
+			Total:     money.New(12994, "USD"),
+			CreatedAt: time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC),
+		},
+	}
+}
@@ -0,0 +1,107 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var statuses = []string{"pending", "paid", "processing", "shipped", "delivered", "cancelled", "refund_requested", "refunded"}
+
+func TestRenderDefaults(t *testing.T) {
+	r := NewRenderer("")
+	for _, locale := range []string{"en", "fr"} {
+		for _, status := range statuses {
+			t.Run(locale+"/"+status, func(t *testing.T) {
+				e, err := r.Render(locale, status, SampleData(status))
+				require.NoError(t, err)
+				require.Contains(t, e.Subject, "1042")
+				require.Contains(t, e.Text, "Jane Doe")
+				require.Contains(t, e.Text, "USB-C cable")
+				require.Contains(t, e.Text, "129.94 USD")
+				require.Contains(t, e.HTML, "<td>Mechanical keyboard</td>")
+			})
+		}
+	}
+}
+
+func TestRenderLocaleFallback(t *testing.T) {
+	r := NewRenderer("")
+
+	e, err := r.Render("fr-CA", "shipped", SampleData("shipped"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(e.Text, "Bonjour"))
+
+	e, err = r.Render("de", "shipped", SampleData("shipped"))
+	require.NoError(t, err)
+	require.Equal(t, "Your order #1042 has shipped", e.Subject)
+
+	_, err = r.Render("en", "lost", SampleData("lost"))
+	require.ErrorIs(t, err, ErrNoTemplate)
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	d := SampleData("pending")
+	d.Customer.Name = "<script>alert(1)</script>"
+
+	e, err := NewRenderer("").Render("en", "pending", d)
+	require.NoError(t, err)
+	require.Contains(t, e.Text, "<script>")
+	require.NotContains(t, e.HTML, "<script>")
+}
+
+func TestRenderOverrides(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "en"), 0o755))
+	err := os.WriteFile(filepath.Join(dir, "en", "shipped.tmpl"), []byte(`{{define "subject"}}On its way: #{{.Order.ID}}{{end}}{{define "intro"}}Shipped!{{end}}`), 0o644)
+	require.NoError(t, err)
+
+	r := NewRenderer(dir)
+	e, err := r.Render("en", "shipped", SampleData("shipped"))
+	require.NoError(t, err)
+	require.Equal(t, "On its way: #1042", e.Subject)
+	require.Contains(t, e.Text, "Shipped!")
+
+	// other statuses still use the embedded defaults
+	e, err = r.Render("en", "delivered", SampleData("delivered"))
+	require.NoError(t, err)
+	require.Equal(t, "Your order #1042 was delivered", e.Subject)
+}
+
+func TestRenderDigest(t *testing.T) {
+	r := NewRenderer("")
+	d := SampleData(Digest)
+
+	e, err := r.Render("en", Digest, d)
+	require.NoError(t, err)
+	require.Equal(t, "Updates on 3 of your orders", e.Subject)
+	require.Contains(t, e.Text, "- Order #1042 (129.94 USD): shipped\n")
+	require.Contains(t, e.Text, "- Order #1044 (129.94 USD): refund requested\n")
+	require.NotContains(t, e.Text, "USB-C cable")
+	require.Contains(t, e.HTML, "<td>#1043</td><td align=\"right\">129.94 USD</td><td>delivered</td>")
+
+	e, err = r.Render("fr-CA", Digest, d)
+	require.NoError(t, err)
+	require.Equal(t, "Du nouveau sur 3 de vos commandes", e.Subject)
+	require.Contains(t, e.Text, "- Commande n° 1043 (129.94 USD) : livrée\n")
+}
+
+func TestRenderPasswordReset(t *testing.T) {
+	r := NewRenderer("")
+	d := SampleData(PasswordReset)
+
+	e, err := r.Render("en", PasswordReset, d)
+	require.NoError(t, err)
+	require.Equal(t, "Reset your ecomm password", e.Subject)
+	require.Contains(t, e.Text, "expires at 10:30 UTC on October 18.")
+	require.Contains(t, e.Text, "\nhttps://shop.example.com/reset-password?token=sample\n")
+	require.Contains(t, e.HTML, `<a href="https://shop.example.com/reset-password?token=sample">`)
+
+	e, err = r.Render("fr-CA", PasswordReset, d)
+	require.NoError(t, err)
+	require.Equal(t, "Réinitialisez votre mot de passe ecomm", e.Subject)
+	require.Contains(t, e.Text, "expire le 18/10/2026 à 10:30 UTC.")
+}
@@ -0,0 +1,194 @@
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency amounts are stored in. Database columns
+// only hold the decimal amount, so every Money read from the database is in
+// this currency and writing any other currency is rejected.
+const DefaultCurrency = "USD"
+
+// minorUnits is the number of minor units in one major unit. All supported
+// currencies have two decimal places, matching the decimal(10,2) columns.
+const minorUnits = 100
+
+// Money is an exact amount of money in minor units (e.g. cents) of an
+// ISO 4217 currency.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse parses a decimal amount in major units such as "12.34".
+func Parse(s string, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > 2 {
+		return Money{}, fmt.Errorf("invalid amount %q: more than 2 decimal places", s)
+	}
+	// ParseInt would take signs in either part
+	if !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("invalid amount %q: not a decimal number", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	f, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+
+	amount := w*minorUnits + f
+	if neg {
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) sameCurrency(o Money) bool {
+	return m.Currency == o.Currency || m.Currency == "" || o.Currency == ""
+}
+
+func (m Money) currency(o Money) string {
+	if m.Currency != "" {
+		return m.Currency
+	}
+	return o.Currency
+}
+
+// Add returns m+o. Adding amounts in different currencies is an error; a
+// zero value without a currency takes the currency of the other operand.
+func (m Money) Add(o Money) (Money, error) {
+	if !m.sameCurrency(o) {
+		return Money{}, fmt.Errorf("cannot add %s to %s", o.Currency, m.Currency)
+	}
+
+	return Money{Amount: m.Amount + o.Amount, Currency: m.currency(o)}, nil
+}
+
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+// MulRatio returns m*num/den rounded half away from zero.
+func (m Money) MulRatio(num, den int64) Money {
+	p := m.Amount * num
+	q := p / den
+	if r := p % den; 2*abs(r) >= abs(den) {
+		if (p < 0) != (den < 0) {
+			q--
+		} else {
+			q++
+		}
+	}
+
+	return Money{Amount: q, Currency: m.Currency}
+}
+
+func (m Money) Equal(o Money) bool {
+	return m.Amount == o.Amount && m.Currency == o.Currency
+}
+
+// Decimal formats the amount in major units, e.g. "12.34".
+func (m Money) Decimal() string {
+	sign := ""
+	a := m.Amount
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, a/minorUnits, a%minorUnits)
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%s %s", m.Decimal(), m.Currency)
+}
+
+// Sum adds up amounts in the given currency.
+func Sum(currency string, ms ...Money) (Money, error) {
+	total := Money{Currency: currency}
+	for _, m := range ms {
+		var err error
+		total, err = total.Add(m)
+		if err != nil {
+			return Money{}, err
+		}
+	}
+
+	return total, nil
+}
+
+func (m *Money) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		*m = Money{Amount: v * minorUnits, Currency: DefaultCurrency}
+		return nil
+	case float64:
+		s = strconv.FormatFloat(v, 'f', 2, 64)
+	case nil:
+		*m = Money{Currency: DefaultCurrency}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+
+	parsed, err := Parse(s, DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	if m.Currency != "" && m.Currency != DefaultCurrency {
+		return nil, fmt.Errorf("cannot store %s amount, only %s is supported", m.Currency, DefaultCurrency)
+	}
+
+	return m.Decimal(), nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
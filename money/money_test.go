@@ -0,0 +1,73 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tcs := []struct {
+		in     string
+		amount int64
+		err    bool
+	}{
+		{in: "12.34", amount: 1234},
+		{in: "12.3", amount: 1230},
+		{in: "12", amount: 1200},
+		{in: ".5", amount: 50},
+		{in: "-0.05", amount: -5},
+		{in: "0.001", err: true},
+		{in: "abc", err: true},
+		{in: "1.-5", err: true},
+		{in: "1.+5", err: true},
+		{in: "--5", err: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.in, func(t *testing.T) {
+			m, err := Parse(tc.in, "USD")
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, New(tc.amount, "USD"), m)
+		})
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a := New(1999, "USD")
+
+	sum, err := Sum("USD", a.Mul(3), New(10, "USD"), Money{})
+	require.NoError(t, err)
+	require.Equal(t, New(6007, "USD"), sum)
+
+	_, err = a.Add(New(1, "EUR"))
+	require.Error(t, err)
+
+	require.Equal(t, int64(781), New(6007, "USD").MulRatio(1300, 10000).Amount)
+	require.Equal(t, int64(1), New(5, "USD").MulRatio(1, 10).Amount)
+	require.Equal(t, int64(-1), New(-5, "USD").MulRatio(1, 10).Amount)
+	require.Equal(t, int64(0), New(4, "USD").MulRatio(1, 10).Amount)
+
+	require.Equal(t, "60.07", sum.Decimal())
+	require.Equal(t, "-0.05 USD", New(-5, "USD").String())
+}
+
+func TestScanValue(t *testing.T) {
+	var m Money
+	require.NoError(t, m.Scan([]byte("99.99")))
+	require.Equal(t, New(9999, DefaultCurrency), m)
+
+	require.NoError(t, m.Scan(int64(3)))
+	require.Equal(t, New(300, DefaultCurrency), m)
+
+	v, err := New(9999, DefaultCurrency).Value()
+	require.NoError(t, err)
+	require.Equal(t, "99.99", v)
+
+	_, err = New(9999, "EUR").Value()
+	require.Error(t, err)
+}
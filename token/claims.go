@@ -8,23 +8,44 @@ import (
 	"github.com/google/uuid"
 )
 
+// TokenType tells access tokens, which authenticate requests, from refresh
+// tokens, which only renew access tokens.
+type TokenType string
+
+const (
+	AccessToken  TokenType = "access"
+	RefreshToken TokenType = "refresh"
+)
+
 type UserClaims struct {
-	ID      int64  `json:"id"`
-	Email   string `json:"email"`
-	IsAdmin bool   `json:"is_admin"`
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	IsAdmin   bool      `json:"is_admin"`
+	SessionID string    `json:"session_id,omitempty"`
+	Type      TokenType `json:"token_type,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func NewUserClaims(id int64, email string, isAdmin bool, duration time.Duration) (*UserClaims, error) {
+// NewUserClaims creates claims for an access token of the session sessionID.
+// A token without a session is a refresh token that starts one: its ID is the
+// session ID.
+func NewUserClaims(id int64, email string, isAdmin bool, sessionID string, duration time.Duration) (*UserClaims, error) {
 	tokenID, err := uuid.NewRandom()
 	if err != nil {
 		return nil, fmt.Errorf("error generating token ID: %w", err)
 	}
+	tokenType := AccessToken
+	if sessionID == "" {
+		sessionID = tokenID.String()
+		tokenType = RefreshToken
+	}
 
 	return &UserClaims{
-		Email:   email,
-		ID:      id,
-		IsAdmin: isAdmin,
+		Email:     email,
+		ID:        id,
+		IsAdmin:   isAdmin,
+		SessionID: sessionID,
+		Type:      tokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        tokenID.String(),
 			Subject:   email,
@@ -33,3 +54,16 @@ func NewUserClaims(id int64, email string, isAdmin bool, duration time.Duration)
 		},
 	}, nil
 }
+
+// TokenType returns the type of the token. Tokens issued before the type
+// claim existed are refresh tokens when they started their session.
+func (c *UserClaims) TokenType() TokenType {
+	if c.Type != "" {
+		return c.Type
+	}
+	if c.SessionID != "" && c.SessionID == c.RegisteredClaims.ID {
+		return RefreshToken
+	}
+
+	return AccessToken
+}
@@ -0,0 +1,26 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenType(t *testing.T) {
+	refresh, err := NewUserClaims(1, "test@example.com", false, "", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, RefreshToken, refresh.TokenType())
+	require.Equal(t, refresh.RegisteredClaims.ID, refresh.SessionID)
+
+	access, err := NewUserClaims(1, "test@example.com", false, refresh.SessionID, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, AccessToken, access.TokenType())
+
+	// tokens issued before the type claim existed
+	legacyRefresh := &UserClaims{SessionID: "s", RegisteredClaims: jwt.RegisteredClaims{ID: "s"}}
+	require.Equal(t, RefreshToken, legacyRefresh.TokenType())
+	legacyAccess := &UserClaims{SessionID: "s", RegisteredClaims: jwt.RegisteredClaims{ID: "t"}}
+	require.Equal(t, AccessToken, legacyAccess.TokenType())
+}
@@ -0,0 +1,43 @@
+package token
+
+import (
+	"fmt"
+	"time"
+)
+
+// FallbackMaker signs tokens with a KeyMaker and verifies them with it or,
+// until a deadline, with the maker it replaces. Switching from the secret key
+// to signing keys thus keeps users logged in with tokens issued before the
+// switch until those expire.
+type FallbackMaker struct {
+	*KeyMaker
+	fallback Maker
+	until    time.Time
+	now      func() time.Time
+}
+
+// NewFallbackMaker returns a maker that also accepts tokens verified by
+// fallback until the given time, which should be no earlier than the switch
+// plus the lifetime of the longest lived token.
+func NewFallbackMaker(maker *KeyMaker, fallback Maker, until time.Time) *FallbackMaker {
+	return &FallbackMaker{
+		KeyMaker: maker,
+		fallback: fallback,
+		until:    until,
+		now:      time.Now,
+	}
+}
+
+func (maker *FallbackMaker) VerifyToken(tokenStr string) (*UserClaims, error) {
+	claims, err := maker.KeyMaker.VerifyToken(tokenStr)
+	if err == nil || !maker.now().Before(maker.until) {
+		return claims, err
+	}
+
+	claims, fallbackErr := maker.fallback.VerifyToken(tokenStr)
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("%w; previous maker: %w", err, fallbackErr)
+	}
+
+	return claims, nil
+}
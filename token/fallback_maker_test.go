@@ -0,0 +1,47 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackMaker(t *testing.T) {
+	keyMaker, err := NewKeyMaker(&Key{ID: "k1", Signer: newEd25519Key(t)})
+	require.NoError(t, err)
+	secretMaker := NewJWTMaker("01234567890123456789012345678901")
+
+	now := time.Now()
+	maker := NewFallbackMaker(keyMaker, secretMaker, now.Add(time.Hour))
+	maker.now = func() time.Time { return now }
+
+	// tokens are signed with the keys
+	tokenStr, _, err := maker.CreateToken(1, "test@example.com", true, "session", time.Minute)
+	require.NoError(t, err)
+	_, err = keyMaker.VerifyToken(tokenStr)
+	require.NoError(t, err)
+	_, err = secretMaker.VerifyToken(tokenStr)
+	require.Error(t, err)
+	require.Len(t, maker.JWKS().Keys, 1)
+
+	// tokens signed with the secret key are accepted until the deadline
+	secretStr, _, err := secretMaker.CreateToken(1, "test@example.com", true, "session", 2*time.Hour)
+	require.NoError(t, err)
+	claims, err := maker.VerifyToken(secretStr)
+	require.NoError(t, err)
+	require.Equal(t, "test@example.com", claims.Email)
+
+	maker.now = func() time.Time { return now.Add(time.Hour) }
+	_, err = maker.VerifyToken(secretStr)
+	require.Error(t, err)
+	_, err = maker.VerifyToken(tokenStr)
+	require.NoError(t, err)
+
+	// tokens neither maker signed are rejected
+	otherStr, _, err := NewJWTMaker("other secret key of 32 characters").CreateToken(1, "test@example.com", true, "session", time.Minute)
+	require.NoError(t, err)
+	maker.now = func() time.Time { return now }
+	_, err = maker.VerifyToken(otherStr)
+	require.Error(t, err)
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// JWTMaker signs tokens with HS256 and a secret key shared by every service
+// that verifies them.
 type JWTMaker struct {
 	secretKey string
 }
@@ -15,8 +17,8 @@ func NewJWTMaker(secretKey string) *JWTMaker {
 	return &JWTMaker{secretKey}
 }
 
-func (maker *JWTMaker) CreateToken(id int64, email string, isAdmin bool, duration time.Duration) (string, *UserClaims, error) {
-	claims, err := NewUserClaims(id, email, isAdmin, duration)
+func (maker *JWTMaker) CreateToken(id int64, email string, isAdmin bool, sessionID string, duration time.Duration) (string, *UserClaims, error) {
+	claims, err := NewUserClaims(id, email, isAdmin, sessionID, duration)
 	if err != nil {
 		return "", nil, err
 	}
@@ -39,7 +41,7 @@ func (maker *JWTMaker) VerifyToken(tokenStr string) (*UserClaims, error) {
 		}
 
 		return []byte(maker.secretKey), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
 		return nil, fmt.Errorf("error parsing token: %w", err)
 	}
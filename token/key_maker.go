@@ -0,0 +1,267 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSAKeyBits = 2048
+
+// Key is a private key a KeyMaker signs tokens with, identified in their kid
+// header.
+type Key struct {
+	ID     string
+	Signer crypto.Signer
+	// ActiveFrom is when the key starts signing tokens. Until then it is only
+	// published, so that verifiers know it before the first token signed with
+	// it shows up.
+	ActiveFrom time.Time
+}
+
+func (k *Key) method() (jwt.SigningMethod, error) {
+	switch key := k.Signer.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("key %s: RSA key must be at least %d bits", k.ID, minRSAKeyBits)
+		}
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", k.ID, k.Signer)
+	}
+}
+
+// KeyMaker signs tokens with RS256 or EdDSA keys. Tokens are signed with the
+// most recently activated key and verified with whichever key their kid names,
+// so a key is rotated by adding its successor with a later ActiveFrom and
+// removing it once the tokens it signed have expired.
+type KeyMaker struct {
+	keys    []*Key
+	methods map[string]jwt.SigningMethod
+	now     func() time.Time
+}
+
+func NewKeyMaker(keys ...*Key) (*KeyMaker, error) {
+	maker := &KeyMaker{
+		methods: make(map[string]jwt.SigningMethod, len(keys)),
+		now:     time.Now,
+	}
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, fmt.Errorf("key ID is required")
+		}
+		if _, ok := maker.methods[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", k.ID)
+		}
+		method, err := k.method()
+		if err != nil {
+			return nil, err
+		}
+		maker.methods[k.ID] = method
+		maker.keys = append(maker.keys, k)
+	}
+	sort.SliceStable(maker.keys, func(i, j int) bool { return maker.keys[i].ActiveFrom.Before(maker.keys[j].ActiveFrom) })
+
+	if maker.signingKey() == nil {
+		return nil, fmt.Errorf("no key is active")
+	}
+
+	return maker, nil
+}
+
+// signingKey is the most recently activated key, nil if none is active yet.
+func (maker *KeyMaker) signingKey() *Key {
+	now := maker.now()
+	var active *Key
+	for _, k := range maker.keys {
+		if k.ActiveFrom.After(now) {
+			break
+		}
+		active = k
+	}
+
+	return active
+}
+
+func (maker *KeyMaker) CreateToken(id int64, email string, isAdmin bool, sessionID string, duration time.Duration) (string, *UserClaims, error) {
+	key := maker.signingKey()
+	if key == nil {
+		return "", nil, fmt.Errorf("no key is active")
+	}
+
+	claims, err := NewUserClaims(id, email, isAdmin, sessionID, duration)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := jwt.NewWithClaims(maker.methods[key.ID], claims)
+	token.Header["kid"] = key.ID
+	tokenStr, err := token.SignedString(key.Signer)
+	if err != nil {
+		return "", nil, fmt.Errorf("error signing token: %w", err)
+	}
+
+	return tokenStr, claims, nil
+}
+
+func (maker *KeyMaker) VerifyToken(tokenStr string) (*UserClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := maker.key(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+
+		// verify the signing method is the one of the key
+		if token.Method.Alg() != maker.methods[kid].Alg() {
+			return nil, fmt.Errorf("invalid token signing method")
+		}
+
+		return key.Signer.Public(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*UserClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	return claims, nil
+}
+
+func (maker *KeyMaker) key(id string) *Key {
+	for _, k := range maker.keys {
+		if k.ID == id {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// JWK is the public part of a key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public keys that tokens may be signed with, including
+// keys that are not active yet.
+func (maker *KeyMaker) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(maker.keys))}
+	for _, k := range maker.keys {
+		jwk := JWK{Kid: k.ID, Alg: maker.methods[k.ID].Alg(), Use: "sig"}
+		switch pub := k.Signer.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
+
+// ParseKeyPEM parses an RSA or Ed25519 private key, PKCS #8 or, for RSA,
+// PKCS #1 encoded.
+func ParseKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %w", err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// LoadKeys loads the keys of spec, a comma separated list of kid=path entries
+// where path is a PEM file. An entry may end in @ and an RFC 3339 time to
+// schedule when the key becomes active, e.g.
+// "2026-10=/keys/a.pem,2026-11=/keys/b.pem@2026-11-01T00:00:00Z".
+func LoadKeys(spec string) ([]*Key, error) {
+	var keys []*Key
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, path, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("invalid key entry %q, want kid=path", entry)
+		}
+
+		k := &Key{ID: id}
+		if p, activeFrom, ok := strings.Cut(path, "@"); ok {
+			t, err := time.Parse(time.RFC3339, activeFrom)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: invalid activation time: %w", id, err)
+			}
+			path, k.ActiveFrom = p, t
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: error reading key file: %w", id, err)
+		}
+		k.Signer, err = ParseKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
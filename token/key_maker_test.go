@@ -0,0 +1,251 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func TestKeyMaker(t *testing.T) {
+	tcs := []struct {
+		name   string
+		signer func(*testing.T) crypto.Signer
+		alg    string
+	}{
+		{name: "RS256", signer: func(t *testing.T) crypto.Signer { return newRSAKey(t) }, alg: "RS256"},
+		{name: "EdDSA", signer: func(t *testing.T) crypto.Signer { return newEd25519Key(t) }, alg: "EdDSA"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			maker, err := NewKeyMaker(&Key{ID: "k1", Signer: tc.signer(t)})
+			require.NoError(t, err)
+
+			tokenStr, claims, err := maker.CreateToken(1, "test@example.com", true, "session", time.Minute)
+			require.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(tokenStr, &UserClaims{})
+			require.NoError(t, err)
+			require.Equal(t, tc.alg, parsed.Method.Alg())
+			require.Equal(t, "k1", parsed.Header["kid"])
+
+			verified, err := maker.VerifyToken(tokenStr)
+			require.NoError(t, err)
+			require.Equal(t, claims.RegisteredClaims.ID, verified.RegisteredClaims.ID)
+			require.Equal(t, "session", verified.SessionID)
+			require.True(t, verified.IsAdmin)
+
+			// a key of another maker with the same kid doesn't verify
+			other, err := NewKeyMaker(&Key{ID: "k1", Signer: tc.signer(t)})
+			require.NoError(t, err)
+			_, err = other.VerifyToken(tokenStr)
+			require.Error(t, err)
+
+			expired, _, err := maker.CreateToken(1, "test@example.com", true, "session", -time.Minute)
+			require.NoError(t, err)
+			_, err = maker.VerifyToken(expired)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestKeyMakerRotation(t *testing.T) {
+	now := time.Now()
+	old := &Key{ID: "old", Signer: newRSAKey(t), ActiveFrom: now.Add(-time.Hour)}
+	next := &Key{ID: "next", Signer: newEd25519Key(t), ActiveFrom: now.Add(time.Hour)}
+
+	maker, err := NewKeyMaker(next, old)
+	require.NoError(t, err)
+
+	kid := func(tokenStr string) string {
+		parsed, _, err := jwt.NewParser().ParseUnverified(tokenStr, &UserClaims{})
+		require.NoError(t, err)
+		return parsed.Header["kid"].(string)
+	}
+
+	// the next key is published ahead of signing anything
+	require.Len(t, maker.JWKS().Keys, 2)
+	before, _, err := maker.CreateToken(1, "test@example.com", false, "", time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, "old", kid(before))
+
+	maker.now = func() time.Time { return now.Add(2 * time.Hour) }
+	after, _, err := maker.CreateToken(1, "test@example.com", false, "", time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, "next", kid(after))
+
+	// tokens signed with the old key stay valid until it's removed
+	_, err = maker.VerifyToken(before)
+	require.NoError(t, err)
+	_, err = maker.VerifyToken(after)
+	require.NoError(t, err)
+
+	// no key is active yet
+	_, err = NewKeyMaker(next)
+	require.Error(t, err)
+	next.ActiveFrom = time.Time{}
+	rotated, err := NewKeyMaker(next)
+	require.NoError(t, err)
+	_, err = rotated.VerifyToken(before)
+	require.Error(t, err)
+}
+
+func TestKeyMakerRejects(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	maker, err := NewKeyMaker(&Key{ID: "rsa", Signer: rsaKey}, &Key{ID: "ed", Signer: newEd25519Key(t)})
+	require.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		claims, err := NewUserClaims(1, "test@example.com", true, "", time.Minute)
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		tokenStr, err := token.SignedString(key)
+		require.NoError(t, err)
+		return tokenStr
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name     string
+		tokenStr string
+	}{
+		{name: "HMAC with the public key", tokenStr: sign(jwt.SigningMethodHS256, "rsa", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))},
+		{name: "shared secret", tokenStr: sign(jwt.SigningMethodHS256, "", []byte("secret"))},
+		{name: "unknown kid", tokenStr: sign(jwt.SigningMethodRS256, "unknown", rsaKey)},
+		{name: "missing kid", tokenStr: sign(jwt.SigningMethodRS256, "", rsaKey)},
+		{name: "method of another key", tokenStr: sign(jwt.SigningMethodRS256, "ed", rsaKey)},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := maker.VerifyToken(tc.tokenStr)
+			require.Error(t, err)
+		})
+	}
+
+	// and HS256 makers don't accept asymmetric tokens
+	tokenStr, _, err := maker.CreateToken(1, "test@example.com", true, "", time.Minute)
+	require.NoError(t, err)
+	_, err = NewJWTMaker("secret").VerifyToken(tokenStr)
+	require.Error(t, err)
+	_, err = NewJWTMaker("secret").VerifyToken(sign(jwt.SigningMethodHS512, "", []byte("secret")))
+	require.Error(t, err)
+}
+
+func TestNewKeyMakerInvalid(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name string
+		keys []*Key
+	}{
+		{name: "no keys"},
+		{name: "missing ID", keys: []*Key{{Signer: newEd25519Key(t)}}},
+		{name: "duplicate ID", keys: []*Key{{ID: "k1", Signer: newEd25519Key(t)}, {ID: "k1", Signer: newEd25519Key(t)}}},
+		{name: "weak RSA key", keys: []*Key{{ID: "k1", Signer: weak}}},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewKeyMaker(tc.keys...)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	edKey := newEd25519Key(t)
+	maker, err := NewKeyMaker(&Key{ID: "rsa", Signer: rsaKey}, &Key{ID: "ed", Signer: edKey})
+	require.NoError(t, err)
+
+	jwks := maker.JWKS()
+	require.Len(t, jwks.Keys, 2)
+
+	byKid := make(map[string]JWK)
+	for _, k := range jwks.Keys {
+		byKid[k.Kid] = k
+	}
+
+	rsaJWK := byKid["rsa"]
+	require.Equal(t, JWK{Kty: "RSA", Kid: "rsa", Alg: "RS256", Use: "sig", N: rsaJWK.N, E: "AQAB"}, rsaJWK)
+	n, err := base64.RawURLEncoding.DecodeString(rsaJWK.N)
+	require.NoError(t, err)
+	require.Equal(t, rsaKey.N.Bytes(), n)
+
+	edJWK := byKid["ed"]
+	require.Equal(t, "OKP", edJWK.Kty)
+	require.Equal(t, "Ed25519", edJWK.Crv)
+	require.Equal(t, "EdDSA", edJWK.Alg)
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)), edJWK.X)
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	writePEM := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+		return path
+	}
+
+	rsaKey := newRSAKey(t)
+	pkcs1 := writePEM("pkcs1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	der, err := x509.MarshalPKCS8PrivateKey(newEd25519Key(t))
+	require.NoError(t, err)
+	pkcs8 := writePEM("pkcs8.pem", "PRIVATE KEY", der)
+	pub, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	require.NoError(t, err)
+	public := writePEM("public.pem", "PUBLIC KEY", pub)
+
+	keys, err := LoadKeys(fmt.Sprintf(" a=%s, b=%s@2026-11-01T00:00:00Z ", pkcs1, pkcs8))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, "a", keys[0].ID)
+	require.True(t, keys[0].Signer.(*rsa.PrivateKey).Equal(rsaKey))
+	require.True(t, keys[0].ActiveFrom.IsZero())
+	require.Equal(t, "b", keys[1].ID)
+	require.IsType(t, ed25519.PrivateKey{}, keys[1].Signer)
+	require.Equal(t, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), keys[1].ActiveFrom)
+
+	for _, spec := range []string{
+		"a",
+		"a=",
+		"=" + pkcs1,
+		"a=" + pkcs1 + "@tomorrow",
+		"a=" + filepath.Join(dir, "missing.pem"),
+		"a=" + public,
+	} {
+		_, err := LoadKeys(spec)
+		require.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,15 @@
+package token
+
+import "time"
+
+// Maker creates and verifies the access and refresh tokens of users.
+type Maker interface {
+	CreateToken(id int64, email string, isAdmin bool, sessionID string, duration time.Duration) (string, *UserClaims, error)
+	VerifyToken(tokenStr string) (*UserClaims, error)
+}
+
+var (
+	_ Maker = (*JWTMaker)(nil)
+	_ Maker = (*KeyMaker)(nil)
+	_ Maker = (*FallbackMaker)(nil)
+)
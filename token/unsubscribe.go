@@ -0,0 +1,71 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const unsubscribeAudience = "unsubscribe"
+
+// UnsubscribeClaims let the holder of an unsubscribe link turn off one type of
+// notification for a user without logging in.
+type UnsubscribeClaims struct {
+	UserID int64  `json:"user_id"`
+	Type   string `json:"type"`
+	jwt.RegisteredClaims
+}
+
+// CreateUnsubscribeToken signs an unsubscribe link for a type of notification.
+// It is signed with a key derived from the secret key so that it can't be
+// used as an access token, and vice versa.
+func (maker *JWTMaker) CreateUnsubscribeToken(userID int64, notificationType string, duration time.Duration) (string, error) {
+	claims := &UnsubscribeClaims{
+		UserID: userID,
+		Type:   notificationType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{unsubscribeAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenStr, err := token.SignedString(maker.unsubscribeKey())
+	if err != nil {
+		return "", fmt.Errorf("error signing unsubscribe token: %w", err)
+	}
+
+	return tokenStr, nil
+}
+
+func (maker *JWTMaker) VerifyUnsubscribeToken(tokenStr string) (*UnsubscribeClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &UnsubscribeClaims{}, func(token *jwt.Token) (interface{}, error) {
+		// verify the signing method
+		_, ok := token.Method.(*jwt.SigningMethodHMAC)
+		if !ok {
+			return nil, fmt.Errorf("invalid token signing method")
+		}
+
+		return maker.unsubscribeKey(), nil
+	}, jwt.WithAudience(unsubscribeAudience))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing unsubscribe token: %w", err)
+	}
+
+	claims, ok := token.Claims.(*UnsubscribeClaims)
+	if !ok || claims.UserID == 0 || claims.Type == "" {
+		return nil, fmt.Errorf("invalid unsubscribe token claims")
+	}
+
+	return claims, nil
+}
+
+func (maker *JWTMaker) unsubscribeKey() []byte {
+	mac := hmac.New(sha256.New, []byte(maker.secretKey))
+	mac.Write([]byte(unsubscribeAudience))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":1,"type":"order.created"}`)
+	now := time.Now()
+
+	tcs := []struct {
+		name   string
+		header func() http.Header
+		body   []byte
+		err    error
+	}{
+		{
+			name: "valid",
+			header: func() http.Header {
+				h := http.Header{}
+				SetHeaders(h, "secret", "42", now, body)
+				return h
+			},
+		},
+		{
+			name: "rotated secret",
+			header: func() http.Header {
+				h := http.Header{}
+				SetHeaders(h, "secret", "42", now, body)
+				h.Set(SignatureHeader, Sign("old secret", "42", now, body)+" "+h.Get(SignatureHeader))
+				return h
+			},
+		},
+		{
+			name: "wrong secret",
+			header: func() http.Header {
+				h := http.Header{}
+				SetHeaders(h, "other secret", "42", now, body)
+				return h
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "tampered body",
+			header: func() http.Header {
+				h := http.Header{}
+				SetHeaders(h, "secret", "42", now, body)
+				return h
+			},
+			body: []byte(`{"id":2,"type":"order.created"}`),
+			err:  ErrInvalidSignature,
+		},
+		{
+			name: "tampered id",
+			header: func() http.Header {
+				h := http.Header{}
+				SetHeaders(h, "secret", "42", now, body)
+				h.Set(IDHeader, "43")
+				return h
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "replayed",
+			header: func() http.Header {
+				h := http.Header{}
+				SetHeaders(h, "secret", "42", now.Add(-time.Hour), body)
+				return h
+			},
+			err: ErrExpired,
+		},
+		{
+			name: "tampered timestamp",
+			header: func() http.Header {
+				h := http.Header{}
+				SetHeaders(h, "secret", "42", now.Add(-time.Hour), body)
+				h.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+				return h
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name:   "unsigned",
+			header: func() http.Header { return http.Header{} },
+			err:    ErrMissingHeader,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			b := body
+			if tc.body != nil {
+				b = tc.body
+			}
+
+			err := Verify("secret", tc.header(), b, DefaultTolerance)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}